@@ -2,75 +2,167 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 
 	"pmeonline/internal/dbexporter/db"
 	"pmeonline/internal/dbexporter/exporter"
+	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/ledger/audit"
+	"pmeonline/pkg/logging"
+	"pmeonline/pkg/metrics"
+	"pmeonline/pkg/tracing"
 )
 
 func main() {
-	log.Println("[DB-EXPORTER] Starting Database Exporter Service...")
+	// Structured logs (LOG_FORMAT, LOG_LEVEL, see pkg/logging); the standard
+	// log package writes through them as well
+	logger, err := logging.New("dbexporter")
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid logging settings", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	logger.Info("Starting Database Exporter Service")
 
 	// Get configuration from environment
 	kafkaURL := getEnv("KAFKA_URL", "localhost:9092")
 	kafkaTopic := getEnv("KAFKA_TOPIC", "pme-ledger")
+	metricsPort := getEnv("METRICS_PORT", "8083")
 
-	log.Printf("[DB-EXPORTER] Kafka URL: %s", kafkaURL)
-	log.Printf("[DB-EXPORTER] Kafka Topic: %s", kafkaTopic)
+	logger.Info("Kafka settings", "url", kafkaURL, "topic", kafkaTopic)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// OpenTelemetry traces (OTEL_TRACES_EXPORTER, see pkg/tracing)
+	shutdownTracing, err := tracing.Setup(ctx, "dbexporter")
+	if err != nil {
+		logging.Fatal(logger, "Invalid tracing settings", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Connect to database
-	database, err := db.NewDBFromEnv()
+	database, err := db.NewDBFromEnv(logger)
 	if err != nil {
-		log.Fatalf("[DB-EXPORTER] Failed to connect to database: %v", err)
+		logging.Fatal(logger, "Failed to connect to database", "error", err)
 	}
 	defer database.Close()
 
 	// Run migrations
 	migrationsPath := filepath.Join("migrations", "001_create_tables.sql")
 	if err := database.RunMigrations(migrationsPath); err != nil {
-		log.Fatalf("[DB-EXPORTER] Failed to run migrations: %v", err)
+		logging.Fatal(logger, "Failed to run migrations", "error", err)
 	}
 
-	// Create exporter
-	exp := exporter.NewExporter(database.DB)
-
 	// Create LedgerPoint
-	log.Println("[DB-EXPORTER] Initializing LedgerPoint...")
-	ledgerPoint := ledger.CreateLedgerPoint(kafkaURL, kafkaTopic, "dbexporter")
+	logger.Info("Initializing LedgerPoint")
+	partitions, err := strconv.Atoi(getEnv("LEDGER_PARTITIONS", "1"))
+	if err != nil || partitions < 1 {
+		logging.Fatal(logger, "Invalid LEDGER_PARTITIONS", "value", getEnv("LEDGER_PARTITIONS", ""))
+	}
+	ledgerPoint, err := ledger.CreatePartitionedLedgerPoint(kafkaURL, kafkaTopic, partitions, nil, "dbexporter")
+	if err != nil {
+		logging.Fatal(logger, "Failed to create LedgerPoint", "error", err)
+	}
+	// Payload encryption and producer signatures (LEDGER_* key settings)
+	security, err := ledger.SecurityFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger security settings", "error", err)
+	}
+	ledgerPoint.SetSecurity(security)
+	// Event types each service may emit (LEDGER_POLICY_FILE, DefaultPolicy otherwise)
+	policy, err := ledger.PolicyFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger policy", "error", err)
+	}
+	ledgerPoint.SetPolicy(policy)
+	ledgerPoint.SetLogger(logger)
+	// Event IDs from this replica's INSTANCE_ID, so replicas writing to the
+	// same partition never produce the same event-id
+	idGenerator, err := idgen.GeneratorFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ID generator settings", "error", err)
+	}
+	ledgerPoint.SetIDGenerator(idGenerator)
+
+	// Create exporter, stamping rows with the ledger's clock
+	exp := exporter.NewExporter(database.DB, ledgerPoint.Clock(), logger)
 
 	// Collect all subscribers
 	subscribers := []ledger.LedgerPointInterface{
 		exp,
 	}
 
+	// Enable ledger snapshots so restarts don't replay the whole topic
+	if snapshotDir := getEnv("SNAPSHOT_DIR", ""); snapshotDir != "" {
+		snapshotInterval, err := strconv.Atoi(getEnv("SNAPSHOT_INTERVAL", "1000"))
+		if err != nil || snapshotInterval <= 0 {
+			logging.Fatal(logger, "Invalid SNAPSHOT_INTERVAL", "value", getEnv("SNAPSHOT_INTERVAL", ""))
+		}
+		store, err := ledger.NewFileSnapshotStore(snapshotDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create snapshot store", "error", err)
+		}
+		ledgerPoint.EnableSnapshots(store, snapshotInterval)
+		logger.Info("Snapshots enabled", "dir", snapshotDir, "every", snapshotInterval)
+	}
+
+	// Replay archived days first when Kafka retention has deleted them
+	if archiveDir := getEnv("ARCHIVE_DIR", ""); archiveDir != "" {
+		archive, err := ledger.NewArchive(archiveDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to open archive", "error", err)
+		}
+		if err := ledgerPoint.EnableArchiveReplay(archive); err != nil {
+			logging.Fatal(logger, "Failed to enable archive replay", "error", err)
+		}
+		logger.Info("Archive replay enabled", "dir", archiveDir)
+	}
+
+	// Check ledger invariants on every applied event
+	if getEnv("LEDGER_AUDIT", "false") == "true" {
+		audit.New(ledgerPoint, audit.LogViolations(logger.With(logging.Component("audit"))))
+		logger.Info("Ledger invariant audit enabled")
+	}
+
 	// Start LedgerPoint with all subscribers
-	log.Println("[DB-EXPORTER] Starting LedgerPoint with subscribers...")
+	logger.Info("Starting LedgerPoint with subscribers")
+	metrics.RegisterLedger(ledgerPoint, "dbexporter")
 	ledgerPoint.Start(subscribers, ctx)
 
-	log.Println("[DB-EXPORTER] Database Exporter Service started successfully")
-	log.Println("[DB-EXPORTER] Listening for Kafka events...")
-	log.Println("[DB-EXPORTER] Press Ctrl+C to stop")
+	// Prometheus metrics, including failed database writes
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Handler())
+		logger.Info("Metrics endpoint listening", "port", metricsPort)
+		if err := http.ListenAndServe(":"+metricsPort, mux); err != nil {
+			logging.Fatal(logger, "Metrics server error", "error", err)
+		}
+	}()
+
+	logger.Info("Database Exporter Service started successfully")
+	logger.Info("Listening for Kafka events")
+	logger.Info("Press Ctrl+C to stop")
 
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	<-sigChan
-	log.Println("[DB-EXPORTER] Shutdown signal received")
+	logger.Info("Shutdown signal received")
 
 	// Cancel context to stop LedgerPoint
 	cancel()
 
-	log.Println("[DB-EXPORTER] Database Exporter Service stopped")
+	logger.Info("Database Exporter Service stopped")
 }
 
 // Helper function to get environment variable with default
@@ -2,19 +2,32 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"pmeonline/internal/eclearapi/handler"
+	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
+	"pmeonline/pkg/metrics"
+	"pmeonline/pkg/tracing"
 )
 
 func main() {
-	log.Println("🚀 Starting eClear API Service...")
+	// Structured logs (LOG_FORMAT, LOG_LEVEL, see pkg/logging); the standard
+	// log package writes through them as well
+	logger, err := logging.New("eclearapi")
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid logging settings", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	logger.Info("Starting eClear API Service")
 
 	// Configuration from environment variables
 	kafkaURL := getEnv("KAFKA_URL", "localhost:9092")
@@ -22,43 +35,96 @@ func main() {
 	apiPort := getEnv("API_PORT", "8081")
 	eclearBaseURL := getEnv("ECLEAR_BASE_URL", "http://localhost:9000")
 
+	// Health reports degraded when more than LEDGER_MAX_LAG messages are unapplied
+	maxLag, err := strconv.ParseInt(getEnv("LEDGER_MAX_LAG", "1000"), 10, 64)
+	if err != nil || maxLag < 0 {
+		logging.Fatal(logger, "Invalid LEDGER_MAX_LAG", "value", getEnv("LEDGER_MAX_LAG", ""))
+	}
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// OpenTelemetry traces (OTEL_TRACES_EXPORTER, see pkg/tracing)
+	shutdownTracing, err := tracing.Setup(ctx, "eclearapi")
+	if err != nil {
+		logging.Fatal(logger, "Invalid tracing settings", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize LedgerPoint
-	log.Println("📊 Initializing LedgerPoint...")
-	ledgerPoint := ledger.CreateLedgerPoint(kafkaURL, kafkaTopic, "eclearapi")
+	logger.Info("Initializing LedgerPoint")
+	partitions, err := strconv.Atoi(getEnv("LEDGER_PARTITIONS", "1"))
+	if err != nil || partitions < 1 {
+		logging.Fatal(logger, "Invalid LEDGER_PARTITIONS", "value", getEnv("LEDGER_PARTITIONS", ""))
+	}
+	ledgerPoint, err := ledger.CreatePartitionedLedgerPoint(kafkaURL, kafkaTopic, partitions, nil, "eclearapi")
+	if err != nil {
+		logging.Fatal(logger, "Failed to create LedgerPoint", "error", err)
+	}
+	// Payload encryption and producer signatures (LEDGER_* key settings)
+	security, err := ledger.SecurityFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger security settings", "error", err)
+	}
+	ledgerPoint.SetSecurity(security)
+	// Event types each service may emit (LEDGER_POLICY_FILE, DefaultPolicy otherwise)
+	policy, err := ledger.PolicyFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger policy", "error", err)
+	}
+	ledgerPoint.SetPolicy(policy)
+	ledgerPoint.SetLogger(logger)
+	// Event IDs and the NIDs of ARO and recall orders from this replica's
+	// INSTANCE_ID, so replicas never produce the same ID
+	idGenerator, err := idgen.GeneratorFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ID generator settings", "error", err)
+	}
+	ledgerPoint.SetIDGenerator(idGenerator)
 
 	// Initialize outbound client (for sending trades to eClear)
-	// This must be created BEFORE starting LedgerPoint to receive all events
-	log.Println("📤 Initializing eClear outbound client...")
-	eclearClient := handler.NewEClearClient(eclearBaseURL, ledgerPoint)
-	eclearSyncHandler := eclearClient.GetSyncHandler()
-
-	// Collect all subscribers
-	subscribers := []ledger.LedgerPointInterface{
-		eclearSyncHandler,
+	// This must be created BEFORE starting LedgerPoint to receive all events;
+	// it subscribes to the events it needs itself
+	logger.Info("Initializing eClear outbound client")
+	eclearClient := handler.NewEClearClient(eclearBaseURL, ledgerPoint, logger)
+
+	// Enable ledger snapshots so restarts don't replay the whole topic
+	if snapshotDir := getEnv("SNAPSHOT_DIR", ""); snapshotDir != "" {
+		snapshotInterval, err := strconv.Atoi(getEnv("SNAPSHOT_INTERVAL", "1000"))
+		if err != nil || snapshotInterval <= 0 {
+			logging.Fatal(logger, "Invalid SNAPSHOT_INTERVAL", "value", getEnv("SNAPSHOT_INTERVAL", ""))
+		}
+		store, err := ledger.NewFileSnapshotStore(snapshotDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create snapshot store", "error", err)
+		}
+		ledgerPoint.EnableSnapshots(store, snapshotInterval)
+		logger.Info("Snapshots enabled", "dir", snapshotDir, "every", snapshotInterval)
 	}
 
-	// Start LedgerPoint with all subscribers
-	log.Println("🚀 Starting LedgerPoint with subscribers...")
-	ledgerPoint.Start(subscribers, ctx)
-
-	// Wait for LedgerPoint to be ready
-	log.Println("⏳ Waiting for LedgerPoint to be ready...")
-	for !ledgerPoint.IsReady {
-		time.Sleep(100 * time.Millisecond)
+	// Replay archived days first when Kafka retention has deleted them
+	if archiveDir := getEnv("ARCHIVE_DIR", ""); archiveDir != "" {
+		archive, err := ledger.NewArchive(archiveDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to open archive", "error", err)
+		}
+		if err := ledgerPoint.EnableArchiveReplay(archive); err != nil {
+			logging.Fatal(logger, "Failed to enable archive replay", "error", err)
+		}
+		logger.Info("Archive replay enabled", "dir", archiveDir)
 	}
-	log.Println("✅ LedgerPoint is ready")
 
-	// Start outbound client processing (after LedgerPoint is ready)
-	log.Println("▶️  Starting eClear outbound client...")
-	go eclearClient.RunProcessing(ctx)
+	// Prometheus metrics on GET /metrics
+	metrics.RegisterLedger(ledgerPoint, "eclearapi")
+
+	// Start LedgerPoint
+	logger.Info("Starting LedgerPoint")
+	ledgerPoint.Start(nil, ctx)
 
 	// Initialize handlers (these don't need event subscription)
 	masterDataHandler := handler.NewMasterDataHandler(ledgerPoint)
-	tradeHandler := handler.NewTradeHandler(ledgerPoint)
+	tradeHandler := handler.NewTradeHandler(ledgerPoint, idGenerator)
 	queryHandler := handler.NewQueryHandler(ledgerPoint)
 	settingsHandler := handler.NewSettingsHandler(ledgerPoint)
 
@@ -81,13 +147,17 @@ func main() {
 	mux.HandleFunc("GET /instrument/list", queryHandler.GetInstruments)
 	mux.HandleFunc("GET /account/list", queryHandler.GetAccounts)
 
-	// Settings endpoints (for parameter, holiday, sessiontime management)
+	// Settings endpoints (for parameter, holiday, sessiontime and risk rule management)
 	mux.HandleFunc("GET /parameter", settingsHandler.GetParameter)
 	mux.HandleFunc("POST /parameter/update", settingsHandler.UpdateParameter)
 	mux.HandleFunc("GET /holiday/list", settingsHandler.GetHolidays)
 	mux.HandleFunc("POST /holiday/add", settingsHandler.AddHoliday)
 	mux.HandleFunc("GET /sessiontime", settingsHandler.GetSessionTime)
 	mux.HandleFunc("POST /sessiontime/update", settingsHandler.UpdateSessionTime)
+	mux.HandleFunc("GET /risk/rules", settingsHandler.GetRiskRules)
+	mux.HandleFunc("POST /risk/rule/update", settingsHandler.UpdateRiskRule)
+	mux.HandleFunc("GET /risk/concentration/list", settingsHandler.GetConcentrationLimits)
+	mux.HandleFunc("POST /risk/concentration/update", settingsHandler.UpdateConcentrationLimit)
 
 	// Serve static files
 	fs := http.FileServer(http.Dir("../../web/static/eclearapi"))
@@ -97,17 +167,16 @@ func main() {
 	mux.HandleFunc("GET /", serveDashboard)
 	mux.HandleFunc("GET /dashboard", serveDashboard)
 
-	// Health check endpoint
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok","service":"eclearapi"}`))
-	})
+	// Only the health check and metrics are served until the LedgerPoint has caught up
+	root := http.NewServeMux()
+	root.Handle("/", ledgerPoint.RequireReady(mux))
+	root.HandleFunc("GET /health", ledgerPoint.HealthHandler("eclearapi", maxLag))
+	root.Handle("GET /metrics", metrics.Handler())
 
 	// Create HTTP server with CORS and logging middleware
 	server := &http.Server{
 		Addr:         ":" + apiPort,
-		Handler:      loggingMiddleware(corsMiddleware(mux)),
+		Handler:      loggingMiddleware(logger, corsMiddleware(root)),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -115,29 +184,38 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("🌐 eClear API listening on port %s\n", apiPort)
+		logger.Info("eClear API listening", "port", apiPort)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("❌ Server error: %v", err)
+			logging.Fatal(logger, "Server error", "error", err)
 		}
 	}()
 
+	// Wait for LedgerPoint to be ready
+	logger.Info("Waiting for LedgerPoint to be ready")
+	<-ledgerPoint.Ready()
+	logger.Info("LedgerPoint is ready")
+
+	// Start outbound client processing (after LedgerPoint is ready)
+	logger.Info("Starting eClear outbound client")
+	go eclearClient.RunProcessing(ctx)
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("🛑 Shutting down server...")
+	logger.Info("Shutting down server")
 
 	// Graceful shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("❌ Server forced to shutdown: %v", err)
+		logger.Error("Server forced to shutdown", "error", err)
 	}
 
 	cancel() // Cancel main context
-	log.Println("✅ Server stopped")
+	logger.Info("Server stopped")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -147,20 +225,24 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// loggingMiddleware logs requests with their request ID, and gives the
+// handlers a logger carrying it (see logging.Middleware)
+func loggingMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return logging.Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		log.Printf("📨 %s %s", r.Method, r.URL.Path)
+		logger := logging.FromContext(r.Context())
+		logger.Info("Request received", "method", r.Method, "path", r.URL.Path)
 		next.ServeHTTP(w, r)
-		log.Printf("✅ %s %s completed in %v", r.Method, r.URL.Path, time.Since(start))
-	})
+		logger.Info("Request completed", "method", r.Method, "path", r.URL.Path, "duration", time.Since(start))
+	}))
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+logging.HeaderRequestID)
+		w.Header().Set("Access-Control-Expose-Headers", logging.HeaderRequestID)
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
+)
+
+// ledgerarchiver copies the days of the ledger topic closed by an Eod into
+// compressed, checksummed segment files (see ledger.Archive for the format),
+// so services started with ARCHIVE_DIR can rebuild state after Kafka
+// retention deleted them:
+//
+//	ledgerarchiver -dir /var/lib/pme/archive
+//	ledgerarchiver -dir /var/lib/pme/archive -interval 10m
+//
+// Without -interval it archives once and exits.
+func main() {
+	kafkaURL := flag.String("kafka", getEnv("KAFKA_URL", "localhost:9092"), "Kafka broker address")
+	kafkaTopic := flag.String("topic", getEnv("KAFKA_TOPIC", "pme-ledger"), "Ledger topic")
+	dir := flag.String("dir", getEnv("ARCHIVE_DIR", ""), "Archive directory")
+	interval := flag.Duration("interval", 0, "Archive periodically instead of once")
+	flag.Parse()
+
+	// Structured logs (LOG_FORMAT, LOG_LEVEL, see pkg/logging)
+	logger, err := logging.New("ledgerarchiver")
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid logging settings", "error", err)
+	}
+
+	if *dir == "" {
+		logging.Fatal(logger, "-dir or ARCHIVE_DIR is required")
+	}
+	archive, err := ledger.NewArchive(*dir)
+	if err != nil {
+		logging.Fatal(logger, "Failed to open archive", "error", err)
+	}
+
+	transport := ledger.NewKafkaTransport(*kafkaURL, *kafkaTopic)
+	defer transport.Close()
+	archiver := ledger.NewArchiver(transport, archive)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	for {
+		runCtx, runCancel := context.WithTimeout(ctx, 30*time.Minute)
+		segments, err := archiver.ArchiveClosedDays(runCtx)
+		runCancel()
+		for _, seg := range segments {
+			logger.Info("Archived segment", "first", seg.First, "last", seg.Last, "path", seg.Path)
+		}
+		if err != nil {
+			if *interval == 0 {
+				logging.Fatal(logger, "Archiving failed", "error", err)
+			}
+			logger.Error("Archiving failed", "error", err)
+		}
+		if *interval == 0 {
+			return
+		}
+
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/ledger/audit"
+	"pmeonline/pkg/logging"
+)
+
+// ledgeraudit replays the ledger topic and checks its invariants, e.g. to
+// certify a day's data:
+//
+//	ledgeraudit -day 2025-11-24
+//	ledgeraudit -from 1200 -to 5400 -json
+//
+// It exits with status 1 when violations are found.
+func main() {
+	kafkaURL := flag.String("kafka", getEnv("KAFKA_URL", "localhost:9092"), "Kafka broker address")
+	kafkaTopic := flag.String("topic", getEnv("KAFKA_TOPIC", "pme-ledger"), "Ledger topic")
+	from := flag.Int64("from", 0, "First offset to report")
+	to := flag.Int64("to", -1, "Last offset to report (-1 for the end of the log)")
+	day := flag.String("day", "", "Only report messages written on this day (YYYY-MM-DD, local time)")
+	asJSON := flag.Bool("json", false, "Print the report as JSON")
+	flag.Parse()
+
+	// Structured logs on stderr (LOG_FORMAT, LOG_LEVEL, see pkg/logging),
+	// the report on stdout
+	logger, err := logging.New("ledgeraudit")
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid logging settings", "error", err)
+	}
+
+	r := audit.Range{From: *from, To: *to}
+	if *day != "" {
+		since, err := time.ParseInLocation("2006-01-02", *day, time.Local)
+		if err != nil {
+			logging.Fatal(logger, "Invalid -day", "error", err)
+		}
+		r.Since = since
+		r.Until = since.AddDate(0, 0, 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	transport := ledger.NewKafkaTransport(*kafkaURL, *kafkaTopic)
+	defer transport.Close()
+
+	security, err := ledger.SecurityFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger security settings", "error", err)
+	}
+	policy, err := ledger.PolicyFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger policy", "error", err)
+	}
+
+	logger.Info("Replaying ledger", "topic", *kafkaTopic, "kafka", *kafkaURL)
+	report, err := audit.Run(ctx, transport, security, policy, r)
+	if err != nil {
+		logging.Fatal(logger, "Replay failed", "error", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, v := range report.Violations {
+			fmt.Println(v)
+		}
+		fmt.Printf("%d events audited (offsets %d-%d), %d violations\n",
+			report.Events, report.FirstOffset, report.LastOffset, len(report.Violations))
+	}
+
+	if len(report.Violations) > 0 {
+		os.Exit(1)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,10 +15,21 @@ import (
 	"pmeonline/internal/pmeapi/websocket"
 	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
+	"pmeonline/pkg/metrics"
+	"pmeonline/pkg/tracing"
 )
 
 func main() {
-	log.Println("[APME-API] Starting APME API Service...")
+	// Structured logs (LOG_FORMAT, LOG_LEVEL, see pkg/logging); the standard
+	// log package writes through them as well
+	logger, err := logging.New("pmeapi")
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid logging settings", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	logger.Info("Starting APME API Service")
 
 	// Configuration from environment variables
 	kafkaURL := getEnv("KAFKA_URL", "localhost:9092")
@@ -29,45 +40,108 @@ func main() {
 	// Parse instance ID
 	instanceID, err := strconv.ParseInt(instanceIDStr, 10, 64)
 	if err != nil || instanceID < 0 || instanceID > 1023 {
-		log.Fatalf("[APME-API] Invalid INSTANCE_ID: must be 0-1023, got '%s'", instanceIDStr)
+		logging.Fatal(logger, "Invalid INSTANCE_ID, must be 0-1023", "value", instanceIDStr)
+	}
+	logger.Info("Instance ID", "instance", instanceID)
+
+	// Health reports degraded when more than LEDGER_MAX_LAG messages are unapplied
+	maxLag, err := strconv.ParseInt(getEnv("LEDGER_MAX_LAG", "1000"), 10, 64)
+	if err != nil || maxLag < 0 {
+		logging.Fatal(logger, "Invalid LEDGER_MAX_LAG", "value", getEnv("LEDGER_MAX_LAG", ""))
 	}
-	log.Printf("[APME-API] Instance ID: %d", instanceID)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// OpenTelemetry traces (OTEL_TRACES_EXPORTER, see pkg/tracing)
+	shutdownTracing, err := tracing.Setup(ctx, "pmeapi")
+	if err != nil {
+		logging.Fatal(logger, "Invalid tracing settings", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize Snowflake ID generator
 	idGenerator, err := idgen.NewGenerator(instanceID)
 	if err != nil {
-		log.Fatalf("[APME-API] Failed to create ID generator: %v", err)
+		logging.Fatal(logger, "Failed to create ID generator", "error", err)
 	}
-	log.Printf("[APME-API] Snowflake ID generator initialized (instance: %d)", instanceID)
+	logger.Info("Snowflake ID generator initialized", "instance", instanceID)
 
 	// Initialize LedgerPoint
-	log.Println("[APME-API] Initializing LedgerPoint...")
-	ledgerPoint := ledger.CreateLedgerPoint(kafkaURL, kafkaTopic, "pmeapi")
+	logger.Info("Initializing LedgerPoint")
+	partitions, err := strconv.Atoi(getEnv("LEDGER_PARTITIONS", "1"))
+	if err != nil || partitions < 1 {
+		logging.Fatal(logger, "Invalid LEDGER_PARTITIONS", "value", getEnv("LEDGER_PARTITIONS", ""))
+	}
+	ledgerPoint, err := ledger.CreatePartitionedLedgerPoint(kafkaURL, kafkaTopic, partitions, nil, "pmeapi")
+	if err != nil {
+		logging.Fatal(logger, "Failed to create LedgerPoint", "error", err)
+	}
+	// Payload encryption and producer signatures (LEDGER_* key settings)
+	security, err := ledger.SecurityFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger security settings", "error", err)
+	}
+	ledgerPoint.SetSecurity(security)
+	// Event types each service may emit (LEDGER_POLICY_FILE, DefaultPolicy otherwise)
+	policy, err := ledger.PolicyFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger policy", "error", err)
+	}
+	ledgerPoint.SetPolicy(policy)
+	ledgerPoint.SetLogger(logger)
+	// Event IDs share the INSTANCE_ID of the order NIDs
+	ledgerPoint.SetIDGenerator(idGenerator)
 
 	// Initialize WebSocket hub
-	hub := websocket.NewHub()
+	hub := websocket.NewHub(logger)
 	go hub.Run(ctx)
 
 	// Subscribe to events for notifications
 	notifier := websocket.NewNotifier(hub, ledgerPoint)
 
-	ledgerPoint.Start([]ledger.LedgerPointInterface{notifier}, ctx)
+	// Prometheus metrics on GET /metrics
+	metrics.RegisterLedger(ledgerPoint, "pmeapi")
+	hub.RegisterMetrics()
 
-	// Wait for LedgerPoint to be ready
-	log.Println("[APME-API] Waiting for LedgerPoint to be ready...")
-	for !ledgerPoint.IsReady {
-		time.Sleep(100 * time.Millisecond)
+	// Enable ledger snapshots so restarts don't replay the whole topic
+	if snapshotDir := getEnv("SNAPSHOT_DIR", ""); snapshotDir != "" {
+		snapshotInterval, err := strconv.Atoi(getEnv("SNAPSHOT_INTERVAL", "1000"))
+		if err != nil || snapshotInterval <= 0 {
+			logging.Fatal(logger, "Invalid SNAPSHOT_INTERVAL", "value", getEnv("SNAPSHOT_INTERVAL", ""))
+		}
+		store, err := ledger.NewFileSnapshotStore(snapshotDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create snapshot store", "error", err)
+		}
+		ledgerPoint.EnableSnapshots(store, snapshotInterval)
+		logger.Info("Snapshots enabled", "dir", snapshotDir, "every", snapshotInterval)
+	}
+
+	// Replay archived days first when Kafka retention has deleted them
+	if archiveDir := getEnv("ARCHIVE_DIR", ""); archiveDir != "" {
+		archive, err := ledger.NewArchive(archiveDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to open archive", "error", err)
+		}
+		if err := ledgerPoint.EnableArchiveReplay(archive); err != nil {
+			logging.Fatal(logger, "Failed to enable archive replay", "error", err)
+		}
+		logger.Info("Archive replay enabled", "dir", archiveDir)
 	}
-	log.Println("[APME-API] LedgerPoint is ready")
+
+	ledgerPoint.Start([]ledger.LedgerPointInterface{notifier}, ctx)
 
 	// Initialize handlers
 	orderHandler := handler.NewOrderHandler(ledgerPoint, idGenerator)
 	queryHandler := handler.NewQueryHandler(ledgerPoint)
 	sblHandler := handler.NewSBLHandler(ledgerPoint)
+	adminToken := getEnv("ADMIN_TOKEN", "")
+	if adminToken == "" {
+		logger.Warn("ADMIN_TOKEN not set, admin endpoints disabled")
+	}
+	adminHandler := handler.NewAdminHandler(ledgerPoint, adminToken)
 
 	// Setup HTTP router
 	mux := http.NewServeMux()
@@ -86,6 +160,9 @@ func main() {
 	mux.HandleFunc("GET /api/sbl/detail", sblHandler.GetSBLDetail)
 	mux.HandleFunc("GET /api/sbl/aggregate", sblHandler.GetSBLAggregate)
 
+	// Admin endpoints
+	mux.HandleFunc("GET /api/admin/ledger/asof", adminHandler.GetLedgerAsOf)
+
 	// WebSocket endpoint
 	mux.HandleFunc("GET /ws/notifications", func(w http.ResponseWriter, r *http.Request) {
 		websocket.ServeWs(hub, w, r)
@@ -99,16 +176,17 @@ func main() {
 	mux.HandleFunc("GET /", serveDashboard)
 	mux.HandleFunc("GET /dashboard", serveDashboard)
 
-	// Health check endpoint
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok","service":"pmeapi"}`))
-	})
+	// Only the health check and metrics are served until the LedgerPoint has caught up
+	root := http.NewServeMux()
+	root.Handle("/", ledgerPoint.RequireReady(mux))
+	root.HandleFunc("GET /health", ledgerPoint.HealthHandler("pmeapi", maxLag))
+	root.Handle("GET /metrics", metrics.Handler())
 
 	// Apply middleware
-	handler := middleware.LoggingMiddleware(
-		middleware.CORSMiddleware(mux),
+	handler := middleware.LoggingMiddleware(logger,
+		middleware.CORSMiddleware(
+			middleware.TracingMiddleware(root),
+		),
 	)
 
 	// Create HTTP server
@@ -122,29 +200,34 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("[APME-API] API listening on port %s\n", apiPort)
+		logger.Info("API listening", "port", apiPort)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("[APME-API] Server error: %v", err)
+			logging.Fatal(logger, "Server error", "error", err)
 		}
 	}()
 
+	// Wait for LedgerPoint to be ready
+	logger.Info("Waiting for LedgerPoint to be ready")
+	<-ledgerPoint.Ready()
+	logger.Info("LedgerPoint is ready")
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("[APME-API] Shutting down server...")
+	logger.Info("Shutting down server")
 
 	// Graceful shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("[APME-API] Server forced to shutdown: %v", err)
+		logger.Error("Server forced to shutdown", "error", err)
 	}
 
 	cancel() // Cancel main context
-	log.Println("[APME-API] Server stopped")
+	logger.Info("Server stopped")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// runDiff replays the log to two offsets and prints the entities that were
+// added, removed or changed between them, with the changed fields
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	conn := addConnectionFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatal("usage: pmectl diff FROM_OFFSET TO_OFFSET")
+	}
+	from, err1 := strconv.ParseInt(fs.Arg(0), 10, 64)
+	to, err2 := strconv.ParseInt(fs.Arg(1), 10, 64)
+	if err1 != nil || err2 != nil || from > to {
+		log.Fatal("offsets must be numbers with FROM_OFFSET <= TO_OFFSET")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	transport := conn.transport(ledger.ControlPartition)
+	defer transport.Close()
+	lp := newLedgerPoint(transport)
+
+	before := lp.CaptureSnapshot(-1)
+	err := replay(ctx, lp, transport, to, func(msg ledger.Message, err error) {
+		if msg.Offset == from {
+			before = lp.CaptureSnapshot(msg.Offset)
+		}
+	})
+	if err != nil {
+		log.Fatalf("replay failed: %v", err)
+	}
+	after := lp.CaptureSnapshot(to)
+
+	changes := 0
+	changes += diffMaps("participant", before.Participants, after.Participants)
+	changes += diffMaps("account", before.Accounts, after.Accounts)
+	changes += diffMaps("instrument", before.Instruments, after.Instruments)
+	changes += diffMaps("holiday", before.Holidays, after.Holidays)
+	changes += diffMaps("risk_rule", before.RiskRules, after.RiskRules)
+	changes += diffMaps("concentration_limit", before.ConcentrationLimits, after.ConcentrationLimits)
+	changes += diffMaps("parameter", map[string]ledger.ParameterEntity{"": before.Parameter},
+		map[string]ledger.ParameterEntity{"": after.Parameter})
+	changes += diffMaps("session_time", map[string]ledger.SessionTimeEntity{"": before.SessionTime},
+		map[string]ledger.SessionTimeEntity{"": after.SessionTime})
+	changes += diffMaps("order", before.Orders, after.Orders)
+	changes += diffMaps("trade", before.Trades, after.Trades)
+	changes += diffMaps("contract", before.Contracts, after.Contracts)
+	fmt.Printf("%d entities changed between offsets %d and %d\n", changes, from, to)
+}
+
+// diffMaps prints "+ kind key" for added, "- kind key" for removed and
+// "~ kind key" followed by the changed fields for modified entities
+func diffMaps[K comparable, V any](kind string, before map[K]V, after map[K]V) int {
+	keys := make([]K, 0, len(after))
+	for k := range after {
+		keys = append(keys, k)
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+
+	changes := 0
+	for _, k := range keys {
+		old, hadOld := before[k]
+		cur, hasCur := after[k]
+		switch {
+		case !hadOld:
+			fmt.Printf("+ %s %v\n", kind, k)
+		case !hasCur:
+			fmt.Printf("- %s %v\n", kind, k)
+		case reflect.DeepEqual(old, cur):
+			continue
+		default:
+			fmt.Printf("~ %s %v\n", kind, k)
+			printFieldChanges(old, cur)
+		}
+		changes++
+	}
+	return changes
+}
+
+// keyLess orders NIDs numerically and codes alphabetically
+func keyLess(a any, b any) bool {
+	if x, ok := a.(int); ok {
+		return x < b.(int)
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// printFieldChanges prints the JSON fields that differ between two entities
+func printFieldChanges(old any, cur any) {
+	var a, b map[string]any
+	data, _ := json.Marshal(old)
+	json.Unmarshal(data, &a)
+	data, _ = json.Marshal(cur)
+	json.Unmarshal(data, &b)
+
+	for _, field := range sortedKeys(b) {
+		if !reflect.DeepEqual(a[field], b[field]) {
+			fmt.Printf("    %s: %v -> %v\n", field, a[field], b[field])
+		}
+	}
+}
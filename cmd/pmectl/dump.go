@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// runDump prints one entity as of the end of the log or an offset. Orders
+// are found by NID or reff_request_id, trades and contracts by NID or
+// KpeiReff, accounts by code.
+func runDump(args []string) {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	conn := addConnectionFlags(fs)
+	at := fs.Int64("at", -1, "Offset to dump the entity at (-1 for the end of the log)")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatal("usage: pmectl dump [-at OFFSET] order|trade|contract|account ID")
+	}
+	kind, id := fs.Arg(0), fs.Arg(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	transport := conn.transport(ledger.ControlPartition)
+	defer transport.Close()
+	lp := newLedgerPoint(transport)
+	if err := replay(ctx, lp, transport, *at, nil); err != nil {
+		log.Fatalf("replay failed: %v", err)
+	}
+
+	entity, ok := lookup(lp, kind, id)
+	if !ok {
+		log.Fatalf("%s %s not found", kind, id)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(entity)
+}
+
+func lookup(lp *ledger.LedgerPoint, kind string, id string) (any, bool) {
+	nid, err := strconv.Atoi(id)
+	byNID := err == nil
+
+	switch kind {
+	case "order":
+		if byNID {
+			return lp.GetOrder(nid)
+		}
+		return lp.GetOrderByReffRequestID(id)
+	case "trade":
+		if byNID {
+			return lp.GetTrade(nid)
+		}
+		return lp.GetTradeByReff(id)
+	case "contract":
+		if byNID {
+			return lp.GetContract(nid)
+		}
+		return lp.GetContractByReff(id)
+	case "account":
+		return lp.GetAccount(id)
+	}
+	log.Fatalf("unknown entity %q, want order, trade, contract or account", kind)
+	return nil, false
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"pmeonline/pkg/ledger"
+)
+
+// pmectl inspects and administers the ledger topic. It decodes with
+// pkg/ledger, so events look exactly as the services see them:
+//
+//	pmectl tail -n 20 -f                    follow the log with decoded events
+//	pmectl dump order 12345                 an entity by NID or reference
+//	pmectl dump -at 5400 trade KPEI-0001    the same, as of an offset
+//	pmectl replay -from 1200 -to 5400       event counts and state summary
+//	pmectl diff 1200 5400                   entities changed between offsets
+//	pmectl publish -file events.json -yes   commit hand-crafted events
+//
+// Security (LEDGER_ENCRYPTION_KEYS, LEDGER_VERIFY_KEYS, ...) and the policy
+// are read from the environment like in the services.
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("pmectl: ")
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+	commands := map[string]func(args []string){
+		"tail":    runTail,
+		"dump":    runDump,
+		"replay":  runReplay,
+		"diff":    runDiff,
+		"publish": runPublish,
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	run(os.Args[2:])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: pmectl <command> [flags]
+
+commands:
+  tail     [-n N] [-from OFFSET] [-f] [-partition P]
+  dump     [-at OFFSET] order|trade|contract|account ID
+  replay   [-from OFFSET] [-to OFFSET]
+  diff     FROM_OFFSET TO_OFFSET
+  publish  -file FILE [-producer ID] [-yes]
+
+Run "pmectl <command> -h" for the flags of a command.`)
+	os.Exit(2)
+}
+
+// connection holds the flags shared by all commands
+type connection struct {
+	kafkaURL string
+	topic    string
+}
+
+func addConnectionFlags(fs *flag.FlagSet) *connection {
+	c := &connection{}
+	fs.StringVar(&c.kafkaURL, "kafka", getEnv("KAFKA_URL", "localhost:9092"), "Kafka broker address")
+	fs.StringVar(&c.topic, "topic", getEnv("KAFKA_TOPIC", "pme-ledger"), "Ledger topic")
+	return c
+}
+
+func (c *connection) transport(partition int) *ledger.KafkaTransport {
+	return ledger.NewKafkaPartitionTransport(c.kafkaURL, c.topic, partition)
+}
+
+// newLedgerPoint creates an unstarted LedgerPoint on transport, configured
+// with the security and policy of the environment
+func newLedgerPoint(transport ledger.Transport) *ledger.LedgerPoint {
+	lp := ledger.CreateLedgerPointWithTransport(transport, "pmectl")
+	configure(lp)
+	return lp
+}
+
+func configure(lp *ledger.LedgerPoint) {
+	security, err := ledger.SecurityFromEnv()
+	if err != nil {
+		log.Fatalf("invalid ledger security settings: %v", err)
+	}
+	lp.SetSecurity(security)
+	policy, err := ledger.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("invalid ledger policy: %v", err)
+	}
+	lp.SetPolicy(policy)
+
+	// Replays report problems themselves
+	lp.SetDeadLetterHandler(func(ledger.Message, error) {})
+	lp.SetRejectedHandler(func(ledger.Message, error) {})
+}
+
+// replay applies the log from its start to lp, which must not be started,
+// up to and including offset to (-1 for the end of the log as it is now).
+// after is called with every message once it has been applied, and the
+// error ApplyMessage returned for it.
+func replay(ctx context.Context, lp *ledger.LedgerPoint, transport ledger.Transport, to int64,
+	after func(msg ledger.Message, err error)) error {
+	end, err := transport.HighWaterMark(ctx)
+	if err != nil {
+		return err
+	}
+	if to < 0 || to >= end {
+		to = end - 1
+	}
+	if to < 0 {
+		return nil
+	}
+
+	r, err := transport.OpenReader(ledger.FirstOffset)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if msg.Offset > to {
+			return nil
+		}
+		applyErr := lp.ApplyMessage(msg)
+		if after != nil {
+			after(msg, applyErr)
+		}
+		if msg.Offset >= to {
+			return nil
+		}
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
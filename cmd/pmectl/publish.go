@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// publishedEvent is one entry of a publish file:
+//
+//	[
+//	  {"type": "Holiday", "event": {"nid": 7, "tahun": 2025, "date": "2025-12-26T00:00:00+07:00"}},
+//	  {"type": "TradeNak", "event": {"trade_nid": 1234, "message": "manual repair"}}
+//	]
+type publishedEvent struct {
+	Type  string          `json:"type"`
+	Event json.RawMessage `json:"event"`
+}
+
+// runPublish commits the events of a JSON file. Without -yes it only shows
+// what would be committed.
+func runPublish(args []string) {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	conn := addConnectionFlags(fs)
+	file := fs.String("file", "", "JSON file with the events to publish")
+	producer := fs.String("producer", "pmectl", "Producer id the events are committed as")
+	defaultPartitions, _ := strconv.Atoi(getEnv("LEDGER_PARTITIONS", "1"))
+	partitions := fs.Int("partitions", max(defaultPartitions, 1), "Number of ledger partitions")
+	yes := fs.Bool("yes", false, "Really publish")
+	fs.Parse(args)
+	if *file == "" {
+		log.Fatal("usage: pmectl publish -file FILE [-producer ID] [-yes]")
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var entries []publishedEvent
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Fatalf("invalid %s: %v", *file, err)
+	}
+	events := make([]any, len(entries))
+	for i, e := range entries {
+		if events[i], err = ledger.NewEvent(e.Type, e.Event); err != nil {
+			log.Fatalf("event %d: %v", i+1, err)
+		}
+	}
+
+	for i, e := range entries {
+		data, _ := json.Marshal(events[i])
+		fmt.Printf("%3d  %-16s %s\n", i+1, e.Type, data)
+	}
+	if !*yes {
+		fmt.Printf("%d events not published, re-run with -yes to commit them as %q to %s\n",
+			len(events), *producer, conn.topic)
+		return
+	}
+
+	lp, err := ledger.CreatePartitionedLedgerPoint(conn.kafkaURL, conn.topic, *partitions, nil, *producer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	configure(lp)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	// Start replays the log first, so events referring to orders and trades
+	// by NID are routed to the right partition
+	lp.Start(nil, ctx)
+	select {
+	case <-lp.Ready():
+	case <-ctx.Done():
+		log.Fatal("timed out replaying the ledger")
+	}
+
+	for i, event := range events {
+		offset, err := lp.CommitSync(ctx, event)
+		if err != nil {
+			log.Fatalf("event %d: %v", i+1, err)
+		}
+		fmt.Printf("%3d  committed at offset %d\n", i+1, offset)
+	}
+}
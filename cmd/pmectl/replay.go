@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// runReplay replays the log up to an offset and summarises the events in a
+// range and the resulting state
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	conn := addConnectionFlags(fs)
+	from := fs.Int64("from", 0, "First offset to count events from")
+	to := fs.Int64("to", -1, "Last offset to replay (-1 for the end of the log)")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	transport := conn.transport(ledger.ControlPartition)
+	defer transport.Close()
+	lp := newLedgerPoint(transport)
+
+	var events, rejected, undecodable int
+	first, last, at := int64(-1), int64(-1), int64(-1)
+	byType := map[string]int{}
+	err := replay(ctx, lp, transport, *to, func(msg ledger.Message, err error) {
+		at = msg.Offset
+		if msg.Offset < *from {
+			return
+		}
+		if first < 0 {
+			first = msg.Offset
+		}
+		last = msg.Offset
+		events++
+
+		env, _ := ledger.ParseEnvelope(msg.Headers)
+		byType[env.EventType]++
+		if errors.As(err, new(*ledger.RejectedError)) {
+			rejected++
+		} else if err != nil {
+			undecodable++
+		}
+	})
+	if err != nil {
+		log.Fatalf("replay failed: %v", err)
+	}
+
+	fmt.Printf("offsets %d-%d: %d events, %d rejected, %d undecodable\n", first, last, events, rejected, undecodable)
+	for _, t := range sortedKeys(byType) {
+		fmt.Printf("  %-18s %d\n", t, byType[t])
+	}
+	fmt.Printf("\nstate at offset %d\n%s", at, summary(lp))
+}
+
+// summary counts the entities of a state by kind and state
+func summary(lp *ledger.LedgerPoint) string {
+	orders := map[string]int{}
+	lp.ForEachOrder(func(o ledger.OrderEntity) bool {
+		orders[o.State]++
+		return true
+	})
+	trades := map[string]int{}
+	lp.ForEachTrade(func(t ledger.TradeEntity) bool {
+		trades[t.State]++
+		return true
+	})
+	contracts := map[string]int{}
+	lp.ForEachContract(func(c ledger.ContractEntity) bool {
+		contracts[c.State]++
+		return true
+	})
+	var participants, accounts, instruments int
+	lp.ForEachParticipant(func(ledger.ParticipantEntity) bool { participants++; return true })
+	lp.ForEachAccount(func(ledger.AccountEntity) bool { accounts++; return true })
+	lp.ForEachInstrument(func(ledger.InstrumentEntity) bool { instruments++; return true })
+
+	return fmt.Sprintf("participants %d, accounts %d, instruments %d\norders     %s\ntrades     %s\ncontracts  %s\n",
+		participants, accounts, instruments, byState(orders), byState(trades), byState(contracts))
+}
+
+// byState formats counts per state, e.g. "12 (O 3, P 1, M 8)"
+func byState(counts map[string]int) string {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	s := strconv.Itoa(total)
+	for i, state := range sortedKeys(counts) {
+		if i == 0 {
+			s += " ("
+		} else {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s %d", state, counts[state])
+	}
+	if len(counts) > 0 {
+		s += ")"
+	}
+	return s
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// runTail prints the last messages of a partition with their decoded events
+func runTail(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	conn := addConnectionFlags(fs)
+	n := fs.Int64("n", 10, "Number of messages to show before the end of the log")
+	from := fs.Int64("from", -1, "First offset to show (overrides -n)")
+	follow := fs.Bool("f", false, "Keep printing new messages")
+	partition := fs.Int("partition", ledger.ControlPartition, "Partition to read on a partitioned ledger")
+	fs.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	transport := conn.transport(*partition)
+	defer transport.Close()
+	lp := newLedgerPoint(transport)
+
+	end, err := transport.HighWaterMark(ctx)
+	if err != nil {
+		log.Fatalf("failed to read the end of the log: %v", err)
+	}
+	start := *from
+	if start < 0 {
+		start = max(end-*n, 0)
+	}
+	if start >= end && !*follow {
+		return
+	}
+
+	r, err := transport.OpenReader(start)
+	if err != nil {
+		log.Fatalf("failed to open reader: %v", err)
+	}
+	defer r.Close()
+
+	for {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatalf("failed to read: %v", err)
+		}
+		printMessage(lp, msg)
+		if !*follow && msg.Offset >= end-1 {
+			return
+		}
+	}
+}
+
+// printMessage writes one line per message: offset, log time, envelope and
+// the decoded event as JSON
+func printMessage(lp *ledger.LedgerPoint, msg ledger.Message) {
+	env, event, err := lp.DecodeMessage(msg)
+	if err != nil {
+		envelope, _ := ledger.ParseEnvelope(msg.Headers)
+		fmt.Fprintf(os.Stdout, "%8d  %s  %-16s %-10s %-20s !! %v\n", msg.Offset, msg.Time.Format(time.RFC3339),
+			envelope.EventType, envelope.Producer, envelope.EventID, err)
+		return
+	}
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(os.Stdout, "%8d  %s  %-16s %-10s %-20s %s\n", msg.Offset, msg.Time.Format(time.RFC3339),
+		env.EventType, env.Producer, env.EventID, data)
+}
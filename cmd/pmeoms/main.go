@@ -2,52 +2,151 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"pmeonline/internal/pmeoms"
+	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
+	"pmeonline/pkg/metrics"
+	"pmeonline/pkg/tracing"
 )
 
 func main() {
-	log.Println("[OMS] Starting OMS (Order Management System) Service...")
+	// Structured logs (LOG_FORMAT, LOG_LEVEL, see pkg/logging); the standard
+	// log package writes through them as well
+	logger, err := logging.New("pmeoms")
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid logging settings", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	logger.Info("Starting OMS (Order Management System) Service")
 
 	// Configuration from environment variables
 	kafkaURL := getEnv("KAFKA_URL", "localhost:9092")
 	kafkaTopic := getEnv("KAFKA_TOPIC", "pme-ledger")
+	healthPort := getEnv("HEALTH_PORT", "8082")
+
+	// Health reports degraded when more than LEDGER_MAX_LAG messages are unapplied
+	maxLag, err := strconv.ParseInt(getEnv("LEDGER_MAX_LAG", "1000"), 10, 64)
+	if err != nil || maxLag < 0 {
+		logging.Fatal(logger, "Invalid LEDGER_MAX_LAG", "value", getEnv("LEDGER_MAX_LAG", ""))
+	}
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// OpenTelemetry traces (OTEL_TRACES_EXPORTER, see pkg/tracing)
+	shutdownTracing, err := tracing.Setup(ctx, "pmeoms")
+	if err != nil {
+		logging.Fatal(logger, "Invalid tracing settings", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize LedgerPoint
-	log.Println("[OMS] Initializing LedgerPoint...")
-	ledgerPoint := ledger.CreateLedgerPoint(kafkaURL, kafkaTopic, "pmeoms")
+	logger.Info("Initializing LedgerPoint")
+	partitions, err := strconv.Atoi(getEnv("LEDGER_PARTITIONS", "1"))
+	if err != nil || partitions < 1 {
+		logging.Fatal(logger, "Invalid LEDGER_PARTITIONS", "value", getEnv("LEDGER_PARTITIONS", ""))
+	}
+	// Each OMS instance matches the instruments of its assigned partitions
+	assigned, err := ledger.ParsePartitions(getEnv("LEDGER_ASSIGNED_PARTITIONS", ""))
+	if err != nil {
+		logging.Fatal(logger, "Invalid LEDGER_ASSIGNED_PARTITIONS", "error", err)
+	}
+	ledgerPoint, err := ledger.CreatePartitionedLedgerPoint(kafkaURL, kafkaTopic, partitions, assigned, "pmeoms")
+	if err != nil {
+		logging.Fatal(logger, "Failed to create LedgerPoint", "error", err)
+	}
+	// Payload encryption and producer signatures (LEDGER_* key settings)
+	security, err := ledger.SecurityFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger security settings", "error", err)
+	}
+	ledgerPoint.SetSecurity(security)
+	// Event types each service may emit (LEDGER_POLICY_FILE, DefaultPolicy otherwise)
+	policy, err := ledger.PolicyFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ledger policy", "error", err)
+	}
+	ledgerPoint.SetPolicy(policy)
+	ledgerPoint.SetLogger(logger)
+	// Event, trade and contract IDs from this replica's INSTANCE_ID, so
+	// replicas never produce the same event-id or trade NID
+	idGenerator, err := idgen.GeneratorFromEnv()
+	if err != nil {
+		logging.Fatal(logger, "Invalid ID generator settings", "error", err)
+	}
+	ledgerPoint.SetIDGenerator(idGenerator)
+	logger.Info("Consuming ledger partitions", "assigned", ledgerPoint.AssignedPartitions(), "partitions", partitions)
 
 	// Initialize OMS
-	log.Println("[OMS] Initializing OMS...")
-	omsEngine := pmeoms.NewOMS(ledgerPoint)
+	logger.Info("Initializing OMS")
+	omsEngine := pmeoms.NewOMS(ledgerPoint, idGenerator, logger)
 
 	// Subscribe to events
-	log.Println("[OMS] Subscribing to ledger events...")
+	logger.Info("Subscribing to ledger events")
 	syncHandler := pmeoms.NewSyncHandler(omsEngine, ledgerPoint)
 
+	// Enable ledger snapshots so restarts don't replay the whole topic
+	if snapshotDir := getEnv("SNAPSHOT_DIR", ""); snapshotDir != "" {
+		snapshotInterval, err := strconv.Atoi(getEnv("SNAPSHOT_INTERVAL", "1000"))
+		if err != nil || snapshotInterval <= 0 {
+			logging.Fatal(logger, "Invalid SNAPSHOT_INTERVAL", "value", getEnv("SNAPSHOT_INTERVAL", ""))
+		}
+		store, err := ledger.NewFileSnapshotStore(snapshotDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to create snapshot store", "error", err)
+		}
+		ledgerPoint.EnableSnapshots(store, snapshotInterval)
+		logger.Info("Snapshots enabled", "dir", snapshotDir, "every", snapshotInterval)
+	}
+
+	// Replay archived days first when Kafka retention has deleted them
+	if archiveDir := getEnv("ARCHIVE_DIR", ""); archiveDir != "" {
+		archive, err := ledger.NewArchive(archiveDir)
+		if err != nil {
+			logging.Fatal(logger, "Failed to open archive", "error", err)
+		}
+		if err := ledgerPoint.EnableArchiveReplay(archive); err != nil {
+			logging.Fatal(logger, "Failed to enable archive replay", "error", err)
+		}
+		logger.Info("Archive replay enabled", "dir", archiveDir)
+	}
+
+	// Prometheus metrics, served next to the health check
+	metrics.RegisterLedger(ledgerPoint, "pmeoms")
+
 	ledgerPoint.Start([]ledger.LedgerPointInterface{syncHandler}, ctx)
 
+	// Health and metrics endpoints, served while catching up so orchestration can see progress
+	go func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /health", ledgerPoint.HealthHandler("pmeoms", maxLag))
+		mux.Handle("GET /metrics", metrics.Handler())
+		logger.Info("Health and metrics endpoints listening", "port", healthPort)
+		if err := http.ListenAndServe(":"+healthPort, mux); err != nil {
+			logging.Fatal(logger, "Health server error", "error", err)
+		}
+	}()
+
 	// Wait for LedgerPoint to be ready
-	log.Println("[OMS] Waiting for LedgerPoint to be ready...")
-	for !ledgerPoint.IsReady {
-		time.Sleep(100 * time.Millisecond)
-	}
-	log.Println("[OMS] LedgerPoint is ready")
+	logger.Info("Waiting for LedgerPoint to be ready")
+	<-ledgerPoint.Ready()
+	logger.Info("LedgerPoint is ready")
 
 	// Initialize existing orders from ledger (process saved and open orders)
 	omsEngine.InitOrders()
 
-	log.Println("[OMS] Service started and ready to process orders")
+	logger.Info("Service started and ready to process orders")
 
 	// Display statistics periodically
 	go func() {
@@ -58,7 +157,7 @@ func main() {
 			select {
 			case <-ticker.C:
 				stats := omsEngine.GetStatistics()
-				log.Printf("[OMS] Statistics: %+v", stats)
+				logger.Info("Statistics", "stats", stats)
 			case <-ctx.Done():
 				return
 			}
@@ -70,9 +169,9 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("[OMS] Shutting down service...")
+	logger.Info("Shutting down service")
 	cancel()
-	log.Println("[OMS] Service stopped")
+	logger.Info("Service stopped")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -3,16 +3,19 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
 	_ "github.com/lib/pq"
+
+	"pmeonline/pkg/logging"
 )
 
 // DB wraps database connection
 type DB struct {
 	*sql.DB
+	logger *slog.Logger
 }
 
 // Config holds database configuration
@@ -25,8 +28,8 @@ type Config struct {
 	SSLMode  string
 }
 
-// NewDB creates a new database connection
-func NewDB(config Config) (*DB, error) {
+// NewDB creates a new database connection logging to logger
+func NewDB(config Config, logger *slog.Logger) (*DB, error) {
 	connStr := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode,
@@ -47,13 +50,14 @@ func NewDB(config Config) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Println("[DB] Connected to PostgreSQL database")
+	logger = logger.With(logging.Component("db"))
+	logger.Info("Connected to PostgreSQL database", "host", config.Host, "database", config.DBName)
 
-	return &DB{db}, nil
+	return &DB{DB: db, logger: logger}, nil
 }
 
 // NewDBFromEnv creates a database connection from environment variables
-func NewDBFromEnv() (*DB, error) {
+func NewDBFromEnv(logger *slog.Logger) (*DB, error) {
 	config := Config{
 		Host:     getEnv("DB_HOST", "localhost"),
 		Port:     getEnv("DB_PORT", "5432"),
@@ -63,12 +67,12 @@ func NewDBFromEnv() (*DB, error) {
 		SSLMode:  getEnv("DB_SSLMODE", "disable"),
 	}
 
-	return NewDB(config)
+	return NewDB(config, logger)
 }
 
 // RunMigrations runs SQL migration files
 func (db *DB) RunMigrations(migrationsPath string) error {
-	log.Println("[DB] Running migrations...")
+	db.logger.Info("Running migrations", "path", migrationsPath)
 
 	// Read migration file
 	content, err := os.ReadFile(migrationsPath)
@@ -81,13 +85,13 @@ func (db *DB) RunMigrations(migrationsPath string) error {
 		return fmt.Errorf("failed to execute migration: %w", err)
 	}
 
-	log.Println("[DB] Migrations completed successfully")
+	db.logger.Info("Migrations completed")
 	return nil
 }
 
 // Close closes the database connection
 func (db *DB) Close() error {
-	log.Println("[DB] Closing database connection")
+	db.logger.Info("Closing database connection")
 	return db.DB.Close()
 }
 
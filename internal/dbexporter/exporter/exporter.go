@@ -2,10 +2,11 @@ package exporter
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 
 	"pmeonline/internal/dbexporter/repository"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 // Exporter implements LedgerPointInterface to export events to database
@@ -17,255 +18,282 @@ type Exporter struct {
 	tradeRepo       *repository.TradeRepository
 	contractRepo    *repository.ContractRepository
 	otherRepo       *repository.OtherRepository
+	clock           ledger.Clock
+	logger          *slog.Logger
 }
 
-// NewExporter creates a new exporter
-func NewExporter(db *sql.DB) *Exporter {
+// NewExporter creates a new exporter logging to logger. Rows and the event
+// log are stamped with clock, normally the LedgerPoint's.
+func NewExporter(db *sql.DB, clock ledger.Clock, logger *slog.Logger) *Exporter {
 	return &Exporter{
-		participantRepo: repository.NewParticipantRepository(db),
-		instrumentRepo:  repository.NewInstrumentRepository(db),
-		accountRepo:     repository.NewAccountRepository(db),
-		orderRepo:       repository.NewOrderRepository(db),
-		tradeRepo:       repository.NewTradeRepository(db),
-		contractRepo:    repository.NewContractRepository(db),
-		otherRepo:       repository.NewOtherRepository(db),
+		participantRepo: repository.NewParticipantRepository(db, clock),
+		instrumentRepo:  repository.NewInstrumentRepository(db, clock),
+		accountRepo:     repository.NewAccountRepository(db, clock),
+		orderRepo:       repository.NewOrderRepository(db, clock),
+		tradeRepo:       repository.NewTradeRepository(db, clock),
+		contractRepo:    repository.NewContractRepository(db, clock),
+		otherRepo:       repository.NewOtherRepository(db, clock),
+		clock:           clock,
+		logger:          logger.With(logging.Component("exporter")),
 	}
 }
 
 // SyncServiceStart handles ServiceStart events
 func (e *Exporter) SyncServiceStart(s ledger.ServiceStart) {
 	if err := e.otherRepo.InsertServiceStart(s); err != nil {
-		log.Printf("[EXPORTER] Error inserting service start: %v", err)
+		e.logger.Error("Error inserting service start", "error", err)
+		dbWriteErrors.WithLabelValues("other").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Service start recorded: %s", s.ID)
-	e.logEvent("ServiceStart", s, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Service start recorded", "id", s.ID)
+	e.logEvent("ServiceStart", s, e.clock.Now().UnixMilli())
 }
 
 // SyncParameter handles Parameter events
 func (e *Exporter) SyncParameter(p ledger.Parameter) {
 	if err := e.otherRepo.UpsertParameter(p); err != nil {
-		log.Printf("[EXPORTER] Error upserting parameter: %v", err)
+		e.logger.Error("Error upserting parameter", "error", err)
+		dbWriteErrors.WithLabelValues("other").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Parameter updated")
-	e.logEvent("Parameter", p, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Parameter updated")
+	e.logEvent("Parameter", p, e.clock.Now().UnixMilli())
 }
 
 // SyncSessionTime handles SessionTime events
 func (e *Exporter) SyncSessionTime(s ledger.SessionTime) {
 	if err := e.otherRepo.UpsertSessionTime(s); err != nil {
-		log.Printf("[EXPORTER] Error upserting session time: %v", err)
+		e.logger.Error("Error upserting session time", "error", err)
+		dbWriteErrors.WithLabelValues("other").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Session time updated")
-	e.logEvent("SessionTime", s, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Session time updated")
+	e.logEvent("SessionTime", s, e.clock.Now().UnixMilli())
 }
 
 // SyncHoliday handles Holiday events
 func (e *Exporter) SyncHoliday(h ledger.Holiday) {
 	if err := e.otherRepo.UpsertHoliday(h); err != nil {
-		log.Printf("[EXPORTER] Error upserting holiday: %v", err)
+		e.logger.Error("Error upserting holiday", "error", err)
+		dbWriteErrors.WithLabelValues("other").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Holiday upserted: %s - %s", h.Date.Format("2006-01-02"), h.Description)
-	e.logEvent("Holiday", h, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Holiday upserted", "date", h.Date.Format("2006-01-02"), "description", h.Description)
+	e.logEvent("Holiday", h, e.clock.Now().UnixMilli())
 }
 
 // SyncAccount handles Account events
 func (e *Exporter) SyncAccount(a ledger.Account) {
 	if err := e.accountRepo.Upsert(a); err != nil {
-		log.Printf("[EXPORTER] Error upserting account: %v", err)
+		e.logger.Error("Error upserting account", "error", err)
+		dbWriteErrors.WithLabelValues("account").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Account upserted: %s - %s", a.Code, a.Name)
-	e.logEvent("Account", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Account upserted", logging.Account(a.Code), "name", a.Name)
+	e.logEvent("Account", a, e.clock.Now().UnixMilli())
 }
 
 // SyncAccountLimit handles AccountLimit events
 func (e *Exporter) SyncAccountLimit(a ledger.AccountLimit) {
 	if err := e.accountRepo.UpdateLimit(a); err != nil {
-		log.Printf("[EXPORTER] Error updating account limit: %v", err)
+		e.logger.Error("Error updating account limit", "error", err)
+		dbWriteErrors.WithLabelValues("account").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Account limit updated: %s", a.Code)
-	e.logEvent("AccountLimit", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Account limit updated", logging.Account(a.Code))
+	e.logEvent("AccountLimit", a, e.clock.Now().UnixMilli())
 }
 
 // SyncParticipant handles Participant events
 func (e *Exporter) SyncParticipant(p ledger.Participant) {
 	if err := e.participantRepo.Upsert(p); err != nil {
-		log.Printf("[EXPORTER] Error upserting participant: %v", err)
+		e.logger.Error("Error upserting participant", "error", err)
+		dbWriteErrors.WithLabelValues("participant").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Participant upserted: %s - %s", p.Code, p.Name)
-	e.logEvent("Participant", p, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Participant upserted", logging.Participant(p.Code), "name", p.Name)
+	e.logEvent("Participant", p, e.clock.Now().UnixMilli())
 }
 
 // SyncInstrument handles Instrument events
 func (e *Exporter) SyncInstrument(i ledger.Instrument) {
 	if err := e.instrumentRepo.Upsert(i); err != nil {
-		log.Printf("[EXPORTER] Error upserting instrument: %v", err)
+		e.logger.Error("Error upserting instrument", "error", err)
+		dbWriteErrors.WithLabelValues("instrument").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Instrument upserted: %s - %s (Status: %v)", i.Code, i.Name, i.Status)
-	e.logEvent("Instrument", i, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Instrument upserted", logging.Instrument(i.Code), "name", i.Name, "status", i.Status)
+	e.logEvent("Instrument", i, e.clock.Now().UnixMilli())
 }
 
 // SyncOrder handles Order events
 func (e *Exporter) SyncOrder(o ledger.Order) {
 	if err := e.orderRepo.Insert(o); err != nil {
-		log.Printf("[EXPORTER] Error inserting order: %v", err)
+		e.logger.Error("Error inserting order", logging.OrderNID(o.NID), "error", err)
+		dbWriteErrors.WithLabelValues("order").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Order inserted: NID=%d, %s-%s, State=%s", o.NID, o.Side, o.InstrumentCode, o.State)
-	e.logEvent("Order", o, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Order inserted", logging.OrderNID(o.NID), logging.Participant(o.ParticipantCode),
+		logging.Account(o.AccountCode), logging.Instrument(o.InstrumentCode), "side", o.Side, "state", o.State)
+	e.logEvent("Order", o, e.clock.Now().UnixMilli())
 }
 
 // SyncOrderAck handles OrderAck events
 func (e *Exporter) SyncOrderAck(a ledger.OrderAck) {
 	if err := e.orderRepo.UpdateState(a.OrderNID, "O", 0); err != nil {
-		log.Printf("[EXPORTER] Error updating order ack: %v", err)
+		e.logger.Error("Error updating order ack", logging.OrderNID(a.OrderNID), "error", err)
+		dbWriteErrors.WithLabelValues("order").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Order acknowledged: NID=%d", a.OrderNID)
-	e.logEvent("OrderAck", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Order acknowledged", logging.OrderNID(a.OrderNID))
+	e.logEvent("OrderAck", a, e.clock.Now().UnixMilli())
 }
 
 // SyncOrderNak handles OrderNak events
 func (e *Exporter) SyncOrderNak(a ledger.OrderNak) {
 	if err := e.orderRepo.UpdateState(a.OrderNID, "R", 0); err != nil {
-		log.Printf("[EXPORTER] Error updating order nak: %v", err)
+		e.logger.Error("Error updating order nak", logging.OrderNID(a.OrderNID), "error", err)
+		dbWriteErrors.WithLabelValues("order").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Order rejected: NID=%d, Message=%s", a.OrderNID, a.Message)
-	e.logEvent("OrderNak", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Order rejected", logging.OrderNID(a.OrderNID), "message", a.Message)
+	e.logEvent("OrderNak", a, e.clock.Now().UnixMilli())
 }
 
 // SyncOrderPending handles OrderPending events
 func (e *Exporter) SyncOrderPending(w ledger.OrderPending) {
-	log.Printf("[EXPORTER] Order pending: NID=%d", w.OrderNID)
-	e.logEvent("OrderPending", w, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Order pending", logging.OrderNID(w.OrderNID))
+	e.logEvent("OrderPending", w, e.clock.Now().UnixMilli())
 }
 
 // SyncOrderWithdraw handles OrderWithdraw events
 func (e *Exporter) SyncOrderWithdraw(w ledger.OrderWithdraw) {
-	log.Printf("[EXPORTER] Order withdraw request: NID=%d", w.OrderNID)
-	e.logEvent("OrderWithdraw", w, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Order withdraw request", logging.OrderNID(w.OrderNID))
+	e.logEvent("OrderWithdraw", w, e.clock.Now().UnixMilli())
 }
 
 // SyncOrderWithdrawAck handles OrderWithdrawAck events
 func (e *Exporter) SyncOrderWithdrawAck(a ledger.OrderWithdrawAck) {
 	if err := e.orderRepo.UpdateState(a.OrderNID, "W", 0); err != nil {
-		log.Printf("[EXPORTER] Error updating order withdraw ack: %v", err)
+		e.logger.Error("Error updating order withdraw ack", logging.OrderNID(a.OrderNID), "error", err)
+		dbWriteErrors.WithLabelValues("order").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Order withdrawn: NID=%d", a.OrderNID)
-	e.logEvent("OrderWithdrawAck", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Order withdrawn", logging.OrderNID(a.OrderNID))
+	e.logEvent("OrderWithdrawAck", a, e.clock.Now().UnixMilli())
 }
 
 // SyncOrderWithdrawNak handles OrderWithdrawNak events
 func (e *Exporter) SyncOrderWithdrawNak(a ledger.OrderWithdrawNak) {
-	log.Printf("[EXPORTER] Order withdraw rejected: NID=%d, Message=%s", a.OrderNID, a.Message)
-	e.logEvent("OrderWithdrawNak", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Order withdraw rejected", logging.OrderNID(a.OrderNID), "message", a.Message)
+	e.logEvent("OrderWithdrawNak", a, e.clock.Now().UnixMilli())
 }
 
 // SyncTrade handles Trade events
 func (e *Exporter) SyncTrade(t ledger.Trade) {
 	// Insert trade
 	if err := e.tradeRepo.Insert(t); err != nil {
-		log.Printf("[EXPORTER] Error inserting trade: %v", err)
+		e.logger.Error("Error inserting trade", logging.TradeNID(t.NID), logging.KpeiReff(t.KpeiReff), "error", err)
+		dbWriteErrors.WithLabelValues("trade").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Trade inserted: NID=%d, Reff=%s, State=%s", t.NID, t.KpeiReff, t.State)
+	e.logger.Info("Trade inserted", logging.TradeNID(t.NID), logging.KpeiReff(t.KpeiReff), "state", t.State)
 
 	// Insert borrower contracts
 	for _, contract := range t.Borrower {
 		if err := e.contractRepo.Insert(contract); err != nil {
-			log.Printf("[EXPORTER] Error inserting borrower contract: %v", err)
+			e.logger.Error("Error inserting borrower contract", logging.ContractNID(contract.NID), "error", err)
+			dbWriteErrors.WithLabelValues("contract").Inc()
 		} else {
-			log.Printf("[EXPORTER] Borrower contract inserted: NID=%d, Account=%s", contract.NID, contract.AccountCode)
+			e.logger.Info("Borrower contract inserted", logging.ContractNID(contract.NID), logging.Account(contract.AccountCode))
 		}
 	}
 
 	// Insert lender contracts
 	for _, contract := range t.Lender {
 		if err := e.contractRepo.Insert(contract); err != nil {
-			log.Printf("[EXPORTER] Error inserting lender contract: %v", err)
+			e.logger.Error("Error inserting lender contract", logging.ContractNID(contract.NID), "error", err)
+			dbWriteErrors.WithLabelValues("contract").Inc()
 		} else {
-			log.Printf("[EXPORTER] Lender contract inserted: NID=%d, Account=%s", contract.NID, contract.AccountCode)
+			e.logger.Info("Lender contract inserted", logging.ContractNID(contract.NID), logging.Account(contract.AccountCode))
 		}
 	}
 
-	e.logEvent("Trade", t, ledger.GetCurrentTimeMillis())
+	e.logEvent("Trade", t, e.clock.Now().UnixMilli())
 }
 
 // SyncTradeWait handles TradeWait events
 func (e *Exporter) SyncTradeWait(w ledger.TradeWait) {
 	if err := e.tradeRepo.UpdateState(w.TradeNID, "E"); err != nil {
-		log.Printf("[EXPORTER] Error updating trade wait: %v", err)
+		e.logger.Error("Error updating trade wait", logging.TradeNID(w.TradeNID), "error", err)
+		dbWriteErrors.WithLabelValues("trade").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Trade waiting approval: NID=%d", w.TradeNID)
-	e.logEvent("TradeWait", w, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Trade waiting approval", logging.TradeNID(w.TradeNID))
+	e.logEvent("TradeWait", w, e.clock.Now().UnixMilli())
 }
 
 // SyncTradeAck handles TradeAck events
 func (e *Exporter) SyncTradeAck(a ledger.TradeAck) {
 	if err := e.tradeRepo.UpdateState(a.TradeNID, "O"); err != nil {
-		log.Printf("[EXPORTER] Error updating trade ack: %v", err)
+		e.logger.Error("Error updating trade ack", logging.TradeNID(a.TradeNID), "error", err)
+		dbWriteErrors.WithLabelValues("trade").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Trade approved: NID=%d", a.TradeNID)
-	e.logEvent("TradeAck", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Trade approved", logging.TradeNID(a.TradeNID))
+	e.logEvent("TradeAck", a, e.clock.Now().UnixMilli())
 }
 
 // SyncTradeNak handles TradeNak events
 func (e *Exporter) SyncTradeNak(a ledger.TradeNak) {
 	if err := e.tradeRepo.UpdateState(a.TradeNID, "R"); err != nil {
-		log.Printf("[EXPORTER] Error updating trade nak: %v", err)
+		e.logger.Error("Error updating trade nak", logging.TradeNID(a.TradeNID), "error", err)
+		dbWriteErrors.WithLabelValues("trade").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Trade rejected: NID=%d, Message=%s", a.TradeNID, a.Message)
-	e.logEvent("TradeNak", a, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Trade rejected", logging.TradeNID(a.TradeNID), "message", a.Message)
+	e.logEvent("TradeNak", a, e.clock.Now().UnixMilli())
 }
 
 // SyncTradeReimburse handles TradeReimburse events
 func (e *Exporter) SyncTradeReimburse(r ledger.TradeReimburse) {
 	if err := e.tradeRepo.UpdateState(r.TradeNID, "C"); err != nil {
-		log.Printf("[EXPORTER] Error updating trade reimburse: %v", err)
+		e.logger.Error("Error updating trade reimburse", logging.TradeNID(r.TradeNID), "error", err)
+		dbWriteErrors.WithLabelValues("trade").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Trade reimbursed: NID=%d", r.TradeNID)
-	e.logEvent("TradeReimburse", r, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Trade reimbursed", logging.TradeNID(r.TradeNID))
+	e.logEvent("TradeReimburse", r, e.clock.Now().UnixMilli())
 }
 
 // SyncContract handles Contract events
 func (e *Exporter) SyncContract(c ledger.Contract) {
 	if err := e.contractRepo.Insert(c); err != nil {
-		log.Printf("[EXPORTER] Error inserting contract: %v", err)
+		e.logger.Error("Error inserting contract", logging.ContractNID(c.NID), "error", err)
+		dbWriteErrors.WithLabelValues("contract").Inc()
 		return
 	}
-	log.Printf("[EXPORTER] Contract inserted: NID=%d, Side=%s, State=%s", c.NID, c.Side, c.State)
-	e.logEvent("Contract", c, ledger.GetCurrentTimeMillis())
+	e.logger.Info("Contract inserted", logging.ContractNID(c.NID), logging.KpeiReff(c.KpeiReff), "side", c.Side, "state", c.State)
+	e.logEvent("Contract", c, e.clock.Now().UnixMilli())
 }
 
 func (e *Exporter) SyncSod(s ledger.Sod) {
-	log.Printf("[EXPORTER] 🌅 Start of Day: %s", s.Date.Format("2006-01-02"))
+	e.logger.Info("Start of Day", "date", s.Date.Format("2006-01-02"))
 	// TODO: Store SOD event in database for audit trail
-	e.logEvent("SOD", s, ledger.GetCurrentTimeMillis())
+	e.logEvent("SOD", s, e.clock.Now().UnixMilli())
 }
 
 func (e *Exporter) SyncEod(eod ledger.Eod) {
-	log.Printf("[EXPORTER] 🌆 End of Day: %s", eod.Date.Format("2006-01-02"))
+	e.logger.Info("End of Day", "date", eod.Date.Format("2006-01-02"))
 	// TODO: Store EOD event in database for audit trail
 	// TODO: Generate and store daily reports
-	e.logEvent("EOD", eod, ledger.GetCurrentTimeMillis())
+	e.logEvent("EOD", eod, e.clock.Now().UnixMilli())
 }
 
 // Helper function to log events
 func (e *Exporter) logEvent(eventType string, eventData interface{}, timestamp int64) {
 	if err := e.otherRepo.LogEvent(eventType, eventData, timestamp); err != nil {
-		log.Printf("[EXPORTER] Error logging event: %v", err)
+		e.logger.Error("Error logging event", logging.EventType(eventType), "error", err)
+		dbWriteErrors.WithLabelValues("other").Inc()
 	}
 }
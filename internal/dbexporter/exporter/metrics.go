@@ -0,0 +1,15 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"pmeonline/pkg/metrics"
+)
+
+var dbWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "exporter",
+	Name:      "db_write_errors_total",
+	Help:      "Failed database writes, by repository",
+}, []string{"repository"})
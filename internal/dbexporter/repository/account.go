@@ -8,11 +8,12 @@ import (
 )
 
 type AccountRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock ledger.Clock
 }
 
-func NewAccountRepository(db *sql.DB) *AccountRepository {
-	return &AccountRepository{db: db}
+func NewAccountRepository(db *sql.DB, clock ledger.Clock) *AccountRepository {
+	return &AccountRepository{db: db, clock: clock}
 }
 
 func (r *AccountRepository) Upsert(a ledger.Account) error {
@@ -24,7 +25,7 @@ func (r *AccountRepository) Upsert(a ledger.Account) error {
 			last_update = EXCLUDED.last_update
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, a.NID, a.Code, a.SID, a.Name, a.ParticipantCode, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to upsert account: %w", err)
@@ -40,7 +41,7 @@ func (r *AccountRepository) UpdateLimit(a ledger.AccountLimit) error {
 		WHERE code = $1
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	result, err := r.db.Exec(query, a.Code, a.TradeLimit, a.PoolLimit, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to update account limit: %w", err)
@@ -8,11 +8,12 @@ import (
 )
 
 type ContractRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock ledger.Clock
 }
 
-func NewContractRepository(db *sql.DB) *ContractRepository {
-	return &ContractRepository{db: db}
+func NewContractRepository(db *sql.DB, clock ledger.Clock) *ContractRepository {
+	return &ContractRepository{db: db, clock: clock}
 }
 
 func (r *ContractRepository) Insert(c ledger.Contract) error {
@@ -30,7 +31,7 @@ func (r *ContractRepository) Insert(c ledger.Contract) error {
 			last_update = EXCLUDED.last_update
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query,
 		c.NID, c.TradeNID, c.KpeiReff, c.Side, c.AccountCode, c.AccountSID, c.AccountParticipantCode,
 		c.OrderNID, c.InstrumentCode, c.Quantity, c.Periode, c.State, c.FeeFlatVal, c.FeeValDaily,
@@ -50,7 +51,7 @@ func (r *ContractRepository) UpdateState(nid int, state string) error {
 		WHERE nid = $1
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, nid, state, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to update contract state: %w", err)
@@ -66,7 +67,7 @@ func (r *ContractRepository) UpdateFees(nid int, feeFlatVal, feeValDaily, feeVal
 		WHERE nid = $1
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, nid, feeFlatVal, feeValDaily, feeValAccumulated, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to update contract fees: %w", err)
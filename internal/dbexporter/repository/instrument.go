@@ -8,11 +8,12 @@ import (
 )
 
 type InstrumentRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock ledger.Clock
 }
 
-func NewInstrumentRepository(db *sql.DB) *InstrumentRepository {
-	return &InstrumentRepository{db: db}
+func NewInstrumentRepository(db *sql.DB, clock ledger.Clock) *InstrumentRepository {
+	return &InstrumentRepository{db: db, clock: clock}
 }
 
 func (r *InstrumentRepository) Upsert(i ledger.Instrument) error {
@@ -26,7 +27,7 @@ func (r *InstrumentRepository) Upsert(i ledger.Instrument) error {
 			last_update = EXCLUDED.last_update
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, i.NID, i.Code, i.Name, i.Type, i.Status, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to upsert instrument: %w", err)
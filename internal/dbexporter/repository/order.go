@@ -8,11 +8,12 @@ import (
 )
 
 type OrderRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock ledger.Clock
 }
 
-func NewOrderRepository(db *sql.DB) *OrderRepository {
-	return &OrderRepository{db: db}
+func NewOrderRepository(db *sql.DB, clock ledger.Clock) *OrderRepository {
+	return &OrderRepository{db: db, clock: clock}
 }
 
 func (r *OrderRepository) Insert(o ledger.Order) error {
@@ -30,7 +31,7 @@ func (r *OrderRepository) Insert(o ledger.Order) error {
 			last_update = EXCLUDED.last_update
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query,
 		o.NID, o.PrevNID, o.ReffRequestID, o.AccountCode, o.ParticipantCode, o.InstrumentCode,
 		o.Side, o.Quantity, o.SettlementDate, o.ReimbursementDate, o.Periode,
@@ -50,7 +51,7 @@ func (r *OrderRepository) UpdateState(nid int, state string, doneQuantity float6
 		WHERE nid = $1
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, nid, state, doneQuantity, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to update order state: %w", err)
@@ -9,11 +9,12 @@ import (
 )
 
 type OtherRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock ledger.Clock
 }
 
-func NewOtherRepository(db *sql.DB) *OtherRepository {
-	return &OtherRepository{db: db}
+func NewOtherRepository(db *sql.DB, clock ledger.Clock) *OtherRepository {
+	return &OtherRepository{db: db, clock: clock}
 }
 
 // Parameter operations
@@ -28,7 +29,7 @@ func (r *OtherRepository) UpsertParameter(p ledger.Parameter) error {
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, p.FlatFee, p.LendingFee, p.BorrowingFee, p.MaxQuantity, p.BorrowMaxOpenDay, p.DenominationLimit, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to upsert parameter: %w", err)
@@ -49,7 +50,7 @@ func (r *OtherRepository) UpsertSessionTime(s ledger.SessionTime) error {
 		VALUES ($1, $2, $3, $4, $5)
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, s.Session1Start, s.Session1End, s.Session2Start, s.Session2End, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to upsert session time: %w", err)
@@ -70,7 +71,7 @@ func (r *OtherRepository) UpsertHoliday(h ledger.Holiday) error {
 			last_update = EXCLUDED.last_update
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, h.NID, h.Tahun, h.Date, h.Description, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to upsert holiday: %w", err)
@@ -86,7 +87,7 @@ func (r *OtherRepository) InsertServiceStart(s ledger.ServiceStart) error {
 		VALUES ($1, $2)
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, s.ID, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to insert service start: %w", err)
@@ -8,11 +8,12 @@ import (
 )
 
 type ParticipantRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock ledger.Clock
 }
 
-func NewParticipantRepository(db *sql.DB) *ParticipantRepository {
-	return &ParticipantRepository{db: db}
+func NewParticipantRepository(db *sql.DB, clock ledger.Clock) *ParticipantRepository {
+	return &ParticipantRepository{db: db, clock: clock}
 }
 
 func (r *ParticipantRepository) Upsert(p ledger.Participant) error {
@@ -26,7 +27,7 @@ func (r *ParticipantRepository) Upsert(p ledger.Participant) error {
 			last_update = EXCLUDED.last_update
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, p.NID, p.Code, p.Name, p.BorrEligibility, p.LendEligibility, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to upsert participant: %w", err)
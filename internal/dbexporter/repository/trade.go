@@ -8,11 +8,12 @@ import (
 )
 
 type TradeRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	clock ledger.Clock
 }
 
-func NewTradeRepository(db *sql.DB) *TradeRepository {
-	return &TradeRepository{db: db}
+func NewTradeRepository(db *sql.DB, clock ledger.Clock) *TradeRepository {
+	return &TradeRepository{db: db, clock: clock}
 }
 
 func (r *TradeRepository) Insert(t ledger.Trade) error {
@@ -26,7 +27,7 @@ func (r *TradeRepository) Insert(t ledger.Trade) error {
 			last_update = EXCLUDED.last_update
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query,
 		t.NID, t.KpeiReff, t.InstrumentCode, t.Quantity, t.Periode, t.State,
 		t.FeeFlatRate, t.FeeBorrRate, t.FeeLendRate, t.MatchedAt, t.ReimburseAt, timestamp,
@@ -45,7 +46,7 @@ func (r *TradeRepository) UpdateState(nid int, state string) error {
 		WHERE nid = $1
 	`
 
-	timestamp := ledger.GetCurrentTimeMillis()
+	timestamp := r.clock.Now().UnixMilli()
 	_, err := r.db.Exec(query, nid, state, timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to update trade state: %w", err)
@@ -5,40 +5,39 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 type EClearClient struct {
-	baseURL     string
-	httpClient  *http.Client
-	ledger      *ledger.LedgerPoint
-	syncHandler *EClearSyncHandler
+	baseURL    string
+	httpClient *http.Client
+	ledger     *ledger.LedgerPoint
+	logger     *slog.Logger
 }
 
-func NewEClearClient(baseURL string, l *ledger.LedgerPoint) *EClearClient {
+// NewEClearClient creates the outbound client and subscribes it to the
+// ledger events it reacts to. It must be created before the LedgerPoint is
+// started to receive all events.
+func NewEClearClient(baseURL string, l *ledger.LedgerPoint, logger *slog.Logger) *EClearClient {
 	client := &EClearClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 		ledger: l,
+		logger: logger.With(logging.Component("eclear-client")),
 	}
 
-	// Create sync handler
-	client.syncHandler = &EClearSyncHandler{client: client}
+	ledger.Subscribe(l, client.onTrade)
 
 	return client
 }
 
-// GetSyncHandler returns the sync handler for LedgerPoint subscription
-func (c *EClearClient) GetSyncHandler() *EClearSyncHandler {
-	return c.syncHandler
-}
-
 // TradeMatchedPayload represents the payload sent to eClear for trade approval
 type TradeMatchedPayload struct {
 	PmeTradeReff   string       `json:"pme_trade_reff"`
@@ -67,44 +66,17 @@ type ContractInfo struct {
 
 // RunProcessing runs the main processing loop (waits for context cancellation)
 func (c *EClearClient) RunProcessing(ctx context.Context) {
-	log.Println("✅ eClear outbound client is now processing events")
+	c.logger.Info("eClear outbound client is now processing events")
 
 	// Wait for context cancellation
 	<-ctx.Done()
-	log.Println("🛑 eClear outbound client stopped")
-}
-
-// EClearSyncHandler implements LedgerPointInterface to receive events
-type EClearSyncHandler struct {
-	client *EClearClient
+	c.logger.Info("eClear outbound client stopped")
 }
 
-func (h *EClearSyncHandler) SyncServiceStart(a ledger.ServiceStart)         {}
-func (h *EClearSyncHandler) SyncParameter(a ledger.Parameter)               {}
-func (h *EClearSyncHandler) SyncSessionTime(a ledger.SessionTime)           {}
-func (h *EClearSyncHandler) SyncHoliday(a ledger.Holiday)                   {}
-func (h *EClearSyncHandler) SyncAccount(a ledger.Account)                   {}
-func (h *EClearSyncHandler) SyncAccountLimit(a ledger.AccountLimit)         {}
-func (h *EClearSyncHandler) SyncParticipant(a ledger.Participant)           {}
-func (h *EClearSyncHandler) SyncInstrument(a ledger.Instrument)             {}
-func (h *EClearSyncHandler) SyncOrder(a ledger.Order)                       {}
-func (h *EClearSyncHandler) SyncOrderAck(a ledger.OrderAck)                 {}
-func (h *EClearSyncHandler) SyncOrderNak(a ledger.OrderNak)                 {}
-func (h *EClearSyncHandler) SyncOrderPending(a ledger.OrderPending)         {}
-func (h *EClearSyncHandler) SyncOrderWithdraw(a ledger.OrderWithdraw)       {}
-func (h *EClearSyncHandler) SyncOrderWithdrawAck(a ledger.OrderWithdrawAck) {}
-func (h *EClearSyncHandler) SyncOrderWithdrawNak(a ledger.OrderWithdrawNak) {}
-func (h *EClearSyncHandler) SyncTradeWait(a ledger.TradeWait)               {}
-func (h *EClearSyncHandler) SyncTradeAck(a ledger.TradeAck)                 {}
-func (h *EClearSyncHandler) SyncTradeNak(a ledger.TradeNak)                 {}
-func (h *EClearSyncHandler) SyncTradeReimburse(a ledger.TradeReimburse)     {}
-func (h *EClearSyncHandler) SyncContract(a ledger.Contract)                 {}
-func (h *EClearSyncHandler) SyncSod(a ledger.Sod)                           {}
-func (h *EClearSyncHandler) SyncEod(a ledger.Eod)                           {}
-
-// SyncTrade is called when a new trade is created
-func (h *EClearSyncHandler) SyncTrade(a ledger.Trade) {
-	log.Printf("📤 New trade detected, preparing to send to eClear: %s", a.KpeiReff)
+// onTrade is called when a new trade is created
+func (c *EClearClient) onTrade(a ledger.Trade) {
+	logger := c.logger.With(logging.TradeNID(a.NID), logging.KpeiReff(a.KpeiReff))
+	logger.InfoContext(c.ledger.CurrentContext(), "New trade detected, preparing to send to eClear")
 
 	// Note: This is a simplified implementation
 	// In production, you would want to:
@@ -113,14 +85,15 @@ func (h *EClearSyncHandler) SyncTrade(a ledger.Trade) {
 	// 3. Track submission status
 
 	// For now, we'll just log that we would send it
-	log.Printf("📨 Would send trade to eClear: %s", a.KpeiReff)
+	logger.InfoContext(c.ledger.CurrentContext(), "Would send trade to eClear")
 
 	// The actual sending would be done by the EClearClient.SendTrade method
 }
 
 // SendTrade sends a trade to eClear for approval
 func (c *EClearClient) SendTrade(trade ledger.Trade) error {
-	log.Printf("📤 Sending trade to eClear: %s", trade.KpeiReff)
+	logger := c.logger.With(logging.TradeNID(trade.NID), logging.KpeiReff(trade.KpeiReff))
+	logger.Info("Sending trade to eClear")
 
 	// Find borrower and lender contracts
 	var borrowerContract ledger.Contract
@@ -206,7 +179,7 @@ func (c *EClearClient) SendTrade(trade ledger.Trade) error {
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		log.Printf("❌ Failed to send trade to eClear: %v", err)
+		logger.Error("Failed to send trade to eClear", "error", err)
 		// Commit TradeWait event (waiting for approval)
 		c.ledger.Commit <- ledger.TradeWait{TradeNID: trade.NID}
 		return fmt.Errorf("failed to send request: %v", err)
@@ -214,7 +187,7 @@ func (c *EClearClient) SendTrade(trade ledger.Trade) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("❌ eClear returned non-OK status: %d", resp.StatusCode)
+		logger.Error("eClear returned non-OK status", "status", resp.StatusCode)
 		// Commit TradeWait event
 		c.ledger.Commit <- ledger.TradeWait{TradeNID: trade.NID}
 		return fmt.Errorf("eClear returned status: %d", resp.StatusCode)
@@ -222,7 +195,7 @@ func (c *EClearClient) SendTrade(trade ledger.Trade) error {
 
 	// Commit TradeWait event (trade submitted, waiting for eClear approval)
 	c.ledger.Commit <- ledger.TradeWait{TradeNID: trade.NID}
-	log.Printf("✅ Trade sent to eClear successfully: %s", trade.KpeiReff)
+	logger.Info("Trade sent to eClear successfully")
 
 	return nil
 }
@@ -230,14 +203,15 @@ func (c *EClearClient) SendTrade(trade ledger.Trade) error {
 // CheckPendingTrades checks for trades in Wait state that haven't been approved by EOD
 // This should be called by a scheduler at EOD
 func (c *EClearClient) CheckPendingTrades() {
-	log.Println("🔍 Checking for pending trades at EOD...")
+	c.logger.Info("Checking for pending trades at EOD...")
 
 	c.ledger.ForEachTrade(func(trade ledger.TradeEntity) bool {
 		// Check if trade is in Wait state (E = Approval/Wait)
 		if trade.State == "E" {
 			// Check if matched today (simplified - should check against session time)
 			if time.Since(trade.MatchedAt) > 24*time.Hour {
-				log.Printf("⚠️  Trade %s not approved by EOD, dropping trade", trade.KpeiReff)
+				c.logger.Warn("Trade not approved by EOD, dropping trade", logging.TradeNID(trade.NID),
+					logging.KpeiReff(trade.KpeiReff))
 
 				// Commit TradeNak to drop the trade
 				c.ledger.Commit <- ledger.TradeNak{
@@ -249,5 +223,5 @@ func (c *EClearClient) CheckPendingTrades() {
 		return true // Continue iteration
 	})
 
-	log.Println("✅ Pending trades check completed")
+	c.logger.Info("Pending trades check completed")
 }
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 type MasterDataHandler struct {
@@ -50,9 +50,11 @@ type AccountLimitRequest struct {
 
 // InsertAccounts handles POST /account/insert
 func (h *MasterDataHandler) InsertAccounts(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -60,18 +62,18 @@ func (h *MasterDataHandler) InsertAccounts(w http.ResponseWriter, r *http.Reques
 
 	var accounts []AccountRequest
 	if err := json.Unmarshal(body, &accounts); err != nil {
-		log.Printf("❌ Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Received %d accounts from eClear", len(accounts))
+	logger.Info("Received accounts from eClear", "count", len(accounts))
 
 	// Generate unique NIDs and commit to Kafka
 	for i, acc := range accounts {
 		// Validate required fields
 		if acc.Code == "" || acc.SID == "" || acc.Participant == "" {
-			log.Printf("⚠️  Skipping account with missing required fields: %+v", acc)
+			logger.Warn("Skipping account with missing required fields", "account", acc)
 			continue
 		}
 
@@ -81,24 +83,24 @@ func (h *MasterDataHandler) InsertAccounts(w http.ResponseWriter, r *http.Reques
 			if existingAcc.SID == acc.SID &&
 				existingAcc.ParticipantCode == acc.Participant &&
 				existingAcc.Name == acc.Name {
-				log.Printf("✅ Identical Account %s already exists, skipping", existingAcc.Code)
+				logger.Info("Identical Account already exists, skipping", logging.Account(existingAcc.Code))
 				continue
 			}
 			// Data is different - log warning and skip (or could update)
-			log.Printf("⚠️  Account %s exists but with different data, skipping update", acc.Code)
+			logger.Warn("Account exists but with different data, skipping update", logging.Account(acc.Code))
 			continue
 		}
 
 		// Check if participant exists
 		if _, exists := h.ledger.GetParticipant(acc.Participant); !exists {
-			log.Printf("⚠️  Participant %s not found for account %s", acc.Participant, acc.Code)
+			logger.Warn("Participant not found for account", logging.Participant(acc.Participant), logging.Account(acc.Code))
 			continue
 		}
 
 		participantEntity, _ := h.ledger.GetParticipant(acc.Participant)
 
 		account := ledger.Account{
-			NID:             generateNID("account", i),
+			NID:             h.generateNID("account", i),
 			Code:            acc.Code,
 			SID:             acc.SID,
 			Name:            acc.Name,
@@ -106,9 +108,13 @@ func (h *MasterDataHandler) InsertAccounts(w http.ResponseWriter, r *http.Reques
 			ParticipantCode: participantEntity.Code,
 		}
 
-		// Commit to Kafka
-		h.ledger.Commit <- account
-		log.Printf("✅ Account committed: %s (SID: %s)", acc.Code, acc.SID)
+		// Commit to Kafka and wait for the broker acknowledgment
+		if _, err := h.ledger.CommitSync(r.Context(), account); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to commit account", logging.Account(acc.Code), "error", err)
+			http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		logger.InfoContext(r.Context(), "Account committed", logging.Account(acc.Code), "sid", acc.SID)
 	}
 
 	// Return success response
@@ -122,9 +128,11 @@ func (h *MasterDataHandler) InsertAccounts(w http.ResponseWriter, r *http.Reques
 
 // InsertInstruments handles POST /instrument/insert
 func (h *MasterDataHandler) InsertInstruments(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -132,17 +140,17 @@ func (h *MasterDataHandler) InsertInstruments(w http.ResponseWriter, r *http.Req
 
 	var instruments []InstrumentRequest
 	if err := json.Unmarshal(body, &instruments); err != nil {
-		log.Printf("❌ Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Received %d instruments from eClear", len(instruments))
+	logger.Info("Received instruments from eClear", "count", len(instruments))
 
 	for i, inst := range instruments {
 		// Validate required fields
 		if inst.Code == "" || inst.Name == "" {
-			log.Printf("⚠️  Skipping instrument with missing required fields: %+v", inst)
+			logger.Warn("Skipping instrument with missing required fields", "instrument", inst)
 			continue
 		}
 
@@ -152,32 +160,36 @@ func (h *MasterDataHandler) InsertInstruments(w http.ResponseWriter, r *http.Req
 			if existingInst.Name == inst.Name &&
 				existingInst.Type == "STOCK" && // Default type is STOCK
 				existingInst.Status == inst.Status {
-				log.Printf("✅ Identical Instrument %s already exists, skipping", inst.Code)
+				logger.Info("Identical Instrument already exists, skipping", logging.Instrument(inst.Code))
 				continue
 			}
 			// Data is different - log warning and skip (or could update)
-			log.Printf("⚠️  Instrument %s exists but with different data, skipping update", inst.Code)
+			logger.Warn("Instrument exists but with different data, skipping update", logging.Instrument(inst.Code))
 			continue
 		}
 
 		instrument := ledger.Instrument{
-			NID:    generateNID("instrument", i),
+			NID:    h.generateNID("instrument", i),
 			Code:   inst.Code,
 			Name:   inst.Name,
 			Type:   "STOCK", // Default type
 			Status: inst.Status,
 		}
 
-		// Commit to Kafka
-		h.ledger.Commit <- instrument
+		// Commit to Kafka and wait for the broker acknowledgment
+		if _, err := h.ledger.CommitSync(r.Context(), instrument); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to commit instrument", logging.Instrument(inst.Code), "error", err)
+			http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+			return
+		}
 
 		// Check eligibility status change
 		if !inst.Status {
-			log.Printf("⚠️  Instrument %s is now INELIGIBLE - matching will be blocked", inst.Code)
+			logger.WarnContext(r.Context(), "Instrument is now INELIGIBLE - matching will be blocked", logging.Instrument(inst.Code))
 			// The OMS will handle blocking matching for this instrument
 		}
 
-		log.Printf("✅ Instrument committed: %s (%s) - Status: %v", inst.Code, inst.Name, inst.Status)
+		logger.InfoContext(r.Context(), "Instrument committed", logging.Instrument(inst.Code), "name", inst.Name, "status", inst.Status)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -190,9 +202,11 @@ func (h *MasterDataHandler) InsertInstruments(w http.ResponseWriter, r *http.Req
 
 // InsertParticipants handles POST /participant/insert
 func (h *MasterDataHandler) InsertParticipants(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -200,17 +214,17 @@ func (h *MasterDataHandler) InsertParticipants(w http.ResponseWriter, r *http.Re
 
 	var participants []ParticipantRequest
 	if err := json.Unmarshal(body, &participants); err != nil {
-		log.Printf("❌ Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Received %d participants from eClear", len(participants))
+	logger.Info("Received participants from eClear", "count", len(participants))
 
 	for i, part := range participants {
 		// Validate required fields
 		if part.Code == "" || part.Name == "" {
-			log.Printf("⚠️  Skipping participant with missing required fields: %+v", part)
+			logger.Warn("Skipping participant with missing required fields", "participant", part)
 			continue
 		}
 
@@ -220,26 +234,30 @@ func (h *MasterDataHandler) InsertParticipants(w http.ResponseWriter, r *http.Re
 			if existingPart.Name == part.Name &&
 				existingPart.BorrEligibility == part.BorrEligibility &&
 				existingPart.LendEligibility == part.LendEligibility {
-				log.Printf("✅ Identical Participant %s already exists, skipping", part.Code)
+				logger.Info("Identical Participant already exists, skipping", logging.Participant(part.Code))
 				continue
 			}
 			// Data is different - log warning and skip (or could update)
-			log.Printf("⚠️  Participant %s exists but with different data, skipping update", part.Code)
+			logger.Warn("Participant exists but with different data, skipping update", logging.Participant(part.Code))
 			continue
 		}
 
 		participant := ledger.Participant{
-			NID:             generateNID("participant", i),
+			NID:             h.generateNID("participant", i),
 			Code:            part.Code,
 			Name:            part.Name,
 			BorrEligibility: part.BorrEligibility,
 			LendEligibility: part.LendEligibility,
 		}
 
-		// Commit to Kafka
-		h.ledger.Commit <- participant
-		log.Printf("✅ Participant committed: %s (%s) - Borr: %v, Lend: %v",
-			part.Code, part.Name, part.BorrEligibility, part.LendEligibility)
+		// Commit to Kafka and wait for the broker acknowledgment
+		if _, err := h.ledger.CommitSync(r.Context(), participant); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to commit participant", logging.Participant(part.Code), "error", err)
+			http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		logger.InfoContext(r.Context(), "Participant committed", logging.Participant(part.Code), "name", part.Name,
+			"borr_eligibility", part.BorrEligibility, "lend_eligibility", part.LendEligibility)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -252,9 +270,11 @@ func (h *MasterDataHandler) InsertParticipants(w http.ResponseWriter, r *http.Re
 
 // UpdateAccountLimit handles POST /account/limit
 func (h *MasterDataHandler) UpdateAccountLimit(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -262,23 +282,23 @@ func (h *MasterDataHandler) UpdateAccountLimit(w http.ResponseWriter, r *http.Re
 
 	var limits []AccountLimitRequest
 	if err := json.Unmarshal(body, &limits); err != nil {
-		log.Printf("❌ Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Received %d account limits from eClear", len(limits))
+	logger.Info("Received account limits from eClear", "count", len(limits))
 
 	for _, limit := range limits {
 		// Validate required fields
 		if limit.Code == "" {
-			log.Printf("⚠️  Skipping limit with missing account code: %+v", limit)
+			logger.Warn("Skipping limit with missing account code", "limit", limit)
 			continue
 		}
 
 		// Check if account exists
 		if _, exists := h.ledger.GetAccount(limit.Code); !exists {
-			log.Printf("⚠️  Account %s not found for limit update", limit.Code)
+			logger.Warn("Account not found for limit update", logging.Account(limit.Code))
 			continue
 		}
 
@@ -292,10 +312,14 @@ func (h *MasterDataHandler) UpdateAccountLimit(w http.ResponseWriter, r *http.Re
 			PoolLimit:  limit.PoolLimit,
 		}
 
-		// Commit to Kafka
-		h.ledger.Commit <- accountLimit
-		log.Printf("✅ Account limit updated: %s - TradeLimit: %.2f, PoolLimit: %.2f",
-			limit.Code, limit.BorrLimit, limit.PoolLimit)
+		// Commit to Kafka and wait for the broker acknowledgment
+		if _, err := h.ledger.CommitSync(r.Context(), accountLimit); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to commit account limit", logging.Account(limit.Code), "error", err)
+			http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		logger.InfoContext(r.Context(), "Account limit updated", logging.Account(limit.Code),
+			"trade_limit", limit.BorrLimit, "pool_limit", limit.PoolLimit)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -308,8 +332,8 @@ func (h *MasterDataHandler) UpdateAccountLimit(w http.ResponseWriter, r *http.Re
 
 // generateNID generates a unique NID based on entity type and index
 // In production, this should use a more robust ID generation strategy
-func generateNID(entityType string, index int) int {
+func (h *MasterDataHandler) generateNID(entityType string, index int) int {
 	// Simple strategy: use timestamp + index
 	// For production, consider using a distributed ID generator
-	return int(ledger.GetCurrentTimeMillis()) + index
+	return int(h.ledger.Clock().Now().UnixMilli()) + index
 }
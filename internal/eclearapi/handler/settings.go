@@ -6,14 +6,17 @@ import (
 	"time"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/ledger/risk"
 )
 
 type SettingsHandler struct {
-	ledger *ledger.LedgerPoint
+	ledger    *ledger.LedgerPoint
+	clock     ledger.Clock
+	validator *risk.Validator // Knows the pre-trade rules and their defaults
 }
 
 func NewSettingsHandler(l *ledger.LedgerPoint) *SettingsHandler {
-	return &SettingsHandler{ledger: l}
+	return &SettingsHandler{ledger: l, clock: l.Clock(), validator: risk.NewValidator(l)}
 }
 
 // GetParameter handles GET /parameter
@@ -75,8 +78,8 @@ func (h *SettingsHandler) UpdateParameter(w http.ResponseWriter, r *http.Request
 
 	// Create parameter entry
 	param := ledger.Parameter{
-		NID:               int(ledger.GetCurrentTimeMillis()),
-		Update:            time.Now(),
+		NID:               int(h.clock.Now().UnixMilli()),
+		Update:            h.clock.Now(),
 		Description:       req.Description,
 		FlatFee:           req.FlatFee,
 		LendingFee:        req.LendingFee,
@@ -86,10 +89,15 @@ func (h *SettingsHandler) UpdateParameter(w http.ResponseWriter, r *http.Request
 		DenominationLimit: req.DenominationLimit,
 	}
 
-	// Commit to ledger
-	h.ledger.Commit <- param
+	// Commit to ledger and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), param)
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable", err)
+		return
+	}
 
 	respondSuccess(w, "Parameter updated successfully", map[string]interface{}{
+		"offset":    offset,
 		"parameter": param,
 	})
 }
@@ -135,16 +143,21 @@ func (h *SettingsHandler) AddHoliday(w http.ResponseWriter, r *http.Request) {
 
 	// Create holiday entry
 	holiday := ledger.Holiday{
-		NID:         int(ledger.GetCurrentTimeMillis()),
+		NID:         int(h.clock.Now().UnixMilli()),
 		Tahun:       date.Year(),
 		Date:        date,
 		Description: req.Description,
 	}
 
-	// Commit to ledger
-	h.ledger.Commit <- holiday
+	// Commit to ledger and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), holiday)
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable", err)
+		return
+	}
 
 	respondSuccess(w, "Holiday added successfully", map[string]interface{}{
+		"offset": offset,
 		"holiday": map[string]interface{}{
 			"nid":         holiday.NID,
 			"tahun":       holiday.Tahun,
@@ -226,19 +239,24 @@ func (h *SettingsHandler) UpdateSessionTime(w http.ResponseWriter, r *http.Reque
 
 	// Create session time entry
 	sessionTime := ledger.SessionTime{
-		NID:           int(ledger.GetCurrentTimeMillis()),
+		NID:           int(h.clock.Now().UnixMilli()),
 		Description:   req.Description,
-		Update:        time.Now(),
+		Update:        h.clock.Now(),
 		Session1Start: session1Start,
 		Session1End:   session1End,
 		Session2Start: session2Start,
 		Session2End:   session2End,
 	}
 
-	// Commit to ledger
-	h.ledger.Commit <- sessionTime
+	// Commit to ledger and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), sessionTime)
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable", err)
+		return
+	}
 
 	respondSuccess(w, "Session time updated successfully", map[string]interface{}{
+		"offset": offset,
 		"sessiontime": map[string]interface{}{
 			"nid":             sessionTime.NID,
 			"description":     sessionTime.Description,
@@ -250,3 +268,164 @@ func (h *SettingsHandler) UpdateSessionTime(w http.ResponseWriter, r *http.Reque
 		},
 	})
 }
+
+// GetRiskRules handles GET /risk/rules. Rules are listed in the order the
+// OMS runs them, with the configuration in effect.
+func (h *SettingsHandler) GetRiskRules(w http.ResponseWriter, r *http.Request) {
+	rules := h.validator.Rules()
+
+	respondSuccess(w, "Risk rules retrieved", map[string]interface{}{
+		"count": len(rules),
+		"rules": rules,
+	})
+}
+
+// UpdateRiskRule handles POST /risk/rule/update. Fields left out keep their
+// current value; params replace the parameters as a whole.
+func (h *SettingsHandler) UpdateRiskRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string            `json:"name"`
+		Description *string           `json:"description"`
+		Enabled     *bool             `json:"enabled"`
+		Priority    *int              `json:"priority"`
+		Params      map[string]string `json:"params"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	// Start from the current configuration of the rule
+	var current *risk.RuleConfig
+	for _, rule := range h.validator.Rules() {
+		if rule.Name == req.Name {
+			current = &rule
+			break
+		}
+	}
+	if current == nil {
+		respondError(w, http.StatusBadRequest, "Unknown risk rule: "+req.Name, nil)
+		return
+	}
+
+	rule := ledger.RiskRule{
+		Timestamp:   h.clock.Now(),
+		Name:        current.Name,
+		Description: current.Description,
+		Enabled:     current.Enabled,
+		Priority:    current.Priority,
+		Params:      current.Params,
+	}
+	if req.Description != nil {
+		rule.Description = *req.Description
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.Params != nil {
+		rule.Params = req.Params
+	}
+
+	// Commit to ledger and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), rule)
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable", err)
+		return
+	}
+
+	respondSuccess(w, "Risk rule updated successfully", map[string]interface{}{
+		"offset": offset,
+		"rule":   rule,
+	})
+}
+
+// GetConcentrationLimits handles GET /risk/concentration/list. Each limit
+// comes with the borrowing its scope has outstanding.
+func (h *SettingsHandler) GetConcentrationLimits(w http.ResponseWriter, r *http.Request) {
+	limits := make([]map[string]interface{}, 0)
+
+	h.ledger.ForEachConcentrationLimit(func(l ledger.ConcentrationLimitEntity) bool {
+		var outstanding *ledger.BorrowOutstanding
+		if o, err := h.ledger.GetBorrowOutstanding(l.Scope()); err == nil {
+			outstanding = &o
+		}
+		limits = append(limits, map[string]interface{}{
+			"participant_code": l.ParticipantCode,
+			"instrument_code":  l.InstrumentCode,
+			"sid":              l.SID,
+			"max_quantity":     l.MaxQuantity,
+			"max_value":        l.MaxValue,
+			"description":      l.Description,
+			"outstanding":      outstanding,
+			"update":           l.LastUpdate.Format("2006-01-02 15:04:05"),
+		})
+		return true
+	})
+
+	respondSuccess(w, "Concentration limits retrieved", map[string]interface{}{
+		"count":  len(limits),
+		"limits": limits,
+	})
+}
+
+// UpdateConcentrationLimit handles POST /risk/concentration/update. Blank
+// codes match any participant, instrument or SID; zero maxima remove the
+// limit.
+func (h *SettingsHandler) UpdateConcentrationLimit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ParticipantCode string  `json:"participant_code"`
+		InstrumentCode  string  `json:"instrument_code"`
+		SID             string  `json:"sid"`
+		MaxQuantity     float64 `json:"max_quantity"`
+		MaxValue        float64 `json:"max_value"`
+		Description     string  `json:"description"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	// Validate inputs
+	if req.ParticipantCode == "" && req.InstrumentCode == "" && req.SID == "" {
+		respondError(w, http.StatusBadRequest, "One of participant_code, instrument_code or sid is required", nil)
+		return
+	}
+
+	if req.MaxQuantity < 0 || req.MaxValue < 0 {
+		respondError(w, http.StatusBadRequest, "Maxima cannot be negative", nil)
+		return
+	}
+
+	// Each OMS only sees the instruments of its partitions
+	if req.InstrumentCode == "" && h.ledger.Partitioned() {
+		respondError(w, http.StatusBadRequest, "instrument_code is required on a partitioned ledger", nil)
+		return
+	}
+
+	limit := ledger.ConcentrationLimit{
+		Timestamp:       h.clock.Now(),
+		ParticipantCode: req.ParticipantCode,
+		InstrumentCode:  req.InstrumentCode,
+		SID:             req.SID,
+		MaxQuantity:     req.MaxQuantity,
+		MaxValue:        req.MaxValue,
+		Description:     req.Description,
+	}
+
+	// Commit to ledger and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), limit)
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable", err)
+		return
+	}
+
+	respondSuccess(w, "Concentration limit updated successfully", map[string]interface{}{
+		"offset": offset,
+		"limit":  limit,
+	})
+}
@@ -3,19 +3,22 @@ package handler
 import (
 	"encoding/json"
 	"io"
-	"log"
 	"net/http"
 	"time"
 
+	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 type TradeHandler struct {
 	ledger *ledger.LedgerPoint
+	clock  ledger.Clock
+	idgen  *idgen.Generator // NIDs of ARO and recall orders
 }
 
-func NewTradeHandler(l *ledger.LedgerPoint) *TradeHandler {
-	return &TradeHandler{ledger: l}
+func NewTradeHandler(l *ledger.LedgerPoint, idGenerator *idgen.Generator) *TradeHandler {
+	return &TradeHandler{ledger: l, clock: l.Clock(), idgen: idGenerator}
 }
 
 // MatchedConfirmRequest represents trade confirmation from eClear
@@ -45,9 +48,11 @@ type LenderRecallRequest struct {
 // MatchedConfirm handles POST /contract/matched
 // This is called by eClear to confirm a trade has been approved
 func (h *TradeHandler) MatchedConfirm(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -55,58 +60,69 @@ func (h *TradeHandler) MatchedConfirm(w http.ResponseWriter, r *http.Request) {
 
 	var confirm MatchedConfirmRequest
 	if err := json.Unmarshal(body, &confirm); err != nil {
-		log.Printf("❌ Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Received trade confirmation from eClear: %s", confirm.PmeTradeReff)
+	logger = logger.With(logging.KpeiReff(confirm.PmeTradeReff))
+	logger.Info("Received trade confirmation from eClear")
 
 	// Find the trade by KpeiReff (which is the PmeTradeReff)
-	var tradeNID int
-	var found bool
-	h.ledger.ForEachTrade(func(trade ledger.TradeEntity) bool {
-		if trade.KpeiReff == confirm.PmeTradeReff {
-			tradeNID = trade.NID
-			found = true
-			return false // Stop iteration
-		}
-		return true // Continue iteration
-	})
-
+	trade, found := h.ledger.GetTradeByReff(confirm.PmeTradeReff)
 	if !found {
-		log.Printf("❌ Trade not found: %s", confirm.PmeTradeReff)
+		logger.Warn("Trade not found")
 		http.Error(w, "Trade not found", http.StatusNotFound)
 		return
 	}
 
+	// A retried confirmation succeeds without committing again
+	if trade.State == "O" {
+		logger.Info("Trade already confirmed", logging.TradeNID(trade.NID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Trade already confirmed",
+		})
+		return
+	}
+
 	// Check if state is OK
 	if confirm.State != "OK" {
-		log.Printf("⚠️  Trade confirmation state is not OK: %s", confirm.State)
+		logger.Warn("Trade confirmation state is not OK", "state", confirm.State)
 		// You might want to handle different states here
 	}
 
 	// Commit TradeAck event to update trade state to Open
 	tradeAck := ledger.TradeAck{
-		TradeNID: tradeNID,
+		TradeNID: trade.NID,
+	}
+	offset, err := h.ledger.CommitSync(r.Context(), tradeAck)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to commit trade approval", logging.TradeNID(trade.NID), "error", err)
+		http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+		return
 	}
-	h.ledger.Commit <- tradeAck
-	log.Printf("✅ Trade approved and opened: %s (NID: %d)", confirm.PmeTradeReff, tradeNID)
+	logger.InfoContext(r.Context(), "Trade approved and opened", logging.TradeNID(trade.NID), logging.Offset(offset))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  "success",
 		"message": "Trade confirmed",
+		"offset":  offset,
 	})
 }
 
 // Reimburse handles POST /contract/reimburse
 // This is called by eClear to instruct reimbursement of a trade
 func (h *TradeHandler) Reimburse(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -114,47 +130,69 @@ func (h *TradeHandler) Reimburse(w http.ResponseWriter, r *http.Request) {
 
 	var reimburse ReimburseRequest
 	if err := json.Unmarshal(body, &reimburse); err != nil {
-		log.Printf("❌ Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Received reimbursement instruction from eClear: %s (State: %s)",
-		reimburse.PmeTradeReff, reimburse.State)
+	logger = logger.With(logging.KpeiReff(reimburse.PmeTradeReff))
+	logger.Info("Received reimbursement instruction from eClear", "state", reimburse.State)
 
 	// Find the trade by KpeiReff
-	var tradeNID int
-	var trade ledger.TradeEntity
-	var found bool
-	h.ledger.ForEachTrade(func(t ledger.TradeEntity) bool {
-		if t.KpeiReff == reimburse.PmeTradeReff {
-			tradeNID = t.NID
-			trade = t
-			found = true
-			return false // Stop iteration
-		}
-		return true // Continue iteration
-	})
-
+	trade, found := h.ledger.GetTradeByReff(reimburse.PmeTradeReff)
 	if !found {
-		log.Printf("❌ Trade not found: %s", reimburse.PmeTradeReff)
+		logger.Warn("Trade not found")
 		http.Error(w, "Trade not found", http.StatusNotFound)
 		return
 	}
 
+	logger = logger.With(logging.TradeNID(trade.NID))
+
+	// A retried instruction must not roll the contracts over twice
+	if trade.State == "C" {
+		logger.Info("Trade already reimbursed")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Trade already reimbursed",
+		})
+		return
+	}
+
 	// Handle ARO (Auto Roll-Over)
 	if reimburse.State == "ARO" {
-		log.Printf("🔄 Processing ARO for trade: %s", reimburse.PmeTradeReff)
+		logger.Info("Processing ARO for trade")
 
-		// Create new order for borrower with ARO flag
+		// Create new order for borrower with ARO flag. Each borrower contract
+		// gets its own reference, so the orders of a trade with several
+		// borrowers aren't taken for retries of each other.
 		for _, contractNID := range trade.Borrower {
 			if contract, exists := h.ledger.GetContract(contractNID); exists {
+				reffRequestID := ledger.AROReffRequestID(reimburse.PmeTradeReff, contract.NID)
+				// Rolled over by an earlier attempt of this instruction
+				if aro, exists := h.ledger.GetOrderByReffRequestID(reffRequestID); exists {
+					if aro.AccountCode != contract.AccountCode || aro.Side != "BORR" || !aro.ARO {
+						logger.Error("ARO reference used by another order", logging.Account(contract.AccountCode),
+							logging.OrderNID(aro.NID), "reff_request_id", reffRequestID)
+						http.Error(w, "ARO reference already used by another order", http.StatusConflict)
+						return
+					}
+					logger.Info("ARO order already created", logging.Account(contract.AccountCode), logging.OrderNID(aro.NID))
+					continue
+				}
 				// Get the original order details
 				if origOrder, exists := h.ledger.GetOrder(contract.OrderNID); exists {
+					nid, err := h.idgen.NextID()
+					if err != nil {
+						logger.Error("Failed to generate ARO order NID", "error", err)
+						http.Error(w, "Failed to generate order ID", http.StatusInternalServerError)
+						return
+					}
 					newOrder := ledger.Order{
-						NID:               int(ledger.GetCurrentTimeMillis()),
+						NID:               int(nid),
 						PrevNID:           0, // ARO order has no previous order
-						ReffRequestID:     reimburse.PmeTradeReff + "-ARO",
+						ReffRequestID:     reffRequestID,
 						AccountNID:        contract.AccountNID,
 						AccountCode:       contract.AccountCode,
 						ParticipantNID:    contract.AccountParticipantNID,
@@ -163,7 +201,7 @@ func (h *TradeHandler) Reimburse(w http.ResponseWriter, r *http.Request) {
 						InstrumentCode:    contract.InstrumentCode,
 						Side:              "BORR",
 						Quantity:          contract.Quantity,
-						SettlementDate:    time.Now().AddDate(0, 0, 1), // T+1
+						SettlementDate:    h.clock.Now().AddDate(0, 0, 1), // T+1
 						ReimbursementDate: origOrder.ReimbursementDate.AddDate(0, 0, trade.Periode),
 						Periode:           trade.Periode,
 						State:             "S",
@@ -174,8 +212,12 @@ func (h *TradeHandler) Reimburse(w http.ResponseWriter, r *http.Request) {
 					}
 
 					// Commit new order
-					h.ledger.Commit <- newOrder
-					log.Printf("✅ Created ARO order for account %s", contract.AccountCode)
+					if _, err := h.ledger.CommitSync(r.Context(), newOrder); err != nil {
+						logger.ErrorContext(r.Context(), "Failed to commit ARO order", logging.Account(contract.AccountCode), "error", err)
+						http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+						return
+					}
+					logger.InfoContext(r.Context(), "Created ARO order", logging.Account(contract.AccountCode), logging.OrderNID(newOrder.NID))
 				}
 			}
 		}
@@ -183,10 +225,15 @@ func (h *TradeHandler) Reimburse(w http.ResponseWriter, r *http.Request) {
 
 	// Commit TradeReimburse event to close the trade
 	tradeReimburse := ledger.TradeReimburse{
-		TradeNID: tradeNID,
+		TradeNID: trade.NID,
+	}
+	offset, err := h.ledger.CommitSync(r.Context(), tradeReimburse)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to commit reimbursement", "error", err)
+		http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+		return
 	}
-	h.ledger.Commit <- tradeReimburse
-	log.Printf("✅ Trade reimbursed: %s (NID: %d)", reimburse.PmeTradeReff, tradeNID)
+	logger.InfoContext(r.Context(), "Trade reimbursed", logging.Offset(offset))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -194,15 +241,18 @@ func (h *TradeHandler) Reimburse(w http.ResponseWriter, r *http.Request) {
 		"status":  "success",
 		"message": "Trade reimbursed",
 		"aro":     reimburse.State == "ARO",
+		"offset":  offset,
 	})
 }
 
 // LenderRecall handles POST /lender/recall
 // This is called by eClear to instruct a lender recall (find new lender)
 func (h *TradeHandler) LenderRecall(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -210,34 +260,25 @@ func (h *TradeHandler) LenderRecall(w http.ResponseWriter, r *http.Request) {
 
 	var recall LenderRecallRequest
 	if err := json.Unmarshal(body, &recall); err != nil {
-		log.Printf("❌ Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("📥 Received lender recall instruction from eClear: %s", recall.ContractReff)
+	logger = logger.With("contract_reff", recall.ContractReff)
+	logger.Info("Received lender recall instruction from eClear")
 
 	// Find the contract
-	var contract ledger.ContractEntity
-	var found bool
-	h.ledger.ForEachContract(func(c ledger.ContractEntity) bool {
-		if c.KpeiReff == recall.ContractReff {
-			contract = c
-			found = true
-			return false // Stop iteration
-		}
-		return true // Continue iteration
-	})
-
+	contract, found := h.ledger.GetContractByReff(recall.ContractReff)
 	if !found {
-		log.Printf("❌ Contract not found: %s", recall.ContractReff)
+		logger.Warn("Contract not found")
 		http.Error(w, "Contract not found", http.StatusNotFound)
 		return
 	}
 
 	// Contract must be a lending contract
 	if contract.Side != "LEND" {
-		log.Printf("❌ Contract is not a lending contract: %s", recall.ContractReff)
+		logger.Warn("Contract is not a lending contract", logging.ContractNID(contract.NID))
 		http.Error(w, "Contract is not a lending contract", http.StatusBadRequest)
 		return
 	}
@@ -245,19 +286,38 @@ func (h *TradeHandler) LenderRecall(w http.ResponseWriter, r *http.Request) {
 	// Get the trade
 	trade, exists := h.ledger.GetTrade(contract.TradeNID)
 	if !exists {
-		log.Printf("❌ Trade not found for contract: %s", recall.ContractReff)
+		logger.Error("Trade not found for contract", logging.ContractNID(contract.NID), logging.TradeNID(contract.TradeNID))
 		http.Error(w, "Trade not found", http.StatusInternalServerError)
 		return
 	}
 
 	// Create a new "matching order" for the borrower to find a new lender
-	// This will be treated like a regular borrow order and matched by the OMS
+	// This will be treated like a regular borrow order and matched by the OMS.
+	// Each borrower contract gets its own reference, which also keeps a
+	// retried instruction from creating the orders twice.
 	for _, contractNID := range trade.Borrower {
 		if borrowContract, exists := h.ledger.GetContract(contractNID); exists {
+			reffRequestID := ledger.RecallReffRequestID(recall.ContractReff, borrowContract.NID)
+			if existing, exists := h.ledger.GetOrderByReffRequestID(reffRequestID); exists {
+				if existing.AccountCode != borrowContract.AccountCode || existing.Side != "BORR" || existing.ARO {
+					logger.Error("Recall reference used by another order", logging.Account(borrowContract.AccountCode),
+						logging.OrderNID(existing.NID), "reff_request_id", reffRequestID)
+					http.Error(w, "Recall reference already used by another order", http.StatusConflict)
+					return
+				}
+				logger.Info("Recall order already created", logging.Account(borrowContract.AccountCode), logging.OrderNID(existing.NID))
+				continue
+			}
+			nid, err := h.idgen.NextID()
+			if err != nil {
+				logger.Error("Failed to generate recall order NID", "error", err)
+				http.Error(w, "Failed to generate order ID", http.StatusInternalServerError)
+				return
+			}
 			newOrder := ledger.Order{
-				NID:               int(ledger.GetCurrentTimeMillis()),
+				NID:               int(nid),
 				PrevNID:           0,
-				ReffRequestID:     recall.ContractReff + "-RECALL",
+				ReffRequestID:     reffRequestID,
 				AccountNID:        borrowContract.AccountNID,
 				AccountCode:       borrowContract.AccountCode,
 				ParticipantNID:    borrowContract.AccountParticipantNID,
@@ -266,7 +326,7 @@ func (h *TradeHandler) LenderRecall(w http.ResponseWriter, r *http.Request) {
 				InstrumentCode:    borrowContract.InstrumentCode,
 				Side:              "BORR",
 				Quantity:          contract.Quantity,
-				SettlementDate:    time.Now(), // Immediate
+				SettlementDate:    h.clock.Now(), // Immediate
 				ReimbursementDate: contract.ReimburseAt,
 				Periode:           contract.Periode,
 				State:             "S",
@@ -277,8 +337,12 @@ func (h *TradeHandler) LenderRecall(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// Commit new order
-			h.ledger.Commit <- newOrder
-			log.Printf("✅ Created recall order for borrower %s", borrowContract.AccountCode)
+			if _, err := h.ledger.CommitSync(r.Context(), newOrder); err != nil {
+				logger.ErrorContext(r.Context(), "Failed to commit recall order", logging.Account(borrowContract.AccountCode), "error", err)
+				http.Error(w, "Ledger unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			logger.InfoContext(r.Context(), "Created recall order", logging.Account(borrowContract.AccountCode), logging.OrderNID(newOrder.NID))
 		}
 	}
 
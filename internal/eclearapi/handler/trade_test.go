@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pmeonline/pkg/idgen"
+	"pmeonline/pkg/ledger"
+)
+
+// waitFor polls cond until it returns true or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLenderRecallWithSeveralBorrowers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "eclearapi")
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+
+	// One lender, two borrowers of different participants
+	trade := ledger.Trade{
+		NID: 7, KpeiReff: "T-7", InstrumentCode: "BBRI", Quantity: 300,
+		Lender: []ledger.Contract{{NID: 71, TradeNID: 7, KpeiReff: "L-71", Side: "LEND", AccountCode: "AK-001",
+			AccountParticipantCode: "AK", InstrumentCode: "BBRI", Quantity: 300}},
+		Borrower: []ledger.Contract{
+			{NID: 72, TradeNID: 7, KpeiReff: "B-72", Side: "BORR", AccountCode: "YU-001",
+				AccountParticipantCode: "YU", InstrumentCode: "BBRI", Quantity: 100},
+			{NID: 73, TradeNID: 7, KpeiReff: "B-73", Side: "BORR", AccountCode: "ZP-001",
+				AccountParticipantCode: "ZP", InstrumentCode: "BBRI", Quantity: 200},
+		},
+	}
+	lp.SyncTrade(trade)
+
+	g, _ := idgen.NewGenerator(1)
+	h := NewTradeHandler(lp, g)
+	recall := func() {
+		w := httptest.NewRecorder()
+		h.LenderRecall(w, httptest.NewRequest(http.MethodPost, "/lender/recall", strings.NewReader(`{"contract_reff":"L-71"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("LenderRecall() status %d: %s", w.Code, w.Body)
+		}
+	}
+	recallOrders := func() map[string]int {
+		accounts := make(map[string]int)
+		lp.ForEachOrder(func(o ledger.OrderEntity) bool {
+			if strings.HasPrefix(o.ReffRequestID, "L-71-RECALL") {
+				accounts[o.AccountCode]++
+			}
+			return true
+		})
+		return accounts
+	}
+
+	recall()
+	waitFor(t, "recall orders to be applied", func() bool { return lp.AppliedEvents()["Order"] == 2 })
+	if got := recallOrders(); got["YU-001"] != 1 || got["ZP-001"] != 1 {
+		t.Fatalf("recall orders by account = %v, want one for each borrower", got)
+	}
+
+	// A retried instruction doesn't create them again
+	recall()
+	if got := recallOrders(); got["YU-001"] != 1 || got["ZP-001"] != 1 {
+		t.Errorf("recall orders by account after a retry = %v, want one for each borrower", got)
+	}
+}
+
+func TestLenderRecallRefusesClaimedReference(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "eclearapi")
+	lp.SyncTrade(ledger.Trade{
+		NID: 7, KpeiReff: "T-7", InstrumentCode: "BBRI", Quantity: 100,
+		Lender: []ledger.Contract{{NID: 71, TradeNID: 7, KpeiReff: "L-71", Side: "LEND", AccountCode: "AK-001",
+			AccountParticipantCode: "AK", InstrumentCode: "BBRI", Quantity: 100}},
+		Borrower: []ledger.Contract{{NID: 72, TradeNID: 7, KpeiReff: "B-72", Side: "BORR", AccountCode: "YU-001",
+			AccountParticipantCode: "YU", InstrumentCode: "BBRI", Quantity: 100}},
+	})
+
+	// Another participant's order under the recall reference isn't a retry
+	lp.SyncOrder(ledger.Order{NID: 9, ReffRequestID: ledger.RecallReffRequestID("L-71", 72), AccountCode: "AK-001",
+		ParticipantCode: "AK", InstrumentCode: "BBRI", Side: "LEND", Quantity: 100})
+
+	g, _ := idgen.NewGenerator(1)
+	w := httptest.NewRecorder()
+	NewTradeHandler(lp, g).LenderRecall(w, httptest.NewRequest(http.MethodPost, "/lender/recall", strings.NewReader(`{"contract_reff":"L-71"}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("LenderRecall() status %d, want %d", w.Code, http.StatusConflict)
+	}
+}
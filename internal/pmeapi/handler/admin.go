@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
+)
+
+// asOfTimeout bounds an as-of replay. The server's WriteTimeout is extended
+// accordingly for the request.
+const asOfTimeout = 2 * time.Minute
+
+type AdminHandler struct {
+	ledger *ledger.LedgerPoint
+	token  string
+
+	// replays admits one as-of replay at a time, since each builds a full
+	// copy of the ledger
+	replays chan struct{}
+}
+
+// NewAdminHandler serves the admin endpoints to requests carrying token as a
+// bearer token. With an empty token they are disabled.
+func NewAdminHandler(l *ledger.LedgerPoint, token string) *AdminHandler {
+	return &AdminHandler{ledger: l, token: token, replays: make(chan struct{}, 1)}
+}
+
+// authorize checks the bearer token, answering the request when it fails
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if h.token == "" {
+		respondError(w, http.StatusForbidden, "Admin endpoints are disabled")
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondError(w, http.StatusUnauthorized, "Invalid or missing admin token")
+		return false
+	}
+	return true
+}
+
+// GetLedgerAsOf handles GET /api/admin/ledger/asof?offset={offset}|at={RFC3339}&participant={code}
+// Replays the ledger up to the given offset or time and returns the account
+// limits and exposure, orders, trades and contracts as they were at that
+// point. Requires the admin token;
+// a replay already running makes it return 429.
+func (h *AdminHandler) GetLedgerAsOf(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	offsetParam := r.URL.Query().Get("offset")
+	atParam := r.URL.Query().Get("at")
+	participantCode := r.URL.Query().Get("participant")
+
+	if (offsetParam == "") == (atParam == "") {
+		respondError(w, http.StatusBadRequest, "Exactly one of offset or at is required")
+		return
+	}
+
+	var offset int64
+	var at time.Time
+	if offsetParam != "" {
+		var err error
+		offset, err = strconv.ParseInt(offsetParam, 10, 64)
+		if err != nil || offset < 0 {
+			respondError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+	} else {
+		var err error
+		at, err = time.Parse(time.RFC3339, atParam)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "at must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	select {
+	case h.replays <- struct{}{}:
+		defer func() { <-h.replays }()
+	default:
+		respondError(w, http.StatusTooManyRequests, "Another as-of replay is running, retry later")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), asOfTimeout)
+	defer cancel()
+	// Leave time to write the response after a replay that used it all
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(asOfTimeout + 15*time.Second)); err != nil {
+		logging.FromContext(r.Context()).Warn("Could not extend the write deadline", "error", err)
+	}
+
+	var view *ledger.LedgerView
+	var err error
+	if offsetParam != "" {
+		view, err = h.ledger.ViewAtOffset(ctx, offset)
+	} else {
+		view, err = h.ledger.ViewAtTime(ctx, at)
+	}
+	if errors.Is(err, ledger.ErrPartitioned) {
+		respondError(w, http.StatusNotImplemented, "As-of views are not available on a partitioned ledger")
+		return
+	}
+	if errors.Is(err, ledger.ErrViewIncomplete) {
+		respondError(w, http.StatusGone, "The ledger at that point is no longer in Kafka, the archive or a snapshot")
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		respondError(w, http.StatusGatewayTimeout, "As-of replay took too long")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("As-of replay failed", "error", err)
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable, replay failed")
+		return
+	}
+
+	var orders []ledger.OrderEntity
+	var contracts []ledger.ContractEntity
+	if participantCode != "" {
+		orders = view.ListOrdersByParticipant(participantCode)
+		contracts = view.ListContractsByParticipant(participantCode)
+	} else {
+		view.ForEachOrder(func(order ledger.OrderEntity) bool {
+			orders = append(orders, order)
+			return true
+		})
+		view.ForEachContract(func(contract ledger.ContractEntity) bool {
+			contracts = append(contracts, contract)
+			return true
+		})
+	}
+
+	// Limits and their usage at that point, for the accounts of the
+	// participant or all of them
+	var accountCodes []string
+	view.ForEachAccount(func(account ledger.AccountEntity) bool {
+		if participantCode == "" || account.ParticipantCode == participantCode {
+			accountCodes = append(accountCodes, account.Code)
+		}
+		return true
+	})
+	var accounts []ledger.AccountExposure
+	for _, code := range accountCodes {
+		if exposure, err := view.GetAccountExposure(code); err == nil {
+			accounts = append(accounts, exposure)
+		}
+	}
+
+	// Trades are included when any of their contracts is
+	var trades []ledger.TradeEntity
+	if participantCode != "" {
+		seen := make(map[int]bool)
+		for _, contract := range contracts {
+			if seen[contract.TradeNID] {
+				continue
+			}
+			seen[contract.TradeNID] = true
+			if trade, exists := view.GetTrade(contract.TradeNID); exists {
+				trades = append(trades, trade)
+			}
+		}
+	} else {
+		view.ForEachTrade(func(trade ledger.TradeEntity) bool {
+			trades = append(trades, trade)
+			return true
+		})
+	}
+
+	logging.FromContext(r.Context()).Info("As-of view", logging.Offset(view.Offset),
+		"accounts", len(accounts), "orders", len(orders), "trades", len(trades), "contracts", len(contracts))
+
+	respondSuccess(w, "Ledger state retrieved", map[string]interface{}{
+		"offset":    view.Offset,
+		"time":      view.Time,
+		"accounts":  accounts,
+		"orders":    orders,
+		"trades":    trades,
+		"contracts": contracts,
+	})
+}
@@ -2,26 +2,109 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
+// pendingTTL bounds how long a reservation waits for its order to be
+// applied, in case it never is (e.g. refused by the consumers)
+const pendingTTL = 15 * time.Minute
+
 type OrderHandler struct {
 	ledger *ledger.LedgerPoint
 	idgen  *idgen.Generator
+
+	// Requests being committed, or committed but not yet applied, by
+	// reff_request_id. The ledger index only answers retries once the first
+	// order has been applied.
+	pendingMu sync.Mutex
+	pending   map[string]pendingRequest
+}
+
+// pendingRequest is the order a reff_request_id was reserved for
+type pendingRequest struct {
+	orderNID        int
+	prevNID         int
+	participantCode string
+	instrumentCode  string
+	committed       bool
 }
 
 func NewOrderHandler(l *ledger.LedgerPoint, idGenerator *idgen.Generator) *OrderHandler {
-	return &OrderHandler{
-		ledger: l,
-		idgen:  idGenerator,
+	h := &OrderHandler{
+		ledger:  l,
+		idgen:   idGenerator,
+		pending: make(map[string]pendingRequest),
+	}
+	ledger.Subscribe(l, h.orderApplied)
+	return h
+}
+
+// orderApplied drops the reservation of an applied order's reff_request_id,
+// the ledger answers for it from now on
+func (h *OrderHandler) orderApplied(order ledger.Order) {
+	if order.ReffRequestID == "" {
+		return
+	}
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+	if p, exists := h.pending[order.ReffRequestID]; exists &&
+		p.participantCode == order.ParticipantCode && p.instrumentCode == order.InstrumentCode {
+		delete(h.pending, order.ReffRequestID)
+	}
+}
+
+// reserve claims reffRequestID for the order about to be committed. If
+// another request holds it, that request is returned with ok false. The
+// reservation lasts until the order is applied, or pendingTTL.
+func (h *OrderHandler) reserve(reffRequestID string, req pendingRequest) (held pendingRequest, ok bool) {
+	if reffRequestID == "" {
+		return pendingRequest{}, true
+	}
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+	if held, exists := h.pending[reffRequestID]; exists {
+		return held, false
+	}
+	h.pending[reffRequestID] = req
+	time.AfterFunc(pendingTTL, func() {
+		h.pendingMu.Lock()
+		defer h.pendingMu.Unlock()
+		if p, exists := h.pending[reffRequestID]; exists && p.orderNID == req.orderNID {
+			delete(h.pending, reffRequestID)
+		}
+	})
+	return req, true
+}
+
+// commitDone keeps the reservation of a request until its order is applied
+// and releases it when CommitSync failed with err without writing the
+// order. After other errors the order may still be written, so a retry is
+// answered with the NID it was reserved for.
+func (h *OrderHandler) commitDone(reffRequestID string, err error) {
+	if reffRequestID == "" {
+		return
+	}
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+	p, exists := h.pending[reffRequestID]
+	if !exists {
+		return // Already applied
+	}
+	if ledger.NotWritten(err) {
+		delete(h.pending, reffRequestID)
+		return
 	}
+	p.committed = true
+	h.pending[reffRequestID] = p
 }
 
 // OrderRequest represents a new order request
@@ -68,9 +151,11 @@ type OrderResponse struct {
 
 // NewOrder handles POST /api/order/new
 func (h *OrderHandler) NewOrder(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("[APME-API] Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		respondError(w, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
@@ -78,18 +163,39 @@ func (h *OrderHandler) NewOrder(w http.ResponseWriter, r *http.Request) {
 
 	var req OrderRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("[APME-API] Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		respondError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
 
+	logger = logger.With(logging.Participant(req.ParticipantCode), logging.Account(req.AccountCode),
+		logging.Instrument(req.InstrumentCode), "reff_request_id", req.ReffRequestID)
+
 	// Validate required fields
 	if err := validateOrderRequest(req); err != nil {
-		log.Printf("[APME-API] Validation failed: %v", err)
+		logger.Warn("Validation failed", "error", err)
 		respondError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
+	// A retried request returns the order it already created
+	if existing, exists := h.ledger.GetOrderByReffRequestID(req.ReffRequestID); exists && req.ReffRequestID != "" {
+		// The ledger only compares references within a participant and
+		// instrument, reuse across them is refused here
+		if existing.ParticipantCode != req.ParticipantCode || existing.InstrumentCode != req.InstrumentCode ||
+			existing.PrevNID != 0 {
+			respondError(w, http.StatusConflict, "reff_request_id already used")
+			return
+		}
+		logger.Info("Duplicate request, order already submitted", logging.OrderNID(existing.NID))
+		respondSuccess(w, "Order already submitted", map[string]interface{}{
+			"order_nid":       existing.NID,
+			"reff_request_id": req.ReffRequestID,
+			"status":          "submitted",
+		})
+		return
+	}
+
 	// Get account and participant NIDs
 	account, accountExists := h.ledger.GetAccount(req.AccountCode)
 	if !accountExists {
@@ -112,12 +218,31 @@ func (h *OrderHandler) NewOrder(w http.ResponseWriter, r *http.Request) {
 	// Generate unique order NID using Snowflake ID
 	nid, err := h.idgen.NextID()
 	if err != nil {
-		log.Printf("[APME-API] Failed to generate order NID: %v", err)
+		logger.Error("Failed to generate order NID", "error", err)
 		respondError(w, http.StatusInternalServerError, "Failed to generate order ID")
 		return
 	}
 	orderNID := int(nid)
 
+	// A retry arriving before the first attempt was applied
+	if held, ok := h.reserve(req.ReffRequestID, pendingRequest{orderNID: orderNID, participantCode: req.ParticipantCode,
+		instrumentCode: req.InstrumentCode}); !ok {
+		switch {
+		case held.participantCode != req.ParticipantCode || held.instrumentCode != req.InstrumentCode || held.prevNID != 0:
+			respondError(w, http.StatusConflict, "reff_request_id already used")
+		case !held.committed:
+			respondError(w, http.StatusConflict, "A request with this reff_request_id is in progress")
+		default:
+			logger.Info("Duplicate request, order already submitted", logging.OrderNID(held.orderNID))
+			respondSuccess(w, "Order already submitted", map[string]interface{}{
+				"order_nid":       held.orderNID,
+				"reff_request_id": req.ReffRequestID,
+				"status":          "submitted",
+			})
+		}
+		return
+	}
+
 	// Create order event
 	order := ledger.Order{
 		NID:               orderNID,
@@ -141,68 +266,80 @@ func (h *OrderHandler) NewOrder(w http.ResponseWriter, r *http.Request) {
 		ARO:               req.ARO,
 	}
 
-	// Commit to Kafka
-	h.ledger.Commit <- order
-	log.Printf("[APME-API] Order submitted: %d (%s %s %.0f shares)",
-		orderNID, req.Side, req.InstrumentCode, req.Quantity)
+	// Commit to Kafka and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), order)
+	h.commitDone(req.ReffRequestID, err)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to commit order", logging.OrderNID(orderNID), "error", err)
+		respondCommitError(w, err, "order not submitted")
+		return
+	}
+	logger.InfoContext(r.Context(), "Order submitted", logging.OrderNID(orderNID), "side", req.Side,
+		"quantity", req.Quantity, logging.Offset(offset))
 
 	// Return success response
 	respondSuccess(w, "Order submitted successfully", map[string]interface{}{
 		"order_nid":       orderNID,
 		"reff_request_id": req.ReffRequestID,
 		"status":          "submitted",
+		"offset":          offset,
 	})
 }
 
 // AmendOrder handles POST /api/order/amend
 func (h *OrderHandler) AmendOrder(w http.ResponseWriter, r *http.Request) {
-	log.Printf("[APME-API] AmendOrder handler called")
+	logger := logging.FromContext(r.Context())
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("[APME-API] Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		respondError(w, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 	defer r.Body.Close()
 
-	log.Printf("[APME-API] Request body: %s", string(body))
-
 	var req AmendOrderRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("[APME-API] Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		respondError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
 
-	log.Printf("[APME-API] Parsed request: %+v", req)
+	logger = logger.With(logging.OrderNID(req.OrderNID), "reff_request_id", req.ReffRequestID)
+	logger.Debug("Amending order", "request", req)
 
 	// Validate order exists
-	log.Printf("[APME-API] Attempting to amend order NID: %d", req.OrderNID)
-
-	// Debug: List all orders in ledger
-	orderCount := 0
-	foundMatch := false
-	h.ledger.ForEachOrder(func(o ledger.OrderEntity) bool {
-		log.Printf("[APME-API] Ledger Order: NID=%d, State=%s", o.NID, o.State)
-		orderCount++
-		if o.NID == req.OrderNID {
-			foundMatch = true
-			log.Printf("[APME-API] Found order in iteration: NID=%d, State=%s", o.NID, o.State)
-		}
-		return true
-	})
-	log.Printf("[APME-API] Total orders in ledger: %d, Found via iteration: %v", orderCount, foundMatch)
-
 	originalOrder, exists := h.ledger.GetOrder(req.OrderNID)
 	if !exists {
-		log.Printf("[APME-API] Order not found via GetOrder: %d", req.OrderNID)
+		logger.Warn("Order not found")
 		respondError(w, http.StatusNotFound, "Order not found: "+fmt.Sprintf("%d", req.OrderNID))
 		return
 	}
 
+	// ARO and recall references belong to eclearapi
+	if ledger.IsReservedReffRequestID(req.ReffRequestID) {
+		respondError(w, http.StatusUnprocessableEntity, "reff_request_id: ARO and recall references are reserved")
+		return
+	}
+
+	// A retried request returns the amendment it already created
+	if existing, exists := h.ledger.GetOrderByReffRequestID(req.ReffRequestID); exists && req.ReffRequestID != "" {
+		if existing.PrevNID != req.OrderNID {
+			respondError(w, http.StatusConflict, "reff_request_id already used")
+			return
+		}
+		logger.Info("Duplicate request, amendment already submitted", "new_order_nid", existing.NID)
+		respondSuccess(w, "Order already amended", map[string]interface{}{
+			"original_order_nid": req.OrderNID,
+			"new_order_nid":      existing.NID,
+			"status":             "submitted",
+		})
+		return
+	}
+
 	// Check if order can be amended (must be Open or Partial)
-	log.Printf("[APME-API] Order %d state: %s", req.OrderNID, originalOrder.State)
+	logger = logger.With(logging.Participant(originalOrder.ParticipantCode), logging.Account(originalOrder.AccountCode),
+		logging.Instrument(originalOrder.InstrumentCode))
 	if originalOrder.State != "O" && originalOrder.State != "P" {
 		respondError(w, http.StatusUnprocessableEntity, "Order cannot be amended in current state: "+originalOrder.State+" (must be O or P)")
 		return
@@ -211,12 +348,31 @@ func (h *OrderHandler) AmendOrder(w http.ResponseWriter, r *http.Request) {
 	// Generate unique order NID using Snowflake ID
 	nid, err := h.idgen.NextID()
 	if err != nil {
-		log.Printf("[APME-API] Failed to generate amended order NID: %v", err)
+		logger.Error("Failed to generate amended order NID", "error", err)
 		respondError(w, http.StatusInternalServerError, "Failed to generate order ID")
 		return
 	}
 	newOrderNID := int(nid)
 
+	// A retry arriving before the first attempt was applied
+	if held, ok := h.reserve(req.ReffRequestID, pendingRequest{orderNID: newOrderNID, prevNID: req.OrderNID,
+		participantCode: originalOrder.ParticipantCode, instrumentCode: originalOrder.InstrumentCode}); !ok {
+		switch {
+		case held.prevNID != req.OrderNID:
+			respondError(w, http.StatusConflict, "reff_request_id already used")
+		case !held.committed:
+			respondError(w, http.StatusConflict, "A request with this reff_request_id is in progress")
+		default:
+			logger.Info("Duplicate request, amendment already submitted", "new_order_nid", held.orderNID)
+			respondSuccess(w, "Order already amended", map[string]interface{}{
+				"original_order_nid": req.OrderNID,
+				"new_order_nid":      held.orderNID,
+				"status":             "submitted",
+			})
+		}
+		return
+	}
+
 	amendedOrder := ledger.Order{
 		NID:               newOrderNID,
 		PrevNID:           req.OrderNID,
@@ -259,51 +415,67 @@ func (h *OrderHandler) AmendOrder(w http.ResponseWriter, r *http.Request) {
 		amendedOrder.Instruction = req.Instruction
 	}
 
-	// Commit to Kafka
-	h.ledger.Commit <- amendedOrder
-	log.Printf("[APME-API] Order amended: %d -> %d", req.OrderNID, newOrderNID)
+	// Commit to Kafka and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), amendedOrder)
+	h.commitDone(req.ReffRequestID, err)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to commit amended order", "new_order_nid", newOrderNID, "error", err)
+		respondCommitError(w, err, "amendment not submitted")
+		return
+	}
+	logger.InfoContext(r.Context(), "Order amended", "new_order_nid", newOrderNID, logging.Offset(offset))
 
 	respondSuccess(w, "Order amended successfully", map[string]interface{}{
 		"original_order_nid": req.OrderNID,
 		"new_order_nid":      newOrderNID,
 		"status":             "submitted",
+		"offset":             offset,
 	})
 }
 
 // WithdrawOrder handles POST /api/order/withdraw
 func (h *OrderHandler) WithdrawOrder(w http.ResponseWriter, r *http.Request) {
-	log.Printf("[APME-API] WithdrawOrder handler called")
+	logger := logging.FromContext(r.Context())
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("[APME-API] Failed to read request body: %v", err)
+		logger.Warn("Failed to read request body", "error", err)
 		respondError(w, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 	defer r.Body.Close()
 
-	log.Printf("[APME-API] Request body: %s", string(body))
-
 	var req WithdrawOrderRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("[APME-API] Failed to parse JSON: %v", err)
+		logger.Warn("Failed to parse JSON", "error", err)
 		respondError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
 
-	log.Printf("[APME-API] Parsed request: %+v", req)
+	logger = logger.With(logging.OrderNID(req.OrderNID), "reff_request_id", req.ReffRequestID)
+	logger.Debug("Withdrawing order", "request", req)
 
 	// Validate order exists
-	log.Printf("[APME-API] Attempting to withdraw order NID: %d", req.OrderNID)
 	order, exists := h.ledger.GetOrder(req.OrderNID)
 	if !exists {
-		log.Printf("[APME-API] Order not found: %d", req.OrderNID)
+		logger.Warn("Order not found")
 		respondError(w, http.StatusNotFound, "Order not found: "+fmt.Sprintf("%d", req.OrderNID))
 		return
 	}
 
+	// A retried request succeeds without withdrawing again
+	if req.ReffRequestID != "" && order.WReffRequestID == req.ReffRequestID {
+		logger.Info("Duplicate request, withdrawal already submitted")
+		respondSuccess(w, "Order withdrawal already submitted", map[string]interface{}{
+			"order_nid": req.OrderNID,
+			"status":    "submitted",
+		})
+		return
+	}
+
 	// Check if order can be withdrawn
-	log.Printf("[APME-API] Order %d state: %s", req.OrderNID, order.State)
+	logger = logger.With(logging.Participant(order.ParticipantCode), logging.Account(order.AccountCode),
+		logging.Instrument(order.InstrumentCode))
 	if order.State != "O" && order.State != "P" {
 		respondError(w, http.StatusUnprocessableEntity, "Order cannot be withdrawn in current state: "+order.State+" (must be O or P)")
 		return
@@ -315,13 +487,19 @@ func (h *OrderHandler) WithdrawOrder(w http.ResponseWriter, r *http.Request) {
 		ReffRequestID: req.ReffRequestID,
 	}
 
-	// Commit to Kafka
-	h.ledger.Commit <- withdraw
-	log.Printf("[APME-API] Order withdrawal requested: %d", req.OrderNID)
+	// Commit to Kafka and wait for the broker acknowledgment
+	offset, err := h.ledger.CommitSync(r.Context(), withdraw)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to commit withdrawal", "error", err)
+		respondCommitError(w, err, "withdrawal not submitted")
+		return
+	}
+	logger.InfoContext(r.Context(), "Order withdrawal requested", logging.Offset(offset))
 
 	respondSuccess(w, "Order withdrawal submitted", map[string]interface{}{
 		"order_nid": req.OrderNID,
 		"status":    "withdrawal_pending",
+		"offset":    offset,
 	})
 }
 
@@ -342,6 +520,10 @@ func validateOrderRequest(req OrderRequest) error {
 	if req.Quantity <= 0 {
 		return &ValidationError{Field: "quantity", Message: "must be greater than 0"}
 	}
+	// ARO and recall references belong to eclearapi
+	if ledger.IsReservedReffRequestID(req.ReffRequestID) {
+		return &ValidationError{Field: "reff_request_id", Message: "ARO and recall references are reserved"}
+	}
 
 	// BORR-specific validations (LEND orders don't need settlement dates, periode, ARO)
 	if req.Side == "BORR" {
@@ -380,6 +562,21 @@ func respondSuccess(w http.ResponseWriter, message string, data interface{}) {
 	})
 }
 
+// respondCommitError answers 503 for a failed CommitSync, asking the client
+// to retry shortly when the ledger is only busy
+func respondCommitError(w http.ResponseWriter, err error, notSubmitted string) {
+	switch {
+	case errors.Is(err, ledger.ErrCommitQueueFull):
+		w.Header().Set("Retry-After", "1")
+		respondError(w, http.StatusServiceUnavailable, "Ledger busy, "+notSubmitted)
+	case ledger.NotWritten(err):
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable, "+notSubmitted)
+	default:
+		// The event may still be written, a retry tells
+		respondError(w, http.StatusServiceUnavailable, "Ledger unavailable, retry with the same reff_request_id to find out whether it was submitted")
+	}
+}
+
 func respondError(w http.ResponseWriter, statusCode int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
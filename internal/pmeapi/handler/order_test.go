@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"pmeonline/pkg/idgen"
+	"pmeonline/pkg/ledger"
+)
+
+func TestReservationLastsUntilOrderIsApplied(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "pmeapi")
+	g, _ := idgen.NewGenerator(0)
+	h := NewOrderHandler(lp, g)
+	req := pendingRequest{orderNID: 10, participantCode: "YU", instrumentCode: "BBRI"}
+
+	// Nothing was written, the reference is free again
+	h.reserve("REQ-1", req)
+	h.commitDone("REQ-1", fmt.Errorf("write: %w", ledger.ErrCommitQueueFull))
+	if _, ok := h.reserve("REQ-1", req); !ok {
+		t.Fatal("reservation kept after ErrCommitQueueFull")
+	}
+
+	// The order may have been written, a retry gets its NID
+	h.commitDone("REQ-1", context.DeadlineExceeded)
+	if held, ok := h.reserve("REQ-1", pendingRequest{orderNID: 11, participantCode: "YU", instrumentCode: "BBRI"}); ok || held.orderNID != 10 || !held.committed {
+		t.Fatalf("reserve() after a timeout = %+v, %v, want order 10 held", held, ok)
+	}
+
+	// Once applied the ledger answers for it
+	lp.SyncOrder(ledger.Order{NID: 10, ReffRequestID: "REQ-1", ParticipantCode: "YU", InstrumentCode: "BBRI"})
+	if len(h.pending) != 0 {
+		t.Errorf("pending = %+v after the order was applied, want none", h.pending)
+	}
+}
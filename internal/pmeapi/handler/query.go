@@ -1,10 +1,10 @@
 package handler
 
 import (
-	"log"
 	"net/http"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 type QueryHandler struct {
@@ -23,7 +23,13 @@ type AccountInfoResponse struct {
 	ParticipantCode string  `json:"participant_code"`
 	ParticipantName string  `json:"participant_name"`
 	TradeLimit      float64 `json:"trade_limit"`
+	TradeReserved   float64 `json:"trade_reserved"`  // Held by open borrow orders
+	TradeUtilised   float64 `json:"trade_utilised"`  // Held by borrow contracts until reimbursed
+	TradeAvailable  float64 `json:"trade_available"` // Left for new borrow orders
 	PoolLimit       float64 `json:"pool_limit"`
+	PoolReserved    float64 `json:"pool_reserved"`  // Held by open lend orders
+	PoolUtilised    float64 `json:"pool_utilised"`  // Held by lend contracts until reimbursed
+	PoolAvailable   float64 `json:"pool_available"` // Left for new lend orders
 }
 
 // OrderInfo represents order information
@@ -98,6 +104,8 @@ func (h *QueryHandler) GetAccountInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	exposure, _ := h.ledger.GetAccountExposure(foundAccount.Code)
+
 	// Build response
 	response := AccountInfoResponse{
 		Code:            foundAccount.Code,
@@ -106,7 +114,13 @@ func (h *QueryHandler) GetAccountInfo(w http.ResponseWriter, r *http.Request) {
 		ParticipantCode: foundAccount.ParticipantCode,
 		ParticipantName: participant.Name,
 		TradeLimit:      foundAccount.TradeLimit,
+		TradeReserved:   exposure.TradeReserved,
+		TradeUtilised:   exposure.TradeUtilised,
+		TradeAvailable:  exposure.TradeAvailable,
 		PoolLimit:       foundAccount.PoolLimit,
+		PoolReserved:    exposure.PoolReserved,
+		PoolUtilised:    exposure.PoolUtilised,
+		PoolAvailable:   exposure.PoolAvailable,
 	}
 
 	respondSuccess(w, "Account information retrieved", response)
@@ -118,34 +132,47 @@ func (h *QueryHandler) GetOrderList(w http.ResponseWriter, r *http.Request) {
 	sid := r.URL.Query().Get("sid")
 	stateFilter := r.URL.Query().Get("state")
 
+	// Narrow the candidates with the ledger indexes
+	var candidates []ledger.OrderEntity
+	switch {
+	case participantCode != "":
+		candidates = h.ledger.ListOrdersByParticipant(participantCode)
+	case sid != "":
+		for _, accountCode := range h.accountCodesBySID(sid) {
+			candidates = append(candidates, h.ledger.ListOrdersByAccount(accountCode)...)
+		}
+	default:
+		h.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
+			candidates = append(candidates, order)
+			return true
+		})
+	}
+
 	// Build filter criteria
 	var orders []OrderInfo
-	totalOrders := 0
+	totalOrders := len(candidates)
 	filteredOrders := 0
 
-	h.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
-		totalOrders++
+	for _, order := range candidates {
 		// Apply filters
 		if participantCode != "" && order.ParticipantCode != participantCode {
-			return true
+			continue
 		}
 
 		if sid != "" {
 			// Find account by SID
 			account, exists := h.ledger.GetAccount(order.AccountCode)
 			if !exists || account.SID != sid {
-				return true
+				continue
 			}
 		}
 
 		if stateFilter != "" && order.State != stateFilter {
-			return true
+			continue
 		}
 
 		filteredOrders++
 
-		log.Printf("[APME-API] Order list: NID=%d, State=%s", order.NID, order.State)
-
 		// Add to results
 		orderInfo := OrderInfo{
 			NID:               order.NID,
@@ -167,19 +194,11 @@ func (h *QueryHandler) GetOrderList(w http.ResponseWriter, r *http.Request) {
 			EntryAt:           order.EntryAt.Format("2006-01-02 15:04:05"),
 		}
 		orders = append(orders, orderInfo)
-		return true
-	})
-
-	log.Printf("[APME-API] GetOrderList: total=%d, filtered=%d, returned=%d", totalOrders, filteredOrders, len(orders))
-
-	// Check if specific NID is in the list
-	for _, o := range orders {
-		if o.NID == 252977047423418370 {
-			log.Printf("[APME-API] Order 252977047423418370 IS in the order list, State=%s", o.State)
-			break
-		}
 	}
 
+	logging.FromContext(r.Context()).Debug("Order list", "total", totalOrders, "filtered", filteredOrders,
+		"returned", len(orders))
+
 	respondSuccess(w, "Order list retrieved", map[string]interface{}{
 		"count":  len(orders),
 		"orders": orders,
@@ -192,25 +211,41 @@ func (h *QueryHandler) GetContractList(w http.ResponseWriter, r *http.Request) {
 	sid := r.URL.Query().Get("sid")
 	stateFilter := r.URL.Query().Get("state")
 
+	// Narrow the candidates with the ledger indexes
+	var candidates []ledger.ContractEntity
+	switch {
+	case participantCode != "":
+		candidates = h.ledger.ListContractsByParticipant(participantCode)
+	case sid != "":
+		for _, accountCode := range h.accountCodesBySID(sid) {
+			candidates = append(candidates, h.ledger.ListContractsByAccount(accountCode)...)
+		}
+	default:
+		h.ledger.ForEachContract(func(contract ledger.ContractEntity) bool {
+			candidates = append(candidates, contract)
+			return true
+		})
+	}
+
 	// Build filter criteria
 	var contracts []ContractInfo
 
-	h.ledger.ForEachContract(func(contract ledger.ContractEntity) bool {
+	for _, contract := range candidates {
 		// Apply filters
 		if participantCode != "" && contract.AccountParticipantCode != participantCode {
-			return true
+			continue
 		}
 
 		if sid != "" {
 			// Find account by SID
 			account, exists := h.ledger.GetAccount(contract.AccountCode)
 			if !exists || account.SID != sid {
-				return true
+				continue
 			}
 		}
 
 		if stateFilter != "" && contract.State != stateFilter {
-			return true
+			continue
 		}
 
 		// Add to results
@@ -234,8 +269,7 @@ func (h *QueryHandler) GetContractList(w http.ResponseWriter, r *http.Request) {
 			ReimburseAt:            contract.ReimburseAt.Format("2006-01-02"),
 		}
 		contracts = append(contracts, contractInfo)
-		return true
-	})
+	}
 
 	respondSuccess(w, "Contract list retrieved", map[string]interface{}{
 		"count":     len(contracts),
@@ -243,6 +277,18 @@ func (h *QueryHandler) GetContractList(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// accountCodesBySID returns the codes of all accounts registered under sid
+func (h *QueryHandler) accountCodesBySID(sid string) []string {
+	var codes []string
+	h.ledger.ForEachAccount(func(account ledger.AccountEntity) bool {
+		if account.SID == sid {
+			codes = append(codes, account.Code)
+		}
+		return true
+	})
+	return codes
+}
+
 // QueryResponse represents a generic query response
 type QueryResponse struct {
 	Status  string      `json:"status"`
@@ -53,31 +53,45 @@ func (h *SBLHandler) GetSBLDetail(w http.ResponseWriter, r *http.Request) {
 	side := r.URL.Query().Get("side")
 	aroFilter := r.URL.Query().Get("aro")
 
+	// Narrow the candidates with the ledger indexes
+	var candidates []ledger.OrderEntity
+	switch {
+	case participantCode != "":
+		candidates = h.ledger.ListOrdersByParticipant(participantCode)
+	case instrumentCode != "":
+		candidates = h.ledger.ListOrdersByInstrument(instrumentCode)
+	default:
+		h.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
+			candidates = append(candidates, order)
+			return true
+		})
+	}
+
 	var orders []SBLOrderInfo
 
-	h.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
+	for _, order := range candidates {
 		// Only include Open and Partial orders
 		if order.State != "O" && order.State != "P" {
-			return true
+			continue
 		}
 
 		// Apply filters
 		if participantCode != "" && order.ParticipantCode != participantCode {
-			return true
+			continue
 		}
 
 		if instrumentCode != "" && order.InstrumentCode != instrumentCode {
-			return true
+			continue
 		}
 
 		if side != "" && order.Side != side {
-			return true
+			continue
 		}
 
 		if aroFilter == "true" && !order.ARO {
-			return true
+			continue
 		} else if aroFilter == "false" && order.ARO {
-			return true
+			continue
 		}
 
 		// Get account SID
@@ -110,8 +124,7 @@ func (h *SBLHandler) GetSBLDetail(w http.ResponseWriter, r *http.Request) {
 		}
 
 		orders = append(orders, orderInfo)
-		return true
-	})
+	}
 
 	respondSuccess(w, "SBL detail retrieved", map[string]interface{}{
 		"count":  len(orders),
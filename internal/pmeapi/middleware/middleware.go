@@ -1,26 +1,38 @@
 package middleware
 
 import (
-	"log"
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"pmeonline/pkg/logging"
 )
 
-// LoggingMiddleware logs HTTP requests
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// LoggingMiddleware logs HTTP requests with their request ID, and gives the
+// handlers a logger carrying it (see logging.Middleware)
+func LoggingMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return logging.Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		logger := logging.FromContext(r.Context())
 
 		// Log request
-		log.Printf("[APME-API] %s %s %s", r.Method, r.URL.Path, r.RemoteAddr)
+		logger.Info("Request received", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
 
 		// Call next handler
 		next.ServeHTTP(w, r)
 
 		// Log duration
-		duration := time.Since(start)
-		log.Printf("[APME-API] %s %s completed in %v", r.Method, r.URL.Path, duration)
-	})
+		logger.Info("Request completed", "method", r.Method, "path", r.URL.Path, "duration", time.Since(start))
+	}))
 }
 
 // CORSMiddleware adds CORS headers
@@ -29,7 +41,8 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+logging.HeaderRequestID)
+		w.Header().Set("Access-Control-Expose-Headers", logging.HeaderRequestID)
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -47,7 +60,7 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("[APME-API] Panic recovered: %v", err)
+				logging.FromContext(r.Context()).Error("Panic recovered", "panic", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
@@ -55,3 +68,55 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// TracingMiddleware starts a server span per request, continuing the trace of
+// a W3C traceparent header when the client sent one. Handlers commit with
+// r.Context(), so the span is carried into the ledger and on to the OMS.
+func TracingMiddleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer("pmeonline/internal/pmeapi")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets the WebSocket endpoint take over the connection
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", r.ResponseWriter)
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -2,7 +2,6 @@ package websocket
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"time"
 
@@ -77,19 +76,19 @@ func (c *Client) readPump() {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("[WS-CLIENT] Unexpected close: %v", err)
+				c.hub.logger.Warn("Unexpected close", "client", c.id, "error", err)
 			}
 			break
 		}
 
-		log.Printf("[WS-CLIENT] Received message from %s: %s", c.id, message)
+		c.hub.logger.Debug("Received message", "client", c.id, "message", string(message))
 
 		// Parse subscribe message to get requested sequence
 		var subMsg ClientSubscribeMessage
 		if err := json.Unmarshal(message, &subMsg); err == nil && subMsg.Type == "subscribe" {
 			c.requestedSeq = subMsg.FromSeq
 			c.hasSubscribed = true
-			log.Printf("[WS-CLIENT] Client %s requesting from seq %d", c.id, c.requestedSeq)
+			c.hub.logger.Info("Client subscribed", "client", c.id, "from_seq", c.requestedSeq)
 			// Trigger recovery by re-registering
 			c.hub.register <- c
 		}
@@ -144,7 +143,7 @@ func (c *Client) writePump() {
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("[WS] Failed to upgrade connection: %v", err)
+		hub.logger.Warn("Failed to upgrade connection", "error", err)
 		return
 	}
 
@@ -164,5 +163,5 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	go client.writePump()
 	go client.readPump()
 
-	log.Printf("[WS] Client connected: %s", clientID)
+	hub.logger.Info("Client connected", "client", clientID)
 }
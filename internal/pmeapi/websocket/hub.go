@@ -3,7 +3,10 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"sync/atomic"
+
+	"pmeonline/pkg/logging"
 )
 
 // Hub maintains active WebSocket clients and broadcasts messages
@@ -11,6 +14,9 @@ type Hub struct {
 	// Registered clients
 	clients map[*Client]bool
 
+	// len(clients), for readers outside Run
+	clientCount atomic.Int64
+
 	// Inbound messages from clients
 	broadcast chan SequencedNotification
 
@@ -22,11 +28,14 @@ type Hub struct {
 
 	// Notification buffer for DropCopy functionality
 	buffer *NotificationBuffer
+
+	logger *slog.Logger
 }
 
-// NewHub creates a new Hub
-func NewHub() *Hub {
+// NewHub creates a new Hub logging to logger
+func NewHub(logger *slog.Logger) *Hub {
 	return &Hub{
+		logger:     logger.With(logging.Component("websocket")),
 		broadcast:  make(chan SequencedNotification, 256),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
@@ -41,7 +50,8 @@ func (h *Hub) Run(ctx context.Context) {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
-			log.Printf("[WS-HUB] Client registered: %s (total: %d)", client.id, len(h.clients))
+			h.clientCount.Store(int64(len(h.clients)))
+			h.logger.Info("Client registered", "client", client.id, "clients", len(h.clients))
 
 			// Send recovery messages only if client has sent subscribe message
 			if client.hasSubscribed {
@@ -52,14 +62,15 @@ func (h *Hub) Run(ctx context.Context) {
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
-				log.Printf("[WS-HUB] Client unregistered: %s (total: %d)", client.id, len(h.clients))
+				h.clientCount.Store(int64(len(h.clients)))
+				h.logger.Info("Client unregistered", "client", client.id, "clients", len(h.clients))
 			}
 
 		case notification := <-h.broadcast:
 			// Marshal notification to JSON
 			jsonData, err := json.Marshal(notification)
 			if err != nil {
-				log.Printf("[WS-HUB] Failed to marshal notification: %v", err)
+				h.logger.Error("Failed to marshal notification", "error", err)
 				continue
 			}
 
@@ -72,12 +83,13 @@ func (h *Hub) Run(ctx context.Context) {
 					// Client's send buffer is full, disconnect
 					close(client.send)
 					delete(h.clients, client)
-					log.Printf("[WS-HUB] Client disconnected (buffer full): %s", client.id)
+					h.clientCount.Store(int64(len(h.clients)))
+					h.logger.Warn("Client disconnected, send buffer full", "client", client.id)
 				}
 			}
 
 		case <-ctx.Done():
-			log.Println("[WS-HUB] Shutting down hub...")
+			h.logger.Info("Shutting down hub")
 			// Close all client connections
 			for client := range h.clients {
 				close(client.send)
@@ -91,19 +103,18 @@ func (h *Hub) Run(ctx context.Context) {
 func (h *Hub) sendRecoveryMessages(client *Client) {
 	// Log buffer state
 	size, capacity, oldest, latest := h.buffer.GetBufferInfo()
-	log.Printf("[WS-HUB] Buffer state: size=%d/%d, oldest_seq=%d, latest_seq=%d",
-		size, capacity, oldest, latest)
+	h.logger.Debug("Buffer state", "size", size, "capacity", capacity, "oldest_seq", oldest, "latest_seq", latest)
 
 	// Get notifications from requested sequence (0 means from oldest available)
 	notifications, allAvailable := h.buffer.GetFrom(client.requestedSeq)
 
 	if !allAvailable {
-		log.Printf("[WS-HUB] Client %s requested seq %d, but oldest available is %d",
-			client.id, client.requestedSeq, h.buffer.GetOldestSequence())
+		h.logger.Warn("Client requested a sequence no longer buffered", "client", client.id,
+			"requested_seq", client.requestedSeq, "oldest_seq", h.buffer.GetOldestSequence())
 	}
 
-	log.Printf("[WS-HUB] Sending %d recovery messages to %s (from seq %d)",
-		len(notifications), client.id, client.requestedSeq)
+	h.logger.Info("Sending recovery messages", "client", client.id, "count", len(notifications),
+		"from_seq", client.requestedSeq)
 
 	// Send recovery header
 	header := map[string]interface{}{
@@ -121,7 +132,7 @@ func (h *Hub) sendRecoveryMessages(client *Client) {
 	for _, notif := range notifications {
 		jsonData, err := json.Marshal(notif)
 		if err != nil {
-			log.Printf("[WS-HUB] Failed to marshal recovery notification: %v", err)
+			h.logger.Error("Failed to marshal recovery notification", "error", err)
 			continue
 		}
 		client.send <- jsonData
@@ -136,7 +147,7 @@ func (h *Hub) sendRecoveryMessages(client *Client) {
 	completeJSON, _ := json.Marshal(complete)
 	client.send <- completeJSON
 
-	log.Printf("[WS-HUB] Recovery complete for %s", client.id)
+	h.logger.Info("Recovery complete", "client", client.id)
 }
 
 // sendBufferInfo sends buffer statistics to client
@@ -173,7 +184,7 @@ func (h *Hub) BroadcastNotification(eventType string, data map[string]interface{
 
 // ClientCount returns the number of connected clients
 func (h *Hub) ClientCount() int {
-	return len(h.clients)
+	return int(h.clientCount.Load())
 }
 
 // GetBufferInfo returns buffer statistics
@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"pmeonline/pkg/metrics"
+)
+
+// RegisterMetrics exports the number of connected clients and the occupancy
+// of the notification buffer used for DropCopy recovery
+func (h *Hub) RegisterMetrics() {
+	gauge := func(name string, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "websocket",
+			Name:      name,
+			Help:      help,
+		}, value)
+	}
+
+	prometheus.MustRegister(
+		gauge("clients", "Connected WebSocket clients", func() float64 {
+			return float64(h.ClientCount())
+		}),
+		gauge("buffer_size", "Notifications held in the recovery buffer", func() float64 {
+			size, _, _, _ := h.GetBufferInfo()
+			return float64(size)
+		}),
+		gauge("buffer_capacity", "Notifications the recovery buffer can hold", func() float64 {
+			_, capacity, _, _ := h.GetBufferInfo()
+			return float64(capacity)
+		}),
+		gauge("buffer_latest_sequence", "Sequence number of the latest notification", func() float64 {
+			_, _, _, latest := h.GetBufferInfo()
+			return float64(latest)
+		}),
+	)
+}
@@ -1,8 +1,6 @@
 package websocket
 
 import (
-	"log"
-
 	"pmeonline/pkg/ledger"
 )
 
@@ -23,12 +21,12 @@ func NewNotifier(hub *Hub, l *ledger.LedgerPoint) *Notifier {
 // Send a notification to all connected clients
 func (n *Notifier) sendNotification(notifType string, data map[string]interface{}) {
 	// Add timestamp to data
-	data["timestamp"] = ledger.GetCurrentTimeMillis()
+	data["timestamp"] = n.ledger.Clock().Now().UnixMilli()
 
 	// Broadcast using sequenced notification
 	seq := n.hub.BroadcastNotification(notifType, data)
 
-	log.Printf("[NOTIFIER] Sent %s notification (seq: %d) to %d clients", notifType, seq, n.hub.ClientCount())
+	n.hub.logger.Debug("Sent notification", "type", notifType, "seq", seq, "clients", n.hub.ClientCount())
 }
 
 // Implement LedgerPointInterface methods
@@ -1,10 +1,12 @@
 package pmeoms
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 // MatchResult represents the result of a matching operation
@@ -24,12 +26,16 @@ type Match struct {
 // Matcher handles order matching logic
 type Matcher struct {
 	orderBooks map[string]*OrderBook // Map of instrument code to order book
+	clock      ledger.Clock
+	logger     *slog.Logger
 }
 
 // NewMatcher creates a new matcher instance
-func NewMatcher() *Matcher {
+func NewMatcher(clock ledger.Clock, logger *slog.Logger) *Matcher {
 	return &Matcher{
 		orderBooks: make(map[string]*OrderBook),
+		clock:      clock,
+		logger:     logger,
 	}
 }
 
@@ -39,7 +45,7 @@ func (m *Matcher) GetOrCreateOrderBook(instrumentCode string) *OrderBook {
 		return ob
 	}
 
-	ob := NewOrderBook(instrumentCode)
+	ob := NewOrderBook(instrumentCode, m.clock)
 	m.orderBooks[instrumentCode] = ob
 	return ob
 }
@@ -62,19 +68,20 @@ func (m *Matcher) RemoveOrder(order ledger.OrderEntity) bool {
 
 // Match attempts to match an order against the order book
 // Returns a MatchResult with all matches found
-func (m *Matcher) Match(order ledger.OrderEntity) *MatchResult {
+func (m *Matcher) Match(ctx context.Context, order ledger.OrderEntity) *MatchResult {
 	ob := m.GetOrCreateOrderBook(order.InstrumentCode)
 
 	result := &MatchResult{
 		Matches:      make([]Match, 0),
-		RemainingQty: order.Quantity,
+		RemainingQty: order.Quantity - order.DoneQuantity,
 	}
 
 	// Get matchable orders (sorted by priority)
 	matchableOrders := ob.GetMatchableOrders(order)
 
-	log.Printf("🔍 Attempting to match order %d (%s %s %.0f shares) - Found %d potential matches",
-		order.NID, order.Side, order.InstrumentCode, order.Quantity, len(matchableOrders))
+	logger := m.logger.With(logging.OrderNID(order.NID), logging.Instrument(order.InstrumentCode))
+	logger.DebugContext(ctx, "Attempting to match order", "side", order.Side,
+		"quantity", order.Quantity, "candidates", len(matchableOrders))
 
 	// Try to match with each order
 	for _, queuedOrder := range matchableOrders {
@@ -111,19 +118,19 @@ func (m *Matcher) Match(order ledger.OrderEntity) *MatchResult {
 		result.Matches = append(result.Matches, match)
 		result.RemainingQty -= matchQty
 
-		log.Printf("✅ Matched %.0f shares: Order %d (%s) <-> Order %d (%s)",
-			matchQty, order.NID, order.Side, matchOrder.NID, matchOrder.Side)
+		logger.InfoContext(ctx, "Matched", "quantity", matchQty, "side", order.Side,
+			"counter_order_nid", matchOrder.NID)
 	}
 
 	result.FullyMatched = result.RemainingQty <= 0
 
 	if result.FullyMatched {
-		log.Printf("🎯 Order %d FULLY matched (%.0f shares)", order.NID, order.Quantity)
+		logger.InfoContext(ctx, "Order FULLY matched", "quantity", order.Quantity)
 	} else if len(result.Matches) > 0 {
-		log.Printf("⚡ Order %d PARTIALLY matched (%.0f/%.0f shares)",
-			order.NID, order.Quantity-result.RemainingQty, order.Quantity)
+		logger.InfoContext(ctx, "Order PARTIALLY matched",
+			"matched", order.Quantity-result.RemainingQty, "quantity", order.Quantity)
 	} else {
-		log.Printf("📋 Order %d queued (no matches found)", order.NID)
+		logger.InfoContext(ctx, "Order queued (no matches found)")
 	}
 
 	return result
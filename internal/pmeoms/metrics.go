@@ -0,0 +1,44 @@
+package pmeoms
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"pmeonline/pkg/ledger/risk"
+	"pmeonline/pkg/metrics"
+)
+
+var (
+	validationRejects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "oms",
+		Name:      "validation_rejects_total",
+		Help:      "Orders rejected by pre-trade validation, by the field that failed",
+	}, []string{"field"})
+
+	matchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "oms",
+		Name:      "matches_total",
+		Help:      "Borrow and lend orders matched against each other",
+	})
+
+	tradesGenerated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "oms",
+		Name:      "trades_generated_total",
+		Help:      "Trades generated from matches",
+	})
+)
+
+// rejectReason is the field of the first risk.ValidationError, "other" for any
+// other error
+func rejectReason(err error) string {
+	var validationErr *risk.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Field
+	}
+	return "other"
+}
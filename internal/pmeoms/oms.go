@@ -1,36 +1,54 @@
 package pmeoms
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/ledger/calendar"
 	"pmeonline/pkg/ledger/risk"
+	"pmeonline/pkg/logging"
 )
 
+var tracer = otel.Tracer("pmeonline/internal/pmeoms")
+
 // OMS represents the Order Management System
 type OMS struct {
 	ledger        *ledger.LedgerPoint
 	validator     *risk.Validator
 	checker       *risk.Checker
+	calendar      *calendar.Calendar
 	matcher       *Matcher
 	tradeGen      *TradeGenerator
+	logger        *slog.Logger
 	mu            sync.RWMutex
 	instrumentMap map[string]bool // Track instrument eligibility
 }
 
-// NewOMS creates a new OMS instance
-func NewOMS(l *ledger.LedgerPoint) *OMS {
+// NewOMS creates a new OMS instance logging to logger. ids generates the NIDs
+// of trades and contracts and must be unique to the instance.
+func NewOMS(l *ledger.LedgerPoint, ids *idgen.Generator, logger *slog.Logger) *OMS {
+	logger = logger.With(logging.Component("oms"))
 	calculator := risk.NewCalculator(l)
 	validator := risk.NewValidator(l)
-	checker := risk.NewChecker(l)
-	matcher := NewMatcher()
-	tradeGen := NewTradeGenerator(calculator)
+	checker := risk.NewChecker(l, logger)
+	matcher := NewMatcher(l.Clock(), logger)
+	tradeGen := NewTradeGenerator(calculator, l.Clock(), ids)
 
 	oms := &OMS{
 		ledger:        l,
+		logger:        logger,
 		validator:     validator,
 		checker:       checker,
+		calendar:      calendar.New(l),
 		matcher:       matcher,
 		tradeGen:      tradeGen,
 		instrumentMap: make(map[string]bool),
@@ -44,131 +62,154 @@ func NewOMS(l *ledger.LedgerPoint) *OMS {
 }
 
 // InitOrders processes all existing orders after ledger sync completes
-// This should be called after ledger.IsReady becomes true
+// This should be called after ledger.Ready() is closed
 func (oms *OMS) InitOrders() {
-	log.Printf("🔄 Initializing orders from ledger...")
+	oms.logger.Info("Initializing orders from ledger...")
 
 	// Process all orders in state "S" (Saved - not yet acknowledged)
 	savedCount := 0
 	oms.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
 		if order.State == "S" {
 			savedCount++
-			log.Printf("[OMS] Processing saved order: %d", order.NID)
-			oms.ProcessOrder(order.NID)
+			oms.logger.Info("Processing saved order", logging.OrderNID(order.NID))
+			oms.ProcessOrder(context.Background(), order.NID)
 		}
 		return true // Continue iteration
 	})
 
-	// Match all orders in state "O" (Open - acknowledged and ready for matching)
+	// Match all orders in state "O" (Open - acknowledged and ready for
+	// matching) or "P" (Partial - the remainder is still in the book)
 	openCount := 0
 	oms.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
-		if order.State == "O" {
+		if order.State == "O" || order.State == "P" {
 			openCount++
-			log.Printf("[OMS] Matching open order: %d", order.NID)
-			oms.MatchOrder(order.NID)
+			oms.logger.Info("Matching open order", logging.OrderNID(order.NID))
+			oms.MatchOrder(context.Background(), order.NID)
 		}
 		return true // Continue iteration
 	})
 
-	log.Printf("✅ Order initialization complete - Processed %d saved orders, matched %d open orders",
-		savedCount, openCount)
+	oms.logger.Info("Order initialization complete", "saved", savedCount, "open", openCount)
 }
 
-// ProcessOrder handles a new order by OrderNID
-func (oms *OMS) ProcessOrder(orderNID int) {
+// ProcessOrder handles a new order by OrderNID. ctx carries the trace of the
+// Order event, which the resulting Ack, Nak or Pending continue.
+func (oms *OMS) ProcessOrder(ctx context.Context, orderNID int) {
+	ctx, span := tracer.Start(ctx, "oms.ProcessOrder", trace.WithAttributes(attribute.Int("order.nid", orderNID)))
+	defer span.End()
+
 	// Get order from ledger
 	orderEntity, exists := oms.ledger.GetOrder(orderNID)
 	if !exists {
-		log.Printf("❌ Order %d not found in ledger", orderNID)
+		oms.logger.ErrorContext(ctx, "Order not found in ledger", logging.OrderNID(orderNID))
+		span.SetStatus(codes.Error, "order not found")
 		return
 	}
 
-	log.Printf("📥 Processing order: %d (%s %s %.0f shares)",
-		orderEntity.NID, orderEntity.Side, orderEntity.InstrumentCode, orderEntity.Quantity)
+	logger := oms.orderLogger(orderEntity)
+	logger.InfoContext(ctx, "Processing order", "side", orderEntity.Side, "quantity", orderEntity.Quantity)
 
 	// Step 1: Validate order
 	if err := oms.validator.ValidateOrder(orderEntity); err != nil {
-		log.Printf("❌ Order %d validation failed: %v", orderNID, err)
-		oms.ledger.Commit <- ledger.OrderNak{
+		logger.WarnContext(ctx, "Order validation failed", "error", err)
+		validationRejects.WithLabelValues(rejectReason(err)).Inc()
+		span.AddEvent("validation failed", trace.WithAttributes(attribute.String("error", err.Error())))
+		oms.ledger.Commit <- ledger.WithContext(ctx, ledger.OrderNak{
 			OrderNID: orderNID,
 			Message:  err.Error(),
-		}
+		})
 		return
 	}
 
 	// Step 2: Check if order should be pending (future settlement date)
 	if oms.validator.IsPendingNew(orderEntity) {
-		log.Printf("⏰ Order %d is pending (settlement date: %s)",
-			orderNID, orderEntity.SettlementDate.Format("2006-01-02"))
+		logger.InfoContext(ctx, "Order is pending",
+			"settlement_date", orderEntity.SettlementDate.Format("2006-01-02"))
 
-		oms.ledger.Commit <- ledger.OrderPending{OrderNID: orderNID}
+		oms.ledger.Commit <- ledger.WithContext(ctx, ledger.OrderPending{OrderNID: orderNID})
 
 		// Pending orders stay in state "G" (Pending) and will be acknowledged during SOD
 		// when their settlement date arrives
-		log.Printf("📋 Order %d will be acknowledged during SOD on %s",
-			orderNID, orderEntity.SettlementDate.Format("2006-01-02"))
 		return
 	}
 
-	// Step 3: Acknowledge order (risk checks passed)
-	oms.ledger.Commit <- ledger.OrderAck{OrderNID: orderNID}
-	log.Printf("✅ Order %d acknowledged", orderNID)
+	// Step 3: Acknowledge order (risk checks passed), reserving its trading
+	// or pool limit. The hold covers the reservation until the ack is applied.
+	reserved := oms.validator.RequiredLimit(orderEntity)
+	oms.validator.Hold(orderEntity, reserved)
+	oms.ledger.Commit <- ledger.WithContext(ctx, ledger.OrderAck{OrderNID: orderNID, ReservedLimit: reserved})
+	logger.InfoContext(ctx, "Order acknowledged", "reserved_limit", reserved)
 	// Note: Matching will be performed when SyncOrderAck is received
 }
 
-// MatchOrder attempts to match an order by OrderNID
-func (oms *OMS) MatchOrder(orderNID int) {
+// MatchOrder attempts to match an order by OrderNID. ctx carries the trace of
+// the OrderAck, which the generated trades continue.
+func (oms *OMS) MatchOrder(ctx context.Context, orderNID int) {
+	ctx, span := tracer.Start(ctx, "oms.MatchOrder", trace.WithAttributes(attribute.Int("order.nid", orderNID)))
+	defer span.End()
+
 	// Get order from ledger
 	orderEntity, exists := oms.ledger.GetOrder(orderNID)
 	if !exists {
-		log.Printf("❌ Order %d not found in ledger", orderNID)
+		oms.logger.ErrorContext(ctx, "Order not found in ledger", logging.OrderNID(orderNID))
+		span.SetStatus(codes.Error, "order not found")
 		return
 	}
 
 	oms.mu.Lock()
 	defer oms.mu.Unlock()
 
-	log.Printf("🔄 Matching order: %d (%s %s %.0f shares)",
-		orderEntity.NID, orderEntity.Side, orderEntity.InstrumentCode, orderEntity.Quantity)
+	logger := oms.orderLogger(orderEntity)
+	logger.InfoContext(ctx, "Matching order", "side", orderEntity.Side, "quantity", orderEntity.Quantity)
 
 	// Check if instrument is eligible
 	if !oms.isInstrumentEligible(orderEntity.InstrumentCode) {
-		log.Printf("⚠️  Instrument %s is ineligible, order %d cannot be matched",
-			orderEntity.InstrumentCode, orderEntity.NID)
+		logger.WarnContext(ctx, "Instrument is ineligible, order cannot be matched")
 		// Add to order book but don't match
 		oms.matcher.AddOrder(orderEntity)
 		return
 	}
 
 	// Perform matching
-	matchResult := oms.matcher.Match(orderEntity)
+	matchResult := oms.matcher.Match(ctx, orderEntity)
+	span.SetAttributes(
+		attribute.Int("oms.matches", len(matchResult.Matches)),
+		attribute.Bool("oms.fully_matched", matchResult.FullyMatched),
+	)
 
 	// Generate trades for matches
 	if len(matchResult.Matches) > 0 {
-		trades := oms.tradeGen.GenerateTrades(matchResult.Matches)
+		matchesTotal.Add(float64(len(matchResult.Matches)))
+		trades, err := oms.tradeGen.GenerateTrades(ctx, matchResult.Matches)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to generate trades", "error", err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		tradesGenerated.Add(float64(len(trades)))
 
 		for _, trade := range trades {
-			log.Printf("📝 Generated trade: %s (%.0f shares)", trade.KpeiReff, trade.Quantity)
-			oms.ledger.Commit <- trade
+			logger.InfoContext(ctx, "Generated trade", logging.KpeiReff(trade.KpeiReff),
+				logging.TradeNID(trade.NID), "quantity", trade.Quantity)
+			oms.ledger.Commit <- ledger.WithContext(ctx, trade)
 		}
 	}
 
 	// If order is not fully matched, add remaining to order book
 	if !matchResult.FullyMatched {
 		oms.matcher.AddOrder(orderEntity)
-		log.Printf("📋 Order %d added to order book (%.0f shares remaining)",
-			orderEntity.NID, matchResult.RemainingQty)
+		logger.InfoContext(ctx, "Order added to order book", "remaining", matchResult.RemainingQty)
 	}
 }
 
 // ProcessOrderWithdraw handles order withdrawal by OrderNID
 func (oms *OMS) ProcessOrderWithdraw(orderNID int) {
-	log.Printf("📥 Processing withdrawal for order: %d", orderNID)
+	logger := oms.logger.With(logging.OrderNID(orderNID))
+	logger.Info("Processing withdrawal")
 
 	// Get order from ledger
 	orderEntity, exists := oms.ledger.GetOrder(orderNID)
 	if !exists {
-		log.Printf("❌ Order %d not found", orderNID)
+		logger.Warn("Order not found")
 		oms.ledger.Commit <- ledger.OrderWithdrawNak{
 			OrderNID: orderNID,
 			Message:  "Order not found",
@@ -178,7 +219,7 @@ func (oms *OMS) ProcessOrderWithdraw(orderNID int) {
 
 	// Check if order can be withdrawn (must be Open or Partial)
 	if orderEntity.State != "O" && orderEntity.State != "P" {
-		log.Printf("❌ Order %d cannot be withdrawn (state: %s)", orderNID, orderEntity.State)
+		logger.Warn("Order cannot be withdrawn", "state", orderEntity.State)
 		oms.ledger.Commit <- ledger.OrderWithdrawNak{
 			OrderNID: orderNID,
 			Message:  "Order cannot be withdrawn in current state",
@@ -192,12 +233,68 @@ func (oms *OMS) ProcessOrderWithdraw(orderNID int) {
 	oms.mu.Unlock()
 
 	if removed {
-		log.Printf("✅ Order %d removed from order book", orderNID)
+		logger.Info("Order removed from order book")
 	}
 
 	// Acknowledge withdrawal
 	oms.ledger.Commit <- ledger.OrderWithdrawAck{OrderNID: orderNID}
-	log.Printf("✅ Order %d withdrawal acknowledged", orderNID)
+	logger.Info("Order withdrawal acknowledged")
+}
+
+// StartOfDay opens the pending orders settling on date or before it. They go
+// through ProcessOrder again, so limits are checked with the day's state.
+// Nothing is opened on weekends and holidays.
+func (oms *OMS) StartOfDay(ctx context.Context, date time.Time) {
+	logger := oms.logger.With("date", date.Format("2006-01-02"))
+	if !oms.calendar.IsBusinessDay(date) {
+		logger.InfoContext(ctx, "Not a business day, no pending orders opened")
+		return
+	}
+
+	endOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location()).AddDate(0, 0, 1)
+	var pending []int
+	oms.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
+		if order.State == "G" && order.SettlementDate.Before(endOfDay) {
+			pending = append(pending, order.NID)
+		}
+		return true
+	})
+
+	for _, nid := range pending {
+		oms.ProcessOrder(ctx, nid)
+	}
+	logger.InfoContext(ctx, "Pending orders processed", "count", len(pending))
+}
+
+// EndOfDay drops the BORR orders still open or partially matched: they are
+// only valid for the day they were entered. Their withdrawal releases the
+// trading limit they reserved. Nothing happens on weekends and holidays.
+func (oms *OMS) EndOfDay(ctx context.Context, date time.Time) {
+	logger := oms.logger.With("date", date.Format("2006-01-02"))
+	if !oms.calendar.IsBusinessDay(date) {
+		logger.InfoContext(ctx, "Not a business day, no orders dropped")
+		return
+	}
+
+	var open []ledger.OrderEntity
+	oms.ledger.ForEachOrder(func(order ledger.OrderEntity) bool {
+		if order.Side == "BORR" && (order.State == "O" || order.State == "P") {
+			open = append(open, order)
+		}
+		return true
+	})
+
+	oms.mu.Lock()
+	for _, order := range open {
+		oms.matcher.RemoveOrder(order)
+	}
+	oms.mu.Unlock()
+
+	for _, order := range open {
+		oms.ledger.Commit <- ledger.WithContext(ctx, ledger.OrderWithdrawAck{OrderNID: order.NID})
+		oms.orderLogger(order).InfoContext(ctx, "Open borrow order dropped at EOD")
+	}
+	logger.InfoContext(ctx, "Open borrow orders dropped", "count", len(open))
 }
 
 // handleInstrumentIneligible handles instrument becoming ineligible
@@ -205,14 +302,14 @@ func (oms *OMS) handleInstrumentIneligible(instrumentCode string) {
 	oms.mu.Lock()
 	defer oms.mu.Unlock()
 
-	log.Printf("⚠️  Instrument %s became ineligible - blocking matching", instrumentCode)
+	oms.logger.Warn("Instrument became ineligible - blocking matching", logging.Instrument(instrumentCode))
 	oms.instrumentMap[instrumentCode] = false
 
 	// Find all open orders for this instrument and mark them as blocked
 	ineligibleOrders := oms.checker.GetIneligibleOrders()
 
 	for _, nid := range ineligibleOrders {
-		log.Printf("🚫 Blocking order %d due to instrument ineligibility", nid)
+		oms.logger.Warn("Blocking order due to instrument ineligibility", logging.OrderNID(nid))
 		// In a real implementation, you would emit OrderBlock event
 		// For now, we just log it
 	}
@@ -223,14 +320,24 @@ func (oms *OMS) handleInstrumentEligible(instrumentCode string) {
 	oms.mu.Lock()
 	defer oms.mu.Unlock()
 
-	log.Printf("✅ Instrument %s is now eligible - enabling matching", instrumentCode)
+	oms.logger.Info("Instrument is now eligible - enabling matching", logging.Instrument(instrumentCode))
 	oms.instrumentMap[instrumentCode] = true
 
 	// Re-match any orders that were blocked
-	log.Printf("🔄 Re-matching blocked orders for %s", instrumentCode)
+	oms.logger.Info("Re-matching blocked orders", logging.Instrument(instrumentCode))
 	// In a real implementation, you would trigger re-matching
 }
 
+// orderLogger returns the logger for messages about order
+func (oms *OMS) orderLogger(order ledger.OrderEntity) *slog.Logger {
+	return oms.logger.With(
+		logging.OrderNID(order.NID),
+		logging.Participant(order.ParticipantCode),
+		logging.Account(order.AccountCode),
+		logging.Instrument(order.InstrumentCode),
+	)
+}
+
 // isInstrumentEligible checks if an instrument is eligible for matching
 func (oms *OMS) isInstrumentEligible(instrumentCode string) bool {
 	// Check cached status first
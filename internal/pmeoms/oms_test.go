@@ -0,0 +1,377 @@
+package pmeoms
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"pmeonline/pkg/idgen"
+	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/ledger/risk"
+)
+
+// newIDGenerator returns the Snowflake generator of an OMS instance
+func newIDGenerator(t *testing.T, instance int64) *idgen.Generator {
+	t.Helper()
+	ids, err := idgen.NewGenerator(instance)
+	if err != nil {
+		t.Fatalf("NewGenerator(%d) error: %v", instance, err)
+	}
+	return ids
+}
+
+// waitFor polls cond until it returns true or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestOrderToTradeWait runs the OMS and a stand-in for the eClear API on a
+// shared in-memory log and follows a LEND/BORR pair from order entry to
+// TradeWait
+func TestOrderToTradeWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := ledger.NewMemoryTransport("pme-ledger")
+	defer transport.Close()
+
+	// OMS service, on a business day whatever day the test runs
+	now := time.Date(2025, 11, 26, 10, 0, 0, 0, time.Local)
+	omsLedger := ledger.CreateLedgerPointWithTransport(transport, "pmeoms")
+	omsLedger.SetClock(ledger.NewFakeClock(now))
+	omsEngine := NewOMS(omsLedger, newIDGenerator(t, 1), slog.Default())
+	omsLedger.Start([]ledger.LedgerPointInterface{NewSyncHandler(omsEngine, omsLedger)}, ctx)
+
+	// eClear side: publishes master data and confirms trades
+	eclear := ledger.CreateLedgerPointWithTransport(transport, "eclearapi")
+	eclear.Start(nil, ctx)
+
+	waitFor(t, "ledger points to be ready", func() bool {
+		return omsLedger.IsReady() && eclear.IsReady()
+	})
+
+	publishMasterData(eclear)
+
+	settlement := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	reimbursement := settlement.Add(7 * 24 * time.Hour)
+
+	eclear.Commit <- ledger.Order{
+		NID: 101, ReffRequestID: "lend-1",
+		AccountNID: 1, AccountCode: "YU-001", ParticipantNID: 1, ParticipantCode: "YU",
+		InstrumentNID: 1, InstrumentCode: "BBCA", Side: "LEND", Quantity: 1000,
+		SettlementDate: settlement, ReimbursementDate: reimbursement, Periode: 7, MarketPrice: 1000,
+	}
+	waitFor(t, "lend order to open", func() bool {
+		order, _ := omsLedger.GetOrder(101)
+		return order.State == "O"
+	})
+
+	eclear.Commit <- ledger.Order{
+		NID: 102, ReffRequestID: "borr-1",
+		AccountNID: 2, AccountCode: "ZP-001", ParticipantNID: 2, ParticipantCode: "ZP",
+		InstrumentNID: 1, InstrumentCode: "BBCA", Side: "BORR", Quantity: 1000,
+		SettlementDate: settlement, ReimbursementDate: reimbursement, Periode: 7, MarketPrice: 1000,
+	}
+
+	var trade ledger.TradeEntity
+	waitFor(t, "trade to be generated", func() bool {
+		eclear.ForEachTrade(func(te ledger.TradeEntity) bool {
+			trade = te
+			return false
+		})
+		return trade.NID != 0
+	})
+
+	if trade.Quantity != 1000 || len(trade.Borrower) != 1 || len(trade.Lender) != 1 {
+		t.Fatalf("unexpected trade: %+v", trade)
+	}
+	for _, nid := range []int{101, 102} {
+		if order, _ := eclear.GetOrder(nid); order.State != "M" || order.DoneQuantity != 1000 {
+			t.Errorf("order %d: state %q done %.0f, want M/1000", nid, order.State, order.DoneQuantity)
+		}
+	}
+
+	eclear.Commit <- ledger.TradeWait{TradeNID: trade.NID}
+	waitFor(t, "trade to wait for approval on the OMS side", func() bool {
+		te, _ := omsLedger.GetTrade(trade.NID)
+		return te.State == "E"
+	})
+	for _, nid := range trade.Borrower {
+		if contract, _ := omsLedger.GetContract(nid); contract.State != "E" {
+			t.Errorf("contract %d: state %q, want E", nid, contract.State)
+		}
+	}
+}
+
+// publishMasterData commits the parameter, instrument BBCA and participants
+// YU and ZP with one account each
+func publishMasterData(eclear *ledger.LedgerPoint) {
+	eclear.Commit <- ledger.Parameter{
+		NID:               1,
+		MaxQuantity:       1000000,
+		BorrowMaxOpenDay:  30,
+		DenominationLimit: 100,
+	}
+	eclear.Commit <- ledger.Instrument{NID: 1, Code: "BBCA", Name: "Bank Central Asia", Status: true}
+	for i, code := range []string{"YU", "ZP"} {
+		eclear.Commit <- ledger.Participant{NID: i + 1, Code: code, Name: code, BorrEligibility: true, LendEligibility: true}
+		eclear.Commit <- ledger.Account{NID: i + 1, Code: code + "-001", SID: "SID" + code, Name: code, ParticipantNID: i + 1, ParticipantCode: code}
+		eclear.Commit <- ledger.AccountLimit{NID: i + 1, Code: code + "-001", AccountNID: i + 1, TradeLimit: 1e12, PoolLimit: 1e12}
+	}
+}
+
+// TestStartAndEndOfDay follows a BORR order entered for the next business
+// day: pending until the SOD of its settlement date, dropped at its EOD. An
+// order whose SOD was missed checks that a holiday SOD opens nothing.
+func TestStartAndEndOfDay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := ledger.NewMemoryTransport("pme-ledger")
+	defer transport.Close()
+
+	// Tuesday before Christmas 2025
+	clock := ledger.NewFakeClock(time.Date(2025, 12, 23, 10, 0, 0, 0, time.Local))
+	omsLedger := ledger.CreateLedgerPointWithTransport(transport, "pmeoms")
+	omsLedger.SetClock(clock)
+	omsEngine := NewOMS(omsLedger, newIDGenerator(t, 1), slog.Default())
+	omsLedger.Start([]ledger.LedgerPointInterface{NewSyncHandler(omsEngine, omsLedger)}, ctx)
+
+	eclear := ledger.CreateLedgerPointWithTransport(transport, "eclearapi")
+	eclear.Start(nil, ctx)
+	waitFor(t, "ledger points to be ready", func() bool {
+		return omsLedger.IsReady() && eclear.IsReady()
+	})
+
+	publishMasterData(eclear)
+	christmas := time.Date(2025, 12, 25, 0, 0, 0, 0, time.Local)
+	eclear.Commit <- ledger.Holiday{NID: 1, Tahun: 2025, Date: christmas}
+
+	// For Wednesday, whose SOD never runs
+	missed := time.Date(2025, 12, 24, 0, 0, 0, 0, time.Local)
+	eclear.Commit <- ledger.Order{
+		NID: 202, ReffRequestID: "borr-missed",
+		AccountNID: 2, AccountCode: "ZP-001", ParticipantNID: 2, ParticipantCode: "ZP",
+		InstrumentNID: 1, InstrumentCode: "BBCA", Side: "BORR", Quantity: 1000,
+		SettlementDate: missed, ReimbursementDate: missed.AddDate(0, 0, 7), Periode: 7, MarketPrice: 1000,
+	}
+	waitFor(t, "order of the missed SOD to be pending", func() bool {
+		order, _ := omsLedger.GetOrder(202)
+		return order.State == "G"
+	})
+
+	clock.Set(missed.Add(10 * time.Hour))
+	settlement := time.Date(2025, 12, 26, 0, 0, 0, 0, time.Local)
+	eclear.Commit <- ledger.Order{
+		NID: 201, ReffRequestID: "borr-sod",
+		AccountNID: 2, AccountCode: "ZP-001", ParticipantNID: 2, ParticipantCode: "ZP",
+		InstrumentNID: 1, InstrumentCode: "BBCA", Side: "BORR", Quantity: 1000,
+		SettlementDate: settlement, ReimbursementDate: settlement.AddDate(0, 0, 7), Periode: 7, MarketPrice: 1000,
+	}
+	waitFor(t, "order to be pending", func() bool {
+		order, _ := omsLedger.GetOrder(201)
+		return order.State == "G"
+	})
+
+	// Nothing opens on Christmas
+	clock.Set(christmas.Add(8 * time.Hour))
+	omsEngine.StartOfDay(ctx, christmas)
+	// A marker queued after whatever SOD committed: once it is applied, so
+	// would an OrderAck of SOD be
+	if _, err := omsLedger.CommitSync(ctx, ledger.Holiday{NID: 2, Tahun: 2026, Date: time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)}); err != nil {
+		t.Fatalf("CommitSync(marker) error: %v", err)
+	}
+	waitFor(t, "marker to be applied", func() bool { return omsLedger.AppliedEvents()["Holiday"] == 2 })
+	for _, nid := range []int{201, 202} {
+		if order, _ := omsLedger.GetOrder(nid); order.State != "G" {
+			t.Fatalf("order %d: state after a holiday SOD = %q, want G", nid, order.State)
+		}
+	}
+
+	clock.Set(settlement.Add(8 * time.Hour))
+	omsEngine.StartOfDay(ctx, settlement)
+	waitFor(t, "order to open at SOD", func() bool {
+		order, _ := omsLedger.GetOrder(201)
+		return order.State == "O" && order.ReservedLimit > 0
+	})
+
+	clock.Set(settlement.Add(17 * time.Hour))
+	omsEngine.EndOfDay(ctx, settlement)
+	waitFor(t, "order to be dropped at EOD", func() bool {
+		order, _ := omsLedger.GetOrder(201)
+		return order.State == "W" && order.ReservedLimit == 0
+	})
+}
+
+func TestRejectReason(t *testing.T) {
+	if got := rejectReason(&risk.ValidationError{Field: "Quantity", Message: "must be greater than 0"}); got != "Quantity" {
+		t.Errorf("rejectReason(ValidationError) = %q, want Quantity", got)
+	}
+	if got := rejectReason(errors.New("boom")); got != "other" {
+		t.Errorf("rejectReason(other error) = %q, want other", got)
+	}
+}
+
+// TestPartialOrderRestoredFromSnapshot restores a LEND order that was
+// partially matched before the snapshot and checks InitOrders puts its
+// remainder back in the order book
+func TestPartialOrderRestoredFromSnapshot(t *testing.T) {
+	settlement := time.Date(2025, 11, 26, 0, 0, 0, 0, time.Local)
+	reimbursement := settlement.Add(7 * 24 * time.Hour)
+	order := func(nid int, account string, side string, quantity float64) ledger.Order {
+		return ledger.Order{
+			NID: nid, AccountCode: account + "-001", ParticipantCode: account,
+			InstrumentCode: "BBCA", Side: side, Quantity: quantity,
+			SettlementDate: settlement, ReimbursementDate: reimbursement, Periode: 7, MarketPrice: 1000,
+		}
+	}
+
+	src := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "pmeoms")
+	src.SyncInstrument(ledger.Instrument{NID: 1, Code: "BBCA", Status: true})
+	for _, o := range []ledger.Order{order(101, "YU", "LEND", 1000), order(102, "ZP", "BORR", 400), order(103, "ZP", "BORR", 1000)} {
+		src.SyncOrder(o)
+		src.SyncOrderAck(ledger.OrderAck{OrderNID: o.NID})
+	}
+	src.SyncTrade(ledger.Trade{
+		NID: 20, KpeiReff: "PME-20", Quantity: 400,
+		Lender:   []ledger.Contract{{NID: 201, TradeNID: 20, OrderNID: 101, Side: "LEND", Quantity: 400}},
+		Borrower: []ledger.Contract{{NID: 202, TradeNID: 20, OrderNID: 102, Side: "BORR", Quantity: 400}},
+	})
+	if lend, _ := src.GetOrder(101); lend.State != "P" || lend.DoneQuantity != 400 {
+		t.Fatalf("order 101: state %q done %.0f, want P/400", lend.State, lend.DoneQuantity)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	omsLedger := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "pmeoms")
+	omsLedger.RestoreSnapshot(src.CaptureSnapshot(10))
+	omsLedger.Start(nil, ctx)
+	waitFor(t, "ledger point to be ready", omsLedger.IsReady)
+
+	omsEngine := NewOMS(omsLedger, newIDGenerator(t, 1), slog.Default())
+	omsEngine.InitOrders()
+
+	// Whichever order is matched first, the BORR order of 1000 gets the 600
+	// left of the LEND order
+	var trade ledger.TradeEntity
+	waitFor(t, "trade to be generated", func() bool {
+		omsLedger.ForEachTrade(func(te ledger.TradeEntity) bool {
+			if te.NID != 20 {
+				trade = te
+			}
+			return trade.NID == 0
+		})
+		return trade.NID != 0
+	})
+	if trade.Quantity != 600 {
+		t.Errorf("trade quantity %.0f, want the 600 left of order 101", trade.Quantity)
+	}
+}
+
+// TestTradesOnSeparatePartitions runs two OMS instances, each matching the
+// instruments of one partition at the same time, and checks their trades and
+// contracts don't share NIDs
+func TestTradesOnSeparatePartitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transports := []ledger.Transport{ledger.NewMemoryTransport("pme-ledger"), ledger.NewMemoryTransport("pme-ledger"), ledger.NewMemoryTransport("pme-ledger")}
+	second := ""
+	for _, code := range []string{"BBRI", "TLKM", "ASII", "BMRI", "UNVR"} {
+		if ledger.PartitionFor(code, len(transports)) != ledger.PartitionFor("BBCA", len(transports)) {
+			second = code
+			break
+		}
+	}
+	instruments := []string{"BBCA", second}
+
+	now := time.Date(2025, 11, 26, 10, 0, 0, 0, time.Local)
+	var omsLedgers []*ledger.LedgerPoint
+	for i, code := range instruments {
+		omsLedger, err := ledger.CreateLedgerPointWithPartitions(transports, []int{ledger.PartitionFor(code, len(transports))}, "pmeoms-"+code)
+		if err != nil {
+			t.Fatalf("CreateLedgerPointWithPartitions() error: %v", err)
+		}
+		omsLedger.SetClock(ledger.NewFakeClock(now))
+		omsEngine := NewOMS(omsLedger, newIDGenerator(t, int64(i+1)), slog.Default())
+		omsLedger.Start([]ledger.LedgerPointInterface{NewSyncHandler(omsEngine, omsLedger)}, ctx)
+		waitFor(t, "OMS ledger point to be ready", omsLedger.IsReady)
+		omsLedgers = append(omsLedgers, omsLedger)
+	}
+
+	eclear, err := ledger.CreateLedgerPointWithPartitions(transports, nil, "eclearapi")
+	if err != nil {
+		t.Fatalf("CreateLedgerPointWithPartitions() error: %v", err)
+	}
+	eclear.Start(nil, ctx)
+	waitFor(t, "eClear ledger point to be ready", eclear.IsReady)
+
+	publishMasterData(eclear)
+	eclear.Commit <- ledger.Instrument{NID: 2, Code: second, Name: second, Status: true}
+	// Limits can't be checked on a partition, checking them is left to KPEI
+	eclear.Commit <- ledger.RiskRule{Name: "trade-limit", Enabled: false, Priority: 110}
+	eclear.Commit <- ledger.RiskRule{Name: "pool-limit", Enabled: false, Priority: 120}
+	for _, omsLedger := range omsLedgers {
+		// Partitions are not ordered against the control partition
+		waitFor(t, "limits to be disabled", func() bool {
+			_, ok := omsLedger.GetRiskRule("pool-limit")
+			return ok
+		})
+	}
+
+	settlement := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	reimbursement := settlement.Add(7 * 24 * time.Hour)
+	for i, code := range instruments {
+		eclear.Commit <- ledger.Order{
+			NID: 301 + 2*i, ReffRequestID: "lend-" + code,
+			AccountNID: 1, AccountCode: "YU-001", ParticipantNID: 1, ParticipantCode: "YU",
+			InstrumentNID: i + 1, InstrumentCode: code, Side: "LEND", Quantity: 1000,
+			SettlementDate: settlement, ReimbursementDate: reimbursement, Periode: 7, MarketPrice: 1000,
+		}
+	}
+	waitFor(t, "lend orders to open", func() bool {
+		first, _ := eclear.GetOrder(301)
+		second, _ := eclear.GetOrder(303)
+		return first.State == "O" && second.State == "O"
+	})
+	for i, code := range instruments {
+		eclear.Commit <- ledger.Order{
+			NID: 302 + 2*i, ReffRequestID: "borr-" + code,
+			AccountNID: 2, AccountCode: "ZP-001", ParticipantNID: 2, ParticipantCode: "ZP",
+			InstrumentNID: i + 1, InstrumentCode: code, Side: "BORR", Quantity: 1000,
+			SettlementDate: settlement, ReimbursementDate: reimbursement, Periode: 7, MarketPrice: 1000,
+		}
+	}
+
+	trades := map[string]ledger.TradeEntity{}
+	waitFor(t, "a trade in each instrument", func() bool {
+		eclear.ForEachTrade(func(te ledger.TradeEntity) bool {
+			trades[te.InstrumentCode] = te
+			return true
+		})
+		return len(trades) == len(instruments)
+	})
+
+	nids := map[int]bool{}
+	for i, code := range instruments {
+		trade := trades[code]
+		if instance := idgen.GetInstanceIDFromID(int64(trade.NID)); instance != int64(i+1) {
+			t.Errorf("trade %d in %s from instance %d, want %d", trade.NID, code, instance, i+1)
+		}
+		for _, nid := range append(append([]int{trade.NID}, trade.Borrower...), trade.Lender...) {
+			if nids[nid] {
+				t.Errorf("NID %d used twice", nid)
+			}
+			nids[nid] = true
+		}
+	}
+}
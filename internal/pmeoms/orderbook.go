@@ -13,6 +13,7 @@ type OrderBook struct {
 	InstrumentCode string
 	BorrowOrders   *OrderQueue // Borrowing orders
 	LendOrders     *OrderQueue // Lending orders
+	clock          ledger.Clock
 	mu             sync.RWMutex
 }
 
@@ -30,9 +31,10 @@ type QueuedOrder struct {
 }
 
 // NewOrderBook creates a new order book for an instrument
-func NewOrderBook(instrumentCode string) *OrderBook {
+func NewOrderBook(instrumentCode string, clock ledger.Clock) *OrderBook {
 	return &OrderBook{
 		InstrumentCode: instrumentCode,
+		clock:          clock,
 		BorrowOrders: &OrderQueue{
 			Orders: make([]*QueuedOrder, 0),
 		},
@@ -49,7 +51,7 @@ func (ob *OrderBook) AddOrder(order ledger.OrderEntity) {
 
 	queuedOrder := &QueuedOrder{
 		Order:    order,
-		QueuedAt: time.Now(),
+		QueuedAt: ob.clock.Now(),
 	}
 
 	switch order.Side {
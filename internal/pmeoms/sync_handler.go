@@ -1,15 +1,17 @@
 package pmeoms
 
 import (
-	"log"
+	"log/slog"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 // SyncHandler implements LedgerPointInterface to receive events
 type SyncHandler struct {
 	oms    *OMS
 	ledger *ledger.LedgerPoint
+	logger *slog.Logger
 }
 
 // NewSyncHandler creates a new sync handler
@@ -17,132 +19,139 @@ func NewSyncHandler(oms *OMS, ledger *ledger.LedgerPoint) *SyncHandler {
 	return &SyncHandler{
 		oms:    oms,
 		ledger: ledger,
+		logger: oms.logger,
 	}
 }
 
 func (h *SyncHandler) SyncServiceStart(a ledger.ServiceStart) {
-	log.Printf("[OMS] Service started: %s", a.StartID)
+	h.logger.Info("Service started", "start_id", a.StartID)
 	// Note: Order initialization is handled by InitOrders() called after ledger is ready
 }
 
 func (h *SyncHandler) SyncParameter(a ledger.Parameter) {
-	log.Printf("[OMS] Parameter updated: FlatFee=%.4f, BorrowFee=%.4f, LendFee=%.4f",
-		a.FlatFee, a.BorrowingFee, a.LendingFee)
+	h.logger.Info("Parameter updated", "flat_fee", a.FlatFee, "borrowing_fee", a.BorrowingFee,
+		"lending_fee", a.LendingFee)
 }
 
 func (h *SyncHandler) SyncSessionTime(a ledger.SessionTime) {
-	log.Printf("[OMS] Session time updated: %s", a.Description)
+	h.logger.Info("Session time updated", "description", a.Description)
 }
 
 func (h *SyncHandler) SyncHoliday(a ledger.Holiday) {
-	log.Printf("[OMS] Holiday added: %s (%s)", a.Date.Format("2006-01-02"), a.Description)
+	h.logger.Info("Holiday added", "date", a.Date.Format("2006-01-02"), "description", a.Description)
 }
 
 func (h *SyncHandler) SyncAccount(a ledger.Account) {
-	log.Printf("[OMS] Account synced: %s (%s)", a.Code, a.Name)
+	h.logger.Info("Account synced", logging.Account(a.Code), "name", a.Name)
 }
 
 func (h *SyncHandler) SyncAccountLimit(a ledger.AccountLimit) {
-	log.Printf("[OMS] Account limit updated: %s - TradeLimit=%.2f, PoolLimit=%.2f",
-		a.Code, a.TradeLimit, a.PoolLimit)
+	h.logger.Info("Account limit updated", logging.Account(a.Code), "trade_limit", a.TradeLimit,
+		"pool_limit", a.PoolLimit)
 }
 
 func (h *SyncHandler) SyncParticipant(a ledger.Participant) {
-	log.Printf("[OMS] Participant synced: %s (%s) - Borr:%v, Lend:%v",
-		a.Code, a.Name, a.BorrEligibility, a.LendEligibility)
+	h.logger.Info("Participant synced", logging.Participant(a.Code), "name", a.Name,
+		"borr_eligibility", a.BorrEligibility, "lend_eligibility", a.LendEligibility)
 }
 
 func (h *SyncHandler) SyncInstrument(a ledger.Instrument) {
-	log.Printf("[OMS] Instrument synced: %s (%s) - Eligible:%v",
-		a.Code, a.Name, a.Status)
+	h.logger.Info("Instrument synced", logging.Instrument(a.Code), "name", a.Name, "eligible", a.Status)
 }
 
 func (h *SyncHandler) SyncOrder(a ledger.Order) {
-	log.Printf("[OMS] Order event: %d (%s %s %.0f shares)",
-		a.NID, a.Side, a.InstrumentCode, a.Quantity)
+	h.logger.Info("Order event", logging.OrderNID(a.NID), logging.Participant(a.ParticipantCode),
+		logging.Account(a.AccountCode), logging.Instrument(a.InstrumentCode),
+		"side", a.Side, "quantity", a.Quantity)
 
 	// Only process orders after initial sync is complete
-	// During initial sync (IsReady=false), we're replaying historical events
-	if h.ledger.IsReady {
-		log.Printf("[OMS] Processing new order: %d", a.NID)
-		h.oms.ProcessOrder(a.NID)
+	// Until Ready is closed we are replaying historical events
+	if h.ledger.IsReady() {
+		h.oms.ProcessOrder(h.ledger.CurrentContext(), a.NID)
 	}
 }
 
 func (h *SyncHandler) SyncOrderAck(a ledger.OrderAck) {
-	log.Printf("[OMS] Order acknowledged: %d", a.OrderNID)
+	h.logger.Info("Order acknowledged", logging.OrderNID(a.OrderNID), "reserved_limit", a.ReservedLimit)
+
+	// The ledger holds the reservation now
+	h.oms.validator.Release(a.OrderNID)
 
 	// Only perform matching after initial sync is complete
-	if h.ledger.IsReady {
-		log.Printf("[OMS] Attempting to match acknowledged order: %d", a.OrderNID)
-		h.oms.MatchOrder(a.OrderNID)
+	if h.ledger.IsReady() {
+		h.oms.MatchOrder(h.ledger.CurrentContext(), a.OrderNID)
 	}
 }
 
 func (h *SyncHandler) SyncOrderNak(a ledger.OrderNak) {
-	log.Printf("[OMS] Order rejected: %d - %s", a.OrderNID, a.Message)
+	h.logger.Info("Order rejected", logging.OrderNID(a.OrderNID), "message", a.Message)
 }
 
 func (h *SyncHandler) SyncOrderPending(a ledger.OrderPending) {
-	log.Printf("[OMS] Order pending: %d", a.OrderNID)
+	h.logger.Info("Order pending", logging.OrderNID(a.OrderNID))
 }
 
 func (h *SyncHandler) SyncOrderWithdraw(a ledger.OrderWithdraw) {
-	log.Printf("[OMS] Order withdrawal event: %d", a.OrderNID)
+	h.logger.Info("Order withdrawal event", logging.OrderNID(a.OrderNID))
 
 	// Only process withdrawals after initial sync is complete
-	if h.ledger.IsReady {
-		log.Printf("[OMS] Processing order withdrawal: %d", a.OrderNID)
+	if h.ledger.IsReady() {
 		h.oms.ProcessOrderWithdraw(a.OrderNID)
 	}
 }
 
 func (h *SyncHandler) SyncOrderWithdrawAck(a ledger.OrderWithdrawAck) {
-	log.Printf("[OMS] Order withdrawal acknowledged: %d", a.OrderNID)
+	h.logger.Info("Order withdrawal acknowledged", logging.OrderNID(a.OrderNID))
 }
 
 func (h *SyncHandler) SyncOrderWithdrawNak(a ledger.OrderWithdrawNak) {
-	log.Printf("[OMS] Order withdrawal rejected: %d - %s", a.OrderNID, a.Message)
+	h.logger.Info("Order withdrawal rejected", logging.OrderNID(a.OrderNID), "message", a.Message)
 }
 
 func (h *SyncHandler) SyncTrade(a ledger.Trade) {
-	log.Printf("[OMS] Trade created: %s (%.0f shares)", a.KpeiReff, a.Quantity)
+	h.logger.Info("Trade created", logging.TradeNID(a.NID), logging.KpeiReff(a.KpeiReff), "quantity", a.Quantity)
 }
 
 func (h *SyncHandler) SyncTradeWait(a ledger.TradeWait) {
-	log.Printf("[OMS] Trade waiting for eClear approval: NID %d", a.TradeNID)
+	h.logger.Info("Trade waiting for eClear approval", logging.TradeNID(a.TradeNID))
 }
 
 func (h *SyncHandler) SyncTradeAck(a ledger.TradeAck) {
-	log.Printf("[OMS] Trade approved by eClear: NID %d", a.TradeNID)
+	h.logger.Info("Trade approved by eClear", logging.TradeNID(a.TradeNID))
 }
 
 func (h *SyncHandler) SyncTradeNak(a ledger.TradeNak) {
-	log.Printf("[OMS] Trade rejected by eClear: NID %d - %s", a.TradeNID, a.Message)
+	h.logger.Info("Trade rejected by eClear", logging.TradeNID(a.TradeNID), "message", a.Message)
 }
 
 func (h *SyncHandler) SyncTradeReimburse(a ledger.TradeReimburse) {
-	log.Printf("[OMS] Trade reimbursed: NID %d", a.TradeNID)
+	h.logger.Info("Trade reimbursed", logging.TradeNID(a.TradeNID))
 }
 
 func (h *SyncHandler) SyncContract(a ledger.Contract) {
-	log.Printf("[OMS] Contract created: %s (%s side, %.0f shares)",
-		a.KpeiReff, a.Side, a.Quantity)
+	h.logger.Info("Contract created", logging.ContractNID(a.NID), logging.TradeNID(a.TradeNID),
+		logging.KpeiReff(a.KpeiReff), "side", a.Side, "quantity", a.Quantity)
 }
 
 func (h *SyncHandler) SyncSod(a ledger.Sod) {
-	log.Printf("[OMS] 🌅 Start of Day: %s", a.Date.Format("2006-01-02"))
-	// TODO: Implement SOD processing
-	// - Activate orders with today's settlement date
-	// - Process pending orders
-	log.Printf("[OMS] SOD processing complete")
+	h.logger.Info("Start of Day", "date", a.Date.Format("2006-01-02"))
+
+	// Open the pending orders settling today
+	if h.ledger.IsReady() {
+		h.oms.StartOfDay(h.ledger.CurrentContext(), a.Date)
+	}
+	h.logger.Info("SOD processing complete")
 }
 
 func (h *SyncHandler) SyncEod(a ledger.Eod) {
-	log.Printf("[OMS] 🌆 End of Day: %s", a.Date.Format("2006-01-02"))
-	// TODO: Implement EOD processing
+	h.logger.Info("End of Day", "date", a.Date.Format("2006-01-02"))
+
+	// Drop open BORR orders (BORR orders valid for 1 day only)
+	if h.ledger.IsReady() {
+		h.oms.EndOfDay(h.ledger.CurrentContext(), a.Date)
+	}
+	// TODO: Implement the rest of EOD processing
 	// - Calculate daily fees
-	// - Drop open BORR orders (BORR orders valid for 1 day only)
 	// - Generate EOD reports
-	log.Printf("[OMS] EOD processing complete")
+	h.logger.Info("EOD processing complete")
 }
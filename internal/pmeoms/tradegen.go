@@ -1,9 +1,14 @@
 package pmeoms
 
 import (
+	"context"
 	"fmt"
-	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"pmeonline/pkg/idgen"
 	"pmeonline/pkg/ledger"
 	"pmeonline/pkg/ledger/risk"
 )
@@ -11,24 +16,45 @@ import (
 // TradeGenerator handles trade and contract generation
 type TradeGenerator struct {
 	calculator *risk.Calculator
-	tradeIDSeq int
+	clock      ledger.Clock
+	ids        *idgen.Generator // Trade and contract NIDs
 }
 
-// NewTradeGenerator creates a new trade generator
-func NewTradeGenerator(calc *risk.Calculator) *TradeGenerator {
+// NewTradeGenerator creates a new trade generator. ids must be unique to the
+// OMS instance, so instances matching other partitions never generate the
+// same trade or contract NIDs.
+func NewTradeGenerator(calc *risk.Calculator, clock ledger.Clock, ids *idgen.Generator) *TradeGenerator {
 	return &TradeGenerator{
 		calculator: calc,
-		tradeIDSeq: 1,
+		clock:      clock,
+		ids:        ids,
+	}
+}
+
+// nextNIDs returns n new NIDs
+func (tg *TradeGenerator) nextNIDs(n int) ([]int, error) {
+	nids := make([]int, n)
+	for i := range nids {
+		id, err := tg.ids.NextID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate NID: %w", err)
+		}
+		nids[i] = int(id)
 	}
+	return nids, nil
 }
 
 // GenerateTrade creates a Trade and Contracts from a match
-func (tg *TradeGenerator) GenerateTrade(match Match) ledger.Trade {
-	// Generate unique trade reference
-	tradeNID := int(ledger.GetCurrentTimeMillis()) + tg.tradeIDSeq
-	tg.tradeIDSeq++
+func (tg *TradeGenerator) GenerateTrade(match Match) (ledger.Trade, error) {
+	// Trade, borrower and lender contract NIDs
+	nids, err := tg.nextNIDs(3)
+	if err != nil {
+		return ledger.Trade{}, err
+	}
+	tradeNID := nids[0]
 
-	kpeiReff := fmt.Sprintf("PME-%s-%d", time.Now().Format("20060102"), tradeNID)
+	now := tg.clock.Now()
+	kpeiReff := fmt.Sprintf("PME-%s-%d", now.Format("20060102"), tradeNID)
 
 	// Get fee rates
 	flatFeeRate, borrowFeeRate, lendFeeRate := tg.calculator.GetFeeRates()
@@ -61,7 +87,7 @@ func (tg *TradeGenerator) GenerateTrade(match Match) ledger.Trade {
 
 	// Create borrower contract
 	borrowerContract := ledger.Contract{
-		NID:                    tradeNID*10 + 1,
+		NID:                    nids[1],
 		TradeNID:               tradeNID,
 		KpeiReff:               kpeiReff + "-BORR",
 		Side:                   "BORR",
@@ -79,13 +105,13 @@ func (tg *TradeGenerator) GenerateTrade(match Match) ledger.Trade {
 		FeeFlatVal:             flatFee,
 		FeeValDaily:            borrowDailyFee,
 		FeeValAccumulated:      0, // Will be updated daily
-		MatchedAt:              time.Now(),
+		MatchedAt:              now,
 		ReimburseAt:            reimbursementDate,
 	}
 
 	// Create lender contract
 	lenderContract := ledger.Contract{
-		NID:                    tradeNID*10 + 2,
+		NID:                    nids[2],
 		TradeNID:               tradeNID,
 		KpeiReff:               kpeiReff + "-LEND",
 		Side:                   "LEND",
@@ -103,7 +129,7 @@ func (tg *TradeGenerator) GenerateTrade(match Match) ledger.Trade {
 		FeeFlatVal:             0,   // Lender doesn't pay flat fee
 		FeeValDaily:            lendDailyFee,
 		FeeValAccumulated:      0, // Will be updated daily
-		MatchedAt:              time.Now(),
+		MatchedAt:              now,
 		ReimburseAt:            reimbursementDate,
 	}
 
@@ -119,23 +145,35 @@ func (tg *TradeGenerator) GenerateTrade(match Match) ledger.Trade {
 		FeeFlatRate:    flatFeeRate,
 		FeeBorrRate:    borrowFeeRate,
 		FeeLendRate:    lendFeeRate,
-		MatchedAt:      time.Now(),
+		MatchedAt:      now,
 		ReimburseAt:    reimbursementDate,
 		Lender:         []ledger.Contract{lenderContract},
 		Borrower:       []ledger.Contract{borrowerContract},
 	}
 
-	return trade
+	return trade, nil
 }
 
-// GenerateTrades creates multiple trades from a match result
-func (tg *TradeGenerator) GenerateTrades(matches []Match) []ledger.Trade {
+// GenerateTrades creates multiple trades from a match result. On error it
+// returns the trades generated so far.
+func (tg *TradeGenerator) GenerateTrades(ctx context.Context, matches []Match) ([]ledger.Trade, error) {
+	_, span := tracer.Start(ctx, "oms.GenerateTrades", trace.WithAttributes(attribute.Int("oms.matches", len(matches))))
+	defer span.End()
+
 	trades := make([]ledger.Trade, 0, len(matches))
 
 	for _, match := range matches {
-		trade := tg.GenerateTrade(match)
+		trade, err := tg.GenerateTrade(match)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return trades, err
+		}
 		trades = append(trades, trade)
+		span.AddEvent("trade generated", trace.WithAttributes(
+			attribute.Int("trade.nid", trade.NID),
+			attribute.String("trade.kpei_reff", trade.KpeiReff),
+		))
 	}
 
-	return trades
+	return trades, nil
 }
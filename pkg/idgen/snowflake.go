@@ -2,6 +2,8 @@ package idgen
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -152,3 +154,18 @@ func GetSequence(id int64) int64 {
 	_, _, sequence := ParseID(id)
 	return sequence
 }
+
+// GeneratorFromEnv creates a generator for the instance ID in INSTANCE_ID.
+// There is no default: replicas of a service sharing an instance would
+// generate the same IDs.
+func GeneratorFromEnv() (*Generator, error) {
+	s, ok := os.LookupEnv("INSTANCE_ID")
+	if !ok || s == "" {
+		return nil, fmt.Errorf("INSTANCE_ID is required (0-%d, unique per replica)", MaxInstance)
+	}
+	instanceID, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("INSTANCE_ID %q is not a number", s)
+	}
+	return NewGenerator(instanceID)
+}
@@ -254,3 +254,22 @@ func BenchmarkNextIDParallel(b *testing.B) {
 		}
 	})
 }
+
+func TestGeneratorFromEnv(t *testing.T) {
+	for _, value := range []string{"", "x", "1024"} {
+		t.Setenv("INSTANCE_ID", value)
+		if _, err := GeneratorFromEnv(); err == nil {
+			t.Errorf("GeneratorFromEnv() with INSTANCE_ID=%q returned no error", value)
+		}
+	}
+
+	t.Setenv("INSTANCE_ID", "7")
+	g, err := GeneratorFromEnv()
+	if err != nil {
+		t.Fatalf("GeneratorFromEnv() error: %v", err)
+	}
+	id, _ := g.NextID()
+	if instance := GetInstanceIDFromID(id); instance != 7 {
+		t.Errorf("instance of generated ID = %d, want 7", instance)
+	}
+}
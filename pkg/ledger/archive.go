@@ -0,0 +1,328 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"pmeonline/pkg/logging"
+)
+
+// Archive keeps closed day segments of a ledger topic in a local directory,
+// so state can be rebuilt after Kafka retention deleted the early events.
+//
+// Each segment covers the messages from the first one after the previous
+// segment up to and including an Eod, and is stored as two files:
+//
+//	<topic>-<first offset>-<last offset>.jsonl.gz         gzip compressed, one JSON message per line
+//	<topic>-<first offset>-<last offset>.jsonl.gz.sha256  SHA-256 of the .gz file, in sha256sum format
+//
+// Offsets are zero padded to 20 digits so the files sort in log order. A
+// line holds the message exactly as it was read from Kafka, payloads still
+// encrypted and signed:
+//
+//	{"offset":42,"time":"2025-11-24T16:00:01+07:00","key":"<base64>","value":"<base64>",
+//	 "headers":[{"key":"event-type","value":"<base64>"},...]}
+//
+// The checksum file is written last, so segments without one are incomplete
+// and ignored.
+type Archive struct {
+	Dir string
+}
+
+// ArchiveSegment is one archived range of offsets
+type ArchiveSegment struct {
+	Topic string
+	First int64
+	Last  int64
+	Path  string
+}
+
+type archivedHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type archivedMessage struct {
+	Offset  int64            `json:"offset"`
+	Time    time.Time        `json:"time"`
+	Key     []byte           `json:"key,omitempty"`
+	Value   []byte           `json:"value"`
+	Headers []archivedHeader `json:"headers"`
+}
+
+// NewArchive creates an archive in dir
+func NewArchive(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &Archive{Dir: dir}, nil
+}
+
+// Segments returns the complete segments of topic in offset order
+func (a *Archive) Segments(topic string) ([]ArchiveSegment, error) {
+	entries, err := os.ReadDir(a.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list archive: %w", err)
+	}
+
+	prefix := topic + "-"
+	suffix := ".jsonl.gz.sha256"
+	var segments []ArchiveSegment
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		first, last, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), "-")
+		if !ok {
+			continue
+		}
+		f, err1 := strconv.ParseInt(first, 10, 64)
+		l, err2 := strconv.ParseInt(last, 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		segments = append(segments, ArchiveSegment{
+			Topic: topic,
+			First: f,
+			Last:  l,
+			Path:  filepath.Join(a.Dir, strings.TrimSuffix(name, ".sha256")),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].First < segments[j].First })
+	return segments, nil
+}
+
+// WriteSegment archives msgs, which must be consecutive messages of topic
+func (a *Archive) WriteSegment(topic string, msgs []Message) (ArchiveSegment, error) {
+	if len(msgs) == 0 {
+		return ArchiveSegment{}, fmt.Errorf("empty segment")
+	}
+	seg := ArchiveSegment{
+		Topic: topic,
+		First: msgs[0].Offset,
+		Last:  msgs[len(msgs)-1].Offset,
+	}
+	seg.Path = filepath.Join(a.Dir, fmt.Sprintf("%s-%020d-%020d.jsonl.gz", topic, seg.First, seg.Last))
+
+	tmp := seg.Path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return seg, fmt.Errorf("failed to create segment: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, hash))
+	enc := json.NewEncoder(gz)
+	for _, m := range msgs {
+		am := archivedMessage{Offset: m.Offset, Time: m.Time, Key: m.Key, Value: m.Value}
+		for _, h := range m.Headers {
+			am.Headers = append(am.Headers, archivedHeader{Key: h.Key, Value: h.Value})
+		}
+		if err := enc.Encode(am); err != nil {
+			file.Close()
+			return seg, fmt.Errorf("failed to write segment: %w", err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		file.Close()
+		return seg, fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return seg, fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err := os.Rename(tmp, seg.Path); err != nil {
+		return seg, fmt.Errorf("failed to rename segment: %w", err)
+	}
+
+	sum := fmt.Sprintf("%s  %s\n", hex.EncodeToString(hash.Sum(nil)), filepath.Base(seg.Path))
+	if err := os.WriteFile(seg.Path+".sha256", []byte(sum), 0644); err != nil {
+		return seg, fmt.Errorf("failed to write checksum: %w", err)
+	}
+	return seg, nil
+}
+
+// ReadSegment verifies the checksum of seg and returns its messages
+func (a *Archive) ReadSegment(seg ArchiveSegment) ([]Message, error) {
+	sum, err := os.ReadFile(seg.Path + ".sha256")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum: %w", err)
+	}
+	want, _, _ := strings.Cut(string(sum), " ")
+
+	data, err := os.ReadFile(seg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segment: %w", err)
+	}
+	got := sha256.Sum256(data)
+	if hex.EncodeToString(got[:]) != want {
+		return nil, fmt.Errorf("checksum mismatch for %s", filepath.Base(seg.Path))
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", filepath.Base(seg.Path), err)
+	}
+	defer gz.Close()
+
+	var msgs []Message
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var am archivedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &am); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(seg.Path), err)
+		}
+		m := Message{Offset: am.Offset, Time: am.Time, Key: am.Key, Value: am.Value}
+		for _, h := range am.Headers {
+			m.Headers = append(m.Headers, Header{Key: h.Key, Value: h.Value})
+		}
+		msgs = append(msgs, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(seg.Path), err)
+	}
+	return msgs, nil
+}
+
+// ============================================================================
+// Archiver
+// ============================================================================
+
+// Archiver copies closed day segments from a transport into an Archive
+type Archiver struct {
+	transport Transport
+	archive   *Archive
+}
+
+// NewArchiver creates an archiver for the topic of transport
+func NewArchiver(transport Transport, archive *Archive) *Archiver {
+	return &Archiver{transport: transport, archive: archive}
+}
+
+// ArchiveClosedDays archives every day closed by an Eod since the last
+// archived segment and returns the new segments. Messages after the last Eod
+// belong to the open day and are left for the next run.
+func (a *Archiver) ArchiveClosedDays(ctx context.Context) ([]ArchiveSegment, error) {
+	topic := a.transport.Topic()
+	existing, err := a.archive.Segments(topic)
+	if err != nil {
+		return nil, err
+	}
+	start := int64(0)
+	if len(existing) > 0 {
+		start = existing[len(existing)-1].Last + 1
+	}
+
+	end, err := a.transport.HighWaterMark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if start >= end {
+		return nil, nil
+	}
+
+	reader, err := a.transport.OpenReader(start)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var written []ArchiveSegment
+	var day []Message
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return written, err
+		}
+		// The archive must stay gapless to be replayable
+		if len(day) == 0 && len(written) == 0 && msg.Offset != start {
+			return written, fmt.Errorf("offsets %d-%d are no longer in %s", start, msg.Offset-1, topic)
+		}
+
+		day = append(day, msg)
+		if env, err := ParseEnvelope(msg.Headers); err == nil && env.EventType == "Eod" {
+			seg, err := a.archive.WriteSegment(topic, day)
+			if err != nil {
+				return written, err
+			}
+			written = append(written, seg)
+			day = nil
+		}
+
+		if msg.Offset >= end-1 {
+			return written, nil
+		}
+	}
+}
+
+// ============================================================================
+// Archive Replay
+// ============================================================================
+
+// EnableArchiveReplay makes the LedgerPoint replay the segments in archive
+// on Start, after restoring a snapshot, and then continue from Kafka at the
+// first offset after them. Not supported on a partitioned ledger. Must be
+// called before Start.
+func (lp *LedgerPoint) EnableArchiveReplay(archive *Archive) error {
+	if lp.Partitioned() {
+		return ErrPartitioned
+	}
+	lp.archive = archive
+	return nil
+}
+
+// replayArchive applies the archived messages newer than the restored state
+func (lp *LedgerPoint) replayArchive() {
+	segments, err := lp.archive.Segments(lp.transport.Topic())
+	if err != nil {
+		lp.fatal("Could not list archive", "error", err)
+	}
+
+	after := atomic.LoadInt64(&lp.offsets[ControlPartition])
+	replayed := 0
+	for _, seg := range segments {
+		if seg.Last <= after {
+			continue
+		}
+		if seg.First > after+1 {
+			lp.fatal("Archive is missing offsets", "from", after+1, "to", seg.First-1)
+		}
+
+		msgs, err := lp.archive.ReadSegment(seg)
+		if err != nil {
+			lp.fatal("Could not read archive segment", "segment", seg.Path, "error", err)
+		}
+		for _, msg := range msgs {
+			if msg.Offset <= after {
+				continue
+			}
+			lp.applyReceived(msg)
+			replayed++
+		}
+		after = seg.Last
+	}
+
+	if replayed > 0 {
+		lp.Logger().Info("Replayed archived events", "events", replayed, logging.Offset(after))
+	}
+}
@@ -0,0 +1,146 @@
+package ledger
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestArchiveClosedDays(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport("pme-ledger")
+	appendEvent := func(event any) {
+		msg, err := events.encode(event, "eclearapi", "")
+		if err != nil {
+			t.Fatalf("encode() error: %v", err)
+		}
+		transport.Append(ctx, msg)
+	}
+
+	appendEvent(Account{Code: "YU-001"})
+	appendEvent(Eod{})
+	appendEvent(AccountLimit{Code: "YU-001", TradeLimit: 1e9})
+	appendEvent(Eod{})
+	appendEvent(Account{Code: "YU-002"}) // Open day
+
+	archive, err := NewArchive(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewArchive() error: %v", err)
+	}
+	archiver := NewArchiver(transport, archive)
+
+	segments, err := archiver.ArchiveClosedDays(ctx)
+	if err != nil {
+		t.Fatalf("ArchiveClosedDays() error: %v", err)
+	}
+	if len(segments) != 2 || segments[0].First != 0 || segments[0].Last != 1 || segments[1].Last != 3 {
+		t.Fatalf("ArchiveClosedDays() = %+v, want segments 0-1 and 2-3", segments)
+	}
+
+	msgs, err := archive.ReadSegment(segments[1])
+	if err != nil {
+		t.Fatalf("ReadSegment() error: %v", err)
+	}
+	want := transport.Messages()[2]
+	if len(msgs) != 2 || msgs[0].Offset != 2 || string(msgs[0].Value) != string(want.Value) || !msgs[0].Time.Equal(want.Time) {
+		t.Errorf("ReadSegment() = %+v, want offsets 2-3 as written", msgs)
+	}
+
+	// Nothing new is closed until the next Eod
+	if segments, err := archiver.ArchiveClosedDays(ctx); err != nil || len(segments) != 0 {
+		t.Errorf("second ArchiveClosedDays() = %v, %v, want nothing", segments, err)
+	}
+	appendEvent(Eod{})
+	if segments, err := archiver.ArchiveClosedDays(ctx); err != nil || len(segments) != 1 || segments[0].First != 4 {
+		t.Errorf("ArchiveClosedDays() after Eod = %+v, %v, want segment from 4", segments, err)
+	}
+}
+
+func TestArchiverRefusesGap(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport("pme-ledger")
+	for i := 0; i < 4; i++ {
+		msg, _ := events.encode(Eod{}, "eclearapi", "")
+		transport.Append(ctx, msg)
+	}
+	transport.DeleteBefore(2)
+
+	archive, _ := NewArchive(t.TempDir())
+	if _, err := NewArchiver(transport, archive).ArchiveClosedDays(ctx); err == nil {
+		t.Error("ArchiveClosedDays() after retention succeeded, want error")
+	}
+}
+
+func TestReadSegmentDetectsCorruption(t *testing.T) {
+	archive, _ := NewArchive(t.TempDir())
+	msg, _ := events.encode(Eod{}, "eclearapi", "1")
+	seg, err := archive.WriteSegment("pme-ledger", []Message{msg})
+	if err != nil {
+		t.Fatalf("WriteSegment() error: %v", err)
+	}
+
+	data, _ := os.ReadFile(seg.Path)
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(seg.Path, data, 0644)
+
+	if _, err := archive.ReadSegment(seg); err == nil {
+		t.Error("ReadSegment() of corrupted file succeeded, want error")
+	}
+}
+
+func TestLedgerPointReplaysArchiveBeforeKafka(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	writer := CreateLedgerPointWithTransport(transport, "eclearapi")
+	writer.Start(nil, ctx)
+	<-writer.Ready()
+	for _, event := range []any{
+		Account{Code: "YU-001"},
+		AccountLimit{Code: "YU-001", TradeLimit: 1e9},
+		Eod{},
+	} {
+		if _, err := writer.CommitSync(ctx, event); err != nil {
+			t.Fatalf("CommitSync() error: %v", err)
+		}
+	}
+
+	archive, _ := NewArchive(t.TempDir())
+	segments, err := NewArchiver(transport, archive).ArchiveClosedDays(ctx)
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("ArchiveClosedDays() = %v, %v, want one segment", segments, err)
+	}
+
+	// Retention deletes the archived day, the next one is still in Kafka
+	if _, err := writer.CommitSync(ctx, Account{Code: "YU-002"}); err != nil {
+		t.Fatalf("CommitSync() error: %v", err)
+	}
+	transport.DeleteBefore(segments[0].Last + 1)
+
+	lp := CreateLedgerPointWithTransport(transport, "pmeapi")
+	if err := lp.EnableArchiveReplay(archive); err != nil {
+		t.Fatalf("EnableArchiveReplay() error: %v", err)
+	}
+	lp.Start(nil, ctx)
+	select {
+	case <-lp.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("LedgerPoint did not become ready")
+	}
+
+	if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 1e9 {
+		t.Errorf("archived account limit = %v, want 1e9", account.TradeLimit)
+	}
+	if _, ok := lp.GetAccount("YU-002"); !ok {
+		t.Error("account committed after the archive was not replayed from Kafka")
+	}
+}
+
+func TestArchiveReplayNotSupportedWhenPartitioned(t *testing.T) {
+	lp, _ := CreateLedgerPointWithPartitions([]Transport{NewMemoryTransport("a"), NewMemoryTransport("a")}, nil, "test")
+	if err := lp.EnableArchiveReplay(&Archive{Dir: t.TempDir()}); err != ErrPartitioned {
+		t.Errorf("EnableArchiveReplay() error = %v, want ErrPartitioned", err)
+	}
+}
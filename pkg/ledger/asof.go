@@ -0,0 +1,333 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LedgerReader is the read-only part of LedgerPoint: getters, iterators and
+// indexed lookups. Handlers that only query state can depend on it so they
+// work equally against the live LedgerPoint and a LedgerView.
+type LedgerReader interface {
+	GetOrder(nid int) (OrderEntity, bool)
+	GetAccount(code string) (AccountEntity, bool)
+	GetParticipant(code string) (ParticipantEntity, bool)
+	GetInstrument(code string) (InstrumentEntity, bool)
+	GetTrade(nid int) (TradeEntity, bool)
+	GetContract(nid int) (ContractEntity, bool)
+	GetHoliday(nid int) (HolidayEntity, bool)
+	GetParameter() ParameterEntity
+	GetSessionTime() SessionTimeEntity
+	GetRiskRule(name string) (RiskRuleEntity, bool)
+	GetConcentrationLimit(scope ConcentrationScope) (ConcentrationLimitEntity, bool)
+
+	ForEachOrder(fn func(OrderEntity) bool)
+	ForEachAccount(fn func(AccountEntity) bool)
+	ForEachParticipant(fn func(ParticipantEntity) bool)
+	ForEachInstrument(fn func(InstrumentEntity) bool)
+	ForEachTrade(fn func(TradeEntity) bool)
+	ForEachContract(fn func(ContractEntity) bool)
+	ForEachHoliday(fn func(HolidayEntity) bool)
+	ForEachRiskRule(fn func(RiskRuleEntity) bool)
+	ForEachConcentrationLimit(fn func(ConcentrationLimitEntity) bool)
+
+	GetTradeByReff(kpeiReff string) (TradeEntity, bool)
+	GetContractByReff(kpeiReff string) (ContractEntity, bool)
+	GetOrderByReffRequestID(reffRequestID string) (OrderEntity, bool)
+	ListOrdersByAccount(accountCode string) []OrderEntity
+	ListOrdersByParticipant(participantCode string) []OrderEntity
+	ListOrdersByInstrument(instrumentCode string) []OrderEntity
+	ListContractsByAccount(accountCode string) []ContractEntity
+	ListContractsByParticipant(participantCode string) []ContractEntity
+	ListContractsByInstrument(instrumentCode string) []ContractEntity
+
+	GetAccountExposure(code string) (AccountExposure, error)
+}
+
+var _ LedgerReader = (*LedgerPoint)(nil)
+
+// LedgerView is a read-only copy of the ledger state as of a past point in
+// the log. Only the LedgerReader methods are reachable, so a view can't be
+// used to commit.
+type LedgerView struct {
+	LedgerReader
+
+	// Offset of the last message applied, -1 when none was
+	Offset int64
+	// Latest log time among the messages applied
+	Time time.Time
+}
+
+// viewReader exposes only the LedgerReader methods of the state behind a
+// view, so callers can't type-assert their way back to a LedgerPoint
+type viewReader struct {
+	lp *LedgerPoint
+}
+
+func (v viewReader) GetOrder(nid int) (OrderEntity, bool) {
+	return v.lp.GetOrder(nid)
+}
+
+func (v viewReader) GetAccount(code string) (AccountEntity, bool) {
+	return v.lp.GetAccount(code)
+}
+
+func (v viewReader) GetParticipant(code string) (ParticipantEntity, bool) {
+	return v.lp.GetParticipant(code)
+}
+
+func (v viewReader) GetInstrument(code string) (InstrumentEntity, bool) {
+	return v.lp.GetInstrument(code)
+}
+
+func (v viewReader) GetTrade(nid int) (TradeEntity, bool) {
+	return v.lp.GetTrade(nid)
+}
+
+func (v viewReader) GetContract(nid int) (ContractEntity, bool) {
+	return v.lp.GetContract(nid)
+}
+
+func (v viewReader) GetHoliday(nid int) (HolidayEntity, bool) {
+	return v.lp.GetHoliday(nid)
+}
+
+func (v viewReader) GetParameter() ParameterEntity {
+	return v.lp.GetParameter()
+}
+
+func (v viewReader) GetSessionTime() SessionTimeEntity {
+	return v.lp.GetSessionTime()
+}
+
+func (v viewReader) GetRiskRule(name string) (RiskRuleEntity, bool) {
+	return v.lp.GetRiskRule(name)
+}
+
+func (v viewReader) GetConcentrationLimit(scope ConcentrationScope) (ConcentrationLimitEntity, bool) {
+	return v.lp.GetConcentrationLimit(scope)
+}
+
+func (v viewReader) ForEachOrder(fn func(OrderEntity) bool) {
+	v.lp.ForEachOrder(fn)
+}
+
+func (v viewReader) ForEachAccount(fn func(AccountEntity) bool) {
+	v.lp.ForEachAccount(fn)
+}
+
+func (v viewReader) ForEachParticipant(fn func(ParticipantEntity) bool) {
+	v.lp.ForEachParticipant(fn)
+}
+
+func (v viewReader) ForEachInstrument(fn func(InstrumentEntity) bool) {
+	v.lp.ForEachInstrument(fn)
+}
+
+func (v viewReader) ForEachTrade(fn func(TradeEntity) bool) {
+	v.lp.ForEachTrade(fn)
+}
+
+func (v viewReader) ForEachContract(fn func(ContractEntity) bool) {
+	v.lp.ForEachContract(fn)
+}
+
+func (v viewReader) ForEachHoliday(fn func(HolidayEntity) bool) {
+	v.lp.ForEachHoliday(fn)
+}
+
+func (v viewReader) ForEachRiskRule(fn func(RiskRuleEntity) bool) {
+	v.lp.ForEachRiskRule(fn)
+}
+
+func (v viewReader) ForEachConcentrationLimit(fn func(ConcentrationLimitEntity) bool) {
+	v.lp.ForEachConcentrationLimit(fn)
+}
+
+func (v viewReader) GetTradeByReff(kpeiReff string) (TradeEntity, bool) {
+	return v.lp.GetTradeByReff(kpeiReff)
+}
+
+func (v viewReader) GetContractByReff(kpeiReff string) (ContractEntity, bool) {
+	return v.lp.GetContractByReff(kpeiReff)
+}
+
+func (v viewReader) GetOrderByReffRequestID(reffRequestID string) (OrderEntity, bool) {
+	return v.lp.GetOrderByReffRequestID(reffRequestID)
+}
+
+func (v viewReader) ListOrdersByAccount(accountCode string) []OrderEntity {
+	return v.lp.ListOrdersByAccount(accountCode)
+}
+
+func (v viewReader) ListOrdersByParticipant(participantCode string) []OrderEntity {
+	return v.lp.ListOrdersByParticipant(participantCode)
+}
+
+func (v viewReader) ListOrdersByInstrument(instrumentCode string) []OrderEntity {
+	return v.lp.ListOrdersByInstrument(instrumentCode)
+}
+
+func (v viewReader) ListContractsByAccount(accountCode string) []ContractEntity {
+	return v.lp.ListContractsByAccount(accountCode)
+}
+
+func (v viewReader) ListContractsByParticipant(participantCode string) []ContractEntity {
+	return v.lp.ListContractsByParticipant(participantCode)
+}
+
+func (v viewReader) ListContractsByInstrument(instrumentCode string) []ContractEntity {
+	return v.lp.ListContractsByInstrument(instrumentCode)
+}
+
+func (v viewReader) GetAccountExposure(code string) (AccountExposure, error) {
+	return v.lp.GetAccountExposure(code)
+}
+
+// ErrViewIncomplete is returned when the messages a view needs are neither
+// in the log, the archive nor a snapshot, e.g. after retention deleted them
+// and they weren't archived
+var ErrViewIncomplete = errors.New("messages needed for the view are no longer available")
+
+// ViewAtOffset replays the log into a fresh state up to and including
+// offset. Offsets past the end of the log return the current state.
+func (lp *LedgerPoint) ViewAtOffset(ctx context.Context, offset int64) (*LedgerView, error) {
+	return lp.replayView(ctx, func(snap *Snapshot) bool {
+		return snap.Offset <= offset
+	}, func(msg Message) bool {
+		return msg.Offset > offset
+	})
+}
+
+// ViewAtTime replays the log into a fresh state up to and including the last
+// message written at or before t. Log times come from the clocks of the
+// producers and aren't strictly increasing, so the view is the longest
+// prefix of the log whose messages are all at or before t: a message stamped
+// before t that follows one stamped after it, e.g. by a producer with a
+// slow clock, is left out. Use ViewAtOffset for an exact point in the log.
+func (lp *LedgerPoint) ViewAtTime(ctx context.Context, t time.Time) (*LedgerView, error) {
+	return lp.replayView(ctx, func(snap *Snapshot) bool {
+		return !snap.Time.IsZero() && !snap.Time.After(t)
+	}, func(msg Message) bool {
+		return msg.Time.After(t)
+	})
+}
+
+// replayView builds a fresh state with the regular Sync* transitions until
+// stop returns true or the end of the log as it was when the replay started
+// is reached. It starts from the newest snapshot usable accepts, if the
+// store keeps older ones, then applies the archived segments and finally the
+// log. Missing offsets in between return ErrViewIncomplete rather than a
+// view that is silently wrong. The live state is not touched. Views need a
+// single ordered log, so partitioned ledgers return ErrPartitioned.
+func (lp *LedgerPoint) replayView(ctx context.Context, usable func(*Snapshot) bool, stop func(Message) bool) (*LedgerView, error) {
+	if lp.Partitioned() {
+		return nil, ErrPartitioned
+	}
+
+	end, err := lp.transport.HighWaterMark(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	state := CreateLedgerPointWithTransport(lp.transport, lp.id+"-view")
+	state.security = lp.security
+	state.policy = lp.policy
+	view := &LedgerView{LedgerReader: viewReader{state}, Offset: -1}
+	if end <= 0 {
+		return view, nil
+	}
+
+	// apply reports false once stop returned true or the end was reached
+	apply := func(msg Message) bool {
+		if stop(msg) {
+			return false
+		}
+		// Undecodable messages were already dead-lettered by the live
+		// LedgerPoint, so they are skipped quietly here
+		state.ApplyMessage(msg)
+		view.Offset = msg.Offset
+		if msg.Time.After(view.Time) {
+			view.Time = msg.Time
+		}
+		return msg.Offset < end-1
+	}
+
+	if history, ok := lp.snapshots.(SnapshotHistory); ok {
+		snap, err := history.LoadBefore(lp.topic, func(snap *Snapshot) bool {
+			return snap.Version == SnapshotVersion && snap.Offsets == nil && usable(snap)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if snap != nil {
+			if snap, err = state.unsealSnapshot(snap); err != nil {
+				return nil, err
+			}
+			state.RestoreSnapshot(snap)
+			for _, key := range snap.EventIDs {
+				state.dedup.add(key)
+			}
+			view.Offset = snap.Offset
+			view.Time = snap.Time
+			if snap.Offset >= end-1 {
+				return view, nil
+			}
+		}
+	}
+
+	if lp.archive != nil {
+		segments, err := lp.archive.Segments(lp.topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, seg := range segments {
+			if seg.Last <= view.Offset {
+				continue
+			}
+			if seg.First > view.Offset+1 {
+				return nil, fmt.Errorf("%w: archive is missing offsets %d to %d", ErrViewIncomplete, view.Offset+1, seg.First-1)
+			}
+			msgs, err := lp.archive.ReadSegment(seg)
+			if err != nil {
+				return nil, err
+			}
+			for _, msg := range msgs {
+				if msg.Offset <= view.Offset {
+					continue
+				}
+				if !apply(msg) {
+					return view, nil
+				}
+			}
+		}
+	}
+
+	next := view.Offset + 1
+	if next == 0 {
+		next = FirstOffset
+	}
+	r, err := lp.transport.OpenReader(next)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Offset > view.Offset+1 {
+			return nil, fmt.Errorf("%w: log starts at offset %d, view needs %d", ErrViewIncomplete, msg.Offset, view.Offset+1)
+		}
+		if msg.Offset <= view.Offset {
+			continue
+		}
+		if !apply(msg) {
+			return view, nil
+		}
+	}
+}
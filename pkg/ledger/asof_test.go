@@ -0,0 +1,198 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestViewAsOf(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport("pme-ledger")
+
+	start := time.Date(2025, 11, 24, 10, 0, 0, 0, time.UTC)
+	for i, event := range []any{
+		Account{NID: 1, Code: "YU-001", ParticipantCode: "YU"},
+		AccountLimit{Code: "YU-001", TradeLimit: 1e9},
+		Order{NID: 10, AccountCode: "YU-001", ParticipantCode: "YU", Side: "BORR", Quantity: 100},
+		OrderAck{OrderNID: 10},
+		AccountLimit{Code: "YU-001", TradeLimit: 2e9},
+	} {
+		msg, err := events.encode(event, "test", "")
+		if err != nil {
+			t.Fatalf("encode(%T) error: %v", event, err)
+		}
+		msg.Time = start.Add(time.Duration(i) * time.Minute)
+		transport.Append(ctx, msg)
+	}
+
+	lp := CreateLedgerPointWithTransport(transport, "test")
+
+	view, err := lp.ViewAtOffset(ctx, 2)
+	if err != nil {
+		t.Fatalf("ViewAtOffset() error: %v", err)
+	}
+	if view.Offset != 2 {
+		t.Errorf("view.Offset = %d, want 2", view.Offset)
+	}
+	if order, ok := view.GetOrder(10); !ok || order.State != "S" {
+		t.Errorf("GetOrder() at offset 2 = %+v, %v, want state S", order, ok)
+	}
+
+	// 10:03:30 falls between the OrderAck and the second limit change
+	view, err = lp.ViewAtTime(ctx, start.Add(3*time.Minute+30*time.Second))
+	if err != nil {
+		t.Fatalf("ViewAtTime() error: %v", err)
+	}
+	if order, _ := view.GetOrder(10); order.State != "O" {
+		t.Errorf("order state = %q, want O", order.State)
+	}
+	if account, _ := view.GetAccount("YU-001"); account.TradeLimit != 1e9 {
+		t.Errorf("TradeLimit = %v, want 1e9", account.TradeLimit)
+	}
+
+	// Past the end of the log the view matches the latest state
+	view, err = lp.ViewAtOffset(ctx, 1000)
+	if err != nil {
+		t.Fatalf("ViewAtOffset() error: %v", err)
+	}
+	if account, _ := view.GetAccount("YU-001"); view.Offset != 4 || account.TradeLimit != 2e9 {
+		t.Errorf("view at end = offset %d, limit %v", view.Offset, account.TradeLimit)
+	}
+
+	// The live LedgerPoint is untouched
+	if _, ok := lp.GetOrder(10); ok {
+		t.Error("replay leaked into the live state")
+	}
+}
+
+func TestViewAfterRetention(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport("pme-ledger")
+
+	start := time.Date(2025, 11, 24, 10, 0, 0, 0, time.UTC)
+	for i, event := range []any{
+		Account{NID: 1, Code: "YU-001", ParticipantCode: "YU"},
+		AccountLimit{Code: "YU-001", TradeLimit: 1e9},
+		Eod{},
+		AccountLimit{Code: "YU-001", TradeLimit: 2e9},
+		Eod{},
+		AccountLimit{Code: "YU-001", TradeLimit: 3e9},
+	} {
+		msg, err := events.encode(event, "test", "")
+		if err != nil {
+			t.Fatalf("encode(%T) error: %v", event, err)
+		}
+		msg.Time = start.Add(time.Duration(i) * time.Minute)
+		transport.Append(ctx, msg)
+	}
+
+	archive, _ := NewArchive(t.TempDir())
+	segments, err := NewArchiver(transport, archive).ArchiveClosedDays(ctx)
+	if err != nil || len(segments) != 2 {
+		t.Fatalf("ArchiveClosedDays() = %v, %v, want two segments", segments, err)
+	}
+	transport.DeleteBefore(5)
+
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	if _, err := lp.ViewAtOffset(ctx, 3); !errors.Is(err, ErrViewIncomplete) {
+		t.Errorf("ViewAtOffset() without archive error = %v, want ErrViewIncomplete", err)
+	}
+
+	// The archive covers what retention deleted
+	if err := lp.EnableArchiveReplay(archive); err != nil {
+		t.Fatalf("EnableArchiveReplay() error: %v", err)
+	}
+	view, err := lp.ViewAtOffset(ctx, 3)
+	if err != nil {
+		t.Fatalf("ViewAtOffset() error: %v", err)
+	}
+	if account, _ := view.GetAccount("YU-001"); view.Offset != 3 || account.TradeLimit != 2e9 {
+		t.Errorf("view at 3 = offset %d, limit %v, want 3 and 2e9", view.Offset, account.TradeLimit)
+	}
+	view, err = lp.ViewAtOffset(ctx, 1000)
+	if err != nil {
+		t.Fatalf("ViewAtOffset() error: %v", err)
+	}
+	if account, _ := view.GetAccount("YU-001"); view.Offset != 5 || account.TradeLimit != 3e9 {
+		t.Errorf("view at end = offset %d, limit %v, want 5 and 3e9", view.Offset, account.TradeLimit)
+	}
+
+	// A snapshot at offset 2 takes the place of the first archived day
+	snapshots, _ := NewFileSnapshotStore(t.TempDir())
+	state := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	state.SyncAccount(Account{NID: 1, Code: "YU-001", ParticipantCode: "YU"})
+	state.SyncAccountLimit(AccountLimit{Code: "YU-001", TradeLimit: 1e9})
+	snap := state.CaptureSnapshot(2)
+	snap.Time = start.Add(2 * time.Minute)
+	if err := snapshots.Save(snap); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	secondDay, _ := NewArchive(t.TempDir())
+	msgs, _ := archive.ReadSegment(segments[1])
+	if _, err := secondDay.WriteSegment("pme-ledger", msgs); err != nil {
+		t.Fatalf("WriteSegment() error: %v", err)
+	}
+	lp = CreateLedgerPointWithTransport(transport, "test")
+	lp.EnableSnapshots(snapshots, 0)
+	lp.EnableArchiveReplay(secondDay)
+
+	view, err = lp.ViewAtTime(ctx, start.Add(3*time.Minute))
+	if err != nil {
+		t.Fatalf("ViewAtTime() error: %v", err)
+	}
+	if account, _ := view.GetAccount("YU-001"); view.Offset != 3 || account.TradeLimit != 2e9 {
+		t.Errorf("view from snapshot = offset %d, limit %v, want 3 and 2e9", view.Offset, account.TradeLimit)
+	}
+	if _, err := lp.ViewAtOffset(ctx, 0); !errors.Is(err, ErrViewIncomplete) {
+		t.Errorf("ViewAtOffset() before the snapshot error = %v, want ErrViewIncomplete", err)
+	}
+}
+
+func TestViewAtTimeWithClockSkew(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport("pme-ledger")
+
+	// The third message comes from a producer whose clock is two minutes slow
+	start := time.Date(2025, 11, 24, 10, 0, 0, 0, time.UTC)
+	for i, event := range []any{
+		Account{NID: 1, Code: "YU-001", ParticipantCode: "YU"},
+		AccountLimit{Code: "YU-001", TradeLimit: 1e9},
+		AccountLimit{Code: "YU-001", TradeLimit: 2e9},
+	} {
+		msg, err := events.encode(event, "test", "")
+		if err != nil {
+			t.Fatalf("encode(%T) error: %v", event, err)
+		}
+		msg.Time = start.Add(time.Duration(i) * time.Minute)
+		if i == 2 {
+			msg.Time = start.Add(-time.Minute)
+		}
+		transport.Append(ctx, msg)
+	}
+
+	lp := CreateLedgerPointWithTransport(transport, "test")
+
+	// The view ends before the 10:01 message and leaves out the one after
+	// it, although that one is stamped 09:59
+	view, err := lp.ViewAtTime(ctx, start.Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("ViewAtTime() error: %v", err)
+	}
+	if account, _ := view.GetAccount("YU-001"); view.Offset != 0 || account.TradeLimit != 0 {
+		t.Errorf("view = offset %d, limit %v, want offset 0 without a limit", view.Offset, account.TradeLimit)
+	}
+
+	view, err = lp.ViewAtTime(ctx, start.Add(5*time.Minute))
+	if err != nil {
+		t.Fatalf("ViewAtTime() error: %v", err)
+	}
+	if !view.Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("view.Time = %v, want the latest log time %v", view.Time, start.Add(time.Minute))
+	}
+
+	if _, ok := view.LedgerReader.(*LedgerPoint); ok {
+		t.Error("view exposes the LedgerPoint behind it")
+	}
+}
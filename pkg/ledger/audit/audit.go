@@ -0,0 +1,249 @@
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+
+	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
+)
+
+// Rules checked by the Auditor
+const (
+	RuleDoneQuantity  = "done-quantity"  // DoneQuantity equals the sum of non-rejected contracts and stays within Quantity
+	RuleTradeBalance  = "trade-balance"  // Borrower and lender quantities both equal the trade quantity
+	RuleOrderState    = "order-state"    // Order state transitions follow the order lifecycle
+	RuleTradeState    = "trade-state"    // Trade state transitions follow the trade lifecycle
+	RuleUnknownEntity = "unknown-entity" // Events refer to orders and trades that exist
+)
+
+// Violation is a broken invariant observed while applying an event
+type Violation struct {
+	Offset  int64  `json:"offset"` // Offset of the event that broke the invariant
+	Event   string `json:"event"`
+	Rule    string `json:"rule"`
+	NID     int    `json:"nid"` // Order or trade the rule was checked on
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("offset %d %s [%s] nid %d: %s", v.Offset, v.Event, v.Rule, v.NID, v.Message)
+}
+
+// orderTransitions lists the legal next states for each order state.
+// "" is an order that has not been seen yet.
+var orderTransitions = map[string][]string{
+	"":  {"S"},
+	"S": {"O", "R", "G"},
+	"G": {"O", "R"},
+	"O": {"P", "M", "W", "A"},
+	"P": {"P", "M", "O", "W", "A"}, // P->O when a trade is rejected
+	"M": {"P", "O"},                // Trade rejected after matching
+}
+
+// tradeTransitions lists the legal next states for each trade state. New
+// trades start in "" (the entity does not carry the Submitted state).
+var tradeTransitions = map[string][]string{
+	"":  {"E"},
+	"E": {"O", "R"},
+	"O": {"C"},
+}
+
+// Auditor validates ledger invariants on every applied event. It keeps its
+// own copy of the last seen order and trade states to check transitions.
+type Auditor struct {
+	ledger *ledger.LedgerPoint
+	report func(Violation)
+
+	mu          sync.Mutex
+	orderStates map[int]string
+	tradeStates map[int]string
+	violations  int
+}
+
+// New creates an Auditor and subscribes it to l. report is called for every
+// violation on the processing goroutine, with the offset of the event that
+// broke the invariant; nil reports them with LogViolations(l.Logger()).
+// Create the Auditor before l.Start so it sees the whole log.
+func New(l *ledger.LedgerPoint, report func(Violation)) *Auditor {
+	if report == nil {
+		report = LogViolations(l.Logger())
+	}
+
+	a := &Auditor{
+		ledger:      l,
+		report:      report,
+		orderStates: make(map[int]string),
+		tradeStates: make(map[int]string),
+	}
+
+	ledger.Subscribe(l, func(e ledger.Order) {
+		a.checkOrder("Order", e.NID)
+	})
+	ledger.Subscribe(l, func(e ledger.OrderAck) {
+		a.checkOrder("OrderAck", e.OrderNID)
+		if order, exists := l.GetOrder(e.OrderNID); exists && order.PrevNID != 0 {
+			a.checkOrder("OrderAck", order.PrevNID)
+		}
+	})
+	ledger.Subscribe(l, func(e ledger.OrderNak) {
+		a.checkOrder("OrderNak", e.OrderNID)
+	})
+	ledger.Subscribe(l, func(e ledger.OrderPending) {
+		a.checkOrder("OrderPending", e.OrderNID)
+	})
+	ledger.Subscribe(l, func(e ledger.OrderWithdrawAck) {
+		a.checkOrder("OrderWithdrawAck", e.OrderNID)
+	})
+	ledger.Subscribe(l, func(e ledger.Trade) {
+		a.checkTradeBalance(e)
+		a.checkTrade("Trade", e.NID)
+	})
+	ledger.Subscribe(l, func(e ledger.TradeWait) {
+		a.checkTrade("TradeWait", e.TradeNID)
+	})
+	ledger.Subscribe(l, func(e ledger.TradeAck) {
+		a.checkTrade("TradeAck", e.TradeNID)
+	})
+	ledger.Subscribe(l, func(e ledger.TradeNak) {
+		a.checkTrade("TradeNak", e.TradeNID)
+	})
+	ledger.Subscribe(l, func(e ledger.TradeReimburse) {
+		a.checkTrade("TradeReimburse", e.TradeNID)
+	})
+
+	return a
+}
+
+// LogViolations returns a report function for New that logs every violation
+// to logger as a warning, with its offset, event, rule and NID as fields
+func LogViolations(logger *slog.Logger) func(Violation) {
+	return func(v Violation) {
+		logger.Warn("Ledger invariant violated", logging.Offset(v.Offset), logging.EventType(v.Event),
+			"rule", v.Rule, "nid", v.NID, "message", v.Message)
+	}
+}
+
+// Violations returns the number of violations reported so far
+func (a *Auditor) Violations() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.violations
+}
+
+func (a *Auditor) violation(event string, rule string, nid int, format string, args ...any) {
+	a.mu.Lock()
+	a.violations++
+	a.mu.Unlock()
+
+	a.report(Violation{
+		Offset:  a.ledger.CurrentOffset(),
+		Event:   event,
+		Rule:    rule,
+		NID:     nid,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkOrder validates the state transition and done quantity of an order
+func (a *Auditor) checkOrder(event string, nid int) {
+	order, exists := a.ledger.GetOrder(nid)
+	if !exists {
+		a.violation(event, RuleUnknownEntity, nid, "order does not exist")
+		return
+	}
+
+	a.mu.Lock()
+	prev, seen := a.orderStates[nid]
+	a.orderStates[nid] = order.State
+	a.mu.Unlock()
+
+	// Orders restored from a snapshot have no known previous state, and
+	// events that leave the state alone (e.g. a duplicate OrderAck) are not
+	// transitions
+	if (seen || event == "Order") && prev != order.State && !legal(orderTransitions, prev, order.State) {
+		a.violation(event, RuleOrderState, nid, "illegal order transition %q -> %q", prev, order.State)
+	}
+
+	a.checkDoneQuantity(event, order)
+}
+
+// checkDoneQuantity compares DoneQuantity with the order's contracts
+func (a *Auditor) checkDoneQuantity(event string, order ledger.OrderEntity) {
+	var done float64
+	for _, contract := range a.ledger.ListContractsByAccount(order.AccountCode) {
+		if contract.OrderNID == order.NID && contract.State != "R" {
+			done += contract.Quantity
+		}
+	}
+
+	if !equal(order.DoneQuantity, done) {
+		a.violation(event, RuleDoneQuantity, order.NID,
+			"done quantity %.0f but non-rejected contracts sum to %.0f", order.DoneQuantity, done)
+	}
+	if order.DoneQuantity < 0 || order.DoneQuantity > order.Quantity {
+		a.violation(event, RuleDoneQuantity, order.NID,
+			"done quantity %.0f outside [0, %.0f]", order.DoneQuantity, order.Quantity)
+	}
+}
+
+// checkTrade validates the state transition of a trade and re-checks the
+// orders behind its contracts
+func (a *Auditor) checkTrade(event string, nid int) {
+	trade, exists := a.ledger.GetTrade(nid)
+	if !exists {
+		a.violation(event, RuleUnknownEntity, nid, "trade does not exist")
+		return
+	}
+
+	a.mu.Lock()
+	prev, seen := a.tradeStates[nid]
+	a.tradeStates[nid] = trade.State
+	a.mu.Unlock()
+
+	// As for orders, trades restored from a snapshot are only checked from
+	// their next transition on
+	if seen && prev != trade.State && !legal(tradeTransitions, prev, trade.State) {
+		a.violation(event, RuleTradeState, nid, "illegal trade transition %q -> %q", prev, trade.State)
+	}
+
+	// Trades and trade rejections move the matched orders
+	if event == "Trade" || event == "TradeNak" {
+		for _, contractNID := range append(append([]int(nil), trade.Borrower...), trade.Lender...) {
+			if contract, exists := a.ledger.GetContract(contractNID); exists {
+				a.checkOrder(event, contract.OrderNID)
+			}
+		}
+	}
+}
+
+// checkTradeBalance verifies that both sides of a trade carry its quantity
+func (a *Auditor) checkTradeBalance(t ledger.Trade) {
+	var borr, lend float64
+	for _, c := range t.Borrower {
+		borr += c.Quantity
+	}
+	for _, c := range t.Lender {
+		lend += c.Quantity
+	}
+
+	if !equal(borr, lend) || !equal(borr, t.Quantity) {
+		a.violation("Trade", RuleTradeBalance, t.NID,
+			"trade quantity %.0f, borrower %.0f, lender %.0f", t.Quantity, borr, lend)
+	}
+}
+
+func legal(transitions map[string][]string, from string, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
+)
+
+func matchedTrade(nid int, borrQty float64, lendQty float64) ledger.Trade {
+	return ledger.Trade{
+		NID:      nid,
+		KpeiReff: "PME-20251124-1",
+		Quantity: borrQty,
+		Borrower: []ledger.Contract{{NID: nid*10 + 1, TradeNID: nid, OrderNID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: borrQty, State: "S"}},
+		Lender:   []ledger.Contract{{NID: nid*10 + 2, TradeNID: nid, OrderNID: 2, AccountCode: "DX-001", Side: "LEND", Quantity: lendQty, State: "S"}},
+	}
+}
+
+func TestAuditorAcceptsLegalLifecycle(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	var violations []Violation
+	New(lp, func(v Violation) { violations = append(violations, v) })
+
+	lp.SyncOrder(ledger.Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+	lp.SyncOrder(ledger.Order{NID: 2, AccountCode: "DX-001", Side: "LEND", Quantity: 100})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 1})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 2})
+	lp.SyncTrade(matchedTrade(7, 100, 100))
+	lp.SyncTradeWait(ledger.TradeWait{TradeNID: 7})
+	lp.SyncTradeAck(ledger.TradeAck{TradeNID: 7})
+	lp.SyncTradeReimburse(ledger.TradeReimburse{TradeNID: 7})
+
+	for _, v := range violations {
+		t.Errorf("unexpected violation: %s", v)
+	}
+}
+
+func TestAuditorReportsViolations(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	rules := make(map[string]int)
+	New(lp, func(v Violation) { rules[v.Rule]++ })
+
+	lp.SyncOrder(ledger.Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+	lp.SyncOrder(ledger.Order{NID: 2, AccountCode: "DX-001", Side: "LEND", Quantity: 100})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 1})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 2})
+
+	// Lender side is short, and order 2 ends up with less done than its contracts
+	lp.SyncTrade(matchedTrade(7, 100, 60))
+	// Confirmed without ever being sent for approval
+	lp.SyncTradeAck(ledger.TradeAck{TradeNID: 7})
+	// Withdrawing a matched order is not a legal transition
+	lp.SyncOrderWithdrawAck(ledger.OrderWithdrawAck{OrderNID: 1})
+	lp.SyncTradeAck(ledger.TradeAck{TradeNID: 99})
+
+	for _, rule := range []string{RuleTradeBalance, RuleTradeState, RuleOrderState, RuleUnknownEntity} {
+		if rules[rule] == 0 {
+			t.Errorf("no %s violation reported (got %v)", rule, rules)
+		}
+	}
+}
+
+func TestRunReportsRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := ledger.NewMemoryTransport("pme-ledger")
+	lp := ledger.CreateLedgerPointWithTransport(transport, "test")
+	lp.Start(nil, ctx)
+	<-lp.Ready() // ServiceStart is written first
+
+	for _, event := range []any{
+		ledger.Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100},
+		ledger.OrderAck{OrderNID: 1},
+		ledger.OrderAck{OrderNID: 42}, // Unknown order
+	} {
+		if _, err := lp.CommitSync(ctx, event); err != nil {
+			t.Fatalf("CommitSync(%T) error: %v", event, err)
+		}
+	}
+
+	msgs := transport.Messages()
+	last := msgs[len(msgs)-1].Offset
+
+	report, err := Run(ctx, transport, nil, nil, Range{From: 0, To: -1})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if report.Events != len(msgs) || len(report.Violations) != 1 || report.Violations[0].Offset != last {
+		t.Errorf("Run() = %+v, want one violation at offset %d", report, last)
+	}
+
+	// The violation is outside the requested range
+	report, err = Run(ctx, transport, nil, nil, Range{From: 0, To: last - 1})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if len(report.Violations) != 0 || report.LastOffset != last-1 {
+		t.Errorf("Run() over [0, %d] = %+v, want no violations", last-1, report)
+	}
+}
+
+func TestAuditorLogsViolationsWithOffset(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := ledger.NewMemoryTransport("pme-ledger")
+	lp := ledger.CreateLedgerPointWithTransport(transport, "test")
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+
+	if _, err := lp.CommitSync(ctx, ledger.OrderAck{OrderNID: 42}); err != nil { // Unknown order
+		t.Fatalf("CommitSync() error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger, err := logging.NewWithWriter(&buf, "test", "json", "info")
+	if err != nil {
+		t.Fatalf("NewWithWriter() error: %v", err)
+	}
+	replay := ledger.CreateLedgerPointWithTransport(transport, "replay")
+	replay.SetLogger(logger)
+	New(replay, nil)
+
+	msgs := transport.Messages()
+	for _, msg := range msgs {
+		if err := replay.ApplyMessage(msg); err != nil {
+			t.Fatalf("ApplyMessage() error: %v", err)
+		}
+	}
+
+	var record struct {
+		Msg    string `json:"msg"`
+		Offset int64  `json:"offset"`
+		Rule   string `json:"rule"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("log output %q: %v", buf.String(), err)
+	}
+	last := msgs[len(msgs)-1].Offset
+	if record.Msg != "Ledger invariant violated" || record.Offset != last || record.Rule != RuleUnknownEntity {
+		t.Errorf("logged %+v, want the unknown-entity violation at offset %d", record, last)
+	}
+}
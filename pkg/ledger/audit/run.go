@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// Rules reported by Run for messages that can't be applied
+const (
+	RuleUndecodable = "undecodable" // Message can't be decoded
+	RuleRejected    = "rejected"    // Invalid signature or producer not authorised
+)
+
+// Range selects the messages whose violations Run reports. The log is always
+// replayed from the start so the checks see complete state.
+type Range struct {
+	From  int64     // First offset to report
+	To    int64     // Last offset to report, -1 for the end of the log
+	Since time.Time // First message time to report, zero for no bound
+	Until time.Time // Report messages before this time, zero for no bound
+}
+
+func (r Range) contains(msg ledger.Message) bool {
+	return msg.Offset >= r.From &&
+		(r.To < 0 || msg.Offset <= r.To) &&
+		(r.Since.IsZero() || !msg.Time.Before(r.Since)) &&
+		(r.Until.IsZero() || msg.Time.Before(r.Until))
+}
+
+func (r Range) passed(msg ledger.Message) bool {
+	return (r.To >= 0 && msg.Offset > r.To) ||
+		(!r.Until.IsZero() && !msg.Time.Before(r.Until))
+}
+
+// Report is the outcome of an offline audit
+type Report struct {
+	FirstOffset int64       `json:"first_offset"` // -1 when no message was in range
+	LastOffset  int64       `json:"last_offset"`
+	Events      int         `json:"events"` // Messages in range
+	Violations  []Violation `json:"violations"`
+}
+
+// Run replays transport into a private LedgerPoint that is never started,
+// so nothing is committed, and audits every message up to the end of the
+// log as it was when Run started. sec and policy verify the messages like
+// the services do; nil reads clear, unsigned ones from any producer.
+func Run(ctx context.Context, transport ledger.Transport, sec *ledger.Security, policy ledger.Policy, r Range) (*Report, error) {
+	report := &Report{FirstOffset: -1, LastOffset: -1, Violations: []Violation{}}
+
+	end, err := transport.HighWaterMark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if end <= 0 {
+		return report, nil
+	}
+
+	reader, err := transport.OpenReader(ledger.FirstOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	lp := ledger.CreateLedgerPointWithTransport(transport, "audit")
+	lp.SetSecurity(sec)
+	lp.SetPolicy(policy)
+	var inRange bool
+	New(lp, func(v Violation) {
+		if inRange {
+			report.Violations = append(report.Violations, v)
+		}
+	})
+
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if r.passed(msg) {
+			break
+		}
+
+		inRange = r.contains(msg)
+		if inRange {
+			if report.FirstOffset < 0 {
+				report.FirstOffset = msg.Offset
+			}
+			report.LastOffset = msg.Offset
+			report.Events++
+		}
+
+		if err := lp.ApplyMessage(msg); err != nil && inRange {
+			rule := RuleUndecodable
+			if errors.As(err, new(*ledger.RejectedError)) {
+				rule = RuleRejected
+			}
+			report.Violations = append(report.Violations, Violation{
+				Offset:  msg.Offset,
+				Rule:    rule,
+				Message: err.Error(),
+			})
+		}
+
+		if msg.Offset >= end-1 {
+			break
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,147 @@
+// Package calendar answers business day and trading session questions from
+// the holidays and session time held by the ledger (Holiday and SessionTime
+// events).
+//
+// A business day is a weekday that is not a holiday. Days are compared by
+// their calendar date in their own location, so a holiday entered as
+// 2025-12-25 covers December 25th whatever the location of the date checked.
+// Session times only carry a time of day, which is read in the location of
+// the time checked.
+package calendar
+
+import (
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// Calendar reads holidays and session times from a ledger on every call, so
+// it follows Holiday and SessionTime events as they are applied
+type Calendar struct {
+	ledger ledger.LedgerReader
+}
+
+// New creates a calendar over the state of l, a LedgerPoint or LedgerView
+func New(l ledger.LedgerReader) *Calendar {
+	return &Calendar{ledger: l}
+}
+
+// day is a calendar date without time or location
+type day struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+func dayOf(t time.Time) day {
+	y, m, d := t.Date()
+	return day{y, m, d}
+}
+
+// holidays returns the set of holiday dates
+func (c *Calendar) holidays() map[day]struct{} {
+	holidays := make(map[day]struct{})
+	c.ledger.ForEachHoliday(func(h ledger.HolidayEntity) bool {
+		holidays[dayOf(h.Date)] = struct{}{}
+		return true
+	})
+	return holidays
+}
+
+func isBusinessDay(date time.Time, holidays map[day]struct{}) bool {
+	if wd := date.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	_, holiday := holidays[dayOf(date)]
+	return !holiday
+}
+
+// IsBusinessDay reports whether date is neither a weekend day nor a holiday
+func (c *Calendar) IsBusinessDay(date time.Time) bool {
+	return isBusinessDay(date, c.holidays())
+}
+
+// AddBusinessDays moves date n business days forward, or backward when n is
+// negative, keeping its time of day. With n = 0 date is returned unchanged,
+// business day or not.
+func (c *Calendar) AddBusinessDays(date time.Time, n int) time.Time {
+	holidays := c.holidays()
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for n > 0 {
+		date = date.AddDate(0, 0, step)
+		if isBusinessDay(date, holidays) {
+			n--
+		}
+	}
+	return date
+}
+
+// NextSettlementDate returns the earliest date an order entered at now can
+// settle on: the same day when it is a business day and its last session
+// hasn't ended, the next business day otherwise. The result is midnight in
+// the location of now.
+func (c *Calendar) NextSettlementDate(now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	sessions := c.sessions()
+	open := len(sessions) == 0
+	for _, s := range sessions {
+		if timeOfDay(now) < s.end {
+			open = true
+		}
+	}
+	if open && c.IsBusinessDay(today) {
+		return today
+	}
+	return c.AddBusinessDays(today, 1)
+}
+
+// IsWithinSession reports whether now falls in a trading session of a
+// business day. Until a SessionTime has been received, the whole of a
+// business day counts as in session.
+func (c *Calendar) IsWithinSession(now time.Time) bool {
+	if !c.IsBusinessDay(now) {
+		return false
+	}
+	sessions := c.sessions()
+	if len(sessions) == 0 {
+		return true
+	}
+	t := timeOfDay(now)
+	for _, s := range sessions {
+		if t >= s.start && t < s.end {
+			return true
+		}
+	}
+	return false
+}
+
+// session is a trading session as offsets from midnight
+type session struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// sessions returns the configured sessions in order; a session whose end
+// isn't after its start (e.g. an unused second session) is left out
+func (c *Calendar) sessions() []session {
+	st := c.ledger.GetSessionTime()
+	var sessions []session
+	for _, s := range [][2]time.Time{
+		{st.Session1Start, st.Session1End},
+		{st.Session2Start, st.Session2End},
+	} {
+		if start, end := timeOfDay(s[0]), timeOfDay(s[1]); end > start {
+			sessions = append(sessions, session{start: start, end: end})
+		}
+	}
+	return sessions
+}
+
+// timeOfDay returns the wall clock time of t as an offset from midnight
+func timeOfDay(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+}
@@ -0,0 +1,117 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// newCalendar returns a calendar with Christmas 2025 as a holiday and
+// sessions 09:00-12:00 and 13:30-15:50
+func newCalendar(t *testing.T) (*Calendar, *ledger.LedgerPoint) {
+	t.Helper()
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	// Entered as UTC dates and times, like the eClear API does
+	lp.SyncHoliday(ledger.Holiday{NID: 1, Tahun: 2025, Date: time.Date(2025, 12, 25, 0, 0, 0, 0, time.UTC)})
+	return New(lp), lp
+}
+
+func setSessions(lp *ledger.LedgerPoint) {
+	clock := func(h, m int) time.Time { return time.Date(0, 1, 1, h, m, 0, 0, time.UTC) }
+	lp.SyncSessionTime(ledger.SessionTime{
+		NID:           1,
+		Session1Start: clock(9, 0), Session1End: clock(12, 0),
+		Session2Start: clock(13, 30), Session2End: clock(15, 50),
+	})
+}
+
+func TestIsBusinessDay(t *testing.T) {
+	cal, _ := newCalendar(t)
+	jakarta := time.FixedZone("WIB", 7*3600)
+
+	for _, tc := range []struct {
+		date time.Time
+		want bool
+	}{
+		{time.Date(2025, 12, 24, 0, 0, 0, 0, jakarta), true},
+		{time.Date(2025, 12, 25, 0, 0, 0, 0, jakarta), false}, // Holiday, entered in UTC
+		{time.Date(2025, 12, 25, 23, 0, 0, 0, jakarta), false},
+		{time.Date(2025, 12, 27, 0, 0, 0, 0, jakarta), false}, // Saturday
+		{time.Date(2025, 12, 28, 0, 0, 0, 0, jakarta), false}, // Sunday
+		{time.Date(2025, 12, 29, 0, 0, 0, 0, jakarta), true},
+	} {
+		if got := cal.IsBusinessDay(tc.date); got != tc.want {
+			t.Errorf("IsBusinessDay(%s) = %v, want %v", tc.date.Format("Mon 2006-01-02"), got, tc.want)
+		}
+	}
+}
+
+func TestAddBusinessDays(t *testing.T) {
+	cal, _ := newCalendar(t)
+	wed := time.Date(2025, 12, 24, 10, 0, 0, 0, time.Local)
+
+	for _, tc := range []struct {
+		n    int
+		want time.Time
+	}{
+		{0, wed},
+		{1, time.Date(2025, 12, 26, 10, 0, 0, 0, time.Local)}, // Skips Christmas
+		{2, time.Date(2025, 12, 29, 10, 0, 0, 0, time.Local)}, // And the weekend
+		{-1, time.Date(2025, 12, 23, 10, 0, 0, 0, time.Local)},
+		{-3, time.Date(2025, 12, 19, 10, 0, 0, 0, time.Local)},
+	} {
+		if got := cal.AddBusinessDays(wed, tc.n); !got.Equal(tc.want) {
+			t.Errorf("AddBusinessDays(%d) = %s, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestIsWithinSession(t *testing.T) {
+	cal, lp := newCalendar(t)
+	at := func(day, h, m int) time.Time { return time.Date(2025, 12, day, h, m, 0, 0, time.Local) }
+
+	if !cal.IsWithinSession(at(24, 7, 0)) {
+		t.Error("IsWithinSession() = false on a business day without session times")
+	}
+
+	setSessions(lp)
+	for _, tc := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{at(24, 8, 59), false},
+		{at(24, 9, 0), true},
+		{at(24, 12, 0), false}, // Lunch break
+		{at(24, 14, 0), true},
+		{at(24, 15, 50), false},
+		{at(25, 10, 0), false}, // Holiday
+		{at(27, 10, 0), false}, // Saturday
+	} {
+		if got := cal.IsWithinSession(tc.now); got != tc.want {
+			t.Errorf("IsWithinSession(%s) = %v, want %v", tc.now.Format("Mon 01-02 15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestNextSettlementDate(t *testing.T) {
+	cal, lp := newCalendar(t)
+	setSessions(lp)
+	date := func(day int) time.Time { return time.Date(2025, 12, day, 0, 0, 0, 0, time.Local) }
+
+	for _, tc := range []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2025, 12, 23, 8, 0, 0, 0, time.Local), date(23)},
+		{time.Date(2025, 12, 23, 15, 0, 0, 0, time.Local), date(23)},
+		{time.Date(2025, 12, 23, 16, 0, 0, 0, time.Local), date(24)}, // After the last session
+		{time.Date(2025, 12, 24, 16, 0, 0, 0, time.Local), date(26)}, // Christmas in between
+		{time.Date(2025, 12, 27, 10, 0, 0, 0, time.Local), date(29)}, // Saturday
+	} {
+		if got := cal.NextSettlementDate(tc.now); !got.Equal(tc.want) {
+			t.Errorf("NextSettlementDate(%s) = %s, want %s", tc.now.Format("Mon 01-02 15:04"),
+				got.Format("2006-01-02"), tc.want.Format("2006-01-02"))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ledger
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. Code built on a LedgerPoint reads business
+// time through its Clock (see LedgerPoint.Clock) so tests and simulations can
+// control it. State transitions themselves use event timestamps, so replaying
+// the log always yields the same state regardless of the clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a manually driven Clock for tests and simulations
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a FakeClock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// SetClock replaces the SystemClock the LedgerPoint was created with. Must be
+// called before Start and before creating components that take their clock
+// from the LedgerPoint (risk.Validator, pmeoms.OMS, API handlers).
+func (lp *LedgerPoint) SetClock(c Clock) {
+	lp.clock = c
+}
+
+// Clock returns the clock used to stamp committed events
+func (lp *LedgerPoint) Clock() Clock {
+	return lp.clock
+}
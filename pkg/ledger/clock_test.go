@@ -0,0 +1,49 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeClockStampsEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	clock := NewFakeClock(time.Date(2025, 11, 24, 9, 0, 0, 0, time.UTC))
+	lp.SetClock(clock)
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+
+	if want := "test_20251124090000"; lp.startid != want {
+		t.Errorf("startid = %q, want %q", lp.startid, want)
+	}
+	if snap := lp.CaptureSnapshot(0); !snap.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("snapshot CreatedAt = %v, want %v", snap.CreatedAt, clock.Now())
+	}
+
+	lp.CommitSync(ctx, Account{NID: 1, Code: "YU-001", ParticipantCode: "YU"})
+	clock.Advance(time.Hour)
+	offset, err := lp.CommitSync(ctx, AccountLimit{Code: "YU-001", TradeLimit: 1e9})
+	if err != nil {
+		t.Fatalf("CommitSync() error: %v", err)
+	}
+
+	msgs := transport.Messages()
+	if got := msgs[offset].Time; !got.Equal(clock.Now()) {
+		t.Errorf("message time = %v, want %v", got, clock.Now())
+	}
+
+	// Replaying later yields the event time, not the time of the replay
+	clock.Advance(24 * time.Hour)
+	view, err := lp.ViewAtOffset(ctx, offset)
+	if err != nil {
+		t.Fatalf("ViewAtOffset() error: %v", err)
+	}
+	want := time.Date(2025, 11, 24, 10, 0, 0, 0, time.UTC)
+	if account, _ := view.GetAccount("YU-001"); !account.LastUpdate.Equal(want) {
+		t.Errorf("LastUpdate = %v, want %v", account.LastUpdate, want)
+	}
+}
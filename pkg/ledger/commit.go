@@ -0,0 +1,332 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"pmeonline/pkg/logging"
+)
+
+// commitTimeout bounds how long a single write to the transport may take
+const commitTimeout = 5 * time.Second
+
+// Defaults for the commit queue, see SetCommitQueue
+const (
+	DefaultCommitQueueSize = 1000
+	DefaultCommitBatchSize = 100
+)
+
+// ErrCommitQueueFull is returned by CommitSync when the queue of pending
+// commits is full, i.e. the transport can't keep up. Callers should fail the
+// request (e.g. with 503) rather than wait.
+var ErrCommitQueueFull = errors.New("ledger commit queue full")
+
+// ErrRefused wraps the error of an event refused before it was written: not
+// allowed by the policy, or not encodable
+var ErrRefused = errors.New("event refused")
+
+// NotWritten reports whether err, returned by CommitSync, means the event
+// was not written: the queue was full or the event was refused. After any
+// other error, e.g. ctx cancelled while the event was being appended, it may
+// still have been.
+func NotWritten(err error) bool {
+	return errors.Is(err, ErrCommitQueueFull) || errors.Is(err, ErrRefused)
+}
+
+// commitRequest is an event handed to go_write by CommitSync or Commit.
+// CommitSync queues it on Commit as is, Commit events are wrapped by
+// asyncCommit when they are taken off the queue.
+type commitRequest struct {
+	ctx    context.Context
+	event  any
+	result chan commitResult // nil for Commit, which nobody waits for
+}
+
+type commitResult struct {
+	offset int64
+	err    error
+}
+
+// CommitStats describes the commit path of a LedgerPoint
+type CommitStats struct {
+	QueueDepth    int `json:"queue_depth"`    // Commits waiting to be written
+	QueueCapacity int `json:"queue_capacity"` // Pending commits before ErrCommitQueueFull
+	ReceiveDepth  int `json:"receive_depth"`  // Messages read but not yet applied
+
+	Committed uint64 `json:"committed"` // Events written
+	Failed    uint64 `json:"failed"`    // Events that could not be written
+	Invalid   uint64 `json:"invalid"`   // Events refused before writing: not allowed by the policy, or not encodable
+	Rejected  uint64 `json:"rejected"`  // CommitSync calls refused with ErrCommitQueueFull
+	Batches   uint64 `json:"batches"`   // Appends to the transport
+
+	LastWriteLatency  time.Duration `json:"last_write_latency_ns"`
+	MaxWriteLatency   time.Duration `json:"max_write_latency_ns"`
+	TotalWriteLatency time.Duration `json:"total_write_latency_ns"` // Divide by Batches for the mean
+}
+
+// commitCounters are updated by go_write and read by CommitStats
+type commitCounters struct {
+	committed    atomic.Uint64
+	failed       atomic.Uint64
+	invalid      atomic.Uint64
+	rejected     atomic.Uint64
+	batches      atomic.Uint64
+	lastLatency  atomic.Int64
+	maxLatency   atomic.Int64
+	totalLatency atomic.Int64
+}
+
+func (c *commitCounters) recordAppend(latency time.Duration) {
+	c.batches.Add(1)
+	c.lastLatency.Store(int64(latency))
+	c.totalLatency.Add(int64(latency))
+	for {
+		old := c.maxLatency.Load()
+		if int64(latency) <= old || c.maxLatency.CompareAndSwap(old, int64(latency)) {
+			return
+		}
+	}
+}
+
+// SetCommitQueue sets how many commits may wait for the writer before
+// CommitSync fails with ErrCommitQueueFull and Commit blocks
+// (DefaultCommitQueueSize), and how many queued events are written to the
+// transport in one append (DefaultCommitBatchSize). Must be called before
+// Start.
+func (lp *LedgerPoint) SetCommitQueue(size int, batch int) {
+	lp.Commit = make(chan any, size)
+	lp.commitBatch = max(batch, 1)
+}
+
+// CommitStats returns the depth of the commit queue and the write counters
+func (lp *LedgerPoint) CommitStats() CommitStats {
+	c := &lp.commitCounters
+	return CommitStats{
+		QueueDepth:        len(lp.Commit),
+		QueueCapacity:     cap(lp.Commit),
+		ReceiveDepth:      len(lp.rx),
+		Committed:         c.committed.Load(),
+		Failed:            c.failed.Load(),
+		Invalid:           c.invalid.Load(),
+		Rejected:          c.rejected.Load(),
+		Batches:           c.batches.Load(),
+		LastWriteLatency:  time.Duration(c.lastLatency.Load()),
+		MaxWriteLatency:   time.Duration(c.maxLatency.Load()),
+		TotalWriteLatency: time.Duration(c.totalLatency.Load()),
+	}
+}
+
+// CommitSync writes event to the ledger and waits until the transport has
+// acknowledged it. It returns the offset the event was stored at (within its
+// partition on a partitioned ledger), or an error if the write failed or ctx
+// was cancelled first. When the commit queue is full it fails immediately
+// with ErrCommitQueueFull instead of waiting.
+//
+// An event whose ctx is cancelled while it is queued is not written; once
+// its append has started it may still be.
+//
+// CommitSync and Commit share one queue, so events are written in the order
+// they were queued: an event sent on Commit before a CommitSync call of the
+// same goroutine is written before the event of that call.
+func (lp *LedgerPoint) CommitSync(ctx context.Context, event any) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+	req := commitRequest{
+		ctx:    ctx,
+		event:  event,
+		result: make(chan commitResult, 1),
+	}
+
+	select {
+	case lp.Commit <- req:
+	default:
+		lp.commitCounters.rejected.Add(1)
+		return -1, ErrCommitQueueFull
+	}
+
+	select {
+	case res := <-req.result:
+		return res.offset, res.err
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+// go_write writes queued commits to the transport in queue order. Whatever
+// is queued when it gets to them, up to commitBatch events, goes out in one
+// append per partition.
+func (lp *LedgerPoint) go_write(ctx context.Context) {
+	for {
+		var batch []commitRequest
+		select {
+		case item := <-lp.Commit:
+			batch = append(batch, queued(item))
+		case <-ctx.Done():
+			return
+		}
+
+	drain:
+		for len(batch) < lp.commitBatch {
+			select {
+			case item := <-lp.Commit:
+				batch = append(batch, queued(item))
+			default:
+				break drain
+			}
+		}
+		lp.writeBatch(batch)
+	}
+}
+
+// queued returns the request of an item of the Commit queue
+func queued(item any) commitRequest {
+	if req, ok := item.(commitRequest); ok {
+		return req
+	}
+	return asyncCommit(item)
+}
+
+// pendingWrite is one encoded event of a batch
+type pendingWrite struct {
+	msg     Message
+	offset  int64
+	err     error
+	invalid bool // Refused by encode, writing it again would fail the same way
+	span    trace.Span
+}
+
+// writeBatch encodes the events of batch, appends them to their partitions
+// and reports the outcome of each
+func (lp *LedgerPoint) writeBatch(batch []commitRequest) {
+	writes := make([]pendingWrite, len(batch))
+	var order []int                    // Partitions in first-use order
+	byPartition := make(map[int][]int) // Indexes into writes per partition
+	for i, req := range batch {
+		w := &writes[i]
+		w.offset = -1
+		// The context of a Commit event only carries its trace
+		if req.result != nil {
+			if w.err = req.ctx.Err(); w.err != nil {
+				continue
+			}
+		}
+		if w.msg, w.err = lp.encode(req.event); w.err != nil {
+			w.err = fmt.Errorf("%w: %w", ErrRefused, w.err)
+			w.invalid = true
+			continue
+		}
+		w.span = lp.startCommitSpan(req.ctx, &w.msg)
+		for _, p := range lp.partitionsFor(req.event) {
+			if _, ok := byPartition[p]; !ok {
+				order = append(order, p)
+			}
+			byPartition[p] = append(byPartition[p], i)
+		}
+	}
+
+	for _, p := range order {
+		idx := byPartition[p]
+		msgs := make([]Message, len(idx))
+		for j, i := range idx {
+			msgs[j] = writes[i].msg
+		}
+
+		writeCtx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+		start := time.Now()
+		first, err := lp.partitions[p].Append(writeCtx, msgs...)
+		cancel()
+		lp.commitCounters.recordAppend(time.Since(start))
+
+		// Events written to several partitions report their first one
+		for j, i := range idx {
+			w := &writes[i]
+			if err != nil {
+				w.err = fmt.Errorf("failed to write message to partition %d: %w", p, err)
+			} else if w.offset < 0 {
+				w.offset = first + int64(j)
+			}
+		}
+	}
+
+	for i, req := range batch {
+		w := writes[i]
+		if w.span != nil {
+			endCommitSpan(w.span, lp.partitionsFor(req.event)[0], w.offset, w.err)
+		}
+		switch {
+		case w.err != nil && w.invalid:
+			w.offset = -1
+			lp.commitCounters.invalid.Add(1)
+			if req.result == nil {
+				// A restart would refuse it again, so it goes to the
+				// dead-letter handler instead
+				lp.Logger().Error("Refused to commit", logging.EventType(events.name(req.event)), "error", w.err)
+				lp.deadLetter(lp.unwritten(req.event), w.err)
+			}
+		case w.err != nil:
+			w.offset = -1
+			lp.commitCounters.failed.Add(1)
+			if req.result == nil {
+				// Nobody waits for a Commit event, so its producer can't
+				// retry it: stop rather than lose it, and let the restart
+				// replay the ledger
+				lp.fatal("Failed to commit", logging.EventType(events.name(req.event)), "error", w.err)
+			}
+		default:
+			lp.commitCounters.committed.Add(1)
+		}
+		if req.result != nil {
+			req.result <- commitResult{offset: w.offset, err: w.err}
+		}
+	}
+}
+
+// unwritten stands in for an event encode refused when it is handed to the
+// dead-letter handler: its type, producer and plain JSON payload, at offset -1
+func (lp *LedgerPoint) unwritten(event any) Message {
+	value, _ := json.Marshal(event)
+	return Message{
+		Offset: -1,
+		Value:  value,
+		Headers: []Header{
+			{Key: HeaderEventType, Value: []byte(events.name(event))},
+			{Key: HeaderProducer, Value: []byte(lp.id)},
+		},
+		Time: lp.clock.Now(),
+	}
+}
+
+// encode checks the policy for event, wraps it in an envelope and seals it
+func (lp *LedgerPoint) encode(event any) (Message, error) {
+	if err := lp.policy.check(lp.id, events.name(event)); err != nil {
+		return Message{}, err
+	}
+
+	eventID, err := lp.nextEventID()
+	if err != nil {
+		return Message{}, err
+	}
+	msg, err := events.encode(event, lp.id, eventID)
+	if err != nil {
+		return Message{}, err
+	}
+	// Stamp with our clock so the log (and so every replay) carries it
+	msg.Time = lp.clock.Now()
+	if lp.security != nil {
+		if err := lp.security.seal(&msg); err != nil {
+			return Message{}, fmt.Errorf("failed to seal %T: %w", event, err)
+		}
+	}
+
+	if code := lp.instrumentOf(event); code != "" && lp.Partitioned() {
+		msg.Key = []byte(code)
+	}
+	return msg, nil
+}
@@ -0,0 +1,272 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommitSyncReturnsOffset(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	lp.Start(nil, ctx)
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer reqCancel()
+
+	offset, err := lp.CommitSync(reqCtx, Holiday{NID: 1, Description: "New Year"})
+	if err != nil {
+		t.Fatalf("CommitSync() error: %v", err)
+	}
+
+	msgs := transport.Messages()
+	if offset < 0 || offset >= int64(len(msgs)) {
+		t.Fatalf("CommitSync() offset = %d, log has %d messages", offset, len(msgs))
+	}
+	if got := string(msgs[offset].Headers[0].Value); got != "Holiday" {
+		t.Errorf("message at offset %d is %q, want Holiday", offset, got)
+	}
+}
+
+func TestCommitSyncReportsTransportFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	lp.Start(nil, ctx)
+	<-lp.Ready() // ServiceStart is written; a failed Commit would stop the process
+	transport.Close()
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer reqCancel()
+
+	if _, err := lp.CommitSync(reqCtx, Holiday{NID: 1}); err == nil {
+		t.Fatal("CommitSync() on a closed transport returned no error")
+	}
+	if _, err := lp.CommitSync(reqCtx, struct{}{}); err == nil {
+		t.Fatal("CommitSync() with an unknown event type returned no error")
+	}
+}
+
+func TestUnauthorisedCommitIsDeadLettered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SetPolicy(Policy{"test": {"Holiday"}})
+	deadLetters := make(chan Message, 1)
+	lp.SetDeadLetterHandler(func(msg Message, err error) {
+		if errors.Is(err, ErrUnauthorised) {
+			deadLetters <- msg
+		}
+	})
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+
+	// Exiting would take the test down with it
+	lp.Commit <- Parameter{NID: 1}
+	select {
+	case msg := <-deadLetters:
+		if env, _ := ParseEnvelope(msg.Headers); env.EventType != "Parameter" || msg.Offset != -1 {
+			t.Errorf("dead letter %s at offset %d, want Parameter at -1", env.EventType, msg.Offset)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("unauthorised Commit was not dead-lettered")
+	}
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer reqCancel()
+	if _, err := lp.CommitSync(reqCtx, Holiday{NID: 1}); err != nil {
+		t.Fatalf("CommitSync() after an unauthorised Commit error: %v", err)
+	}
+	if stats := lp.CommitStats(); stats.Invalid != 1 || stats.Failed != 0 {
+		t.Errorf("CommitStats() = %+v, want 1 invalid and none failed", stats)
+	}
+}
+
+// gatedTransport holds appends until release is closed
+type gatedTransport struct {
+	*MemoryTransport
+	release chan struct{}
+	appends chan int // Number of messages of each append, sent when it starts
+}
+
+func newGatedTransport() *gatedTransport {
+	return &gatedTransport{NewMemoryTransport("pme-ledger"), make(chan struct{}), make(chan int, 100)}
+}
+
+func (t *gatedTransport) Append(ctx context.Context, msgs ...Message) (int64, error) {
+	t.appends <- len(msgs)
+	select {
+	case <-t.release:
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+	return t.MemoryTransport.Append(ctx, msgs...)
+}
+
+func TestCommitSyncFailsWhenQueueIsFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := newGatedTransport()
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	lp.SetCommitQueue(2, 1)
+	lp.Start(nil, ctx)
+
+	// The writer holds ServiceStart; two more fill the queue
+	<-transport.appends
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func(nid int) {
+			_, err := lp.CommitSync(ctx, Holiday{NID: nid})
+			results <- err
+		}(i + 1)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for lp.CommitStats().QueueDepth < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := lp.CommitSync(ctx, Holiday{NID: 3}); !errors.Is(err, ErrCommitQueueFull) || !NotWritten(err) {
+		t.Fatalf("CommitSync() on a full queue error = %v, want ErrCommitQueueFull", err)
+	}
+	if stats := lp.CommitStats(); stats.Rejected != 1 || stats.QueueCapacity != 2 {
+		t.Errorf("CommitStats() = %+v, want 1 rejected of capacity 2", stats)
+	}
+
+	close(transport.release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("queued CommitSync() error: %v", err)
+		}
+	}
+}
+
+func TestCommitsAreBatched(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := newGatedTransport()
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	lp.Start(nil, ctx)
+
+	// Queue the events while the writer holds ServiceStart
+	if first := <-transport.appends; first != 1 {
+		t.Fatalf("ServiceStart appended with %d messages, want 1", first)
+	}
+	const n = 20
+	offsets := make(chan int64, n)
+	for i := 0; i < n; i++ {
+		go func(nid int) {
+			offset, err := lp.CommitSync(ctx, Holiday{NID: nid})
+			if err != nil {
+				t.Errorf("CommitSync() error: %v", err)
+			}
+			offsets <- offset
+		}(i + 1)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for lp.CommitStats().QueueDepth < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(transport.release)
+
+	if second := <-transport.appends; second != n {
+		t.Errorf("queued events appended with %d messages, want %d", second, n)
+	}
+
+	seen := make(map[int64]bool)
+	for i := 0; i < n; i++ {
+		offset := <-offsets
+		msgs := transport.Messages()
+		if seen[offset] {
+			t.Errorf("offset %d returned twice", offset)
+		}
+		seen[offset] = true
+		if env, _ := ParseEnvelope(msgs[offset].Headers); env.EventType != "Holiday" {
+			t.Errorf("offset %d holds %s, want Holiday", offset, env.EventType)
+		}
+	}
+
+	stats := lp.CommitStats()
+	if stats.Committed != n+1 || stats.Batches != 2 || stats.MaxWriteLatency <= 0 {
+		t.Errorf("CommitStats() = %+v, want %d committed in 2 batches", stats, n+1)
+	}
+}
+
+func TestCommitSyncFromSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	acked := make(chan error, 1)
+	Subscribe(lp, func(h Holiday) {
+		if h.NID == 1 {
+			_, err := lp.CommitSync(ctx, Holiday{NID: 2})
+			acked <- err
+		}
+	})
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+
+	lp.Commit <- Holiday{NID: 1}
+	select {
+	case err := <-acked:
+		if err != nil {
+			t.Errorf("CommitSync() from subscriber error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("CommitSync() from subscriber did not return")
+	}
+}
+
+func TestCommitAndCommitSyncKeepQueueOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := newGatedTransport()
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	lp.SetCommitQueue(10, 1)
+	lp.Start(nil, ctx)
+
+	// Queue Commit, CommitSync, Commit while the writer holds ServiceStart
+	<-transport.appends
+	lp.Commit <- Holiday{NID: 1}
+	synced := make(chan error, 1)
+	go func() {
+		_, err := lp.CommitSync(ctx, Holiday{NID: 2})
+		synced <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for lp.CommitStats().QueueDepth < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	lp.Commit <- Holiday{NID: 3}
+
+	close(transport.release)
+	if err := <-synced; err != nil {
+		t.Fatalf("CommitSync() error: %v", err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for len(transport.Messages()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	var nids []int
+	for _, msg := range transport.Messages()[1:] {
+		_, event, err := lp.DecodeMessage(msg)
+		if err != nil {
+			t.Fatalf("DecodeMessage() error: %v", err)
+		}
+		nids = append(nids, event.(Holiday).NID)
+	}
+	if len(nids) != 3 || nids[0] != 1 || nids[1] != 2 || nids[2] != 3 {
+		t.Errorf("holidays written in order %v, want [1 2 3]", nids)
+	}
+}
@@ -0,0 +1,159 @@
+package ledger
+
+import (
+	"errors"
+	"strings"
+)
+
+// Concentration. A ConcentrationLimit caps how much can be borrowed within a
+// scope: one participant in one instrument, one instrument market-wide, one
+// SID, or any other combination of the three. What a scope has outstanding
+// is worked out from the ledger: the quantity of its BORR contracts not yet
+// reimbursed or rejected, plus what its open and pending BORR orders could
+// still add when matched. Values use the market price of the order behind
+// each contract.
+//
+// On a partitioned ledger a LedgerPoint assigned only some partitions holds
+// the orders and contracts of their instruments only, so it can't tell the
+// borrowing of scopes without an instrument.
+
+// ErrScopeNeedsInstrument is returned by GetBorrowOutstanding for scopes
+// without an instrument on a LedgerPoint that doesn't consume every partition
+var ErrScopeNeedsInstrument = errors.New("scope without an instrument spans partitions not consumed")
+
+// ConcentrationScope selects borrowing by participant, instrument and SID;
+// blank fields match any
+type ConcentrationScope struct {
+	ParticipantCode string `json:"participant_code"`
+	InstrumentCode  string `json:"instrument_code"`
+	SID             string `json:"sid"`
+}
+
+// Scope returns the scope the limit applies to
+func (l ConcentrationLimitEntity) Scope() ConcentrationScope {
+	return ConcentrationScope{ParticipantCode: l.ParticipantCode, InstrumentCode: l.InstrumentCode, SID: l.SID}
+}
+
+// key identifies the scope in the limit map and snapshots
+func (s ConcentrationScope) key() string {
+	return s.ParticipantCode + "|" + s.InstrumentCode + "|" + s.SID
+}
+
+// String describes the scope, e.g. "participant YU, instrument BBRI"
+func (s ConcentrationScope) String() string {
+	var parts []string
+	if s.ParticipantCode != "" {
+		parts = append(parts, "participant "+s.ParticipantCode)
+	}
+	if s.InstrumentCode != "" {
+		parts = append(parts, "instrument "+s.InstrumentCode)
+	}
+	if s.SID != "" {
+		parts = append(parts, "SID "+s.SID)
+	}
+	if len(parts) == 0 {
+		return "market"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Matches reports whether an order or contract of the participant,
+// instrument and SID falls in the scope
+func (s ConcentrationScope) Matches(participantCode string, instrumentCode string, sid string) bool {
+	return (s.ParticipantCode == "" || s.ParticipantCode == participantCode) &&
+		(s.InstrumentCode == "" || s.InstrumentCode == instrumentCode) &&
+		(s.SID == "" || s.SID == sid)
+}
+
+// BorrowOutstanding is the borrowing of a scope
+type BorrowOutstanding struct {
+	Quantity float64 `json:"quantity"`
+	Value    float64 `json:"value"`
+}
+
+// GetBorrowOutstanding returns the borrowing of scope: live BORR contracts
+// and the unmatched quantity of open, partially matched and pending BORR
+// orders. It fails with ErrScopeNeedsInstrument rather than under-count a
+// scope covering partitions the LedgerPoint doesn't consume.
+func (lp *LedgerPoint) GetBorrowOutstanding(scope ConcentrationScope) (BorrowOutstanding, error) {
+	if scope.InstrumentCode == "" && !lp.ConsumesAllPartitions() {
+		return BorrowOutstanding{}, ErrScopeNeedsInstrument
+	}
+
+	// Accounts of the SID, read before the order and contract locks
+	var accounts map[string]struct{}
+	if scope.SID != "" {
+		accounts = make(map[string]struct{})
+		lp.ForEachAccount(func(a AccountEntity) bool {
+			if a.SID == scope.SID {
+				accounts[a.Code] = struct{}{}
+			}
+			return true
+		})
+	}
+	inScope := func(participantCode string, instrumentCode string, accountCode string) bool {
+		if accounts != nil {
+			if _, ok := accounts[accountCode]; !ok {
+				return false
+			}
+		}
+		return (scope.ParticipantCode == "" || scope.ParticipantCode == participantCode) &&
+			(scope.InstrumentCode == "" || scope.InstrumentCode == instrumentCode)
+	}
+
+	var out BorrowOutstanding
+
+	// Same order as SyncTrade, so a trade is seen either before or after
+	lp.ordersMu.RLock()
+	lp.contractsMu.RLock()
+	orderNIDs := candidates(scope, lp.orderIdx.byInstrument, lp.orderIdx.byParticipant, lp.orderIdx.byAccount, accounts, lp.orders)
+	for nid := range orderNIDs {
+		order := lp.orders[nid]
+		if order.Side != "BORR" || (order.State != "O" && order.State != "P" && order.State != "G") ||
+			!inScope(order.ParticipantCode, order.InstrumentCode, order.AccountCode) {
+			continue
+		}
+		open := order.Quantity - order.DoneQuantity
+		out.Quantity += open
+		out.Value += open * order.MarketPrice
+	}
+	contractNIDs := candidates(scope, lp.contractIdx.byInstrument, lp.contractIdx.byParticipant, lp.contractIdx.byAccount, accounts, lp.contracts)
+	for nid := range contractNIDs {
+		contract := lp.contracts[nid]
+		if contract.Side != "BORR" || contract.State == "R" || contract.State == "C" ||
+			!inScope(contract.AccountParticipantCode, contract.InstrumentCode, contract.AccountCode) {
+			continue
+		}
+		out.Quantity += contract.Quantity
+		out.Value += contract.Quantity * lp.orders[contract.OrderNID].MarketPrice
+	}
+	lp.contractsMu.RUnlock()
+	lp.ordersMu.RUnlock()
+
+	return out, nil
+}
+
+// candidates returns the NIDs to look at for the scope from the narrowest
+// index available, every NID of all when the scope is empty
+func candidates[E any](s ConcentrationScope, byInstrument nidIndex, byParticipant nidIndex, byAccount nidIndex,
+	accounts map[string]struct{}, all map[int]E) map[int]struct{} {
+	switch {
+	case s.InstrumentCode != "":
+		return byInstrument[s.InstrumentCode]
+	case s.ParticipantCode != "":
+		return byParticipant[s.ParticipantCode]
+	case accounts != nil:
+		nids := make(map[int]struct{})
+		for code := range accounts {
+			for nid := range byAccount[code] {
+				nids[nid] = struct{}{}
+			}
+		}
+		return nids
+	}
+	nids := make(map[int]struct{}, len(all))
+	for nid := range all {
+		nids[nid] = struct{}{}
+	}
+	return nids
+}
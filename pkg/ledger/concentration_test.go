@@ -0,0 +1,97 @@
+package ledger
+
+import "testing"
+
+func TestBorrowOutstanding(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(Account{Code: "YU-001", SID: "IDD1", ParticipantCode: "YU"})
+	lp.SyncAccount(Account{Code: "YU-002", SID: "IDD2", ParticipantCode: "YU"})
+	lp.SyncAccount(Account{Code: "AK-001", SID: "IDD1", ParticipantCode: "AK"})
+
+	order := func(nid int, account string, participant string, instrument string, qty float64) {
+		lp.SyncOrder(Order{NID: nid, AccountCode: account, ParticipantCode: participant, InstrumentCode: instrument,
+			Side: "BORR", Quantity: qty, MarketPrice: 10})
+		lp.SyncOrderAck(OrderAck{OrderNID: nid})
+	}
+	order(1, "YU-001", "YU", "BBRI", 100)
+	order(2, "YU-002", "YU", "BBRI", 200)
+	order(3, "AK-001", "AK", "BBRI", 400)
+	order(4, "YU-001", "YU", "TLKM", 800)
+	lp.SyncOrder(Order{NID: 9, AccountCode: "AK-001", InstrumentCode: "BBRI", Side: "LEND", Quantity: 1000, MarketPrice: 10})
+
+	// 60 of order 1 matched: 40 stays open, 60 is under contract
+	lp.SyncTrade(Trade{NID: 7, InstrumentCode: "BBRI", Quantity: 60,
+		Borrower: []Contract{{NID: 71, TradeNID: 7, OrderNID: 1, AccountCode: "YU-001", AccountParticipantCode: "YU",
+			InstrumentCode: "BBRI", Side: "BORR", Quantity: 60}},
+		Lender: []Contract{{NID: 72, TradeNID: 7, OrderNID: 9, AccountCode: "AK-001", AccountParticipantCode: "AK",
+			InstrumentCode: "BBRI", Side: "LEND", Quantity: 60}},
+	})
+
+	for _, tc := range []struct {
+		scope ConcentrationScope
+		want  float64
+	}{
+		{ConcentrationScope{ParticipantCode: "YU", InstrumentCode: "BBRI"}, 300},
+		{ConcentrationScope{InstrumentCode: "BBRI"}, 700},
+		{ConcentrationScope{SID: "IDD1"}, 1300},
+		{ConcentrationScope{SID: "IDD1", InstrumentCode: "BBRI"}, 500},
+		{ConcentrationScope{ParticipantCode: "YU"}, 1100},
+	} {
+		got, err := lp.GetBorrowOutstanding(tc.scope)
+		if err != nil || got.Quantity != tc.want || got.Value != tc.want*10 {
+			t.Errorf("GetBorrowOutstanding(%s) = %+v, %v, want quantity %.0f", tc.scope, got, err, tc.want)
+		}
+	}
+
+	// Reimbursed contracts and withdrawn orders no longer count
+	lp.SyncTradeReimburse(TradeReimburse{TradeNID: 7})
+	lp.SyncOrderWithdrawAck(OrderWithdrawAck{OrderNID: 2})
+	scope := ConcentrationScope{ParticipantCode: "YU", InstrumentCode: "BBRI"}
+	if got, _ := lp.GetBorrowOutstanding(scope); got.Quantity != 40 {
+		t.Errorf("GetBorrowOutstanding(%s) after reimburse and withdraw = %+v, want quantity 40", scope, got)
+	}
+}
+
+func TestConcentrationLimitReplaceAndRemove(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	scope := ConcentrationScope{InstrumentCode: "BBRI"}
+
+	lp.SyncConcentrationLimit(ConcentrationLimit{InstrumentCode: "BBRI", MaxQuantity: 1000})
+	lp.SyncConcentrationLimit(ConcentrationLimit{InstrumentCode: "BBRI", MaxValue: 5000})
+	limit, exists := lp.GetConcentrationLimit(scope)
+	if !exists || limit.MaxQuantity != 0 || limit.MaxValue != 5000 {
+		t.Errorf("GetConcentrationLimit() = %+v, %v, want the second limit", limit, exists)
+	}
+
+	restored := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	restored.RestoreSnapshot(lp.CaptureSnapshot(0))
+	if got, _ := restored.GetConcentrationLimit(scope); got != limit {
+		t.Errorf("restored limit = %+v, want %+v", got, limit)
+	}
+
+	lp.SyncConcentrationLimit(ConcentrationLimit{InstrumentCode: "BBRI"})
+	if _, exists := lp.GetConcentrationLimit(scope); exists {
+		t.Error("a limit without maxima was kept")
+	}
+}
+
+func TestBorrowOutstandingOnPartitions(t *testing.T) {
+	transports := []Transport{NewMemoryTransport("pme-ledger"), NewMemoryTransport("pme-ledger"), NewMemoryTransport("pme-ledger")}
+	some, err := CreateLedgerPointWithPartitions(transports, []int{1}, "pmeoms")
+	if err != nil {
+		t.Fatalf("CreateLedgerPointWithPartitions() error: %v", err)
+	}
+	all, _ := CreateLedgerPointWithPartitions(transports, nil, "eclearapi")
+
+	for _, scope := range []ConcentrationScope{{ParticipantCode: "YU"}, {SID: "IDD1"}} {
+		if _, err := some.GetBorrowOutstanding(scope); err != ErrScopeNeedsInstrument {
+			t.Errorf("GetBorrowOutstanding(%s) on some partitions error = %v, want ErrScopeNeedsInstrument", scope, err)
+		}
+		if _, err := all.GetBorrowOutstanding(scope); err != nil {
+			t.Errorf("GetBorrowOutstanding(%s) on all partitions error: %v", scope, err)
+		}
+	}
+	if _, err := some.GetBorrowOutstanding(ConcentrationScope{ParticipantCode: "YU", InstrumentCode: "BBRI"}); err != nil {
+		t.Errorf("GetBorrowOutstanding() of an instrument error: %v", err)
+	}
+}
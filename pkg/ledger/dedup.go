@@ -0,0 +1,102 @@
+package ledger
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	"pmeonline/pkg/idgen"
+)
+
+// DefaultDedupWindow is the number of recent event IDs a LedgerPoint
+// remembers to drop redelivered messages
+const DefaultDedupWindow = 10000
+
+// dedupWindow remembers the last size keys in insertion order
+type dedupWindow struct {
+	size int
+	seen map[string]struct{}
+	ring []string
+	next int
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		size: size,
+		seen: make(map[string]struct{}, size),
+		ring: make([]string, 0, size),
+	}
+}
+
+// add records key and reports whether it was new. The oldest key is
+// forgotten once the window is full.
+func (w *dedupWindow) add(key string) bool {
+	if w.size <= 0 {
+		return true
+	}
+	if _, dup := w.seen[key]; dup {
+		return false
+	}
+
+	if len(w.ring) < w.size {
+		w.ring = append(w.ring, key)
+	} else {
+		delete(w.seen, w.ring[w.next])
+		w.ring[w.next] = key
+		w.next = (w.next + 1) % w.size
+	}
+	w.seen[key] = struct{}{}
+	return true
+}
+
+// keys returns the remembered keys, oldest first
+func (w *dedupWindow) keys() []string {
+	if len(w.ring) < w.size {
+		return append([]string(nil), w.ring...)
+	}
+	return append(append([]string(nil), w.ring[w.next:]...), w.ring[:w.next]...)
+}
+
+// dedupKey identifies a message by its partition, producer and event ID
+// (ServiceStart is written to several partitions with the same ID). Messages
+// without an event ID (written before the envelope existed) return "".
+func dedupKey(msg Message, env Envelope) string {
+	if env.EventID == "" {
+		return ""
+	}
+	return strconv.Itoa(msg.Partition) + "/" + env.Producer + "/" + env.EventID
+}
+
+// SetDedupWindow sets how many recent event IDs are remembered to drop
+// redelivered messages (DefaultDedupWindow by default, 0 disables it). Must
+// be called before Start.
+func (lp *LedgerPoint) SetDedupWindow(size int) {
+	lp.dedup = newDedupWindow(size)
+}
+
+// SetIDGenerator replaces the generator of event IDs, e.g. with the one a
+// service already uses for order NIDs so both share its INSTANCE_ID. Must be
+// called before Start.
+func (lp *LedgerPoint) SetIDGenerator(g *idgen.Generator) {
+	lp.ids = g
+}
+
+// defaultIDGenerator derives the Snowflake instance from the LedgerPoint ID
+func defaultIDGenerator(id string) *idgen.Generator {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	g, err := idgen.NewGenerator(int64(h.Sum32() % (idgen.MaxInstance + 1)))
+	if err != nil {
+		// The instance is always in range
+		panic(err)
+	}
+	return g
+}
+
+// nextEventID returns a unique ID for the next committed event
+func (lp *LedgerPoint) nextEventID() (string, error) {
+	id, err := lp.ids.NextID()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
@@ -0,0 +1,101 @@
+package ledger
+
+import (
+	"testing"
+)
+
+func testTrade(nid int, qty float64) Trade {
+	return Trade{
+		NID:            nid,
+		InstrumentCode: "BBRI",
+		Quantity:       qty,
+		Borrower:       []Contract{{NID: nid*10 + 1, TradeNID: nid, OrderNID: 1, Side: "BORR", Quantity: qty}},
+		Lender:         []Contract{{NID: nid*10 + 2, TradeNID: nid, OrderNID: 2, Side: "LEND", Quantity: qty}},
+	}
+}
+
+func TestRedeliveredMessageIsSkipped(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncOrder(Order{NID: 1, Side: "BORR", Quantity: 100})
+	lp.SyncOrder(Order{NID: 2, Side: "LEND", Quantity: 100})
+
+	msg, err := events.encode(testTrade(7, 40), "pmeoms", "1001")
+	if err != nil {
+		t.Fatalf("encode() error: %v", err)
+	}
+	for offset := int64(0); offset < 2; offset++ {
+		msg.Offset = offset
+		if err := lp.ApplyMessage(msg); err != nil {
+			t.Fatalf("ApplyMessage() error: %v", err)
+		}
+	}
+
+	if order, _ := lp.GetOrder(1); order.DoneQuantity != 40 {
+		t.Errorf("DoneQuantity = %v after a redelivered Trade, want 40", order.DoneQuantity)
+	}
+
+	// The same event ID from another producer is a different event
+	other, _ := events.encode(Order{NID: 3, Side: "BORR", Quantity: 10}, "pmeapi", "1001")
+	lp.ApplyMessage(other)
+	if _, ok := lp.GetOrder(3); !ok {
+		t.Error("event from another producer with the same ID was skipped")
+	}
+}
+
+func TestSyncIsIdempotent(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncOrder(Order{NID: 1, Side: "BORR", Quantity: 100})
+	lp.SyncOrder(Order{NID: 2, Side: "LEND", Quantity: 100})
+	lp.SyncOrderAck(OrderAck{OrderNID: 1})
+	lp.SyncOrderAck(OrderAck{OrderNID: 2})
+
+	lp.SyncTrade(testTrade(7, 40))
+	lp.SyncTrade(testTrade(7, 40))
+	if order, _ := lp.GetOrder(1); order.DoneQuantity != 40 || order.State != "P" {
+		t.Errorf("after repeated Trade = %.0f %q, want 40 P", order.DoneQuantity, order.State)
+	}
+
+	// A late OrderAck does not reopen a partially matched order
+	lp.SyncOrderAck(OrderAck{OrderNID: 1})
+	if order, _ := lp.GetOrder(1); order.State != "P" {
+		t.Errorf("state after late OrderAck = %q, want P", order.State)
+	}
+
+	lp.SyncTradeNak(TradeNak{TradeNID: 7})
+	lp.SyncTradeNak(TradeNak{TradeNID: 7})
+	if order, _ := lp.GetOrder(2); order.DoneQuantity != 0 || order.State != "O" {
+		t.Errorf("after repeated TradeNak = %.0f %q, want 0 O", order.DoneQuantity, order.State)
+	}
+
+	// A repeated Order keeps the state
+	lp.SyncOrder(Order{NID: 1, Side: "BORR", Quantity: 100})
+	if order, _ := lp.GetOrder(1); order.State != "O" {
+		t.Errorf("state after repeated Order = %q, want O", order.State)
+	}
+
+	// A repeated Account keeps the limits
+	lp.SyncAccount(Account{Code: "YU-001"})
+	lp.SyncAccountLimit(AccountLimit{Code: "YU-001", TradeLimit: 1e9})
+	lp.SyncAccount(Account{Code: "YU-001"})
+	if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 1e9 {
+		t.Errorf("TradeLimit after repeated Account = %v, want 1e9", account.TradeLimit)
+	}
+}
+
+func TestDedupWindowEvictsOldest(t *testing.T) {
+	w := newDedupWindow(2)
+	for _, key := range []string{"a", "b", "c"} {
+		if !w.add(key) {
+			t.Fatalf("add(%q) = false for a new key", key)
+		}
+	}
+	if w.add("c") {
+		t.Error("add(c) = true for a remembered key")
+	}
+	if !w.add("a") {
+		t.Error("add(a) = false after it was evicted")
+	}
+	if keys := w.keys(); len(keys) != 2 || keys[0] != "c" || keys[1] != "a" {
+		t.Errorf("keys() = %v, want [c a]", keys)
+	}
+}
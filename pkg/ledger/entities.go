@@ -33,6 +33,25 @@ type SessionTimeEntity struct {
 	LastUpdate    time.Time `json:"last_update"`
 }
 
+type RiskRuleEntity struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Enabled     bool              `json:"enabled"`
+	Priority    int               `json:"priority"`
+	Params      map[string]string `json:"params"`
+	LastUpdate  time.Time         `json:"last_update"`
+}
+
+type ConcentrationLimitEntity struct {
+	ParticipantCode string    `json:"participant_code"`
+	InstrumentCode  string    `json:"instrument_code"`
+	SID             string    `json:"sid"`
+	MaxQuantity     float64   `json:"max_quantity"`
+	MaxValue        float64   `json:"max_value"`
+	Description     string    `json:"description"`
+	LastUpdate      time.Time `json:"last_update"`
+}
+
 type InstrumentEntity struct {
 	NID        int       `json:"nid"`
 	Code       string    `json:"code"` // KPEI-012345
@@ -76,6 +95,7 @@ type OrderEntity struct {
 	Side              string    `json:"side"`
 	Quantity          float64   `json:"quantity"`
 	DoneQuantity      float64   `json:"done_quantity"`
+	ReservedLimit     float64   `json:"reserved_limit"` // Trading (BORR) or pool (LEND) limit held for the open quantity, see exposure.go
 	SettlementDate    time.Time `json:"settlement_date"`
 	ReimbursementDate time.Time `json:"reimbursement_date"`
 	Periode           int       `json:"periode"`
@@ -131,6 +151,7 @@ type ContractEntity struct {
 	FeeFlatVal             float64   `json:"fee_flat_val"`
 	FeeValDaily            float64   `json:"fee_val_daily"`
 	FeeValAccumulated      float64   `json:"fee_val_accumulated"`
+	UtilisedLimit          float64   `json:"utilised_limit"` // Trading (BORR) or pool (LEND) limit used until reimbursed, see exposure.go
 	MatchedAt              time.Time `json:"matched_at"`
 	ReimburseAt            time.Time `json:"reimburse_at"`
 }
@@ -102,8 +102,9 @@ type Order struct {
 }
 
 type OrderAck struct {
-	Timestamp time.Time `json:"timestamp"`
-	OrderNID  int       `json:"order_nid"`
+	Timestamp     time.Time `json:"timestamp"`
+	OrderNID      int       `json:"order_nid"`
+	ReservedLimit float64   `json:"reserved_limit"` // Trading (BORR) or pool (LEND) limit the order holds while open
 }
 
 type OrderNak struct {
@@ -206,3 +207,27 @@ type Eod struct {
 	Timestamp time.Time `json:"timestamp"`
 	Date      time.Time `json:"date"`
 }
+
+// RiskRule configures one pre-trade rule of pkg/ledger/risk by name. It
+// replaces the previous configuration of the rule as a whole.
+type RiskRule struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Enabled     bool              `json:"enabled"`
+	Priority    int               `json:"priority"` // Lower runs first
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+// ConcentrationLimit caps the outstanding borrowing of a participant,
+// instrument and SID; blank ones match any (see ConcentrationScope). It
+// replaces the limit of the same scope, and zero maxima remove it.
+type ConcentrationLimit struct {
+	Timestamp       time.Time `json:"timestamp"`
+	ParticipantCode string    `json:"participant_code"`
+	InstrumentCode  string    `json:"instrument_code"`
+	SID             string    `json:"sid"`
+	MaxQuantity     float64   `json:"max_quantity"` // Shares, 0 for no cap
+	MaxValue        float64   `json:"max_value"`    // Market value, 0 for no cap
+	Description     string    `json:"description"`
+}
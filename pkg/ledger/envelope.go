@@ -0,0 +1,102 @@
+package ledger
+
+import (
+	"fmt"
+	"strconv"
+
+	"pmeonline/pkg/logging"
+)
+
+// Envelope header keys. Every message written by a LedgerPoint carries all
+// four; messages from older producers may only carry HeaderEventType.
+const (
+	HeaderEventType     = "event-type"
+	HeaderSchemaVersion = "schema-version"
+	HeaderProducer      = "producer"
+	HeaderEventID       = "event-id"
+)
+
+// Envelope describes a ledger message independently of its payload
+type Envelope struct {
+	EventType     string
+	SchemaVersion int
+	Producer      string
+	EventID       string
+
+	legacy bool // Parsed from a message with only the event-type header
+}
+
+// Legacy reports whether the envelope was parsed from a message written
+// before the envelope existed, which carries the event type only
+func (e Envelope) Legacy() bool {
+	return e.legacy
+}
+
+// Headers returns the envelope as message headers
+func (e Envelope) Headers() []Header {
+	return []Header{
+		{Key: HeaderEventType, Value: []byte(e.EventType)},
+		{Key: HeaderSchemaVersion, Value: []byte(strconv.Itoa(e.SchemaVersion))},
+		{Key: HeaderProducer, Value: []byte(e.Producer)},
+		{Key: HeaderEventID, Value: []byte(e.EventID)},
+	}
+}
+
+// ParseEnvelope reads the envelope from message headers. Messages written
+// before the envelope existed have no schema-version and are treated as
+// version 1.
+func ParseEnvelope(headers []Header) (Envelope, error) {
+	env := Envelope{SchemaVersion: 1, legacy: true}
+	for _, h := range headers {
+		switch h.Key {
+		case HeaderSchemaVersion, HeaderProducer, HeaderEventID:
+			env.legacy = false
+		}
+		switch h.Key {
+		case HeaderEventType:
+			env.EventType = string(h.Value)
+		case HeaderSchemaVersion:
+			v, err := strconv.Atoi(string(h.Value))
+			if err != nil || v < 1 {
+				return env, fmt.Errorf("invalid schema version %q", h.Value)
+			}
+			env.SchemaVersion = v
+		case HeaderProducer:
+			env.Producer = string(h.Value)
+		case HeaderEventID:
+			env.EventID = string(h.Value)
+		}
+	}
+	if env.EventType == "" {
+		return env, fmt.Errorf("missing %s header", HeaderEventType)
+	}
+	return env, nil
+}
+
+// DecodeMessage verifies, decrypts and decodes msg the way the LedgerPoint
+// applies it, without changing any state. Tools use it to show the log.
+func (lp *LedgerPoint) DecodeMessage(msg Message) (Envelope, any, error) {
+	if lp.security != nil {
+		opened, err := lp.security.open(msg)
+		if err != nil {
+			return Envelope{}, nil, &RejectedError{Err: err}
+		}
+		msg = opened
+	}
+	return events.decode(msg)
+}
+
+// DeadLetterHandler receives messages that could not be decoded or applied,
+// and events sent on Commit that could not be encoded (at offset -1)
+type DeadLetterHandler func(msg Message, err error)
+
+// SetDeadLetterHandler replaces the default handler, which only logs the
+// message. The handler runs on the processing and writing goroutines and
+// must not block.
+func (lp *LedgerPoint) SetDeadLetterHandler(fn DeadLetterHandler) {
+	lp.deadLetter = fn
+}
+
+func (lp *LedgerPoint) logDeadLetter(msg Message, err error) {
+	lp.Logger().Warn("Dead-lettered message", logging.Offset(msg.Offset), "error", err)
+}
@@ -0,0 +1,87 @@
+package ledger
+
+import "errors"
+
+// Limit usage. An order reserves part of its account's limit when it is
+// acknowledged (OrderAck.ReservedLimit, worked out by the OMS validator):
+// BORR orders the trading limit, LEND orders the pool limit. Matching moves
+// the share of the matched quantity from the order to its contract, where it
+// stays utilised until the trade is reimbursed. Withdrawing or amending an
+// order releases what it still holds, and a trade rejected by eClear hands
+// its share back to the reopened order.
+//
+// The amounts live on the orders and contracts themselves, so snapshots and
+// as-of views carry them; account totals are summed over the account indexes.
+// On a partitioned ledger those totals need every partition: a LedgerPoint
+// assigned only some holds the orders and contracts of their instruments, so
+// GetAccountExposure refuses rather than under-count.
+
+// ErrAccountNotFound is returned by GetAccountExposure for unknown accounts
+var ErrAccountNotFound = errors.New("account not found")
+
+// AccountExposure is the limit usage of an account
+type AccountExposure struct {
+	AccountCode string `json:"account_code"`
+
+	TradeLimit     float64 `json:"trade_limit"`
+	TradeReserved  float64 `json:"trade_reserved"`  // Held by open BORR orders
+	TradeUtilised  float64 `json:"trade_utilised"`  // Held by BORR contracts until reimbursed
+	TradeAvailable float64 `json:"trade_available"` // TradeLimit - TradeReserved - TradeUtilised
+
+	PoolLimit     float64 `json:"pool_limit"`
+	PoolReserved  float64 `json:"pool_reserved"`  // Held by open LEND orders
+	PoolUtilised  float64 `json:"pool_utilised"`  // Held by LEND contracts until reimbursed
+	PoolAvailable float64 `json:"pool_available"` // PoolLimit - PoolReserved - PoolUtilised
+}
+
+// GetAccountExposure returns the limit usage of the account with the given
+// code. It fails with ErrAccountNotFound for unknown accounts and with
+// ErrPartialLedger on a LedgerPoint that doesn't consume every partition.
+func (lp *LedgerPoint) GetAccountExposure(code string) (AccountExposure, error) {
+	if !lp.ConsumesAllPartitions() {
+		return AccountExposure{}, ErrPartialLedger
+	}
+	account, exists := lp.GetAccount(code)
+	if !exists {
+		return AccountExposure{}, ErrAccountNotFound
+	}
+	exposure := AccountExposure{AccountCode: code, TradeLimit: account.TradeLimit, PoolLimit: account.PoolLimit}
+
+	// Same order as SyncTrade, so a trade is seen either before or after
+	lp.ordersMu.RLock()
+	lp.contractsMu.RLock()
+	for nid := range lp.orderIdx.byAccount[code] {
+		order := lp.orders[nid]
+		if order.Side == "LEND" {
+			exposure.PoolReserved += order.ReservedLimit
+		} else {
+			exposure.TradeReserved += order.ReservedLimit
+		}
+	}
+	for nid := range lp.contractIdx.byAccount[code] {
+		contract := lp.contracts[nid]
+		if contract.Side == "LEND" {
+			exposure.PoolUtilised += contract.UtilisedLimit
+		} else {
+			exposure.TradeUtilised += contract.UtilisedLimit
+		}
+	}
+	lp.contractsMu.RUnlock()
+	lp.ordersMu.RUnlock()
+
+	exposure.TradeAvailable = exposure.TradeLimit - exposure.TradeReserved - exposure.TradeUtilised
+	exposure.PoolAvailable = exposure.PoolLimit - exposure.PoolReserved - exposure.PoolUtilised
+	return exposure, nil
+}
+
+// takeReservation removes the share of quantity from the order's reservation
+// and returns it. Called before quantity is added to DoneQuantity; the last
+// match takes whatever is left.
+func (o *OrderEntity) takeReservation(quantity float64) float64 {
+	share := o.ReservedLimit
+	if open := o.Quantity - o.DoneQuantity; quantity < open {
+		share = o.ReservedLimit * quantity / open
+	}
+	o.ReservedLimit -= share
+	return share
+}
@@ -0,0 +1,148 @@
+package ledger
+
+import (
+	"math"
+	"testing"
+)
+
+func exposureTrade(nid int, orderNID int, qty float64) Trade {
+	return Trade{
+		NID:            nid,
+		InstrumentCode: "BBRI",
+		Quantity:       qty,
+		Borrower:       []Contract{{NID: nid*10 + 1, TradeNID: nid, OrderNID: orderNID, AccountCode: "YU-001", Side: "BORR", Quantity: qty}},
+		Lender:         []Contract{{NID: nid*10 + 2, TradeNID: nid, OrderNID: 99, AccountCode: "AK-001", Side: "LEND", Quantity: qty}},
+	}
+}
+
+func TestAccountExposure(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(Account{Code: "YU-001"})
+	lp.SyncAccountLimit(AccountLimit{Code: "YU-001", TradeLimit: 1000})
+	lp.SyncOrder(Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+	lp.SyncOrder(Order{NID: 2, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+
+	check := func(step string, reserved float64, utilised float64) {
+		t.Helper()
+		exposure, err := lp.GetAccountExposure("YU-001")
+		if err != nil {
+			t.Fatalf("%s: GetAccountExposure() error: %v", step, err)
+		}
+		if math.Abs(exposure.TradeReserved-reserved) > 1e-9 || math.Abs(exposure.TradeUtilised-utilised) > 1e-9 ||
+			math.Abs(exposure.TradeAvailable-(1000-reserved-utilised)) > 1e-9 {
+			t.Errorf("%s: exposure = %+v, want reserved %.0f utilised %.0f", step, exposure, reserved, utilised)
+		}
+	}
+
+	check("before ack", 0, 0)
+	lp.SyncOrderAck(OrderAck{OrderNID: 1, ReservedLimit: 400})
+	lp.SyncOrderAck(OrderAck{OrderNID: 2, ReservedLimit: 300})
+	lp.SyncOrderAck(OrderAck{OrderNID: 1, ReservedLimit: 400})
+	check("after ack", 700, 0)
+
+	// Matching 25 of 100 moves a quarter of the reservation
+	lp.SyncTrade(exposureTrade(7, 1, 25))
+	check("after trade", 600, 100)
+	if contract, _ := lp.GetContract(71); contract.UtilisedLimit != 100 {
+		t.Errorf("borrower contract UtilisedLimit = %v, want 100", contract.UtilisedLimit)
+	}
+
+	// A rejected trade hands its share back to the reopened order
+	lp.SyncTradeNak(TradeNak{TradeNID: 7})
+	lp.SyncTradeNak(TradeNak{TradeNID: 7})
+	check("after trade nak", 700, 0)
+
+	// The last match takes whatever is left
+	lp.SyncTrade(exposureTrade(8, 1, 40))
+	lp.SyncTrade(exposureTrade(9, 1, 60))
+	check("after full match", 300, 400)
+
+	lp.SyncOrderWithdrawAck(OrderWithdrawAck{OrderNID: 2})
+	check("after withdraw", 0, 400)
+
+	lp.SyncTradeReimburse(TradeReimburse{TradeNID: 8})
+	check("after reimburse", 0, 240)
+
+	if _, err := lp.GetAccountExposure("XX-001"); err != ErrAccountNotFound {
+		t.Errorf("GetAccountExposure() of an unknown account error = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func TestPoolExposure(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(Account{Code: "AK-001"})
+	lp.SyncAccountLimit(AccountLimit{Code: "AK-001", TradeLimit: 1000, PoolLimit: 5000})
+	lp.SyncOrder(Order{NID: 99, AccountCode: "AK-001", Side: "LEND", Quantity: 100})
+	lp.SyncOrderAck(OrderAck{OrderNID: 99, ReservedLimit: 2000})
+
+	lp.SyncTrade(exposureTrade(7, 1, 30))
+	exposure, _ := lp.GetAccountExposure("AK-001")
+	if exposure.PoolReserved != 1400 || exposure.PoolUtilised != 600 || exposure.PoolAvailable != 3000 {
+		t.Errorf("after trade = %+v, want pool reserved 1400 utilised 600 available 3000", exposure)
+	}
+	if exposure.TradeReserved != 0 || exposure.TradeUtilised != 0 || exposure.TradeAvailable != 1000 {
+		t.Errorf("LEND side used the trading limit: %+v", exposure)
+	}
+
+	lp.SyncTradeNak(TradeNak{TradeNID: 7})
+	if exposure, _ := lp.GetAccountExposure("AK-001"); exposure.PoolReserved != 2000 || exposure.PoolUtilised != 0 {
+		t.Errorf("after trade nak = %+v, want pool reserved 2000 utilised 0", exposure)
+	}
+
+	lp.SyncTrade(exposureTrade(8, 1, 30))
+	lp.SyncTradeReimburse(TradeReimburse{TradeNID: 8})
+	lp.SyncOrderWithdrawAck(OrderWithdrawAck{OrderNID: 99})
+	if exposure, _ := lp.GetAccountExposure("AK-001"); exposure.PoolAvailable != 5000 {
+		t.Errorf("after reimburse and withdraw = %+v, want the whole pool limit available", exposure)
+	}
+}
+
+func TestAmendmentReleasesReservation(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(Account{Code: "YU-001"})
+	lp.SyncAccountLimit(AccountLimit{Code: "YU-001", TradeLimit: 1000})
+	lp.SyncOrder(Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+	lp.SyncOrderAck(OrderAck{OrderNID: 1, ReservedLimit: 400})
+	lp.SyncOrder(Order{NID: 2, PrevNID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 50})
+	lp.SyncOrderAck(OrderAck{OrderNID: 2, ReservedLimit: 200})
+
+	if exposure, _ := lp.GetAccountExposure("YU-001"); exposure.TradeReserved != 200 {
+		t.Errorf("TradeReserved after amendment = %v, want 200", exposure.TradeReserved)
+	}
+}
+
+func TestExposureSurvivesSnapshot(t *testing.T) {
+	src := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	src.SyncAccount(Account{Code: "YU-001"})
+	src.SyncAccountLimit(AccountLimit{Code: "YU-001", TradeLimit: 1000})
+	src.SyncOrder(Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+	src.SyncOrderAck(OrderAck{OrderNID: 1, ReservedLimit: 400})
+	src.SyncTrade(exposureTrade(7, 1, 50))
+
+	dst := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	dst.RestoreSnapshot(src.CaptureSnapshot(0))
+
+	want, _ := src.GetAccountExposure("YU-001")
+	if got, _ := dst.GetAccountExposure("YU-001"); got != want {
+		t.Errorf("restored exposure = %+v, want %+v", got, want)
+	}
+}
+
+func TestAccountExposureOnPartitions(t *testing.T) {
+	transports := []Transport{NewMemoryTransport("pme-ledger"), NewMemoryTransport("pme-ledger"), NewMemoryTransport("pme-ledger")}
+	some, err := CreateLedgerPointWithPartitions(transports, []int{1}, "pmeoms")
+	if err != nil {
+		t.Fatalf("CreateLedgerPointWithPartitions() error: %v", err)
+	}
+	all, _ := CreateLedgerPointWithPartitions(transports, nil, "eclearapi")
+	for _, lp := range []*LedgerPoint{some, all} {
+		lp.SyncAccount(Account{Code: "YU-001"})
+	}
+
+	if _, err := some.GetAccountExposure("YU-001"); err != ErrPartialLedger {
+		t.Errorf("GetAccountExposure() on some partitions error = %v, want ErrPartialLedger", err)
+	}
+	if _, err := all.GetAccountExposure("YU-001"); err != nil {
+		t.Errorf("GetAccountExposure() on all partitions error: %v", err)
+	}
+}
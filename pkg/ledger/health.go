@@ -0,0 +1,153 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Ready returns a channel that is closed once the LedgerPoint has replayed
+// the log up to its own ServiceStart, i.e. its state reflects everything
+// written before it started
+func (lp *LedgerPoint) Ready() <-chan struct{} {
+	return lp.ready
+}
+
+// IsReady reports whether Ready has been closed, without blocking
+func (lp *LedgerPoint) IsReady() bool {
+	select {
+	case <-lp.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// LagInfo describes how far the applied state is behind the log
+type LagInfo struct {
+	Partition     int   `json:"partition"`
+	Offset        int64 `json:"offset"`          // Last applied offset, -1 when none
+	HighWaterMark int64 `json:"high_water_mark"` // Offset the next message will get
+	Lag           int64 `json:"lag"`             // Messages written but not yet applied
+
+	// On a partitioned ledger the fields above describe the control
+	// partition, except Lag which is the total over Partitions
+	Partitions []LagInfo `json:"partitions,omitempty"`
+}
+
+// Lag compares the last applied offset with the high-water mark of the log,
+// for each consumed partition
+func (lp *LedgerPoint) Lag(ctx context.Context) (LagInfo, error) {
+	var total int64
+	partitions := make([]LagInfo, 0, len(lp.assigned))
+	for _, p := range lp.assigned {
+		hwm, err := lp.partitions[p].HighWaterMark(ctx)
+		if err != nil {
+			return LagInfo{}, err
+		}
+
+		info := LagInfo{
+			Partition:     p,
+			Offset:        atomic.LoadInt64(&lp.offsets[p]),
+			HighWaterMark: hwm,
+		}
+		info.Lag = hwm - info.Offset - 1
+		if info.Lag < 0 {
+			info.Lag = 0
+		}
+		total += info.Lag
+		partitions = append(partitions, info)
+	}
+
+	info := partitions[0] // The control partition
+	if lp.Partitioned() {
+		info.Lag = total
+		info.Partitions = partitions
+	}
+	return info, nil
+}
+
+// AppliedEvents returns how many events of each type this process has
+// applied, including those replayed on start but not those restored from a
+// snapshot
+func (lp *LedgerPoint) AppliedEvents() map[string]uint64 {
+	counts := make(map[string]uint64)
+	lp.applied.Range(func(eventType any, count any) bool {
+		counts[eventType.(string)] = count.(*atomic.Uint64).Load()
+		return true
+	})
+	return counts
+}
+
+func (lp *LedgerPoint) countApplied(eventType string) {
+	count, ok := lp.applied.Load(eventType)
+	if !ok {
+		count, _ = lp.applied.LoadOrStore(eventType, new(atomic.Uint64))
+	}
+	count.(*atomic.Uint64).Add(1)
+}
+
+// HealthHandler serves the readiness of the LedgerPoint as JSON:
+//
+//   - 503 "starting" until Ready is closed
+//   - 200 "degraded" when the lag exceeds maxLag or can't be determined, or
+//     the commit queue is full
+//   - 200 "ok" otherwise
+func (lp *LedgerPoint) HealthHandler(service string, maxLag int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := "ok"
+		code := http.StatusOK
+		resp := map[string]interface{}{
+			"service": service,
+			"ready":   lp.IsReady(),
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if lag, err := lp.Lag(ctx); err != nil {
+			status = "degraded"
+			resp["error"] = err.Error()
+		} else {
+			resp["offset"] = lag.Offset
+			resp["high_water_mark"] = lag.HighWaterMark
+			resp["lag"] = lag.Lag
+			if lag.Partitions != nil {
+				resp["partitions"] = lag.Partitions
+			}
+			if lag.Lag > maxLag {
+				status = "degraded"
+			}
+		}
+
+		commits := lp.CommitStats()
+		resp["commits"] = commits
+		if commits.QueueDepth >= commits.QueueCapacity {
+			status = "degraded"
+		}
+
+		if !lp.IsReady() {
+			status = "starting"
+			code = http.StatusServiceUnavailable
+		}
+		resp["status"] = status
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// RequireReady rejects requests with 503 until the LedgerPoint is ready, so
+// a service can accept connections (and health checks) while it catches up
+// without serving or committing on partial state
+func (lp *LedgerPoint) RequireReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !lp.IsReady() {
+			http.Error(w, "Ledger is catching up, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyAndLag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	for i := 0; i < 5; i++ {
+		msg, _ := events.encode(Holiday{NID: i}, "test", "")
+		transport.Append(ctx, msg)
+	}
+
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	health := lp.HealthHandler("test", 0)
+
+	rec := httptest.NewRecorder()
+	health(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("health before Start = %d, want 503", rec.Code)
+	}
+	if lag, err := lp.Lag(ctx); err != nil || lag.Lag != 5 {
+		t.Errorf("Lag() before Start = %+v, %v, want 5", lag, err)
+	}
+
+	lp.Start(nil, ctx)
+	select {
+	case <-lp.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("LedgerPoint did not become ready")
+	}
+
+	// ServiceStart at offset 5 is the last message to apply
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		lag, err := lp.Lag(ctx)
+		if err == nil && lag.Lag == 0 && lag.Offset == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Lag() after ready = %+v, %v, want offset 5 and no lag", lag, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec = httptest.NewRecorder()
+	health(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp["status"] != "ok" {
+		t.Errorf("health when ready = %d %v", rec.Code, resp)
+	}
+
+	applied := lp.AppliedEvents()
+	if applied["Holiday"] != 5 || applied["ServiceStart"] != 1 {
+		t.Errorf("AppliedEvents() = %v, want 5 Holiday and 1 ServiceStart", applied)
+	}
+}
@@ -0,0 +1,319 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Secondary indexes over orders, trades and contracts. Each index lives next
+// to its primary map and is guarded by the same mutex, so a reader never sees
+// one without the other. Indexed fields (references, account, participant,
+// instrument) never change after an entity is created; state updates write
+// the primary map directly and only inserts go through the index.
+
+// nidIndex maps a lookup key to the set of entity NIDs carrying it
+type nidIndex map[string]map[int]struct{}
+
+func (ix nidIndex) add(key string, nid int) {
+	if key == "" {
+		return
+	}
+	set, ok := ix[key]
+	if !ok {
+		set = make(map[int]struct{})
+		ix[key] = set
+	}
+	set[nid] = struct{}{}
+}
+
+func (ix nidIndex) remove(key string, nid int) {
+	if set, ok := ix[key]; ok {
+		delete(set, nid)
+		if len(set) == 0 {
+			delete(ix, key)
+		}
+	}
+}
+
+// nids returns the NIDs stored under key in ascending order
+func (ix nidIndex) nids(key string) []int {
+	set := ix[key]
+	nids := make([]int, 0, len(set))
+	for nid := range set {
+		nids = append(nids, nid)
+	}
+	sort.Ints(nids)
+	return nids
+}
+
+// orderRequest identifies the request an order was submitted with. Orders
+// of one participant and instrument are on the same partition, so every
+// LedgerPoint consuming it sees the same orders under a key.
+type orderRequest struct {
+	participantCode string
+	instrumentCode  string
+	reffRequestID   string
+}
+
+func requestOf(participantCode string, instrumentCode string, reffRequestID string) orderRequest {
+	return orderRequest{participantCode: participantCode, instrumentCode: instrumentCode, reffRequestID: reffRequestID}
+}
+
+// orderIndex is guarded by ordersMu
+type orderIndex struct {
+	byReffRequestID map[string]int
+	byRequest       map[orderRequest]int
+	byAccount       nidIndex
+	byParticipant   nidIndex
+	byInstrument    nidIndex
+}
+
+func newOrderIndex() orderIndex {
+	return orderIndex{
+		byReffRequestID: make(map[string]int),
+		byRequest:       make(map[orderRequest]int),
+		byAccount:       make(nidIndex),
+		byParticipant:   make(nidIndex),
+		byInstrument:    make(nidIndex),
+	}
+}
+
+func (ix orderIndex) add(o OrderEntity) {
+	if o.ReffRequestID != "" {
+		ix.byReffRequestID[o.ReffRequestID] = o.NID
+		ix.byRequest[requestOf(o.ParticipantCode, o.InstrumentCode, o.ReffRequestID)] = o.NID
+	}
+	ix.byAccount.add(o.AccountCode, o.NID)
+	ix.byParticipant.add(o.ParticipantCode, o.NID)
+	ix.byInstrument.add(o.InstrumentCode, o.NID)
+}
+
+func (ix orderIndex) remove(o OrderEntity) {
+	if ix.byReffRequestID[o.ReffRequestID] == o.NID {
+		delete(ix.byReffRequestID, o.ReffRequestID)
+	}
+	if key := requestOf(o.ParticipantCode, o.InstrumentCode, o.ReffRequestID); ix.byRequest[key] == o.NID {
+		delete(ix.byRequest, key)
+	}
+	ix.byAccount.remove(o.AccountCode, o.NID)
+	ix.byParticipant.remove(o.ParticipantCode, o.NID)
+	ix.byInstrument.remove(o.InstrumentCode, o.NID)
+}
+
+// contractIndex is guarded by contractsMu
+type contractIndex struct {
+	byReff        map[string]int
+	byAccount     nidIndex
+	byParticipant nidIndex
+	byInstrument  nidIndex
+}
+
+func newContractIndex() contractIndex {
+	return contractIndex{
+		byReff:        make(map[string]int),
+		byAccount:     make(nidIndex),
+		byParticipant: make(nidIndex),
+		byInstrument:  make(nidIndex),
+	}
+}
+
+func (ix contractIndex) add(c ContractEntity) {
+	if c.KpeiReff != "" {
+		ix.byReff[c.KpeiReff] = c.NID
+	}
+	ix.byAccount.add(c.AccountCode, c.NID)
+	ix.byParticipant.add(c.AccountParticipantCode, c.NID)
+	ix.byInstrument.add(c.InstrumentCode, c.NID)
+}
+
+func (ix contractIndex) remove(c ContractEntity) {
+	if ix.byReff[c.KpeiReff] == c.NID {
+		delete(ix.byReff, c.KpeiReff)
+	}
+	ix.byAccount.remove(c.AccountCode, c.NID)
+	ix.byParticipant.remove(c.AccountParticipantCode, c.NID)
+	ix.byInstrument.remove(c.InstrumentCode, c.NID)
+}
+
+// putOrder stores o and indexes it. Caller must hold ordersMu.
+func (lp *LedgerPoint) putOrder(o OrderEntity) {
+	if old, exists := lp.orders[o.NID]; exists {
+		lp.orderIdx.remove(old)
+	}
+	lp.orders[o.NID] = o
+	lp.orderIdx.add(o)
+}
+
+// putContract stores c and indexes it. Caller must hold contractsMu.
+func (lp *LedgerPoint) putContract(c ContractEntity) {
+	if old, exists := lp.contracts[c.NID]; exists {
+		lp.contractIdx.remove(old)
+	}
+	lp.contracts[c.NID] = c
+	lp.contractIdx.add(c)
+}
+
+// putTrade stores t and indexes it. Caller must hold tradesMu.
+func (lp *LedgerPoint) putTrade(t TradeEntity) {
+	if old, exists := lp.trades[t.NID]; exists && lp.tradeByReff[old.KpeiReff] == old.NID {
+		delete(lp.tradeByReff, old.KpeiReff)
+	}
+	lp.trades[t.NID] = t
+	if t.KpeiReff != "" {
+		lp.tradeByReff[t.KpeiReff] = t.NID
+	}
+}
+
+// reindexOrders rebuilds the order index from the order map, e.g. after a
+// snapshot restore. Caller must hold ordersMu.
+func (lp *LedgerPoint) reindexOrders() {
+	lp.orderIdx = newOrderIndex()
+	for _, o := range lp.orders {
+		lp.orderIdx.add(o)
+	}
+}
+
+// reindexTrades rebuilds the trade index. Caller must hold tradesMu.
+func (lp *LedgerPoint) reindexTrades() {
+	lp.tradeByReff = make(map[string]int, len(lp.trades))
+	for _, t := range lp.trades {
+		if t.KpeiReff != "" {
+			lp.tradeByReff[t.KpeiReff] = t.NID
+		}
+	}
+}
+
+// reindexContracts rebuilds the contract index. Caller must hold contractsMu.
+func (lp *LedgerPoint) reindexContracts() {
+	lp.contractIdx = newContractIndex()
+	for _, c := range lp.contracts {
+		lp.contractIdx.add(c)
+	}
+}
+
+// ============================================================================
+// Indexed Lookups (return copies)
+// ============================================================================
+
+// GetTradeByReff returns a copy of the trade with the given KPEI reference
+func (lp *LedgerPoint) GetTradeByReff(kpeiReff string) (TradeEntity, bool) {
+	lp.tradesMu.RLock()
+	defer lp.tradesMu.RUnlock()
+	nid, exists := lp.tradeByReff[kpeiReff]
+	if !exists {
+		return TradeEntity{}, false
+	}
+	trade, exists := lp.trades[nid]
+	return trade, exists
+}
+
+// GetContractByReff returns a copy of the contract with the given KPEI reference
+func (lp *LedgerPoint) GetContractByReff(kpeiReff string) (ContractEntity, bool) {
+	lp.contractsMu.RLock()
+	defer lp.contractsMu.RUnlock()
+	nid, exists := lp.contractIdx.byReff[kpeiReff]
+	if !exists {
+		return ContractEntity{}, false
+	}
+	contract, exists := lp.contracts[nid]
+	return contract, exists
+}
+
+// GetOrderByReffRequestID returns a copy of the order submitted with the
+// given request reference, the latest when orders of other participants or
+// instruments reuse it. SyncOrder keeps the first of a participant and
+// instrument.
+func (lp *LedgerPoint) GetOrderByReffRequestID(reffRequestID string) (OrderEntity, bool) {
+	lp.ordersMu.RLock()
+	defer lp.ordersMu.RUnlock()
+	nid, exists := lp.orderIdx.byReffRequestID[reffRequestID]
+	if !exists {
+		return OrderEntity{}, false
+	}
+	order, exists := lp.orders[nid]
+	return order, exists
+}
+
+// AROReffRequestID is the reference of the ARO order eclearapi creates for
+// a borrower contract of a reimbursed trade
+func AROReffRequestID(tradeReff string, contractNID int) string {
+	return fmt.Sprintf("%s-ARO-%d", tradeReff, contractNID)
+}
+
+// RecallReffRequestID is the reference of the order eclearapi creates for a
+// borrower contract when the lender of contractReff recalls
+func RecallReffRequestID(contractReff string, contractNID int) string {
+	return fmt.Sprintf("%s-RECALL-%d", contractReff, contractNID)
+}
+
+// IsReservedReffRequestID reports whether reffRequestID has the form of an
+// ARO or recall reference. Orders submitted by participants must not use
+// one, or they could claim the reference before eclearapi does.
+func IsReservedReffRequestID(reffRequestID string) bool {
+	return strings.Contains(reffRequestID, "-ARO-") || strings.Contains(reffRequestID, "-RECALL-")
+}
+
+// ListOrdersByAccount returns copies of the account's orders ordered by NID
+func (lp *LedgerPoint) ListOrdersByAccount(accountCode string) []OrderEntity {
+	lp.ordersMu.RLock()
+	defer lp.ordersMu.RUnlock()
+	return lp.ordersByNID(lp.orderIdx.byAccount.nids(accountCode))
+}
+
+// ListOrdersByParticipant returns copies of the participant's orders ordered by NID
+func (lp *LedgerPoint) ListOrdersByParticipant(participantCode string) []OrderEntity {
+	lp.ordersMu.RLock()
+	defer lp.ordersMu.RUnlock()
+	return lp.ordersByNID(lp.orderIdx.byParticipant.nids(participantCode))
+}
+
+// ListOrdersByInstrument returns copies of the instrument's orders ordered by NID
+func (lp *LedgerPoint) ListOrdersByInstrument(instrumentCode string) []OrderEntity {
+	lp.ordersMu.RLock()
+	defer lp.ordersMu.RUnlock()
+	return lp.ordersByNID(lp.orderIdx.byInstrument.nids(instrumentCode))
+}
+
+// ListContractsByAccount returns copies of the account's contracts ordered by NID
+func (lp *LedgerPoint) ListContractsByAccount(accountCode string) []ContractEntity {
+	lp.contractsMu.RLock()
+	defer lp.contractsMu.RUnlock()
+	return lp.contractsByNID(lp.contractIdx.byAccount.nids(accountCode))
+}
+
+// ListContractsByParticipant returns copies of the participant's contracts ordered by NID
+func (lp *LedgerPoint) ListContractsByParticipant(participantCode string) []ContractEntity {
+	lp.contractsMu.RLock()
+	defer lp.contractsMu.RUnlock()
+	return lp.contractsByNID(lp.contractIdx.byParticipant.nids(participantCode))
+}
+
+// ListContractsByInstrument returns copies of the instrument's contracts ordered by NID
+func (lp *LedgerPoint) ListContractsByInstrument(instrumentCode string) []ContractEntity {
+	lp.contractsMu.RLock()
+	defer lp.contractsMu.RUnlock()
+	return lp.contractsByNID(lp.contractIdx.byInstrument.nids(instrumentCode))
+}
+
+// ordersByNID looks up nids in the order map. Caller must hold ordersMu.
+func (lp *LedgerPoint) ordersByNID(nids []int) []OrderEntity {
+	orders := make([]OrderEntity, 0, len(nids))
+	for _, nid := range nids {
+		if order, exists := lp.orders[nid]; exists {
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
+
+// contractsByNID looks up nids in the contract map. Caller must hold contractsMu.
+func (lp *LedgerPoint) contractsByNID(nids []int) []ContractEntity {
+	contracts := make([]ContractEntity, 0, len(nids))
+	for _, nid := range nids {
+		if contract, exists := lp.contracts[nid]; exists {
+			contracts = append(contracts, contract)
+		}
+	}
+	return contracts
+}
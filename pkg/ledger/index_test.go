@@ -0,0 +1,129 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIndexedLookups(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncOrder(Order{NID: 11, ReffRequestID: "REQ-11", AccountCode: "YU-001", ParticipantCode: "YU", InstrumentCode: "BBRI", Side: "BORR", Quantity: 100, Timestamp: time.Now()})
+	lp.SyncOrder(Order{NID: 10, ReffRequestID: "REQ-10", AccountCode: "YU-001", ParticipantCode: "YU", InstrumentCode: "TLKM", Side: "BORR", Quantity: 50, Timestamp: time.Now()})
+	lp.SyncOrder(Order{NID: 12, ReffRequestID: "REQ-12", AccountCode: "DX-001", ParticipantCode: "DX", InstrumentCode: "BBRI", Side: "LEND", Quantity: 100, Timestamp: time.Now()})
+	lp.SyncTrade(Trade{
+		NID:      20,
+		KpeiReff: "PME-20",
+		Borrower: []Contract{{NID: 201, TradeNID: 20, KpeiReff: "PME-20-BORR", OrderNID: 11, AccountCode: "YU-001", AccountParticipantCode: "YU", InstrumentCode: "BBRI", Side: "BORR", Quantity: 100}},
+		Lender:   []Contract{{NID: 202, TradeNID: 20, KpeiReff: "PME-20-LEND", OrderNID: 12, AccountCode: "DX-001", AccountParticipantCode: "DX", InstrumentCode: "BBRI", Side: "LEND", Quantity: 100}},
+	})
+
+	check := func(lp *LedgerPoint) {
+		t.Helper()
+		if trade, ok := lp.GetTradeByReff("PME-20"); !ok || trade.NID != 20 {
+			t.Errorf("GetTradeByReff() = %+v, %v", trade, ok)
+		}
+		if contract, ok := lp.GetContractByReff("PME-20-LEND"); !ok || contract.NID != 202 {
+			t.Errorf("GetContractByReff() = %+v, %v", contract, ok)
+		}
+		if order, ok := lp.GetOrderByReffRequestID("REQ-12"); !ok || order.NID != 12 {
+			t.Errorf("GetOrderByReffRequestID() = %+v, %v", order, ok)
+		}
+		if _, ok := lp.GetTradeByReff("PME-99"); ok {
+			t.Error("GetTradeByReff() found an unknown reference")
+		}
+
+		orders := lp.ListOrdersByParticipant("YU")
+		if len(orders) != 2 || orders[0].NID != 10 || orders[1].NID != 11 {
+			t.Errorf("ListOrdersByParticipant() = %+v, want NIDs 10, 11", orders)
+		}
+		// Lists reflect state updates made after indexing
+		if orders := lp.ListOrdersByInstrument("BBRI"); len(orders) != 2 || orders[0].State != "M" {
+			t.Errorf("ListOrdersByInstrument() = %+v", orders)
+		}
+		if orders := lp.ListOrdersByAccount("DX-001"); len(orders) != 1 || orders[0].NID != 12 {
+			t.Errorf("ListOrdersByAccount() = %+v", orders)
+		}
+		if contracts := lp.ListContractsByParticipant("DX"); len(contracts) != 1 || contracts[0].NID != 202 {
+			t.Errorf("ListContractsByParticipant() = %+v", contracts)
+		}
+		if contracts := lp.ListContractsByInstrument("BBRI"); len(contracts) != 2 {
+			t.Errorf("ListContractsByInstrument() = %+v", contracts)
+		}
+	}
+
+	check(lp)
+
+	// Indexes are rebuilt from a restored snapshot
+	restored := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	restored.RestoreSnapshot(lp.CaptureSnapshot(0))
+	check(restored)
+}
+
+func TestReindexOnReplacedOrder(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncOrder(Order{NID: 10, ReffRequestID: "REQ-A", AccountCode: "YU-001", ParticipantCode: "YU", Timestamp: time.Now()})
+
+	// A repeated Order is ignored
+	lp.SyncOrder(Order{NID: 10, ReffRequestID: "REQ-B", AccountCode: "DX-001", ParticipantCode: "DX", Timestamp: time.Now()})
+	if order, ok := lp.GetOrderByReffRequestID("REQ-A"); !ok || order.AccountCode != "YU-001" {
+		t.Errorf("GetOrderByReffRequestID(REQ-A) = %+v, %v, want the first order", order, ok)
+	}
+
+	lp.ordersMu.Lock()
+	lp.putOrder(OrderEntity{NID: 10, ReffRequestID: "REQ-B", AccountCode: "DX-001", ParticipantCode: "DX"})
+	lp.ordersMu.Unlock()
+
+	if _, ok := lp.GetOrderByReffRequestID("REQ-A"); ok {
+		t.Error("stale ReffRequestID still indexed")
+	}
+	if orders := lp.ListOrdersByParticipant("YU"); len(orders) != 0 {
+		t.Errorf("ListOrdersByParticipant(YU) = %+v, want none", orders)
+	}
+	if orders := lp.ListOrdersByParticipant("DX"); len(orders) != 1 {
+		t.Errorf("ListOrdersByParticipant(DX) = %+v, want order 10", orders)
+	}
+}
+
+func TestReusedReffRequestIDKeepsFirstOrder(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncOrder(Order{NID: 10, ReffRequestID: "REQ-A", AccountCode: "YU-001", ParticipantCode: "YU", Quantity: 100, Timestamp: time.Now()})
+
+	// A retry committed under another NID before the first was applied
+	lp.SyncOrder(Order{NID: 11, ReffRequestID: "REQ-A", AccountCode: "YU-001", ParticipantCode: "YU", Quantity: 100, Timestamp: time.Now()})
+	if _, ok := lp.GetOrder(11); ok {
+		t.Error("order with a reused reff_request_id was applied")
+	}
+	if order, ok := lp.GetOrderByReffRequestID("REQ-A"); !ok || order.NID != 10 {
+		t.Errorf("GetOrderByReffRequestID(REQ-A) = %+v, %v, want order 10", order, ok)
+	}
+
+	// Another instrument or participant is on another partition, which a
+	// LedgerPoint may not consume, so the reference isn't compared
+	lp.SyncOrder(Order{NID: 14, ReffRequestID: "REQ-A", AccountCode: "YU-001", ParticipantCode: "YU", InstrumentCode: "TLKM", Timestamp: time.Now()})
+	lp.SyncOrder(Order{NID: 15, ReffRequestID: "REQ-A", AccountCode: "DX-001", ParticipantCode: "DX", Timestamp: time.Now()})
+	for _, nid := range []int{14, 15} {
+		if _, ok := lp.GetOrder(nid); !ok {
+			t.Errorf("order %d of another instrument or participant was skipped", nid)
+		}
+	}
+
+	// Orders without a reference are not deduplicated
+	lp.SyncOrder(Order{NID: 12, AccountCode: "YU-001", ParticipantCode: "YU", Timestamp: time.Now()})
+	lp.SyncOrder(Order{NID: 13, AccountCode: "YU-001", ParticipantCode: "YU", Timestamp: time.Now()})
+	if _, ok := lp.GetOrder(13); !ok {
+		t.Error("second order without reff_request_id was skipped")
+	}
+}
+
+func TestIsReservedReffRequestID(t *testing.T) {
+	for reff, want := range map[string]bool{
+		AROReffRequestID("PME-20251124-1", 11):         true,
+		RecallReffRequestID("PME-20251124-1-LEND", 11): true,
+		"REQ-001": false,
+		"":        false,
+	} {
+		if got := IsReservedReffRequestID(reff); got != want {
+			t.Errorf("IsReservedReffRequestID(%q) = %v, want %v", reff, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaTransport keeps the ledger log in one partition of a Kafka topic
+type KafkaTransport struct {
+	url       string
+	topic     string
+	partition int
+	client    *kafka.Client
+}
+
+// NewKafkaTransport creates a transport for partition 0 of topic on the
+// broker at url. No connection is made until the first Append or OpenReader.
+func NewKafkaTransport(url string, topic string) *KafkaTransport {
+	return NewKafkaPartitionTransport(url, topic, 0)
+}
+
+// NewKafkaPartitionTransport creates a transport for one partition of topic,
+// see CreatePartitionedLedgerPoint
+func NewKafkaPartitionTransport(url string, topic string, partition int) *KafkaTransport {
+	return &KafkaTransport{
+		url:       url,
+		topic:     topic,
+		partition: partition,
+		client: &kafka.Client{
+			Addr:    kafka.TCP(url),
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+func (t *KafkaTransport) Topic() string {
+	return t.topic
+}
+
+// Append produces msgs to the partition and waits for the leader acknowledgment
+func (t *KafkaTransport) Append(ctx context.Context, msgs ...Message) (int64, error) {
+	records := make([]kafka.Record, len(msgs))
+	for i, m := range msgs {
+		headers := make([]kafka.Header, len(m.Headers))
+		for j, h := range m.Headers {
+			headers[j] = kafka.Header{Key: h.Key, Value: h.Value}
+		}
+		records[i] = kafka.Record{
+			Time:    m.Time,
+			Key:     kafka.NewBytes(m.Key),
+			Value:   kafka.NewBytes(m.Value),
+			Headers: headers,
+		}
+	}
+
+	res, err := t.client.Produce(ctx, &kafka.ProduceRequest{
+		Topic:        t.topic,
+		Partition:    t.partition,
+		RequiredAcks: kafka.RequireOne,
+		Records:      kafka.NewRecordReader(records...),
+	})
+	if err != nil {
+		return -1, err
+	}
+	if res.Error != nil {
+		return -1, res.Error
+	}
+	return res.BaseOffset, nil
+}
+
+func (t *KafkaTransport) OpenReader(offset int64) (TransportReader, error) {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{t.url},
+		Topic:     t.topic,
+		Partition: t.partition, // required when no GroupID
+		MinBytes:  1,
+		MaxBytes:  10e6,
+		MaxWait:   100 * time.Millisecond, // Don't wait too long for batches
+	})
+	if offset == FirstOffset {
+		offset = kafka.FirstOffset
+	}
+	if err := r.SetOffset(offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &kafkaReader{r: r}, nil
+}
+
+func (t *KafkaTransport) HighWaterMark(ctx context.Context) (int64, error) {
+	res, err := t.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{
+			t.topic: {kafka.LastOffsetOf(t.partition)},
+		},
+	})
+	if err != nil {
+		return -1, err
+	}
+	for _, p := range res.Topics[t.topic] {
+		if p.Partition != t.partition {
+			continue
+		}
+		if p.Error != nil {
+			return -1, p.Error
+		}
+		return p.LastOffset, nil
+	}
+	return -1, fmt.Errorf("no offsets returned for topic %s partition %d", t.topic, t.partition)
+}
+
+func (t *KafkaTransport) Close() error {
+	return nil
+}
+
+type kafkaReader struct {
+	r *kafka.Reader
+}
+
+func (kr *kafkaReader) ReadMessage(ctx context.Context) (Message, error) {
+	m, err := kr.r.ReadMessage(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+
+	headers := make([]Header, len(m.Headers))
+	for i, h := range m.Headers {
+		headers[i] = Header{Key: h.Key, Value: h.Value}
+	}
+	return Message{
+		Offset:  m.Offset,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+		Time:    m.Time,
+	}, nil
+}
+
+func (kr *kafkaReader) Close() error {
+	return kr.r.Close()
+}
@@ -2,12 +2,15 @@ package ledger
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/segmentio/kafka-go"
+	"pmeonline/pkg/idgen"
+	"pmeonline/pkg/logging"
 )
 
 type LedgerPoint struct {
@@ -30,29 +33,72 @@ type LedgerPoint struct {
 	holidays  map[int]HolidayEntity
 	holidayMu sync.RWMutex
 
+	riskRules  map[string]RiskRuleEntity
+	riskRuleMu sync.RWMutex
+
+	concentrationLimits map[string]ConcentrationLimitEntity // By ConcentrationScope key
+	concentrationMu     sync.RWMutex
+
 	orders   map[int]OrderEntity
+	orderIdx orderIndex
 	ordersMu sync.RWMutex
 
-	trades   map[int]TradeEntity
-	tradesMu sync.RWMutex
+	trades      map[int]TradeEntity
+	tradeByReff map[string]int
+	tradesMu    sync.RWMutex
 
 	contracts   map[int]ContractEntity
+	contractIdx contractIndex
 	contractsMu sync.RWMutex
 
 	// Public fields (channels, config)
-	Commit  chan any
-	IsReady bool
+	Commit chan any // Fire-and-forget commits (sends block when full), also the queue of CommitSync (see commit.go)
+
+	// Readiness (see health.go)
+	ready     chan struct{}
+	readyOnce sync.Once
 
 	// Private fields
 	allSync      []LedgerPointInterface
-	rx           chan kafka.Message
-	url          string
+	rx           chan Message
+	commitBatch  int         // Events written per append
+	transport    Transport   // Control partition, the whole log when not partitioned
+	partitions   []Transport // One transport per partition (see partition.go)
+	assigned     []int       // Partitions consumed, always including the control partition
+	startsSeen   int         // Own ServiceStart markers applied so far
 	topic        string
 	id           string
 	startid      string
 	lastOrderNID int
+	ids          *idgen.Generator // Event IDs (see dedup.go)
+	dedup        *dedupWindow     // Recently applied event IDs
+	deadLetter   DeadLetterHandler
+	rejected     DeadLetterHandler // Messages failing verification (see security.go)
+	security     *Security
+	policy       Policy
+	clock        Clock
+	logger       *slog.Logger // See logging.go
+
+	commitCounters commitCounters
+	applied        sync.Map // Event type -> *atomic.Uint64, see AppliedEvents
+
+	// Typed subscribers registered with Subscribe
+	subscribers   map[reflect.Type][]func(any)
+	subscribersMu sync.RWMutex
+
+	// Snapshot state (see snapshot.go)
+	snapshots           SnapshotStore
+	snapshotEvery       int
+	eventsSinceSnapshot int
+	archive             *Archive        // Replayed before Kafka when set (see archive.go)
+	offsets             []int64         // Offset of the last applied message per partition, written atomically
+	current             int64           // Offset of the message being applied
+	lastTime            time.Time       // Latest log time of the messages applied from the log, recorded in snapshots
+	currentCtx          context.Context // Trace context of the message being applied (see tracing.go)
 }
 
+// LedgerPointInterface receives every event applied by a LedgerPoint.
+// Subscribers that only care about a few event types can use Subscribe instead.
 type LedgerPointInterface interface {
 	SyncServiceStart(a ServiceStart)
 	SyncParameter(a Parameter)
@@ -235,299 +281,252 @@ func (lp *LedgerPoint) ForEachHoliday(fn func(HolidayEntity) bool) {
 	}
 }
 
+// GetRiskRule returns a copy of the configuration of the named risk rule
+func (lp *LedgerPoint) GetRiskRule(name string) (RiskRuleEntity, bool) {
+	lp.riskRuleMu.RLock()
+	defer lp.riskRuleMu.RUnlock()
+	rule, exists := lp.riskRules[name]
+	return rule, exists
+}
+
+// ForEachRiskRule iterates over all risk rule configurations
+func (lp *LedgerPoint) ForEachRiskRule(fn func(RiskRuleEntity) bool) {
+	lp.riskRuleMu.RLock()
+	defer lp.riskRuleMu.RUnlock()
+	for _, rule := range lp.riskRules {
+		if !fn(rule) {
+			break
+		}
+	}
+}
+
+// GetConcentrationLimit returns a copy of the concentration limit of scope
+func (lp *LedgerPoint) GetConcentrationLimit(scope ConcentrationScope) (ConcentrationLimitEntity, bool) {
+	lp.concentrationMu.RLock()
+	defer lp.concentrationMu.RUnlock()
+	limit, exists := lp.concentrationLimits[scope.key()]
+	return limit, exists
+}
+
+// ForEachConcentrationLimit iterates over all concentration limits
+func (lp *LedgerPoint) ForEachConcentrationLimit(fn func(ConcentrationLimitEntity) bool) {
+	lp.concentrationMu.RLock()
+	defer lp.concentrationMu.RUnlock()
+	for _, limit := range lp.concentrationLimits {
+		if !fn(limit) {
+			break
+		}
+	}
+}
+
 func CreateLedgerPoint(url string, topic string, id string) *LedgerPoint {
+	return CreateLedgerPointWithTransport(NewKafkaTransport(url, topic), id)
+}
+
+// CreateLedgerPointWithTransport creates a LedgerPoint on top of an arbitrary
+// transport, e.g. a MemoryTransport shared by several services in tests
+func CreateLedgerPointWithTransport(transport Transport, id string) *LedgerPoint {
 
 	point := LedgerPoint{
 		// Initialize private entity maps
-		holidays:     make(map[int]HolidayEntity),
-		orders:       make(map[int]OrderEntity),
-		trades:       make(map[int]TradeEntity),
-		contracts:    make(map[int]ContractEntity),
-		participants: make(map[string]ParticipantEntity),
-		accounts:     make(map[string]AccountEntity),
-		instruments:  make(map[string]InstrumentEntity),
+		holidays:            make(map[int]HolidayEntity),
+		riskRules:           make(map[string]RiskRuleEntity),
+		concentrationLimits: make(map[string]ConcentrationLimitEntity),
+		orders:              make(map[int]OrderEntity),
+		trades:              make(map[int]TradeEntity),
+		contracts:           make(map[int]ContractEntity),
+		participants:        make(map[string]ParticipantEntity),
+		accounts:            make(map[string]AccountEntity),
+		instruments:         make(map[string]InstrumentEntity),
+
+		// Initialize secondary indexes (see index.go)
+		orderIdx:    newOrderIndex(),
+		tradeByReff: make(map[string]int),
+		contractIdx: newContractIndex(),
 
 		// Initialize public channels
-		Commit:  make(chan any, 1000),
-		IsReady: false,
+		Commit: make(chan any, DefaultCommitQueueSize),
+		ready:  make(chan struct{}),
 
 		// Initialize private fields
-		url:          url,
-		topic:        topic,
+		transport:    transport,
+		partitions:   []Transport{transport},
+		assigned:     []int{ControlPartition},
+		topic:        transport.Topic(),
 		id:           id,
-		startid:      id + "_" + time.Now().Format("20060102150405"),
-		rx:           make(chan kafka.Message, 1000), // Buffer 1000 messages
+		rx:           make(chan Message, 1000), // Buffer 1000 messages
+		commitBatch:  DefaultCommitBatchSize,
 		lastOrderNID: 0,
+		ids:          defaultIDGenerator(id),
+		dedup:        newDedupWindow(DefaultDedupWindow),
+		clock:        SystemClock{},
+		subscribers:  make(map[reflect.Type][]func(any)),
+		offsets:      []int64{-1},
+		current:      -1,
 	}
 
+	point.deadLetter = point.logDeadLetter
+	point.rejected = point.logRejected
 	return &point
 }
 
 func (obj *LedgerPoint) Start(subscriber []LedgerPointInterface, ctx context.Context) {
-	log.Println("🚀 Starting LedgerPoint processing...")
+	obj.Logger().Info("Starting LedgerPoint processing...")
 	if subscriber != nil {
 		obj.allSync = subscriber
 	}
+	// Stamped with the clock set by now, see SetClock
+	obj.startid = obj.id + "_" + obj.clock.Now().Format("20060102150405")
 
 	go obj.go_process(ctx)
 }
 
 func (obj *LedgerPoint) go_process(ctx context.Context) {
 
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:   []string{obj.url},
-		Topic:     obj.topic,
-		Partition: 0, // required when no GroupID
-		MinBytes:  1,
-		MaxBytes:  10e6,
-		MaxWait:   100 * time.Millisecond, // Don't wait too long for batches
-	})
-	r.SetOffset(kafka.FirstOffset) // Start from beginning
+	// Restore from the latest snapshot when enabled, otherwise replay everything
+	obj.loadSnapshot()
+	if obj.archive != nil {
+		obj.replayArchive()
+	}
 
-	go obj.go_receive(r)
+	readers := make([]TransportReader, 0, len(obj.assigned))
+	for _, p := range obj.assigned {
+		startOffset := FirstOffset
+		if offset := atomic.LoadInt64(&obj.offsets[p]); offset >= 0 {
+			startOffset = offset + 1
+		}
 
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      []string{obj.url},
-		Topic:        obj.topic,
-		Balancer:     &kafka.LeastBytes{},
-		BatchTimeout: 1 * time.Millisecond, // Flush immediately (default is 1 second!)
-		Async:        false,                // Synchronous writes
-		RequiredAcks: 1,                    // Wait for leader acknowledgment
-	})
-	defer writer.Close()
+		r, err := obj.partitions[p].OpenReader(startOffset)
+		if err != nil {
+			obj.fatal("Could not open reader", "partition", p, "error", err)
+		}
+		readers = append(readers, r)
+
+		go obj.go_receive(r, p, ctx)
+	}
+
+	// Commits are written on their own goroutine so a slow transport doesn't
+	// hold up applying messages
+	go obj.go_write(ctx)
 
 	// Commit ServiceStart event to mark the beginning of this LedgerPoint instance
 	start := ServiceStart{
 		ID:        obj.id,
 		StartID:   obj.startid,
-		StartTime: time.Now(),
+		StartTime: obj.clock.Now(),
 	}
 	obj.Commit <- start
 
 	for {
 		select {
-		case trx := <-obj.Commit:
-
-			val, _ := json.Marshal(trx)
-			msg := kafka.Message{
-				Key:   []byte("ledgerpoint"),
-				Value: val,
-			}
-
-			switch trx.(type) {
-			case ServiceStart:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("ServiceStart")}}
-			case Holiday:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Holiday")}}
-			case Parameter:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Parameter")}}
-			case SessionTime:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("SessionTime")}}
-			case Instrument:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Instrument")}}
-			case Participant:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Participant")}}
-			case Account:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Account")}}
-			case AccountLimit:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("AccountLimit")}}
-			case Order:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Order")}}
-			case OrderAck:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("OrderAck")}}
-			case OrderNak:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("OrderNak")}}
-			case OrderPending:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("OrderPending")}}
-			case OrderWithdraw:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("OrderWithdraw")}}
-			case OrderWithdrawAck:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("OrderWithdrawAck")}}
-			case OrderWithdrawNak:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("OrderWithdrawNak")}}
-			case Trade:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Trade")}}
-			case TradeWait:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("TradeWait")}}
-			case TradeAck:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("TradeAck")}}
-			case TradeNak:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("TradeNak")}}
-			case TradeReimburse:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("TradeReimburse")}}
-			case Contract:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Contract")}}
-			case Sod:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Sod")}}
-			case Eod:
-				msg.Headers = []kafka.Header{{Key: "event-type", Value: []byte("Eod")}}
-			}
-
-			writeCtx, writeCancel := context.WithTimeout(context.Background(), 5*time.Second)
-			err := writer.WriteMessages(writeCtx, msg)
-			writeCancel() // Cancel immediately after write, don't defer in loop!
-			if err != nil {
-				log.Fatalf("failed to write message: %v", err)
-			}
-
 		case msg := <-obj.rx:
-			// Process incoming message
-			// Check if message has headers
-			if len(msg.Headers) == 0 {
-				log.Printf("⚠️  Received message without headers, skipping")
-				continue
-			}
-
-			// Extract Kafka message timestamp
-			kafkaTimestamp := msg.Time
-
-			switch string(msg.Headers[0].Value) {
-			case "ServiceStart":
-				var serviceStart ServiceStart
-				json.Unmarshal(msg.Value, &serviceStart)
-				serviceStart.Timestamp = kafkaTimestamp
-				obj.SyncServiceStart(serviceStart)
-			case "Holiday":
-				var holiday Holiday
-				json.Unmarshal(msg.Value, &holiday)
-				holiday.Timestamp = kafkaTimestamp
-				obj.SyncHoliday(holiday)
-			case "Parameter":
-				var parameter Parameter
-				json.Unmarshal(msg.Value, &parameter)
-				parameter.Timestamp = kafkaTimestamp
-				obj.SyncParameter(parameter)
-			case "SessionTime":
-				var sessionTime SessionTime
-				json.Unmarshal(msg.Value, &sessionTime)
-				sessionTime.Timestamp = kafkaTimestamp
-				obj.SyncSessionTime(sessionTime)
-			case "Account":
-				var account Account
-				json.Unmarshal(msg.Value, &account)
-				account.Timestamp = kafkaTimestamp
-				obj.SyncAccount(account)
-			case "AccountLimit":
-				var accountLimit AccountLimit
-				json.Unmarshal(msg.Value, &accountLimit)
-				accountLimit.Timestamp = kafkaTimestamp
-				obj.SyncAccountLimit(accountLimit)
-			case "Instrument":
-				var instrument Instrument
-				json.Unmarshal(msg.Value, &instrument)
-				instrument.Timestamp = kafkaTimestamp
-				obj.SyncInstrument(instrument)
-			case "Participant":
-				var participant Participant
-				json.Unmarshal(msg.Value, &participant)
-				participant.Timestamp = kafkaTimestamp
-				obj.SyncParticipant(participant)
-			case "Order":
-				var order Order
-				json.Unmarshal(msg.Value, &order)
-				order.Timestamp = kafkaTimestamp
-				obj.SyncOrder(order)
-			case "OrderAck":
-				var orderAck OrderAck
-				json.Unmarshal(msg.Value, &orderAck)
-				orderAck.Timestamp = kafkaTimestamp
-				obj.SyncOrderAck(orderAck)
-			case "OrderNak":
-				var orderNak OrderNak
-				json.Unmarshal(msg.Value, &orderNak)
-				orderNak.Timestamp = kafkaTimestamp
-				obj.SyncOrderNak(orderNak)
-			case "OrderPending":
-				var orderPending OrderPending
-				json.Unmarshal(msg.Value, &orderPending)
-				orderPending.Timestamp = kafkaTimestamp
-				obj.SyncOrderPending(orderPending)
-			case "OrderWithdraw":
-				var orderWithdraw OrderWithdraw
-				json.Unmarshal(msg.Value, &orderWithdraw)
-				orderWithdraw.Timestamp = kafkaTimestamp
-				obj.SyncOrderWithdraw(orderWithdraw)
-			case "OrderWithdrawAck":
-				var orderWithdrawAck OrderWithdrawAck
-				json.Unmarshal(msg.Value, &orderWithdrawAck)
-				orderWithdrawAck.Timestamp = kafkaTimestamp
-				obj.SyncOrderWithdrawAck(orderWithdrawAck)
-			case "OrderWithdrawNak":
-				var orderWithdrawNak OrderWithdrawNak
-				json.Unmarshal(msg.Value, &orderWithdrawNak)
-				orderWithdrawNak.Timestamp = kafkaTimestamp
-				obj.SyncOrderWithdrawNak(orderWithdrawNak)
-			case "Trade":
-				var trade Trade
-				json.Unmarshal(msg.Value, &trade)
-				trade.Timestamp = kafkaTimestamp
-				obj.SyncTrade(trade)
-			case "TradeWait":
-				var tradeWait TradeWait
-				json.Unmarshal(msg.Value, &tradeWait)
-				tradeWait.Timestamp = kafkaTimestamp
-				obj.SyncTradeWait(tradeWait)
-			case "TradeAck":
-				var tradeAck TradeAck
-				json.Unmarshal(msg.Value, &tradeAck)
-				tradeAck.Timestamp = kafkaTimestamp
-				obj.SyncTradeAck(tradeAck)
-			case "TradeNak":
-				var tradeNak TradeNak
-				json.Unmarshal(msg.Value, &tradeNak)
-				tradeNak.Timestamp = kafkaTimestamp
-				obj.SyncTradeNak(tradeNak)
-			case "TradeReimburse":
-				var tradeReimburse TradeReimburse
-				json.Unmarshal(msg.Value, &tradeReimburse)
-				tradeReimburse.Timestamp = kafkaTimestamp
-				obj.SyncTradeReimburse(tradeReimburse)
-			case "Contract":
-				var contract Contract
-				json.Unmarshal(msg.Value, &contract)
-				contract.Timestamp = kafkaTimestamp
-				obj.SyncContract(contract)
-			case "Sod":
-				var sod Sod
-				json.Unmarshal(msg.Value, &sod)
-				sod.Timestamp = kafkaTimestamp
-				obj.SyncSod(sod)
-			case "Eod":
-				var eod Eod
-				json.Unmarshal(msg.Value, &eod)
-				eod.Timestamp = kafkaTimestamp
-				obj.SyncEod(eod)
-			}
+			obj.applyReceived(msg)
 
 		case <-ctx.Done():
-			r.Close()
-			log.Println("⏰ Context cancelled, stopping processing...")
+			for _, r := range readers {
+				r.Close()
+			}
+			obj.saveSnapshot()
+			obj.Logger().Info("Context cancelled, stopping processing...")
 			return
 		}
 	}
 }
 
-func (obj *LedgerPoint) go_receive(r *kafka.Reader) {
-	log.Println("📥 Waiting for messages...")
+// applyReceived applies a message read from the log and records its offset
+func (obj *LedgerPoint) applyReceived(msg Message) {
+	// Anything we can't make sense of goes to the dead-letter handler
+	// instead of being dropped, forged or unreadable messages to the
+	// rejected handler
+	if err := obj.ApplyMessage(msg); err != nil {
+		var rejected *RejectedError
+		if errors.As(err, &rejected) {
+			obj.rejected(msg, err)
+		} else {
+			obj.deadLetter(msg, err)
+		}
+	}
+
+	atomic.StoreInt64(&obj.offsets[msg.Partition], msg.Offset)
+	if msg.Time.After(obj.lastTime) {
+		obj.lastTime = msg.Time
+	}
+	if obj.snapshots != nil && obj.snapshotEvery > 0 {
+		obj.eventsSinceSnapshot++
+		if obj.eventsSinceSnapshot >= obj.snapshotEvery {
+			obj.saveSnapshot()
+		}
+	}
+}
+
+// ApplyMessage decodes msg and applies it as if it had been read from the
+// log, updating state and notifying subscribers. Started LedgerPoints call it
+// from their processing goroutine; offline tools can use it to replay a
+// transport into a LedgerPoint that is never started.
+//
+// Messages whose event ID was applied recently are redeliveries (e.g. a
+// producer retry) and are skipped.
+func (obj *LedgerPoint) ApplyMessage(msg Message) error {
+	obj.current = msg.Offset
+	env, event, err := obj.DecodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	if err := obj.policy.checkMessage(env); err != nil {
+		return &RejectedError{Err: err}
+	}
+	if key := dedupKey(msg, env); key != "" && !obj.dedup.add(key) {
+		obj.Logger().Info("Skipping duplicate event", logging.EventType(env.EventType),
+			"event_id", env.EventID, "producer", env.Producer, logging.Offset(msg.Offset))
+		return nil
+	}
+	ctx, span := obj.startApplySpan(msg, env)
+	obj.currentCtx = ctx
+	events.apply(obj, event)
+	obj.currentCtx = nil
+	if span != nil {
+		span.End()
+	}
+	obj.countApplied(env.EventType)
+	return nil
+}
+
+// CurrentOffset returns the offset of the message being applied, so
+// subscribers can tag what they observe with its position in the log
+func (obj *LedgerPoint) CurrentOffset() int64 {
+	return obj.current
+}
+
+// go_receive feeds the messages of one partition to the processing goroutine
+// in offset order
+func (obj *LedgerPoint) go_receive(r TransportReader, partition int, ctx context.Context) {
+	obj.Logger().Debug("Waiting for messages...", "partition", partition)
 
 	for {
-		m, err := r.ReadMessage(context.Background())
+		m, err := r.ReadMessage(ctx)
 		if err != nil {
-			log.Fatalf("❌ could not read message: %v", err)
+			if ctx.Err() != nil || errors.Is(err, ErrTransportClosed) {
+				return
+			}
+			obj.fatal("Could not read message", "partition", partition, "error", err)
 		}
+		m.Partition = partition
 
-		// log.Printf("🔔 <-- key=%s value=%s offset=%d\n",
-		// 	string(m.Key), string(m.Value), m.Offset)
-
-		obj.rx <- m
+		select {
+		case obj.rx <- m:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
 func (obj *LedgerPoint) SyncServiceStart(a ServiceStart) {
+	// Ready once our marker has come back on every consumed partition
 	if a.StartID == obj.startid {
-		obj.IsReady = true
-		for _, sync := range obj.allSync {
-			sync.SyncServiceStart(a)
+		obj.startsSeen++
+		if obj.startsSeen == len(obj.assigned) {
+			obj.readyOnce.Do(func() { close(obj.ready) })
+			obj.notify(a)
 		}
 	}
 }
@@ -541,9 +540,7 @@ func (obj *LedgerPoint) SyncHoliday(a Holiday) {
 	}
 	obj.holidayMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncHoliday(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncParameter(a Parameter) {
@@ -558,13 +555,11 @@ func (obj *LedgerPoint) SyncParameter(a Parameter) {
 		MaxQuantity:       a.MaxQuantity,
 		BorrowMaxOpenDay:  a.BorrowMaxOpenDay,
 		DenominationLimit: a.DenominationLimit,
-		LastUpdate:        time.Now(),
+		LastUpdate:        a.Timestamp,
 	}
 	obj.parameterMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncParameter(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncSessionTime(a SessionTime) {
@@ -577,17 +572,60 @@ func (obj *LedgerPoint) SyncSessionTime(a SessionTime) {
 		Session1End:   a.Session1End,
 		Session2Start: a.Session2Start,
 		Session2End:   a.Session2End,
-		LastUpdate:    time.Now(),
+		LastUpdate:    a.Timestamp,
 	}
 	obj.sessionTimeMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncSessionTime(a)
+	obj.notify(a)
+}
+
+func (obj *LedgerPoint) SyncRiskRule(a RiskRule) {
+	params := make(map[string]string, len(a.Params))
+	for k, v := range a.Params {
+		params[k] = v
+	}
+
+	obj.riskRuleMu.Lock()
+	obj.riskRules[a.Name] = RiskRuleEntity{
+		Name:        a.Name,
+		Description: a.Description,
+		Enabled:     a.Enabled,
+		Priority:    a.Priority,
+		Params:      params,
+		LastUpdate:  a.Timestamp,
+	}
+	obj.riskRuleMu.Unlock()
+
+	obj.notify(a)
+}
+
+func (obj *LedgerPoint) SyncConcentrationLimit(a ConcentrationLimit) {
+	limit := ConcentrationLimitEntity{
+		ParticipantCode: a.ParticipantCode,
+		InstrumentCode:  a.InstrumentCode,
+		SID:             a.SID,
+		MaxQuantity:     a.MaxQuantity,
+		MaxValue:        a.MaxValue,
+		Description:     a.Description,
+		LastUpdate:      a.Timestamp,
+	}
+	key := limit.Scope().key()
+
+	obj.concentrationMu.Lock()
+	if a.MaxQuantity <= 0 && a.MaxValue <= 0 {
+		delete(obj.concentrationLimits, key)
+	} else {
+		obj.concentrationLimits[key] = limit
 	}
+	obj.concentrationMu.Unlock()
+
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncAccount(a Account) {
 	obj.accountMu.Lock()
+	// Limits are set by AccountLimit; a repeated Account keeps them
+	existing := obj.accounts[a.Code]
 	obj.accounts[a.Code] = AccountEntity{
 		NID:             a.NID,
 		Code:            a.Code,
@@ -595,15 +633,13 @@ func (obj *LedgerPoint) SyncAccount(a Account) {
 		Name:            a.Name,
 		ParticipantNID:  a.ParticipantNID,
 		ParticipantCode: a.ParticipantCode,
-		TradeLimit:      0,
-		PoolLimit:       0,
-		LastUpdate:      time.Now(),
+		TradeLimit:      existing.TradeLimit,
+		PoolLimit:       existing.PoolLimit,
+		LastUpdate:      a.Timestamp,
 	}
 	obj.accountMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncAccount(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncAccountLimit(a AccountLimit) {
@@ -611,14 +647,12 @@ func (obj *LedgerPoint) SyncAccountLimit(a AccountLimit) {
 	if account, exists := obj.accounts[a.Code]; exists {
 		account.TradeLimit = a.TradeLimit
 		account.PoolLimit = a.PoolLimit
-		account.LastUpdate = time.Now()
+		account.LastUpdate = a.Timestamp
 		obj.accounts[a.Code] = account
 	}
 	obj.accountMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncAccountLimit(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncInstrument(a Instrument) {
@@ -629,13 +663,11 @@ func (obj *LedgerPoint) SyncInstrument(a Instrument) {
 		Name:       a.Name,
 		Type:       a.Type,
 		Status:     a.Status,
-		LastUpdate: time.Now(),
+		LastUpdate: a.Timestamp,
 	}
 	obj.instrumentMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncInstrument(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncParticipant(a Participant) {
@@ -646,18 +678,33 @@ func (obj *LedgerPoint) SyncParticipant(a Participant) {
 		Name:            a.Name,
 		BorrEligibility: a.BorrEligibility,
 		LendEligibility: a.LendEligibility,
-		LastUpdate:      time.Now(),
+		LastUpdate:      a.Timestamp,
 	}
 	obj.participantMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncParticipant(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncOrder(a Order) {
 	obj.ordersMu.Lock()
-	obj.orders[a.NID] = OrderEntity{
+	// A repeated Order must not reset the state and done quantity
+	if _, exists := obj.orders[a.NID]; exists {
+		obj.ordersMu.Unlock()
+		return
+	}
+	// A request retried before the first order was applied, e.g. through
+	// another pmeapi instance, is committed twice under different NIDs. The
+	// first order counts and the retry never reaches the OMS. Only orders of
+	// the same participant and instrument are compared, they are on the same
+	// partition so every service keeps the same one; pmeapi refuses reuse
+	// across instruments.
+	if nid, used := obj.orderIdx.byRequest[requestOf(a.ParticipantCode, a.InstrumentCode, a.ReffRequestID)]; used && a.ReffRequestID != "" {
+		obj.ordersMu.Unlock()
+		obj.Logger().Info("Skipping order of a reused reff_request_id", logging.OrderNID(a.NID),
+			"reff_request_id", a.ReffRequestID, "first_order_nid", nid)
+		return
+	}
+	obj.putOrder(OrderEntity{
 		NID:               a.NID,
 		PrevNID:           a.PrevNID,
 		ReffRequestID:     a.ReffRequestID,
@@ -681,42 +728,51 @@ func (obj *LedgerPoint) SyncOrder(a Order) {
 		WReffRequestID:    "",
 		Message:           "",
 		EntryAt:           a.Timestamp,
-		OpenAt:            time.Now(),
-		RejectAt:          time.Now(),
-		AmmendAt:          time.Now(),
-		WithdrawAt:        time.Now(),
-	}
+		OpenAt:            a.Timestamp,
+		RejectAt:          a.Timestamp,
+		AmmendAt:          a.Timestamp,
+		WithdrawAt:        a.Timestamp,
+	})
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncOrder(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncOrderAck(a OrderAck) {
 	obj.ordersMu.Lock()
-	if order, exists := obj.orders[a.OrderNID]; exists {
+	order, exists := obj.orders[a.OrderNID]
+	if exists && order.State != "S" && order.State != "G" {
+		// Already acknowledged, or matched since
+		obj.ordersMu.Unlock()
+		return
+	}
+	if exists {
 		order.OpenAt = a.Timestamp
 		order.State = "O"
+		order.ReservedLimit = a.ReservedLimit
 		obj.orders[a.OrderNID] = order
 		if order.PrevNID != 0 {
 			if prevOrder, exists := obj.orders[order.PrevNID]; exists {
 				prevOrder.AmmendAt = a.Timestamp
 				prevOrder.State = "A"
+				prevOrder.ReservedLimit = 0 // The amendment holds the limit now
 				obj.orders[order.PrevNID] = prevOrder
 			}
 		}
 	}
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncOrderAck(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncOrderNak(a OrderNak) {
 	obj.ordersMu.Lock()
-	if order, exists := obj.orders[a.OrderNID]; exists {
+	order, exists := obj.orders[a.OrderNID]
+	if exists && order.State != "S" && order.State != "G" {
+		obj.ordersMu.Unlock()
+		return
+	}
+	if exists {
 		order.RejectAt = a.Timestamp
 		order.State = "R"
 		order.Message = a.Message
@@ -724,23 +780,24 @@ func (obj *LedgerPoint) SyncOrderNak(a OrderNak) {
 	}
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncOrderNak(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncOrderPending(a OrderPending) {
 	obj.ordersMu.Lock()
-	if order, exists := obj.orders[a.OrderNID]; exists {
+	order, exists := obj.orders[a.OrderNID]
+	if exists && order.State != "S" {
+		obj.ordersMu.Unlock()
+		return
+	}
+	if exists {
 		order.PendingAt = a.Timestamp
 		order.State = "G"
 		obj.orders[a.OrderNID] = order
 	}
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncOrderPending(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncOrderWithdraw(a OrderWithdraw) {
@@ -751,23 +808,25 @@ func (obj *LedgerPoint) SyncOrderWithdraw(a OrderWithdraw) {
 	}
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncOrderWithdraw(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncOrderWithdrawAck(a OrderWithdrawAck) {
 	obj.ordersMu.Lock()
-	if order, exists := obj.orders[a.OrderNID]; exists {
+	order, exists := obj.orders[a.OrderNID]
+	if exists && order.State == "W" {
+		obj.ordersMu.Unlock()
+		return
+	}
+	if exists {
 		order.WithdrawAt = a.Timestamp
 		order.State = "W"
+		order.ReservedLimit = 0
 		obj.orders[a.OrderNID] = order
 	}
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncOrderWithdrawAck(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncOrderWithdrawNak(a OrderWithdrawNak) {
@@ -778,9 +837,7 @@ func (obj *LedgerPoint) SyncOrderWithdrawNak(a OrderWithdrawNak) {
 	}
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncOrderWithdrawNak(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncTrade(a Trade) {
@@ -789,6 +846,14 @@ func (obj *LedgerPoint) SyncTrade(a Trade) {
 	obj.contractsMu.Lock()
 	obj.tradesMu.Lock()
 
+	// Applying a trade twice would count its quantity twice
+	if _, exists := obj.trades[a.NID]; exists {
+		obj.tradesMu.Unlock()
+		obj.contractsMu.Unlock()
+		obj.ordersMu.Unlock()
+		return
+	}
+
 	borrContract := make([]int, 0)
 	for _, borr := range a.Borrower {
 		contract := ContractEntity{
@@ -812,10 +877,14 @@ func (obj *LedgerPoint) SyncTrade(a Trade) {
 			MatchedAt:              borr.MatchedAt,
 			ReimburseAt:            borr.ReimburseAt,
 		}
+		order, orderExists := obj.orders[borr.OrderNID]
+		if orderExists {
+			contract.UtilisedLimit = order.takeReservation(borr.Quantity)
+		}
 		borrContract = append(borrContract, borr.NID)
-		obj.contracts[borr.NID] = contract
+		obj.putContract(contract)
 
-		if order, exists := obj.orders[borr.OrderNID]; exists {
+		if orderExists {
 			order.DoneQuantity += borr.Quantity
 			if order.DoneQuantity >= order.Quantity {
 				order.State = "M"
@@ -849,10 +918,14 @@ func (obj *LedgerPoint) SyncTrade(a Trade) {
 			MatchedAt:              lend.MatchedAt,
 			ReimburseAt:            lend.ReimburseAt,
 		}
+		order, orderExists := obj.orders[lend.OrderNID]
+		if orderExists {
+			contract.UtilisedLimit = order.takeReservation(lend.Quantity)
+		}
 		lendContract = append(lendContract, lend.NID)
-		obj.contracts[lend.NID] = contract
+		obj.putContract(contract)
 
-		if order, exists := obj.orders[lend.OrderNID]; exists {
+		if orderExists {
 			order.DoneQuantity += lend.Quantity
 			if order.DoneQuantity >= order.Quantity {
 				order.State = "M"
@@ -863,7 +936,7 @@ func (obj *LedgerPoint) SyncTrade(a Trade) {
 		}
 	}
 
-	obj.trades[a.NID] = TradeEntity{
+	obj.putTrade(TradeEntity{
 		NID:            a.NID,
 		KpeiReff:       a.KpeiReff,
 		InstrumentNID:  a.InstrumentNID,
@@ -875,23 +948,27 @@ func (obj *LedgerPoint) SyncTrade(a Trade) {
 		ReimburseAt:    a.ReimburseAt,
 		Borrower:       borrContract,
 		Lender:         lendContract,
-	}
+	})
 
 	// Unlock in reverse order
 	obj.tradesMu.Unlock()
 	obj.contractsMu.Unlock()
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncTrade(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncTradeWait(a TradeWait) {
 	obj.tradesMu.Lock()
 	obj.contractsMu.Lock()
 
-	if trade, exists := obj.trades[a.TradeNID]; exists {
+	trade, exists := obj.trades[a.TradeNID]
+	if exists && trade.State != "" {
+		obj.contractsMu.Unlock()
+		obj.tradesMu.Unlock()
+		return
+	}
+	if exists {
 		trade.State = "E"
 		obj.trades[a.TradeNID] = trade
 
@@ -912,16 +989,20 @@ func (obj *LedgerPoint) SyncTradeWait(a TradeWait) {
 	obj.contractsMu.Unlock()
 	obj.tradesMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncTradeWait(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncTradeAck(a TradeAck) {
 	obj.tradesMu.Lock()
 	obj.contractsMu.Lock()
 
-	if trade, exists := obj.trades[a.TradeNID]; exists {
+	trade, exists := obj.trades[a.TradeNID]
+	if exists && (trade.State == "O" || trade.State == "R" || trade.State == "C") {
+		obj.contractsMu.Unlock()
+		obj.tradesMu.Unlock()
+		return
+	}
+	if exists {
 		trade.State = "O"
 		obj.trades[a.TradeNID] = trade
 		for _, contractNID := range trade.Borrower {
@@ -940,9 +1021,7 @@ func (obj *LedgerPoint) SyncTradeAck(a TradeAck) {
 	obj.contractsMu.Unlock()
 	obj.tradesMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncTradeAck(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncTradeNak(a TradeNak) {
@@ -950,14 +1029,26 @@ func (obj *LedgerPoint) SyncTradeNak(a TradeNak) {
 	obj.tradesMu.Lock()
 	obj.contractsMu.Lock()
 
-	if trade, exists := obj.trades[a.TradeNID]; exists {
+	// Rejecting twice would give back the done quantity twice
+	trade, exists := obj.trades[a.TradeNID]
+	if exists && (trade.State == "R" || trade.State == "C") {
+		obj.contractsMu.Unlock()
+		obj.tradesMu.Unlock()
+		obj.ordersMu.Unlock()
+		return
+	}
+	if exists {
 		trade.State = "R"
 		obj.trades[a.TradeNID] = trade
 		for _, contractNID := range trade.Borrower {
 			if contract, exists := obj.contracts[contractNID]; exists {
+				released := contract.UtilisedLimit
 				contract.State = "R"
+				contract.UtilisedLimit = 0
 				obj.contracts[contractNID] = contract
 				if order, exists := obj.orders[contract.OrderNID]; exists {
+					// The quantity is open again and holds its share of the limit
+					order.ReservedLimit += released
 					order.DoneQuantity -= contract.Quantity
 					if order.DoneQuantity > 0 {
 						order.State = "P"
@@ -971,9 +1062,12 @@ func (obj *LedgerPoint) SyncTradeNak(a TradeNak) {
 		}
 		for _, contractNID := range trade.Lender {
 			if contract, exists := obj.contracts[contractNID]; exists {
+				released := contract.UtilisedLimit
 				contract.State = "R"
+				contract.UtilisedLimit = 0
 				obj.contracts[contractNID] = contract
 				if order, exists := obj.orders[contract.OrderNID]; exists {
+					order.ReservedLimit += released
 					order.DoneQuantity -= contract.Quantity
 					if order.DoneQuantity > 0 {
 						order.State = "P"
@@ -989,30 +1083,36 @@ func (obj *LedgerPoint) SyncTradeNak(a TradeNak) {
 	obj.tradesMu.Unlock()
 	obj.ordersMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncTradeNak(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncTradeReimburse(a TradeReimburse) {
 	obj.tradesMu.Lock()
 	obj.contractsMu.Lock()
 
-	if trade, exists := obj.trades[a.TradeNID]; exists {
+	trade, exists := obj.trades[a.TradeNID]
+	if exists && trade.State == "C" {
+		obj.contractsMu.Unlock()
+		obj.tradesMu.Unlock()
+		return
+	}
+	if exists {
 		trade.State = "C"
-		trade.ReimburseAt = time.Now()
+		trade.ReimburseAt = a.Timestamp
 		obj.trades[a.TradeNID] = trade
 		for _, contractNID := range trade.Borrower {
 			if contract, exists := obj.contracts[contractNID]; exists {
 				contract.State = "C"
-				contract.ReimburseAt = time.Now()
+				contract.ReimburseAt = a.Timestamp
+				contract.UtilisedLimit = 0
 				obj.contracts[contractNID] = contract
 			}
 		}
 		for _, contractNID := range trade.Lender {
 			if contract, exists := obj.contracts[contractNID]; exists {
 				contract.State = "C"
-				contract.ReimburseAt = time.Now()
+				contract.ReimburseAt = a.Timestamp
+				contract.UtilisedLimit = 0
 				obj.contracts[contractNID] = contract
 			}
 		}
@@ -1020,15 +1120,13 @@ func (obj *LedgerPoint) SyncTradeReimburse(a TradeReimburse) {
 	obj.contractsMu.Unlock()
 	obj.tradesMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncTradeReimburse(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncContract(a Contract) {
 	obj.contractsMu.Lock()
 
-	obj.contracts[a.NID] = ContractEntity{
+	obj.putContract(ContractEntity{
 		NID:                    a.NID,
 		TradeNID:               a.TradeNID,
 		KpeiReff:               a.KpeiReff,
@@ -1048,27 +1146,16 @@ func (obj *LedgerPoint) SyncContract(a Contract) {
 		State:                  a.State,
 		MatchedAt:              a.MatchedAt,
 		ReimburseAt:            a.ReimburseAt,
-	}
+	})
 	obj.contractsMu.Unlock()
 
-	for _, sync := range obj.allSync {
-		sync.SyncContract(a)
-	}
-}
-
-// GetCurrentTimeMillis returns current time in milliseconds
-func GetCurrentTimeMillis() int64 {
-	return time.Now().UnixNano() / int64(time.Millisecond)
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncSod(a Sod) {
-	for _, sync := range obj.allSync {
-		sync.SyncSod(a)
-	}
+	obj.notify(a)
 }
 
 func (obj *LedgerPoint) SyncEod(a Eod) {
-	for _, sync := range obj.allSync {
-		sync.SyncEod(a)
-	}
+	obj.notify(a)
 }
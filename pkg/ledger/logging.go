@@ -0,0 +1,29 @@
+package ledger
+
+import (
+	"log/slog"
+	"os"
+
+	"pmeonline/pkg/logging"
+)
+
+// SetLogger replaces the default logger (slog.Default()) the LedgerPoint
+// logs its progress, dead letters and rejected messages to. Must be called
+// before Start.
+func (lp *LedgerPoint) SetLogger(l *slog.Logger) {
+	lp.logger = l.With(logging.Component("ledger"))
+}
+
+// Logger returns the logger of the LedgerPoint
+func (lp *LedgerPoint) Logger() *slog.Logger {
+	if lp.logger == nil {
+		return slog.Default()
+	}
+	return lp.logger
+}
+
+// fatal logs an error the LedgerPoint can't recover from and exits
+func (lp *LedgerPoint) fatal(msg string, args ...any) {
+	lp.Logger().Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,150 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTransportClosed is returned by transports and readers after Close
+var ErrTransportClosed = errors.New("transport closed")
+
+// MemoryTransport is an in-process log. Several LedgerPoints can share one
+// instance to run the whole system inside a single test binary.
+type MemoryTransport struct {
+	topic string
+
+	mu       sync.Mutex
+	messages []Message
+	start    int64         // First retained offset, see DeleteBefore
+	appended chan struct{} // Closed and replaced on every append
+	closed   bool
+}
+
+// NewMemoryTransport creates an empty in-process log
+func NewMemoryTransport(topic string) *MemoryTransport {
+	return &MemoryTransport{
+		topic:    topic,
+		appended: make(chan struct{}),
+	}
+}
+
+func (t *MemoryTransport) Topic() string {
+	return t.topic
+}
+
+func (t *MemoryTransport) Append(ctx context.Context, msgs ...Message) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return -1, ErrTransportClosed
+	}
+
+	first := int64(len(t.messages))
+	now := time.Now()
+	for _, m := range msgs {
+		m.Offset = int64(len(t.messages))
+		if m.Time.IsZero() {
+			m.Time = now
+		}
+		t.messages = append(t.messages, m)
+	}
+
+	close(t.appended)
+	t.appended = make(chan struct{})
+	return first, nil
+}
+
+func (t *MemoryTransport) OpenReader(offset int64) (TransportReader, error) {
+	if offset == FirstOffset || offset < 0 {
+		offset = 0
+	}
+	return &memoryReader{t: t, next: offset, done: make(chan struct{})}, nil
+}
+
+func (t *MemoryTransport) HighWaterMark(ctx context.Context) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int64(len(t.messages)), nil
+}
+
+// Close wakes up all readers, which then return ErrTransportClosed
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		close(t.appended)
+	}
+	return nil
+}
+
+// Messages returns a copy of the log, mainly for assertions in tests
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.messages...)
+}
+
+// DeleteBefore drops the messages before offset like Kafka retention does.
+// Readers positioned before it continue at offset.
+func (t *MemoryTransport) DeleteBefore(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := t.start; i < offset && i < int64(len(t.messages)); i++ {
+		t.messages[i] = Message{Offset: i}
+	}
+	if offset > t.start {
+		t.start = offset
+	}
+}
+
+type memoryReader struct {
+	t    *MemoryTransport
+	next int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (r *memoryReader) ReadMessage(ctx context.Context) (Message, error) {
+	for {
+		r.t.mu.Lock()
+		if r.next < r.t.start {
+			r.next = r.t.start
+		}
+		if r.next < int64(len(r.t.messages)) {
+			m := r.t.messages[r.next]
+			r.next++
+			r.t.mu.Unlock()
+			return m, nil
+		}
+		closed := r.t.closed
+		appended := r.t.appended
+		r.t.mu.Unlock()
+
+		if closed {
+			return Message{}, ErrTransportClosed
+		}
+
+		select {
+		case <-appended:
+		case <-r.done:
+			return Message{}, ErrTransportClosed
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+func (r *memoryReader) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
+}
@@ -0,0 +1,187 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ControlPartition carries master data, configuration and session events on
+// a partitioned ledger. Every LedgerPoint consumes it.
+const ControlPartition = 0
+
+// ErrPartitioned is returned by operations that need a single ordered log,
+// such as point-in-time views
+var ErrPartitioned = errors.New("not supported on a partitioned ledger")
+
+// ErrPartialLedger is returned by account-wide queries on a LedgerPoint that
+// doesn't consume every partition: it holds the orders and contracts of its
+// own instruments only, so totals over an account would be under-counted
+var ErrPartialLedger = errors.New("ledger doesn't consume every partition")
+
+// PartitionFor returns the partition events of an instrument are written to
+// on a ledger with the given number of partitions. Instruments are spread
+// over the partitions after the control partition, so all events of one
+// instrument stay in order.
+func PartitionFor(instrumentCode string, partitions int) int {
+	if partitions <= 1 {
+		return ControlPartition
+	}
+	h := fnv.New32a()
+	h.Write([]byte(instrumentCode))
+	return 1 + int(h.Sum32()%uint32(partitions-1))
+}
+
+// ParsePartitions parses a comma separated list of partitions, e.g. "1,3",
+// as used by the LEDGER_ASSIGNED_PARTITIONS setting
+func ParsePartitions(s string) ([]int, error) {
+	var partitions []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		p, err := strconv.Atoi(field)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid partition %q", field)
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// CreatePartitionedLedgerPoint creates a LedgerPoint on a Kafka topic with
+// the given number of partitions. It consumes the control partition and the
+// assigned ones; nil assigns all of them.
+func CreatePartitionedLedgerPoint(url string, topic string, partitions int, assigned []int, id string) (*LedgerPoint, error) {
+	transports := make([]Transport, partitions)
+	for p := range transports {
+		transports[p] = NewKafkaPartitionTransport(url, topic, p)
+	}
+	return CreateLedgerPointWithPartitions(transports, assigned, id)
+}
+
+// CreateLedgerPointWithPartitions creates a LedgerPoint on a log split over
+// one transport per partition, transports[ControlPartition] being the
+// control partition. Instrument events (orders, trades and their
+// acknowledgements) are written to PartitionFor their instrument; everything
+// else goes to the control partition. Ordering is only guaranteed within a
+// partition, i.e. per instrument and for the control events.
+func CreateLedgerPointWithPartitions(transports []Transport, assigned []int, id string) (*LedgerPoint, error) {
+	if len(transports) == 0 {
+		return nil, errors.New("at least one partition is required")
+	}
+
+	seen := map[int]bool{ControlPartition: true}
+	consumed := []int{ControlPartition}
+	if assigned == nil {
+		for p := range transports {
+			assigned = append(assigned, p)
+		}
+	}
+	for _, p := range assigned {
+		if p < 0 || p >= len(transports) {
+			return nil, fmt.Errorf("partition %d out of range [0, %d)", p, len(transports))
+		}
+		if !seen[p] {
+			seen[p] = true
+			consumed = append(consumed, p)
+		}
+	}
+	sort.Ints(consumed)
+
+	lp := CreateLedgerPointWithTransport(transports[ControlPartition], id)
+	lp.partitions = transports
+	lp.assigned = consumed
+	lp.offsets = make([]int64, len(transports))
+	for p := range lp.offsets {
+		lp.offsets[p] = -1
+	}
+	if len(transports) > 1 {
+		// Instances consuming different partitions must not share snapshots
+		parts := make([]string, len(consumed))
+		for i, p := range consumed {
+			parts[i] = strconv.Itoa(p)
+		}
+		lp.topic = fmt.Sprintf("%s-p%s", lp.topic, strings.Join(parts, "."))
+	}
+	return lp, nil
+}
+
+// Partitioned reports whether the LedgerPoint runs on more than one partition
+func (lp *LedgerPoint) Partitioned() bool {
+	return len(lp.partitions) > 1
+}
+
+// ConsumesAllPartitions reports whether the LedgerPoint consumes every
+// partition and so holds the orders and contracts of every instrument
+func (lp *LedgerPoint) ConsumesAllPartitions() bool {
+	return len(lp.assigned) >= len(lp.partitions)
+}
+
+// AssignedPartitions returns the partitions the LedgerPoint consumes
+func (lp *LedgerPoint) AssignedPartitions() []int {
+	return append([]int(nil), lp.assigned...)
+}
+
+// partitionsFor returns the partitions event is written to. ServiceStart
+// goes to every consumed partition so Ready can tell when all of them have
+// been replayed.
+func (lp *LedgerPoint) partitionsFor(event any) []int {
+	if !lp.Partitioned() {
+		return []int{ControlPartition}
+	}
+	if _, ok := event.(ServiceStart); ok {
+		return lp.assigned
+	}
+	if code := lp.instrumentOf(event); code != "" {
+		return []int{PartitionFor(code, len(lp.partitions))}
+	}
+	return []int{ControlPartition}
+}
+
+// instrumentOf returns the instrument an event belongs to, looking up the
+// order or trade for events that only carry its NID. Events of orders and
+// trades this LedgerPoint doesn't know about, and non-instrument events,
+// return "".
+func (lp *LedgerPoint) instrumentOf(event any) string {
+	orderInstrument := func(nid int) string {
+		order, _ := lp.GetOrder(nid)
+		return order.InstrumentCode
+	}
+	tradeInstrument := func(nid int) string {
+		trade, _ := lp.GetTrade(nid)
+		return trade.InstrumentCode
+	}
+
+	switch e := event.(type) {
+	case Order:
+		return e.InstrumentCode
+	case OrderAck:
+		return orderInstrument(e.OrderNID)
+	case OrderNak:
+		return orderInstrument(e.OrderNID)
+	case OrderPending:
+		return orderInstrument(e.OrderNID)
+	case OrderWithdraw:
+		return orderInstrument(e.OrderNID)
+	case OrderWithdrawAck:
+		return orderInstrument(e.OrderNID)
+	case OrderWithdrawNak:
+		return orderInstrument(e.OrderNID)
+	case Trade:
+		return e.InstrumentCode
+	case TradeWait:
+		return tradeInstrument(e.TradeNID)
+	case TradeAck:
+		return tradeInstrument(e.TradeNID)
+	case TradeNak:
+		return tradeInstrument(e.TradeNID)
+	case TradeReimburse:
+		return tradeInstrument(e.TradeNID)
+	}
+	return ""
+}
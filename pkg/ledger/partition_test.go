@@ -0,0 +1,86 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPartitionedLedger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transports := []Transport{NewMemoryTransport("pme-ledger"), NewMemoryTransport("pme-ledger"), NewMemoryTransport("pme-ledger")}
+
+	// Two instruments on different partitions
+	first, second := "BBRI", ""
+	for _, code := range []string{"TLKM", "ASII", "BBCA", "BMRI", "UNVR"} {
+		if PartitionFor(code, len(transports)) != PartitionFor(first, len(transports)) {
+			second = code
+			break
+		}
+	}
+	p1, p2 := PartitionFor(first, len(transports)), PartitionFor(second, len(transports))
+
+	writer, err := CreateLedgerPointWithPartitions(transports, nil, "pmeapi")
+	if err != nil {
+		t.Fatalf("CreateLedgerPointWithPartitions() error: %v", err)
+	}
+	writer.Start(nil, ctx)
+	<-writer.Ready()
+
+	writer.CommitSync(ctx, Account{NID: 1, Code: "YU-001", ParticipantCode: "YU"})
+	writer.CommitSync(ctx, Order{NID: 1, AccountCode: "YU-001", InstrumentCode: first, Side: "BORR", Quantity: 100})
+	writer.CommitSync(ctx, Order{NID: 2, AccountCode: "YU-001", InstrumentCode: second, Side: "BORR", Quantity: 100})
+	waitFor(t, "order 1", func() bool { _, ok := writer.GetOrder(1); return ok })
+
+	// Routed through the order's instrument
+	writer.CommitSync(ctx, OrderAck{OrderNID: 1})
+
+	msgs := transports[p1].(*MemoryTransport).Messages()
+	if len(msgs) != 3 || string(msgs[1].Key) != first || eventTypeOf(msgs[2]) != "OrderAck" {
+		t.Errorf("partition %d = %d messages, want ServiceStart, Order and OrderAck", p1, len(msgs))
+	}
+	if msgs := transports[ControlPartition].(*MemoryTransport).Messages(); len(msgs) != 2 || eventTypeOf(msgs[1]) != "Account" {
+		t.Errorf("control partition = %d messages, want ServiceStart and Account", len(msgs))
+	}
+
+	// An instance assigned to one partition sees the control events and
+	// that partition's instruments only
+	oms, err := CreateLedgerPointWithPartitions(transports, []int{p1}, "pmeoms")
+	if err != nil {
+		t.Fatalf("CreateLedgerPointWithPartitions() error: %v", err)
+	}
+	oms.Start(nil, ctx)
+	<-oms.Ready()
+	waitFor(t, "no lag", func() bool { lag, err := oms.Lag(ctx); return err == nil && lag.Lag == 0 })
+
+	if order, ok := oms.GetOrder(1); !ok || order.State != "O" {
+		t.Errorf("GetOrder(1) = %+v, %v, want state O", order, ok)
+	}
+	if _, ok := oms.GetOrder(2); ok {
+		t.Errorf("order 2 on partition %d was applied by an instance assigned to %d", p2, p1)
+	}
+	if _, ok := oms.GetAccount("YU-001"); !ok {
+		t.Error("account from the control partition missing")
+	}
+	if _, err := oms.ViewAtOffset(ctx, 0); err != ErrPartitioned {
+		t.Errorf("ViewAtOffset() error = %v, want ErrPartitioned", err)
+	}
+}
+
+func eventTypeOf(msg Message) string {
+	env, _ := ParseEnvelope(msg.Headers)
+	return env.EventType
+}
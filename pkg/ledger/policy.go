@@ -0,0 +1,106 @@
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrUnauthorised is returned when a producer commits an event type the
+// policy doesn't allow it to
+var ErrUnauthorised = errors.New("producer not authorised for event type")
+
+// AnyEvent in a Policy allows a producer every event type
+const AnyEvent = "*"
+
+// Policy maps producers (the id passed to CreateLedgerPoint) to the event
+// types they may emit. Every producer may emit ServiceStart. Messages
+// written before the envelope existed carry no producer and are allowed;
+// an enveloped message without one is not.
+type Policy map[string][]string
+
+// DefaultPolicy is the authorisation matrix of the PME services
+var DefaultPolicy = Policy{
+	"pmeapi": {"Order", "OrderWithdraw"},
+	"pmeoms": {
+		"OrderAck", "OrderNak", "OrderPending",
+		"OrderWithdrawAck", "OrderWithdrawNak",
+		"Trade", "Contract",
+	},
+	"eclearapi": {
+		// Configuration and master data from eClear
+		"Parameter", "Holiday", "SessionTime", "RiskRule", "ConcentrationLimit",
+		"Instrument", "Participant", "Account", "AccountLimit",
+		"Sod", "Eod",
+		// Clearing of trades, including ARO orders on reimbursement
+		"TradeWait", "TradeAck", "TradeNak", "TradeReimburse", "Order",
+	},
+	"dbexporter": {},
+}
+
+// Allows reports whether producer may emit eventType. A nil Policy allows
+// everything; otherwise a blank producer may emit nothing but ServiceStart.
+func (p Policy) Allows(producer string, eventType string) bool {
+	if p == nil || eventType == "ServiceStart" {
+		return true
+	}
+	for _, t := range p[producer] {
+		if t == eventType || t == AnyEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an ErrUnauthorised error if producer may not emit eventType
+func (p Policy) check(producer string, eventType string) error {
+	if !p.Allows(producer, eventType) {
+		return fmt.Errorf("%w: %q may not emit %s", ErrUnauthorised, producer, eventType)
+	}
+	return nil
+}
+
+// checkMessage checks the producer of a message read from the log. Messages
+// written before the envelope existed are let through.
+func (p Policy) checkMessage(env Envelope) error {
+	if env.Legacy() {
+		return nil
+	}
+	return p.check(env.Producer, env.EventType)
+}
+
+// SetPolicy restricts the event types each producer may emit. The
+// LedgerPoint refuses to commit events its own id isn't allowed, and rejects
+// messages from other producers that break the policy as it reads them. The
+// default nil Policy allows everything. Must be called before Start.
+func (lp *LedgerPoint) SetPolicy(p Policy) {
+	lp.policy = p
+}
+
+// PolicyFromEnv returns the policy in the JSON file named by
+// LEDGER_POLICY_FILE, e.g. {"pmeapi": ["Order", "OrderWithdraw"]}, or
+// DefaultPolicy when it is not set
+func PolicyFromEnv() (Policy, error) {
+	path := os.Getenv("LEDGER_POLICY_FILE")
+	if path == "" {
+		return DefaultPolicy, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	for producer, types := range p {
+		for _, t := range types {
+			if _, ok := events.byName[t]; !ok && t != AnyEvent {
+				return nil, fmt.Errorf("invalid policy %s: unknown event type %q for %s", path, t, producer)
+			}
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,136 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommitOfUnauthorisedEventFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	lp := CreateLedgerPointWithTransport(transport, "pmeapi")
+	lp.SetPolicy(DefaultPolicy)
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+
+	if _, err := lp.CommitSync(ctx, TradeAck{TradeNID: 7}); !errors.Is(err, ErrUnauthorised) || !NotWritten(err) {
+		t.Errorf("CommitSync(TradeAck) from pmeapi error = %v, want ErrUnauthorised, not written", err)
+	}
+	if _, err := lp.CommitSync(ctx, Order{NID: 1, InstrumentCode: "BBRI"}); err != nil {
+		t.Errorf("CommitSync(Order) from pmeapi error: %v", err)
+	}
+
+	for _, msg := range transport.Messages() {
+		if env, _ := ParseEnvelope(msg.Headers); env.EventType == "TradeAck" {
+			t.Error("unauthorised TradeAck was written to the log")
+		}
+	}
+}
+
+func TestUnauthorisedMessageIsRejected(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "eclearapi")
+	lp.SetPolicy(DefaultPolicy)
+	lp.SyncAccount(Account{Code: "YU-001"})
+
+	forged, _ := events.encode(AccountLimit{Code: "YU-001", TradeLimit: 1e9}, "pmeoms", "1")
+	var rejected *RejectedError
+	if err := lp.ApplyMessage(forged); !errors.As(err, &rejected) || !errors.Is(err, ErrUnauthorised) {
+		t.Fatalf("ApplyMessage(AccountLimit from pmeoms) error = %v, want RejectedError", err)
+	}
+	if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 0 {
+		t.Error("unauthorised AccountLimit was applied")
+	}
+
+	allowed, _ := events.encode(AccountLimit{Code: "YU-001", TradeLimit: 1e9}, "eclearapi", "2")
+	if err := lp.ApplyMessage(allowed); err != nil {
+		t.Fatalf("ApplyMessage(AccountLimit from eclearapi) error: %v", err)
+	}
+	if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 1e9 {
+		t.Errorf("TradeLimit = %v, want 1e9", account.TradeLimit)
+	}
+}
+
+func TestMessageWithoutProducer(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "eclearapi")
+	lp.SetPolicy(DefaultPolicy)
+	lp.SyncAccount(Account{Code: "YU-001"})
+
+	// An envelope with the producer header left out
+	forged, _ := events.encode(AccountLimit{Code: "YU-001", TradeLimit: 1e9}, "pmeoms", "1")
+	headers := forged.Headers[:0]
+	for _, h := range forged.Headers {
+		if h.Key != HeaderProducer {
+			headers = append(headers, h)
+		}
+	}
+	forged.Headers = headers
+	if err := lp.ApplyMessage(forged); !errors.Is(err, ErrUnauthorised) {
+		t.Fatalf("ApplyMessage(AccountLimit without producer) error = %v, want ErrUnauthorised", err)
+	}
+	if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 0 {
+		t.Error("AccountLimit without producer was applied")
+	}
+
+	// Written before the envelope existed: the event type only
+	legacy := Message{
+		Value:   forged.Value,
+		Headers: []Header{{Key: HeaderEventType, Value: []byte("AccountLimit")}},
+	}
+	if err := lp.ApplyMessage(legacy); err != nil {
+		t.Fatalf("ApplyMessage(legacy AccountLimit) error: %v", err)
+	}
+	if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 1e9 {
+		t.Errorf("TradeLimit after legacy message = %v, want 1e9", account.TradeLimit)
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	p := Policy{"pmeapi": {"Order"}, "pmectl": {AnyEvent}}
+	tests := []struct {
+		producer, eventType string
+		want                bool
+	}{
+		{"pmeapi", "Order", true},
+		{"pmeapi", "TradeAck", false},
+		{"pmeapi", "ServiceStart", true},
+		{"pmeoms", "OrderAck", false},
+		{"pmectl", "Parameter", true},
+		{"", "TradeAck", false},
+		{"", "ServiceStart", true},
+	}
+	for _, tt := range tests {
+		if got := p.Allows(tt.producer, tt.eventType); got != tt.want {
+			t.Errorf("Allows(%q, %q) = %v, want %v", tt.producer, tt.eventType, got, tt.want)
+		}
+	}
+	if !Policy(nil).Allows("pmeoms", "AccountLimit") {
+		t.Error("nil Policy refused an event")
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	if p, err := PolicyFromEnv(); err != nil || p["pmeapi"] == nil {
+		t.Fatalf("PolicyFromEnv() without file = %v, %v, want DefaultPolicy", p, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"pmeapi": ["Order", "OrderWithdraw"]}`), 0o644)
+	t.Setenv("LEDGER_POLICY_FILE", path)
+	p, err := PolicyFromEnv()
+	if err != nil {
+		t.Fatalf("PolicyFromEnv() error: %v", err)
+	}
+	if !p.Allows("pmeapi", "OrderWithdraw") || p.Allows("eclearapi", "TradeAck") {
+		t.Errorf("PolicyFromEnv() = %v", p)
+	}
+
+	os.WriteFile(path, []byte(`{"pmeapi": ["Orders"]}`), 0o644)
+	if _, err := PolicyFromEnv(); err == nil {
+		t.Error("PolicyFromEnv() with unknown event type succeeded, want error")
+	}
+}
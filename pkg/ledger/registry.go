@@ -0,0 +1,226 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Upcaster rewrites the JSON payload of an event from one schema version to
+// the next, e.g. by filling in a field that was added to the struct
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+// eventType describes how one entry struct is encoded on the log
+type eventType struct {
+	name      string
+	version   int // Schema version written by this build
+	newEvent  func() any
+	upcasters map[int]Upcaster // Keyed by the version they upgrade from
+	apply     func(lp *LedgerPoint, event any)
+	legacy    func(s LedgerPointInterface, event any)
+}
+
+// eventRegistry maps event type names to entry structs and back
+type eventRegistry struct {
+	byName map[string]*eventType
+	byType map[reflect.Type]*eventType
+}
+
+func newEventRegistry() *eventRegistry {
+	return &eventRegistry{
+		byName: make(map[string]*eventType),
+		byType: make(map[reflect.Type]*eventType),
+	}
+}
+
+// events holds every entry type the LedgerPoint knows how to encode, decode
+// and dispatch. Adding an event type only requires a line in init below.
+var events = newEventRegistry()
+
+func init() {
+	registerEvent(events, "ServiceStart", 1, (*LedgerPoint).SyncServiceStart, LedgerPointInterface.SyncServiceStart)
+	registerEvent(events, "Holiday", 1, (*LedgerPoint).SyncHoliday, LedgerPointInterface.SyncHoliday)
+	registerEvent(events, "Parameter", 1, (*LedgerPoint).SyncParameter, LedgerPointInterface.SyncParameter)
+	registerEvent(events, "SessionTime", 1, (*LedgerPoint).SyncSessionTime, LedgerPointInterface.SyncSessionTime)
+	registerEvent(events, "Instrument", 1, (*LedgerPoint).SyncInstrument, LedgerPointInterface.SyncInstrument)
+	registerEvent(events, "Participant", 1, (*LedgerPoint).SyncParticipant, LedgerPointInterface.SyncParticipant)
+	registerEvent(events, "Account", 1, (*LedgerPoint).SyncAccount, LedgerPointInterface.SyncAccount)
+	registerEvent(events, "AccountLimit", 1, (*LedgerPoint).SyncAccountLimit, LedgerPointInterface.SyncAccountLimit)
+	registerEvent(events, "Order", 1, (*LedgerPoint).SyncOrder, LedgerPointInterface.SyncOrder)
+	registerEvent(events, "OrderAck", 2, (*LedgerPoint).SyncOrderAck, LedgerPointInterface.SyncOrderAck)
+	registerEvent(events, "OrderNak", 1, (*LedgerPoint).SyncOrderNak, LedgerPointInterface.SyncOrderNak)
+	registerEvent(events, "OrderPending", 1, (*LedgerPoint).SyncOrderPending, LedgerPointInterface.SyncOrderPending)
+	registerEvent(events, "OrderWithdraw", 1, (*LedgerPoint).SyncOrderWithdraw, LedgerPointInterface.SyncOrderWithdraw)
+	registerEvent(events, "OrderWithdrawAck", 1, (*LedgerPoint).SyncOrderWithdrawAck, LedgerPointInterface.SyncOrderWithdrawAck)
+	registerEvent(events, "OrderWithdrawNak", 1, (*LedgerPoint).SyncOrderWithdrawNak, LedgerPointInterface.SyncOrderWithdrawNak)
+	registerEvent(events, "Trade", 1, (*LedgerPoint).SyncTrade, LedgerPointInterface.SyncTrade)
+	registerEvent(events, "TradeWait", 1, (*LedgerPoint).SyncTradeWait, LedgerPointInterface.SyncTradeWait)
+	registerEvent(events, "TradeAck", 1, (*LedgerPoint).SyncTradeAck, LedgerPointInterface.SyncTradeAck)
+	registerEvent(events, "TradeNak", 1, (*LedgerPoint).SyncTradeNak, LedgerPointInterface.SyncTradeNak)
+	registerEvent(events, "TradeReimburse", 1, (*LedgerPoint).SyncTradeReimburse, LedgerPointInterface.SyncTradeReimburse)
+	registerEvent(events, "Contract", 1, (*LedgerPoint).SyncContract, LedgerPointInterface.SyncContract)
+	registerEvent(events, "Sod", 1, (*LedgerPoint).SyncSod, LedgerPointInterface.SyncSod)
+	registerEvent(events, "Eod", 1, (*LedgerPoint).SyncEod, LedgerPointInterface.SyncEod)
+
+	// Newer event types are only delivered through Subscribe
+	registerEvent(events, "RiskRule", 1, (*LedgerPoint).SyncRiskRule, nil)
+	registerEvent(events, "ConcentrationLimit", 1, (*LedgerPoint).SyncConcentrationLimit, nil)
+
+	// OrderAck v1 predates limit reservation: those orders hold none
+	events.registerUpcaster("OrderAck", 1, func(data json.RawMessage) (json.RawMessage, error) {
+		return data, nil
+	})
+}
+
+// registerEvent adds entry type T under name with its current schema
+// version. apply updates the LedgerPoint state when the event is read back
+// and legacy forwards it to subscribers implementing LedgerPointInterface.
+func registerEvent[T any](r *eventRegistry, name string, version int,
+	apply func(*LedgerPoint, T), legacy func(LedgerPointInterface, T)) {
+	et := &eventType{
+		name:      name,
+		version:   version,
+		newEvent:  func() any { return new(T) },
+		upcasters: make(map[int]Upcaster),
+	}
+	if apply != nil {
+		et.apply = func(lp *LedgerPoint, event any) { apply(lp, event.(T)) }
+	}
+	if legacy != nil {
+		et.legacy = func(s LedgerPointInterface, event any) { legacy(s, event.(T)) }
+	}
+	r.byName[name] = et
+	r.byType[reflect.TypeOf((*T)(nil)).Elem()] = et
+}
+
+// RegisterUpcaster installs fn to upgrade eventType payloads written with
+// schema version fromVersion to fromVersion+1. When an entry struct changes,
+// bump its version in the registry and register an upcaster for the old one
+// so historical topics keep replaying.
+func RegisterUpcaster(eventType string, fromVersion int, fn Upcaster) {
+	events.registerUpcaster(eventType, fromVersion, fn)
+}
+
+func (r *eventRegistry) registerUpcaster(eventType string, fromVersion int, fn Upcaster) {
+	et, ok := r.byName[eventType]
+	if !ok {
+		panic(fmt.Sprintf("ledger: upcaster for unknown event type %q", eventType))
+	}
+	et.upcasters[fromVersion] = fn
+}
+
+// EventTypes returns the names of all registered event types
+func EventTypes() []string {
+	names := make([]string, 0, len(events.byName))
+	for name := range events.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEvent unmarshals a JSON payload into the entry struct registered as
+// eventType, e.g. to publish hand-written events. The result is a value
+// ready to commit (Order, not *Order).
+func NewEvent(eventType string, payload json.RawMessage) (any, error) {
+	et, ok := events.byName[eventType]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	ptr := et.newEvent()
+	if err := json.Unmarshal(payload, ptr); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", eventType, err)
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), nil
+}
+
+// name returns the registered name of event's type, or its Go type for
+// unknown events
+func (r *eventRegistry) name(event any) string {
+	if et, ok := r.byType[reflect.TypeOf(event)]; ok {
+		return et.name
+	}
+	return fmt.Sprintf("%T", event)
+}
+
+// encode marshals event and wraps it in an envelope
+func (r *eventRegistry) encode(event any, producer string, eventID string) (Message, error) {
+	et, ok := r.byType[reflect.TypeOf(event)]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown event type %T", event)
+	}
+
+	val, err := json.Marshal(event)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal %s: %w", et.name, err)
+	}
+
+	env := Envelope{
+		EventType:     et.name,
+		SchemaVersion: et.version,
+		Producer:      producer,
+		EventID:       eventID,
+	}
+	return Message{
+		Key:     []byte("ledgerpoint"),
+		Value:   val,
+		Headers: env.Headers(),
+	}, nil
+}
+
+// decode parses the envelope, upcasts the payload to the current schema
+// version and unmarshals it. The returned event is a value (e.g. Order, not
+// *Order) with its Timestamp set to the log time of the message.
+func (r *eventRegistry) decode(msg Message) (Envelope, any, error) {
+	env, err := ParseEnvelope(msg.Headers)
+	if err != nil {
+		return env, nil, err
+	}
+
+	et, ok := r.byName[env.EventType]
+	if !ok {
+		return env, nil, fmt.Errorf("unknown event type %q", env.EventType)
+	}
+	if env.SchemaVersion > et.version {
+		return env, nil, fmt.Errorf("%s schema version %d is newer than supported version %d",
+			env.EventType, env.SchemaVersion, et.version)
+	}
+
+	data := json.RawMessage(msg.Value)
+	for v := env.SchemaVersion; v < et.version; v++ {
+		upcast, ok := et.upcasters[v]
+		if !ok {
+			return env, nil, fmt.Errorf("no upcaster for %s version %d", env.EventType, v)
+		}
+		if data, err = upcast(data); err != nil {
+			return env, nil, fmt.Errorf("failed to upcast %s from version %d: %w", env.EventType, v, err)
+		}
+	}
+
+	ptr := et.newEvent()
+	if err := json.Unmarshal(data, ptr); err != nil {
+		return env, nil, fmt.Errorf("failed to decode %s: %w", env.EventType, err)
+	}
+
+	setTimestamp(ptr, msg.Time)
+	return env, reflect.ValueOf(ptr).Elem().Interface(), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// setTimestamp sets the Timestamp field of the struct ptr points to
+func setTimestamp(ptr any, ts time.Time) {
+	f := reflect.ValueOf(ptr).Elem().FieldByName("Timestamp")
+	if f.IsValid() && f.CanSet() && f.Type() == timeType {
+		f.Set(reflect.ValueOf(ts))
+	}
+}
+
+// apply hands a decoded event to the LedgerPoint method registered for it
+func (r *eventRegistry) apply(lp *LedgerPoint, event any) {
+	if et, ok := r.byType[reflect.TypeOf(event)]; ok && et.apply != nil {
+		et.apply(lp, event)
+	}
+}
@@ -0,0 +1,137 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDecodeLegacyMessage(t *testing.T) {
+	// Messages written before the envelope only carry event-type
+	msg := Message{
+		Value:   []byte(`{"order_nid":42}`),
+		Headers: []Header{{Key: HeaderEventType, Value: []byte("OrderAck")}},
+		Time:    time.Date(2025, 11, 24, 9, 0, 0, 0, time.UTC),
+	}
+
+	env, event, err := events.decode(msg)
+	if err != nil {
+		t.Fatalf("decode() error: %v", err)
+	}
+	if env.SchemaVersion != 1 {
+		t.Errorf("SchemaVersion = %d, want 1", env.SchemaVersion)
+	}
+	ack, ok := event.(OrderAck)
+	if !ok {
+		t.Fatalf("decode() returned %T, want OrderAck", event)
+	}
+	if ack.OrderNID != 42 || !ack.Timestamp.Equal(msg.Time) {
+		t.Errorf("decode() = %+v", ack)
+	}
+}
+
+func TestDecodeUpcastsOldVersions(t *testing.T) {
+	type orderV3 struct {
+		Timestamp time.Time `json:"timestamp"`
+		NID       int       `json:"nid"`
+		Side      string    `json:"side"`
+		Venue     string    `json:"venue"`
+	}
+
+	r := newEventRegistry()
+	registerEvent[orderV3](r, "Order", 3, nil, nil)
+	r.registerUpcaster("Order", 1, func(data json.RawMessage) (json.RawMessage, error) {
+		var m map[string]any
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		m["side"] = "BORR"
+		return json.Marshal(m)
+	})
+	r.registerUpcaster("Order", 2, func(data json.RawMessage) (json.RawMessage, error) {
+		var m map[string]any
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		m["venue"] = "PME"
+		return json.Marshal(m)
+	})
+
+	v1 := Message{Value: []byte(`{"nid":7}`), Headers: Envelope{EventType: "Order", SchemaVersion: 1}.Headers()}
+	_, event, err := r.decode(v1)
+	if err != nil {
+		t.Fatalf("decode() error: %v", err)
+	}
+	if got := event.(orderV3); got.NID != 7 || got.Side != "BORR" || got.Venue != "PME" {
+		t.Errorf("decode() = %+v", got)
+	}
+
+	v4 := Message{Value: []byte(`{"nid":7}`), Headers: Envelope{EventType: "Order", SchemaVersion: 4}.Headers()}
+	if _, _, err := r.decode(v4); err == nil {
+		t.Error("decode() of a newer schema version returned no error")
+	}
+}
+
+func TestUndecodableMessagesAreDeadLettered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	transport.Append(ctx,
+		Message{Value: []byte(`{}`)},
+		Message{Value: []byte(`{}`), Headers: []Header{{Key: HeaderEventType, Value: []byte("Unknown")}}},
+		Message{Value: []byte(`not json`), Headers: []Header{{Key: HeaderEventType, Value: []byte("Order")}}},
+	)
+
+	dead := make(chan int64, 3)
+	lp := CreateLedgerPointWithTransport(transport, "test")
+	lp.SetDeadLetterHandler(func(msg Message, err error) {
+		dead <- msg.Offset
+	})
+	lp.Start(nil, ctx)
+
+	for want := int64(0); want < 3; want++ {
+		select {
+		case got := <-dead:
+			if got != want {
+				t.Errorf("dead-lettered offset %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message at offset %d was not dead-lettered", want)
+		}
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	event, err := NewEvent("OrderAck", []byte(`{"order_nid": 7}`))
+	if err != nil {
+		t.Fatalf("NewEvent() error: %v", err)
+	}
+	if ack, ok := event.(OrderAck); !ok || ack.OrderNID != 7 {
+		t.Errorf("NewEvent() = %#v, want OrderAck{OrderNID: 7}", event)
+	}
+
+	if _, err := NewEvent("OrderAcknowledged", []byte(`{}`)); err == nil {
+		t.Error("NewEvent() of an unknown type returned no error")
+	}
+	if len(EventTypes()) != len(events.byName) {
+		t.Errorf("EventTypes() = %v", EventTypes())
+	}
+}
+
+func TestDecodeMessageLeavesStateAlone(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	msg, _ := events.encode(Account{Code: "YU-001"}, "eclearapi", "1")
+
+	env, event, err := lp.DecodeMessage(msg)
+	if err != nil {
+		t.Fatalf("DecodeMessage() error: %v", err)
+	}
+	if env.Producer != "eclearapi" || event.(Account).Code != "YU-001" {
+		t.Errorf("DecodeMessage() = %+v, %+v", env, event)
+	}
+	if _, ok := lp.GetAccount("YU-001"); ok {
+		t.Error("DecodeMessage() applied the event")
+	}
+}
@@ -1,17 +1,20 @@
 package risk
 
 import (
+	"time"
+
 	"pmeonline/pkg/ledger"
 )
 
 // Calculator handles fee and value calculations
 type Calculator struct {
 	ledger *ledger.LedgerPoint
+	clock  ledger.Clock
 }
 
 // NewCalculator creates a new calculator instance
 func NewCalculator(l *ledger.LedgerPoint) *Calculator {
-	return &Calculator{ledger: l}
+	return &Calculator{ledger: l, clock: l.Clock()}
 }
 
 // Static fee rates from design document F.3
@@ -82,6 +85,22 @@ func (c *Calculator) CalculateBorrowingAccumulatedFee(marketPrice, quantity floa
 	return dailyFee * float64(daysPassed)
 }
 
+// DaysPassed returns the number of calendar days from since to today
+// according to the ledger's clock, for the accumulated fee calculations
+func (c *Calculator) DaysPassed(since time.Time) int {
+	now := c.clock.Now()
+	loc := now.Location()
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	since = since.In(loc)
+	start := time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, loc)
+
+	if start.After(today) {
+		return 0
+	}
+	return int(today.Sub(start).Hours()/24 + 0.5)
+}
+
 // CalculateLendingDailyFee calculates the daily lending revenue
 // Formula: FeeLendDaily = MarketPrice × Quantity × LendingFeeRate / 365
 func (c *Calculator) CalculateLendingDailyFee(marketPrice, quantity float64) float64 {
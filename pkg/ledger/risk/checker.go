@@ -1,23 +1,27 @@
 package risk
 
 import (
-	"log"
+	"log/slog"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/logging"
 )
 
 // Checker monitors eligibility changes for instruments and participants
 type Checker struct {
 	ledger                  *ledger.LedgerPoint
+	logger                  *slog.Logger
 	onInstrumentIneligible  func(instrumentCode string)
 	onInstrumentEligible    func(instrumentCode string)
 	onParticipantIneligible func(participantCode string, side string)
 }
 
-// NewChecker creates a new eligibility checker
-func NewChecker(l *ledger.LedgerPoint) *Checker {
+// NewChecker creates a new eligibility checker logging eligibility changes
+// to logger
+func NewChecker(l *ledger.LedgerPoint, logger *slog.Logger) *Checker {
 	return &Checker{
 		ledger: l,
+		logger: logger,
 	}
 }
 
@@ -78,13 +82,13 @@ func (c *Checker) MonitorInstrument(prevStatus bool, instrument ledger.Instrumen
 	if prevStatus != instrument.Status {
 		if instrument.Status {
 			// Instrument became eligible
-			log.Printf("✅ Instrument %s is now ELIGIBLE", instrument.Code)
+			c.logger.Info("Instrument is now eligible", logging.Instrument(instrument.Code))
 			if c.onInstrumentEligible != nil {
 				c.onInstrumentEligible(instrument.Code)
 			}
 		} else {
 			// Instrument became ineligible
-			log.Printf("⚠️  Instrument %s is now INELIGIBLE", instrument.Code)
+			c.logger.Warn("Instrument is now ineligible", logging.Instrument(instrument.Code))
 			if c.onInstrumentIneligible != nil {
 				c.onInstrumentIneligible(instrument.Code)
 			}
@@ -97,24 +101,24 @@ func (c *Checker) MonitorParticipant(prevBorrEligibility, prevLendEligibility bo
 	// Check if borrowing eligibility changed
 	if prevBorrEligibility != participant.BorrEligibility {
 		if !participant.BorrEligibility {
-			log.Printf("⚠️  Participant %s is no longer eligible for BORROWING", participant.Code)
+			c.logger.Warn("Participant is no longer eligible for borrowing", logging.Participant(participant.Code))
 			if c.onParticipantIneligible != nil {
 				c.onParticipantIneligible(participant.Code, "BORR")
 			}
 		} else {
-			log.Printf("✅ Participant %s is now eligible for BORROWING", participant.Code)
+			c.logger.Info("Participant is now eligible for borrowing", logging.Participant(participant.Code))
 		}
 	}
 
 	// Check if lending eligibility changed
 	if prevLendEligibility != participant.LendEligibility {
 		if !participant.LendEligibility {
-			log.Printf("⚠️  Participant %s is no longer eligible for LENDING", participant.Code)
+			c.logger.Warn("Participant is no longer eligible for lending", logging.Participant(participant.Code))
 			if c.onParticipantIneligible != nil {
 				c.onParticipantIneligible(participant.Code, "LEND")
 			}
 		} else {
-			log.Printf("✅ Participant %s is now eligible for LENDING", participant.Code)
+			c.logger.Info("Participant is now eligible for lending", logging.Participant(participant.Code))
 		}
 	}
 }
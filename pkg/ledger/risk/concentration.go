@@ -0,0 +1,115 @@
+package risk
+
+import (
+	"fmt"
+	"sort"
+
+	"pmeonline/pkg/ledger"
+)
+
+// validateConcentration checks a BORR order against every ConcentrationLimit
+// whose scope covers it. The order counts on top of what the scope has
+// outstanding in the ledger and the orders this OMS has acknowledged but not
+// yet seen applied; the order an amendment replaces no longer counts. An
+// order the ledger already counts, e.g. a pending order opened at SOD, is
+// not added again.
+func (v *Validator) validateConcentration(order ledger.OrderEntity) error {
+	if order.Side != "BORR" {
+		return nil
+	}
+
+	var limits []ledger.ConcentrationLimitEntity
+	v.ledger.ForEachConcentrationLimit(func(l ledger.ConcentrationLimitEntity) bool {
+		if l.Scope().Matches(order.ParticipantCode, order.InstrumentCode, v.sidOf(order.AccountCode)) {
+			limits = append(limits, l)
+		}
+		return true
+	})
+	if len(limits) == 0 {
+		return nil
+	}
+	sort.Slice(limits, func(i, j int) bool { return limits[i].Scope().String() < limits[j].Scope().String() })
+
+	// Orders on top of the ledger, with the quantity they add (or remove)
+	type change struct {
+		order    ledger.OrderEntity
+		quantity float64
+	}
+	var changes []change
+	if current, exists := v.ledger.GetOrder(order.NID); !exists ||
+		(current.State != "O" && current.State != "P" && current.State != "G") {
+		changes = append(changes, change{order: order, quantity: order.Quantity})
+	}
+	for _, held := range v.unappliedHolds(order.NID) {
+		if held.Side == "BORR" {
+			changes = append(changes, change{order: held, quantity: held.Quantity})
+		}
+	}
+	if prev, exists := v.ledger.GetOrder(order.PrevNID); exists && prev.Side == "BORR" &&
+		(prev.State == "O" || prev.State == "P" || prev.State == "G") {
+		changes = append(changes, change{order: prev, quantity: -(prev.Quantity - prev.DoneQuantity)})
+	}
+
+	for _, limit := range limits {
+		scope := limit.Scope()
+		outstanding, err := v.ledger.GetBorrowOutstanding(scope)
+		if err != nil {
+			// Rejected rather than checked against a partial count
+			return &ValidationError{
+				Field:   "Concentration",
+				Message: fmt.Sprintf("limit on %s can't be checked by this OMS: %v", scope, err),
+			}
+		}
+		quantity, value := outstanding.Quantity, outstanding.Value
+		for _, c := range changes {
+			if scope.Matches(c.order.ParticipantCode, c.order.InstrumentCode, v.sidOf(c.order.AccountCode)) {
+				quantity += c.quantity
+				value += c.quantity * c.order.MarketPrice
+			}
+		}
+
+		if limit.MaxQuantity > 0 && quantity > limit.MaxQuantity {
+			return &ValidationError{
+				Field: "Concentration",
+				Message: fmt.Sprintf("borrowing of %s would reach %.0f shares, above the limit of %.0f",
+					scope, quantity, limit.MaxQuantity),
+			}
+		}
+		if limit.MaxValue > 0 && value > limit.MaxValue {
+			return &ValidationError{
+				Field: "Concentration",
+				Message: fmt.Sprintf("borrowing of %s would reach a value of %.2f, above the limit of %.2f",
+					scope, value, limit.MaxValue),
+			}
+		}
+	}
+
+	return nil
+}
+
+// sidOf returns the SID of an account, "" when unknown
+func (v *Validator) sidOf(accountCode string) string {
+	account, _ := v.ledger.GetAccount(accountCode)
+	return account.SID
+}
+
+// unappliedHolds returns the held orders other than exclude whose OrderAck
+// hasn't been applied yet, so the ledger doesn't count them
+func (v *Validator) unappliedHolds(exclude int) []ledger.OrderEntity {
+	v.heldMu.Lock()
+	var orders []ledger.OrderEntity
+	for nid, h := range v.held {
+		if nid != exclude {
+			orders = append(orders, h.order)
+		}
+	}
+	v.heldMu.Unlock()
+
+	unapplied := orders[:0]
+	for _, o := range orders {
+		if current, exists := v.ledger.GetOrder(o.NID); !exists || current.State == "S" {
+			unapplied = append(unapplied, o)
+		}
+	}
+	return unapplied
+}
@@ -0,0 +1,73 @@
+package risk
+
+import (
+	"testing"
+
+	"pmeonline/pkg/ledger"
+)
+
+func TestConcentrationLimits(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(ledger.Account{Code: "YU-001", SID: "IDD1", ParticipantCode: "YU"})
+	lp.SyncAccount(ledger.Account{Code: "AK-001", SID: "IDD2", ParticipantCode: "AK"})
+	v := NewValidator(lp)
+
+	borrow := func(nid int, account string, participant string, qty float64) ledger.OrderEntity {
+		return ledger.OrderEntity{NID: nid, AccountCode: account, ParticipantCode: participant,
+			InstrumentCode: "BBRI", Side: "BORR", Quantity: qty, MarketPrice: 100}
+	}
+	open := func(o ledger.OrderEntity) {
+		lp.SyncOrder(ledger.Order{NID: o.NID, PrevNID: o.PrevNID, AccountCode: o.AccountCode, ParticipantCode: o.ParticipantCode,
+			InstrumentCode: o.InstrumentCode, Side: o.Side, Quantity: o.Quantity, MarketPrice: o.MarketPrice})
+		lp.SyncOrderAck(ledger.OrderAck{OrderNID: o.NID})
+	}
+
+	lp.SyncConcentrationLimit(ledger.ConcentrationLimit{ParticipantCode: "YU", InstrumentCode: "BBRI", MaxQuantity: 1000})
+	lp.SyncConcentrationLimit(ledger.ConcentrationLimit{InstrumentCode: "BBRI", MaxValue: 150000})
+	lp.SyncConcentrationLimit(ledger.ConcentrationLimit{SID: "IDD2", MaxQuantity: 300})
+
+	open(borrow(1, "YU-001", "YU", 600))
+	if err := v.validateConcentration(borrow(2, "YU-001", "YU", 400)); err != nil {
+		t.Errorf("order up to the participant limit rejected: %v", err)
+	}
+	if err := v.validateConcentration(borrow(2, "YU-001", "YU", 500)); err == nil {
+		t.Error("order above the participant limit accepted")
+	}
+
+	// An order the ledger counts already, like a pending one at SOD, is not
+	// counted twice
+	if err := v.validateConcentration(borrow(1, "YU-001", "YU", 600)); err != nil {
+		t.Errorf("open order revalidated against its own quantity: %v", err)
+	}
+
+	// An amendment replaces the quantity of the order it amends
+	amendment := borrow(2, "YU-001", "YU", 900)
+	amendment.PrevNID = 1
+	if err := v.validateConcentration(amendment); err != nil {
+		t.Errorf("amendment within the participant limit rejected: %v", err)
+	}
+
+	// Acknowledged orders count before their ack is applied
+	held := borrow(3, "AK-001", "AK", 200)
+	v.Hold(held, v.RequiredLimit(held))
+	if err := v.validateConcentration(borrow(4, "AK-001", "AK", 200)); err == nil {
+		t.Error("order above the SID limit accepted next to a held order")
+	}
+	open(held)
+	v.Release(3)
+
+	// Market-wide: 600 + 200 open, 800 more would be worth 160000
+	err := v.validateConcentration(borrow(5, "XX-001", "XX", 800))
+	if ve, ok := err.(*ValidationError); !ok || ve.Field != "Concentration" {
+		t.Errorf("order above the market-wide limit: error %v, want a Concentration ValidationError", err)
+	}
+	if err := v.validateConcentration(borrow(5, "XX-001", "XX", 700)); err != nil {
+		t.Errorf("order within the market-wide limit rejected: %v", err)
+	}
+
+	lend := borrow(6, "YU-001", "YU", 5000)
+	lend.Side = "LEND"
+	if err := v.validateConcentration(lend); err != nil {
+		t.Errorf("LEND order checked against concentration limits: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package risk
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pmeonline/pkg/ledger"
+)
+
+// Pre-trade rules. ValidateOrder runs every enabled rule in priority order
+// (lowest first) and reports all that fail. A rule's enabled flag, priority
+// and parameters come from the RiskRule event of the same name, which KPEI
+// changes through the eClear API; a rule without one runs with its
+// defaults below.
+
+// Names of the rules of NewValidator
+const (
+	RuleBasicFields           = "basic-fields"
+	RuleSession               = "session"
+	RuleAccount               = "account"
+	RuleInstrument            = "instrument"
+	RuleParticipant           = "participant"
+	RuleDates                 = "dates"
+	RuleQuantity              = "quantity"
+	RuleInstrumentMaxQuantity = "instrument-max-quantity"
+	RuleBlockedSIDs           = "blocked-sids"
+	RuleMaxOpenOrders         = "max-open-orders"
+	RuleConcentration         = "concentration"
+	RuleTradeLimit            = "trade-limit"
+	RulePoolLimit             = "pool-limit"
+)
+
+// Rule is a pre-trade check. Check returns nil when the order passes,
+// preferably a *ValidationError otherwise.
+type Rule interface {
+	Name() string
+	Description() string
+	Check(order ledger.OrderEntity, params Params) error
+}
+
+// NewRule makes a Rule of a check function
+func NewRule(name string, description string, check func(ledger.OrderEntity, Params) error) Rule {
+	return funcRule{name: name, description: description, check: check}
+}
+
+type funcRule struct {
+	name        string
+	description string
+	check       func(ledger.OrderEntity, Params) error
+}
+
+func (r funcRule) Name() string        { return r.name }
+func (r funcRule) Description() string { return r.description }
+
+func (r funcRule) Check(order ledger.OrderEntity, params Params) error {
+	return r.check(order, params)
+}
+
+// Params are the parameters of a rule, as set in its RiskRule event
+type Params map[string]string
+
+// Float returns the parameter key as a number; ok is false when it isn't set
+func (p Params) Float(key string) (value float64, ok bool, err error) {
+	s, ok := p[key]
+	if !ok || strings.TrimSpace(s) == "" {
+		return 0, false, nil
+	}
+	value, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parameter %s: %q is not a number", key, s)
+	}
+	return value, true, nil
+}
+
+// List returns the parameter key split on commas, without empty items
+func (p Params) List(key string) []string {
+	var items []string
+	for _, item := range strings.Split(p[key], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// RuleConfig is the effective configuration of a rule
+type RuleConfig struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Priority    int    `json:"priority"`
+	Params      Params `json:"params"`
+	Configured  bool   `json:"configured"` // Set by a RiskRule event rather than the defaults
+}
+
+// registeredRule is a rule with its default priority
+type registeredRule struct {
+	rule     Rule
+	priority int
+}
+
+// RuleFailure is a rule that failed on an order
+type RuleFailure struct {
+	Rule string
+	Err  *ValidationError
+}
+
+// ValidationResult lists the failed rules of an order, in the order they ran
+type ValidationResult struct {
+	Failures []RuleFailure
+}
+
+func (r *ValidationResult) Error() string {
+	messages := make([]string, len(r.Failures))
+	for i, f := range r.Failures {
+		messages[i] = f.Err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap gives errors.As access to the ValidationError of each failure
+func (r *ValidationResult) Unwrap() []error {
+	errs := make([]error, len(r.Failures))
+	for i, f := range r.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// AddRule adds a rule running at priority unless a RiskRule event says
+// otherwise. A rule with the name of an existing one replaces it.
+func (v *Validator) AddRule(rule Rule, priority int) {
+	v.rulesMu.Lock()
+	defer v.rulesMu.Unlock()
+	for i, r := range v.rules {
+		if r.rule.Name() == rule.Name() {
+			v.rules[i] = registeredRule{rule: rule, priority: priority}
+			return
+		}
+	}
+	v.rules = append(v.rules, registeredRule{rule: rule, priority: priority})
+}
+
+// HasRule reports whether the validator has a rule called name
+func (v *Validator) HasRule(name string) bool {
+	v.rulesMu.RLock()
+	defer v.rulesMu.RUnlock()
+	for _, r := range v.rules {
+		if r.rule.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules returns the effective configuration of every rule in the order they
+// run
+func (v *Validator) Rules() []RuleConfig {
+	configs, _ := v.resolveRules()
+	return configs
+}
+
+// resolveRules applies the RiskRule events to the registered rules and
+// sorts them by priority, keeping the registration order on ties
+func (v *Validator) resolveRules() ([]RuleConfig, []Rule) {
+	v.rulesMu.RLock()
+	registered := make([]registeredRule, len(v.rules))
+	copy(registered, v.rules)
+	v.rulesMu.RUnlock()
+
+	configs := make([]RuleConfig, len(registered))
+	for i, r := range registered {
+		config := RuleConfig{
+			Name:        r.rule.Name(),
+			Description: r.rule.Description(),
+			Enabled:     true,
+			Priority:    r.priority,
+			Params:      Params{},
+		}
+		if entity, exists := v.ledger.GetRiskRule(config.Name); exists {
+			config.Enabled = entity.Enabled
+			config.Priority = entity.Priority
+			config.Params = Params(entity.Params)
+			config.Configured = true
+			if entity.Description != "" {
+				config.Description = entity.Description
+			}
+		}
+		configs[i] = config
+	}
+
+	idx := make([]int, len(registered))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return configs[idx[a]].Priority < configs[idx[b]].Priority })
+
+	sortedConfigs := make([]RuleConfig, len(idx))
+	sortedRules := make([]Rule, len(idx))
+	for i, j := range idx {
+		sortedConfigs[i] = configs[j]
+		sortedRules[i] = registered[j].rule
+	}
+	return sortedConfigs, sortedRules
+}
+
+// builtinRules are the rules of NewValidator with their default priorities
+func (v *Validator) builtinRules() []registeredRule {
+	return []registeredRule{
+		{NewRule(RuleBasicFields, "Required fields are present and side is BORR or LEND",
+			func(o ledger.OrderEntity, _ Params) error { return v.validateBasicFields(o) }), 10},
+		{NewRule(RuleSession, "New orders arrive during a trading session on a business day",
+			func(o ledger.OrderEntity, _ Params) error { return v.validateSession(o) }), 20},
+		{NewRule(RuleAccount, "Account exists and belongs to the participant",
+			func(o ledger.OrderEntity, _ Params) error { return v.validateAccount(o) }), 30},
+		{NewRule(RuleInstrument, "Instrument exists and is eligible for SBL",
+			func(o ledger.OrderEntity, _ Params) error { return v.validateInstrument(o) }), 40},
+		{NewRule(RuleParticipant, "Participant exists and is eligible for the side",
+			func(o ledger.OrderEntity, _ Params) error { return v.validateParticipant(o) }), 50},
+		{NewRule(RuleDates, "Settlement and reimbursement dates of BORR orders",
+			func(o ledger.OrderEntity, _ Params) error {
+				if o.Side != "BORR" {
+					return nil
+				}
+				return v.validateDates(o)
+			}), 60},
+		{NewRule(RuleQuantity, "Quantity is a multiple of the denomination and within the maximum",
+			func(o ledger.OrderEntity, _ Params) error { return v.validateQuantity(o) }), 70},
+		{NewRule(RuleInstrumentMaxQuantity, "Maximum quantity per instrument; params: <instrument code>=<shares>",
+			v.checkInstrumentMaxQuantity), 80},
+		{NewRule(RuleBlockedSIDs, "Rejects orders of blocked SIDs; params: sids=<SID>,<SID>,...",
+			v.checkBlockedSIDs), 90},
+		{NewRule(RuleMaxOpenOrders, "Maximum open and pending orders per account; params: max=<orders>",
+			v.checkMaxOpenOrders), 100},
+		{NewRule(RuleConcentration, "BORR orders stay within the ConcentrationLimits of their participant, instrument and SID",
+			func(o ledger.OrderEntity, _ Params) error { return v.validateConcentration(o) }), 105},
+		{NewRule(RuleTradeLimit, "BORR orders fit in the remaining trading limit",
+			func(o ledger.OrderEntity, _ Params) error {
+				if o.Side != "BORR" || !v.accountExists(o) {
+					return nil
+				}
+				return v.validateBorrowOrder(o)
+			}), 110},
+		{NewRule(RulePoolLimit, "LEND orders fit in the remaining pool limit",
+			func(o ledger.OrderEntity, _ Params) error {
+				if o.Side != "LEND" || !v.accountExists(o) {
+					return nil
+				}
+				return v.validateLendOrder(o)
+			}), 120},
+	}
+}
+
+// accountExists keeps the limit rules from repeating a failure of the
+// account rule
+func (v *Validator) accountExists(order ledger.OrderEntity) bool {
+	_, exists := v.ledger.GetAccount(order.AccountCode)
+	return exists
+}
+
+// checkInstrumentMaxQuantity applies the maximum set for the instrument of
+// the order, if any
+func (v *Validator) checkInstrumentMaxQuantity(order ledger.OrderEntity, params Params) error {
+	max, ok, err := params.Float(order.InstrumentCode)
+	if err != nil || !ok {
+		return err
+	}
+	if order.Quantity > max {
+		return &ValidationError{
+			Field: "Quantity",
+			Message: fmt.Sprintf("exceeds maximum allowed quantity of %.0f shares for %s",
+				max, order.InstrumentCode),
+		}
+	}
+	return nil
+}
+
+// checkBlockedSIDs rejects orders of accounts whose SID is listed
+func (v *Validator) checkBlockedSIDs(order ledger.OrderEntity, params Params) error {
+	account, exists := v.ledger.GetAccount(order.AccountCode)
+	if !exists || account.SID == "" {
+		return nil
+	}
+	for _, sid := range params.List("sids") {
+		if sid == account.SID {
+			return &ValidationError{
+				Field:   "AccountCode",
+				Message: fmt.Sprintf("SID %s of account %s is blocked", account.SID, order.AccountCode),
+			}
+		}
+	}
+	return nil
+}
+
+// checkMaxOpenOrders counts the open, partially matched and pending orders
+// of the account, plus those acknowledged but not yet applied. The order an
+// amendment replaces doesn't count. An OMS that only consumes some
+// partitions doesn't see the account's orders in other instruments, so it
+// rejects the order instead of counting part of them.
+func (v *Validator) checkMaxOpenOrders(order ledger.OrderEntity, params Params) error {
+	max, ok, err := params.Float("max")
+	if err != nil || !ok {
+		return err
+	}
+	if !v.ledger.ConsumesAllPartitions() {
+		return &ValidationError{
+			Field:   "AccountCode",
+			Message: fmt.Sprintf("open orders can't be counted by this OMS: %v", ledger.ErrPartialLedger),
+		}
+	}
+
+	counted := make(map[int]bool)
+	for _, o := range v.ledger.ListOrdersByAccount(order.AccountCode) {
+		if o.NID == order.NID || o.NID == order.PrevNID {
+			continue
+		}
+		if o.State == "O" || o.State == "P" || o.State == "G" {
+			counted[o.NID] = true
+		}
+	}
+	v.heldMu.Lock()
+	for nid, h := range v.held {
+		if h.order.AccountCode == order.AccountCode && nid != order.NID && nid != order.PrevNID {
+			counted[nid] = true
+		}
+	}
+	v.heldMu.Unlock()
+
+	if float64(len(counted)) >= max {
+		return &ValidationError{
+			Field:   "AccountCode",
+			Message: fmt.Sprintf("account %s already has %d open orders (maximum %.0f)", order.AccountCode, len(counted), max),
+		}
+	}
+	return nil
+}
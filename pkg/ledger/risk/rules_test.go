@@ -0,0 +1,145 @@
+package risk
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+// newRulesLedger returns a ledger where a 100 share LEND order of AK-001 in
+// BBRI passes every rule
+func newRulesLedger(t *testing.T) *ledger.LedgerPoint {
+	t.Helper()
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	lp.SetClock(ledger.NewFakeClock(time.Date(2025, 12, 23, 10, 0, 0, 0, time.Local)))
+	lp.SyncParameter(ledger.Parameter{MaxQuantity: 10000, DenominationLimit: 100, BorrowMaxOpenDay: 30})
+	lp.SyncParticipant(ledger.Participant{Code: "AK", BorrEligibility: true, LendEligibility: true})
+	lp.SyncInstrument(ledger.Instrument{Code: "BBRI", Status: true})
+	lp.SyncAccount(ledger.Account{Code: "AK-001", SID: "IDD1234", ParticipantCode: "AK"})
+	lp.SyncAccountLimit(ledger.AccountLimit{Code: "AK-001", PoolLimit: 1e6})
+	return lp
+}
+
+func lendOrder(nid int, quantity float64) ledger.OrderEntity {
+	return ledger.OrderEntity{
+		NID: nid, State: "S", AccountCode: "AK-001", ParticipantCode: "AK", InstrumentCode: "BBRI",
+		Side: "LEND", Quantity: quantity, MarketPrice: 100,
+	}
+}
+
+func failedRules(err error) []string {
+	var result *ValidationResult
+	if !errors.As(err, &result) {
+		return nil
+	}
+	var names []string
+	for _, f := range result.Failures {
+		names = append(names, f.Rule)
+	}
+	return names
+}
+
+func TestValidateOrderListsEveryFailure(t *testing.T) {
+	lp := newRulesLedger(t)
+	v := NewValidator(lp)
+
+	if err := v.ValidateOrder(lendOrder(1, 100)); err != nil {
+		t.Fatalf("valid order rejected: %v", err)
+	}
+
+	order := lendOrder(1, 150) // Not a multiple of 100
+	order.InstrumentCode = "XXXX"
+	err := v.ValidateOrder(order)
+	if got := strings.Join(failedRules(err), ","); got != "instrument,quantity" {
+		t.Fatalf("failed rules = %q, want instrument,quantity (error %v)", got, err)
+	}
+	if !strings.Contains(err.Error(), "instrument XXXX not found; Quantity: must be in multiples") {
+		t.Errorf("Error() = %q, want both failures", err.Error())
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "InstrumentCode" {
+		t.Errorf("errors.As() = %v, want the first failure", validationErr)
+	}
+}
+
+func TestRiskRuleEventsConfigureRules(t *testing.T) {
+	lp := newRulesLedger(t)
+	v := NewValidator(lp)
+	order := lendOrder(1, 150)
+	order.InstrumentCode = "XXXX"
+
+	// Disabled rules don't run
+	lp.SyncRiskRule(ledger.RiskRule{Name: RuleQuantity, Enabled: false, Priority: 70})
+	if got := strings.Join(failedRules(v.ValidateOrder(order)), ","); got != "instrument" {
+		t.Errorf("failed rules with quantity disabled = %q, want instrument", got)
+	}
+
+	// Priorities decide the order
+	lp.SyncRiskRule(ledger.RiskRule{Name: RuleQuantity, Enabled: true, Priority: 1})
+	if got := strings.Join(failedRules(v.ValidateOrder(order)), ","); got != "quantity,instrument" {
+		t.Errorf("failed rules with quantity first = %q, want quantity,instrument", got)
+	}
+	if rules := v.Rules(); rules[0].Name != RuleQuantity || !rules[0].Configured {
+		t.Errorf("Rules()[0] = %+v, want the configured quantity rule", rules[0])
+	}
+}
+
+func TestParameterisedRules(t *testing.T) {
+	lp := newRulesLedger(t)
+	v := NewValidator(lp)
+	check := func(step string, order ledger.OrderEntity, want string) {
+		t.Helper()
+		if got := strings.Join(failedRules(v.ValidateOrder(order)), ","); got != want {
+			t.Errorf("%s: failed rules = %q, want %q", step, got, want)
+		}
+	}
+
+	lp.SyncRiskRule(ledger.RiskRule{Name: RuleInstrumentMaxQuantity, Enabled: true, Priority: 80,
+		Params: map[string]string{"BBRI": "500", "TLKM": "100"}})
+	check("within instrument maximum", lendOrder(1, 500), "")
+	check("above instrument maximum", lendOrder(1, 600), RuleInstrumentMaxQuantity)
+
+	lp.SyncRiskRule(ledger.RiskRule{Name: RuleBlockedSIDs, Enabled: true, Priority: 90,
+		Params: map[string]string{"sids": "IDD9999, IDD1234"}})
+	check("blocked SID", lendOrder(1, 100), RuleBlockedSIDs)
+	lp.SyncRiskRule(ledger.RiskRule{Name: RuleBlockedSIDs, Enabled: true, Priority: 90})
+
+	lp.SyncRiskRule(ledger.RiskRule{Name: RuleMaxOpenOrders, Enabled: true, Priority: 100,
+		Params: map[string]string{"max": "2"}})
+	lp.SyncOrder(ledger.Order{NID: 1, AccountCode: "AK-001", Side: "LEND", Quantity: 100})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 1})
+	v.Hold(lendOrder(2, 100), 10000)
+	check("third open order", lendOrder(3, 100), RuleMaxOpenOrders)
+	amendment := lendOrder(3, 100)
+	amendment.PrevNID = 1
+	check("amendment of an open order", amendment, "")
+
+	// Invalid parameters fail closed
+	lp.SyncRiskRule(ledger.RiskRule{Name: RuleMaxOpenOrders, Enabled: true, Priority: 100,
+		Params: map[string]string{"max": "two"}})
+	check("invalid parameter", lendOrder(3, 100), RuleMaxOpenOrders)
+}
+
+func TestAddRule(t *testing.T) {
+	lp := newRulesLedger(t)
+	v := NewValidator(lp)
+	v.AddRule(NewRule("no-odd-nids", "Test rule", func(o ledger.OrderEntity, _ Params) error {
+		if o.NID%2 == 1 {
+			return errors.New("odd")
+		}
+		return nil
+	}), 5)
+
+	err := v.ValidateOrder(lendOrder(1, 100))
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "RiskRule" || validationErr.Message != "rule no-odd-nids: odd" {
+		t.Errorf("ValidateOrder() = %v, want the added rule to fail", err)
+	}
+	if rules := v.Rules(); rules[0].Name != "no-odd-nids" {
+		t.Errorf("Rules()[0] = %s, want the added rule first", rules[0].Name)
+	}
+}
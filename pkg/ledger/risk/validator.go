@@ -1,24 +1,48 @@
 package risk
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"pmeonline/pkg/ledger"
+	"pmeonline/pkg/ledger/calendar"
 )
 
 // Validator handles pre-trade validation
 type Validator struct {
-	ledger *ledger.LedgerPoint
-	calc   *Calculator
+	ledger   *ledger.LedgerPoint
+	calc     *Calculator
+	calendar *calendar.Calendar
+	clock    ledger.Clock
+
+	heldMu sync.Mutex
+	held   map[int]heldLimit // Reservations sent in an OrderAck not applied yet, by order NID
+
+	rulesMu sync.RWMutex
+	rules   []registeredRule
 }
 
-// NewValidator creates a new validator instance
+// heldLimit is a limit reservation on its way to the ledger
+type heldLimit struct {
+	order  ledger.OrderEntity
+	amount float64
+}
+
+// NewValidator creates a new validator instance with the built-in rules.
+// Dates are checked against the ledger's clock and its holidays and session
+// times.
 func NewValidator(l *ledger.LedgerPoint) *Validator {
-	return &Validator{
-		ledger: l,
-		calc:   NewCalculator(l),
+	v := &Validator{
+		ledger:   l,
+		calc:     NewCalculator(l),
+		calendar: calendar.New(l),
+		clock:    l.Clock(),
+		held:     make(map[int]heldLimit),
 	}
+	v.rules = v.builtinRules()
+	return v
 }
 
 // ValidationError represents a validation failure
@@ -31,53 +55,30 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Field, e.Message)
 }
 
-// ValidateOrder performs comprehensive pre-trade validation using OrderEntity from ledger
+// ValidateOrder runs the enabled rules on an order from the ledger. When any
+// fail it returns a *ValidationResult listing all of them.
 func (v *Validator) ValidateOrder(order ledger.OrderEntity) error {
-	// 1. Basic field validation
-	if err := v.validateBasicFields(order); err != nil {
-		return err
-	}
-
-	// 2. Account validation
-	if err := v.validateAccount(order); err != nil {
-		return err
-	}
-
-	// 3. Instrument validation
-	if err := v.validateInstrument(order); err != nil {
-		return err
-	}
-
-	// 4. Participant validation
-	if err := v.validateParticipant(order); err != nil {
-		return err
-	}
+	configs, rules := v.resolveRules()
 
-	// 5. Date validation (BORR orders only)
-	if order.Side == "BORR" {
-		if err := v.validateDates(order); err != nil {
-			return err
+	result := &ValidationResult{}
+	for i, rule := range rules {
+		if !configs[i].Enabled {
+			continue
 		}
-	}
-
-	// 6. Quantity validation
-	if err := v.validateQuantity(order); err != nil {
-		return err
-	}
-
-	// 7. Side-specific validation
-	if order.Side == "BORR" {
-		if err := v.validateBorrowOrder(order); err != nil {
-			return err
+		err := rule.Check(order, configs[i].Params)
+		if err == nil {
+			continue
 		}
-	} else if order.Side == "LEND" {
-		if err := v.validateLendOrder(order); err != nil {
-			return err
+		validationErr, ok := err.(*ValidationError)
+		if !ok {
+			validationErr = &ValidationError{Field: "RiskRule", Message: fmt.Sprintf("rule %s: %v", rule.Name(), err)}
 		}
-	} else {
-		return &ValidationError{Field: "Side", Message: "must be BORR or LEND"}
+		result.Failures = append(result.Failures, RuleFailure{Rule: rule.Name(), Err: validationErr})
 	}
 
+	if len(result.Failures) > 0 {
+		return result
+	}
 	return nil
 }
 
@@ -95,6 +96,9 @@ func (v *Validator) validateBasicFields(order ledger.OrderEntity) error {
 	if order.Side == "" {
 		return &ValidationError{Field: "Side", Message: "is required"}
 	}
+	if order.Side != "BORR" && order.Side != "LEND" {
+		return &ValidationError{Field: "Side", Message: "must be BORR or LEND"}
+	}
 	if order.Quantity <= 0 {
 		return &ValidationError{Field: "Quantity", Message: "must be greater than 0"}
 	}
@@ -109,6 +113,21 @@ func (v *Validator) validateBasicFields(order ledger.OrderEntity) error {
 	return nil
 }
 
+// validateSession checks that a new order arrives during a trading session.
+// Pending orders opened at SOD were checked when they were entered.
+func (v *Validator) validateSession(order ledger.OrderEntity) error {
+	if order.State != "S" {
+		return nil
+	}
+	if !v.calendar.IsWithinSession(v.clock.Now()) {
+		return &ValidationError{
+			Field:   "SessionTime",
+			Message: "orders are only accepted during trading sessions on business days",
+		}
+	}
+	return nil
+}
+
 // validateAccount checks if account exists and is active
 func (v *Validator) validateAccount(order ledger.OrderEntity) error {
 	account, exists := v.ledger.GetAccount(order.AccountCode)
@@ -182,7 +201,7 @@ func (v *Validator) validateParticipant(order ledger.OrderEntity) error {
 
 // validateDates checks settlement and reimbursement dates
 func (v *Validator) validateDates(order ledger.OrderEntity) error {
-	now := time.Now()
+	now := v.clock.Now()
 	serverLoc := now.Location()
 
 	// Normalize to date-only comparison (ignore time part)
@@ -192,13 +211,26 @@ func (v *Validator) validateDates(order ledger.OrderEntity) error {
 	settlementInServerTz := order.SettlementDate.In(serverLoc)
 	settlementDate := time.Date(settlementInServerTz.Year(), settlementInServerTz.Month(), settlementInServerTz.Day(), 0, 0, 0, 0, serverLoc)
 
-	// Settlement date must be today or in the future (date part only)
+	// Settlement date must be today or in the future (date part only), and
+	// not today once its last session is over
 	if settlementDate.Before(today) {
 		return &ValidationError{
 			Field:   "SettlementDate",
 			Message: "must be today or in the future",
 		}
 	}
+	if earliest := v.calendar.NextSettlementDate(now); settlementDate.Before(earliest) {
+		return &ValidationError{
+			Field:   "SettlementDate",
+			Message: fmt.Sprintf("must be %s or later", earliest.Format("2006-01-02")),
+		}
+	}
+	if !v.calendar.IsBusinessDay(settlementDate) {
+		return &ValidationError{
+			Field:   "SettlementDate",
+			Message: "must be a business day",
+		}
+	}
 
 	// Reimbursement date must be after settlement date
 	if order.ReimbursementDate.Before(order.SettlementDate) || order.ReimbursementDate.Equal(order.SettlementDate) {
@@ -207,8 +239,15 @@ func (v *Validator) validateDates(order ledger.OrderEntity) error {
 			Message: "must be after settlement date",
 		}
 	}
+	if !v.calendar.IsBusinessDay(order.ReimbursementDate.In(serverLoc)) {
+		return &ValidationError{
+			Field:   "ReimbursementDate",
+			Message: "must be a business day",
+		}
+	}
 
-	// Calculate expected periode
+	// Calculate expected periode. It counts calendar days, over which the
+	// borrowing fee accrues daily (F.3).
 	days := int(order.ReimbursementDate.Sub(order.SettlementDate).Hours() / 24)
 	if days != order.Periode {
 		return &ValidationError{
@@ -235,6 +274,11 @@ func (v *Validator) validateDates(order ledger.OrderEntity) error {
 func (v *Validator) validateQuantity(order ledger.OrderEntity) error {
 	param := v.ledger.GetParameter()
 
+	// No Parameter event has set the denomination yet
+	if param.DenominationLimit <= 0 {
+		return &ValidationError{Field: "Quantity", Message: "denomination limit is not configured"}
+	}
+
 	// Check minimum denomination
 	if int(order.Quantity)%param.DenominationLimit != 0 {
 		return &ValidationError{
@@ -256,45 +300,144 @@ func (v *Validator) validateQuantity(order ledger.OrderEntity) error {
 	return nil
 }
 
-// validateBorrowOrder performs borrowing-specific validation
+// validateBorrowOrder performs borrowing-specific validation. The order must
+// fit in what is left of the trading limit after the reservations of other
+// open orders and the contracts not yet reimbursed. The order an amendment
+// replaces gives its reservation back.
 func (v *Validator) validateBorrowOrder(order ledger.OrderEntity) error {
-	account, exists := v.ledger.GetAccount(order.AccountCode)
-	if !exists {
-		return &ValidationError{Field: "AccountCode", Message: "account not found"}
+	exposure, err := v.accountExposure(order, "AccountLimit", "trading limit")
+	if err != nil {
+		return err
 	}
 
-	// Calculate borrowing value and fees
-	// Formula from F.1.1:
-	// BorrVal = MarketPrice × Quantity
-	// TotalFee = BorrVal × FeeBorr × Period + FeeFlat
-	// TradingLimit >= TotalFee + BorrVal
+	requiredLimit := v.RequiredLimit(order)
+	available := exposure.TradeAvailable - v.heldFor(order.AccountCode, "BORR") + v.amendmentCredit(order)
 
-	borrVal := order.MarketPrice * order.Quantity
-	totalFee := v.calc.CalculateBorrowingTotalFee(order.MarketPrice, order.Quantity, order.Periode)
-
-	requiredLimit := totalFee + borrVal
-
-	if account.TradeLimit < requiredLimit {
+	if available < requiredLimit {
 		return &ValidationError{
 			Field: "AccountLimit",
 			Message: fmt.Sprintf("insufficient trading limit: required %.2f, available %.2f",
-				requiredLimit, account.TradeLimit),
+				requiredLimit, available),
 		}
 	}
 
 	return nil
 }
 
-// validateLendOrder performs lending-specific validation
+// accountExposure returns the limit usage of the order's account. An OMS
+// assigned only some partitions doesn't see the account's orders and
+// contracts on the others, so the order is rejected rather than checked
+// against a partial count.
+func (v *Validator) accountExposure(order ledger.OrderEntity, field string, limit string) (ledger.AccountExposure, error) {
+	exposure, err := v.ledger.GetAccountExposure(order.AccountCode)
+	switch {
+	case errors.Is(err, ledger.ErrAccountNotFound):
+		return exposure, &ValidationError{Field: "AccountCode", Message: "account not found"}
+	case err != nil:
+		return exposure, &ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("%s can't be checked by this OMS: %v", limit, err),
+		}
+	}
+	return exposure, nil
+}
+
+// RequiredLimit returns the limit an order reserves while open: trading
+// limit for BORR orders, pool limit for LEND orders.
+//
+// Formula from F.1.1:
+// BorrVal = MarketPrice × Quantity
+// TotalFee = BorrVal × FeeBorr × Period + FeeFlat
+// TradingLimit >= TotalFee + BorrVal
+// PoolLimit >= MarketPrice × Quantity (lent)
+func (v *Validator) RequiredLimit(order ledger.OrderEntity) float64 {
+	switch order.Side {
+	case "BORR":
+		borrVal := v.calc.CalculateBorrowingValue(order.MarketPrice, order.Quantity)
+		totalFee := v.calc.CalculateBorrowingTotalFee(order.MarketPrice, order.Quantity, order.Periode)
+		return totalFee + borrVal
+	case "LEND":
+		return order.MarketPrice * order.Quantity
+	}
+	return 0
+}
+
+// amendmentCredit returns the limit reserved and held for the order an
+// amendment replaces while that order is still open. Its OrderAck moves the
+// reservation to the amendment, so it must not count twice.
+func (v *Validator) amendmentCredit(order ledger.OrderEntity) float64 {
+	if order.PrevNID == 0 {
+		return 0
+	}
+	prev, exists := v.ledger.GetOrder(order.PrevNID)
+	if !exists || prev.AccountCode != order.AccountCode || prev.Side != order.Side ||
+		(prev.State != "O" && prev.State != "P" && prev.State != "G") {
+		return 0
+	}
+	v.heldMu.Lock()
+	held := v.held[prev.NID].amount
+	v.heldMu.Unlock()
+	return prev.ReservedLimit + held
+}
+
+// Hold counts amount against the account of order until Release is called
+// for it. OrderAcks are committed asynchronously, so the OMS holds the limit
+// it acknowledges to keep the orders validated in the meantime from using it
+// as well.
+func (v *Validator) Hold(order ledger.OrderEntity, amount float64) {
+	v.heldMu.Lock()
+	defer v.heldMu.Unlock()
+	v.held[order.NID] = heldLimit{order: order, amount: amount}
+}
+
+// Release drops the hold of an order once its OrderAck has been applied
+func (v *Validator) Release(orderNID int) {
+	v.heldMu.Lock()
+	defer v.heldMu.Unlock()
+	delete(v.held, orderNID)
+}
+
+// heldFor sums the limit held for the orders of the account on side
+func (v *Validator) heldFor(accountCode string, side string) float64 {
+	v.heldMu.Lock()
+	defer v.heldMu.Unlock()
+	total := 0.0
+	for _, h := range v.held {
+		if h.order.AccountCode == accountCode && h.order.Side == side {
+			total += h.amount
+		}
+	}
+	return total
+}
+
+// validateLendOrder performs lending-specific validation. The market value
+// of the order must fit in what is left of the pool limit (NAV / effective
+// collateral, F.1) after the other open lend orders and the lending
+// contracts not yet reimbursed. The order an amendment replaces gives its
+// reservation back.
 func (v *Validator) validateLendOrder(order ledger.OrderEntity) error {
-	// According to F.1.2, no pool limit check is required for lending orders
-	// Just basic validation is sufficient
+	exposure, err := v.accountExposure(order, "PoolLimit", "pool limit")
+	if err != nil {
+		return err
+	}
+
+	requiredLimit := v.RequiredLimit(order)
+	available := exposure.PoolAvailable - v.heldFor(order.AccountCode, "LEND") + v.amendmentCredit(order)
+
+	if available < requiredLimit {
+		return &ValidationError{
+			Field: "PoolLimit",
+			Message: fmt.Sprintf("insufficient pool limit: required %.2f, available %.2f",
+				requiredLimit, available),
+		}
+	}
+
 	return nil
 }
 
 // IsPendingNew checks if order should be in Pending-New state
 func (v *Validator) IsPendingNew(order ledger.OrderEntity) bool {
-	now := time.Now()
+	now := v.clock.Now()
 	serverLoc := now.Location()
 
 	// Normalize today to midnight in server timezone
@@ -0,0 +1,325 @@
+package risk
+
+import (
+	"testing"
+	"time"
+
+	"pmeonline/pkg/ledger"
+)
+
+func TestValidatorUsesLedgerClock(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	clock := ledger.NewFakeClock(time.Date(2025, 11, 24, 10, 0, 0, 0, time.Local))
+	lp.SetClock(clock)
+	lp.SyncParameter(ledger.Parameter{BorrowMaxOpenDay: 30})
+	v := NewValidator(lp)
+
+	settlement := time.Date(2025, 11, 25, 0, 0, 0, 0, time.Local)
+	order := ledger.OrderEntity{
+		Side:              "BORR",
+		SettlementDate:    settlement,
+		ReimbursementDate: settlement.AddDate(0, 0, 7),
+		Periode:           7,
+	}
+
+	if err := v.validateDates(order); err != nil {
+		t.Errorf("validateDates() error: %v", err)
+	}
+	if !v.IsPendingNew(order) {
+		t.Error("IsPendingNew() = false before the settlement date")
+	}
+
+	clock.Advance(24 * time.Hour)
+	if v.IsPendingNew(order) {
+		t.Error("IsPendingNew() = true on the settlement date")
+	}
+
+	clock.Advance(24 * time.Hour)
+	if err := v.validateDates(order); err == nil {
+		t.Error("validateDates() accepted a settlement date in the past")
+	}
+	if days := v.calc.DaysPassed(settlement); days != 1 {
+		t.Errorf("DaysPassed() = %d, want 1", days)
+	}
+}
+
+func TestBorrowOrderChecksRemainingLimit(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(ledger.Account{Code: "YU-001"})
+	lp.SyncAccountLimit(ledger.AccountLimit{Code: "YU-001", TradeLimit: 25000})
+	v := NewValidator(lp)
+
+	// 10000 borrowing value plus 5 flat and about 49.32 fees over 10 days
+	order := ledger.OrderEntity{NID: 1, AccountCode: "YU-001", Side: "BORR", MarketPrice: 100, Quantity: 100, Periode: 10}
+	required := v.RequiredLimit(order)
+	if required < 10054 || required > 10055 {
+		t.Fatalf("RequiredLimit() = %.2f, want 10054.32", required)
+	}
+
+	// An acknowledged order counts while its ack is on the way...
+	v.Hold(order, required)
+	second := order
+	second.NID = 2
+	if err := v.validateBorrowOrder(second); err != nil {
+		t.Errorf("second order rejected: %v", err)
+	}
+	v.Hold(second, required)
+	third := order
+	third.NID = 3
+	if err := v.validateBorrowOrder(third); err == nil {
+		t.Error("third order fits a limit already used by two")
+	}
+
+	// ...and after it has been applied
+	lp.SyncOrder(ledger.Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 1, ReservedLimit: required})
+	v.Release(1)
+	if err := v.validateBorrowOrder(third); err == nil {
+		t.Error("third order fits once the first ack is applied")
+	}
+
+	// A withdrawal gives the limit back
+	lp.SyncOrderWithdrawAck(ledger.OrderWithdrawAck{OrderNID: 1})
+	if err := v.validateBorrowOrder(third); err != nil {
+		t.Errorf("third order rejected after a withdrawal: %v", err)
+	}
+}
+
+// partialLedgers returns two LedgerPoints of a three partition ledger, each
+// consuming one instrument partition like two OMS instances, and an
+// instrument of each
+func partialLedgers(t *testing.T) ([]*ledger.LedgerPoint, []string) {
+	t.Helper()
+	transports := []ledger.Transport{ledger.NewMemoryTransport("pme-ledger"), ledger.NewMemoryTransport("pme-ledger"), ledger.NewMemoryTransport("pme-ledger")}
+	var lps []*ledger.LedgerPoint
+	instruments := make([]string, 2)
+	for _, code := range []string{"BBRI", "BBCA", "TLKM", "ASII", "BMRI", "UNVR"} {
+		if p := ledger.PartitionFor(code, 3); instruments[p-1] == "" {
+			instruments[p-1] = code
+		}
+	}
+	if instruments[0] == "" || instruments[1] == "" {
+		t.Fatalf("no instrument for each partition: %v", instruments)
+	}
+	for p := 1; p <= 2; p++ {
+		lp, err := ledger.CreateLedgerPointWithPartitions(transports, []int{p}, "pmeoms")
+		if err != nil {
+			t.Fatalf("CreateLedgerPointWithPartitions() error: %v", err)
+		}
+		lps = append(lps, lp)
+	}
+	return lps, instruments
+}
+
+func TestTradeLimitOnPartialLedgers(t *testing.T) {
+	lps, instruments := partialLedgers(t)
+
+	// Each order alone fits the limit, both together don't. Neither OMS sees
+	// the other's order, so both refuse to check.
+	for i, lp := range lps {
+		lp.SyncAccount(ledger.Account{Code: "YU-001"})
+		lp.SyncAccountLimit(ledger.AccountLimit{Code: "YU-001", TradeLimit: 15000})
+		v := NewValidator(lp)
+		order := ledger.OrderEntity{NID: i + 1, AccountCode: "YU-001", InstrumentCode: instruments[i],
+			Side: "BORR", MarketPrice: 100, Quantity: 100, Periode: 10}
+		err := v.validateBorrowOrder(order)
+		if ve, ok := err.(*ValidationError); !ok || ve.Field != "AccountLimit" {
+			t.Errorf("order on partition %d: error %v, want an AccountLimit ValidationError", i+1, err)
+		}
+	}
+}
+
+func TestMaxOpenOrdersOnPartialLedgers(t *testing.T) {
+	lps, instruments := partialLedgers(t)
+
+	// One open order per partition, so each OMS alone sees one of two
+	for i, lp := range lps {
+		lp.SyncOrder(ledger.Order{NID: i + 1, AccountCode: "YU-001", InstrumentCode: instruments[i], Side: "LEND", Quantity: 100})
+		lp.SyncOrderAck(ledger.OrderAck{OrderNID: i + 1})
+
+		v := NewValidator(lp)
+		order := ledger.OrderEntity{NID: 10 + i, AccountCode: "YU-001", InstrumentCode: instruments[i], Side: "LEND", Quantity: 100}
+		err := v.checkMaxOpenOrders(order, Params{"max": "2"})
+		if ve, ok := err.(*ValidationError); !ok || ve.Field != "AccountCode" {
+			t.Errorf("order on partition %d: error %v, want an AccountCode ValidationError", i+1, err)
+		}
+	}
+}
+
+func TestBorrowAmendmentNearTheLimit(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(ledger.Account{Code: "YU-001"})
+	lp.SyncAccountLimit(ledger.AccountLimit{Code: "YU-001", TradeLimit: 15000})
+	v := NewValidator(lp)
+
+	order := ledger.OrderEntity{NID: 1, AccountCode: "YU-001", Side: "BORR", MarketPrice: 100, Quantity: 100, Periode: 10}
+	required := v.RequiredLimit(order)
+	lp.SyncOrder(ledger.Order{NID: 1, AccountCode: "YU-001", Side: "BORR", Quantity: 100})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 1, ReservedLimit: required})
+
+	// Raising the quantity by 20% only needs the difference, but a new order
+	// of that size doesn't fit next to the first
+	amendment := order
+	amendment.NID = 2
+	amendment.PrevNID = 1
+	amendment.Quantity = 120
+	if err := v.validateBorrowOrder(amendment); err != nil {
+		t.Errorf("amendment near the limit rejected: %v", err)
+	}
+	unrelated := amendment
+	unrelated.PrevNID = 0
+	if err := v.validateBorrowOrder(unrelated); err == nil {
+		t.Error("second order fits next to the first")
+	}
+
+	// Beyond the limit even with the reservation given back
+	amendment.Quantity = 160
+	if err := v.validateBorrowOrder(amendment); err == nil {
+		t.Error("amendment above the limit accepted")
+	}
+
+	// A hold still pending for the amended order is given back as well
+	amendment.Quantity = 120
+	v.Hold(order, required)
+	if err := v.validateBorrowOrder(amendment); err != nil {
+		t.Errorf("amendment rejected while the original is held: %v", err)
+	}
+	v.Release(1)
+
+	// Once the original is closed it has nothing to give back
+	lp.SyncOrderWithdrawAck(ledger.OrderWithdrawAck{OrderNID: 1})
+	if credit := v.amendmentCredit(amendment); credit != 0 {
+		t.Errorf("amendmentCredit() of a withdrawn order = %.2f, want 0", credit)
+	}
+}
+
+func TestLendOrderChecksRemainingPoolLimit(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	lp.SyncAccount(ledger.Account{Code: "AK-001"})
+	lp.SyncAccountLimit(ledger.AccountLimit{Code: "AK-001", PoolLimit: 15000})
+	v := NewValidator(lp)
+
+	order := ledger.OrderEntity{NID: 1, AccountCode: "AK-001", Side: "LEND", MarketPrice: 100, Quantity: 100}
+	if required := v.RequiredLimit(order); required != 10000 {
+		t.Fatalf("RequiredLimit() = %.2f, want the market value 10000", required)
+	}
+	if err := v.validateLendOrder(order); err != nil {
+		t.Fatalf("first order rejected: %v", err)
+	}
+
+	lp.SyncOrder(ledger.Order{NID: 1, AccountCode: "AK-001", Side: "LEND", Quantity: 100})
+	lp.SyncOrderAck(ledger.OrderAck{OrderNID: 1, ReservedLimit: 10000})
+	second := order
+	second.NID = 2
+	err := v.validateLendOrder(second)
+	if ve, ok := err.(*ValidationError); !ok || ve.Field != "PoolLimit" {
+		t.Fatalf("second order: error %v, want a PoolLimit ValidationError", err)
+	}
+
+	// Amending the first order only needs the difference
+	amendment := order
+	amendment.NID = 4
+	amendment.PrevNID = 1
+	amendment.Quantity = 140
+	if err := v.validateLendOrder(amendment); err != nil {
+		t.Errorf("amendment within the pool limit rejected: %v", err)
+	}
+	amendment.Quantity = 160
+	if err := v.validateLendOrder(amendment); err == nil {
+		t.Error("amendment above the pool limit accepted")
+	}
+
+	// A borrow hold doesn't count against the pool limit
+	lp.SyncOrderWithdrawAck(ledger.OrderWithdrawAck{OrderNID: 1})
+	v.Hold(ledger.OrderEntity{NID: 3, AccountCode: "AK-001", Side: "BORR"}, 10000)
+	if err := v.validateLendOrder(second); err != nil {
+		t.Errorf("second order rejected after a withdrawal: %v", err)
+	}
+}
+
+func TestPoolLimitOnPartialLedgers(t *testing.T) {
+	lps, instruments := partialLedgers(t)
+
+	// As with the trading limit, each instance alone would let an order use
+	// the whole pool limit
+	for i, lp := range lps {
+		lp.SyncAccount(ledger.Account{Code: "AK-001"})
+		lp.SyncAccountLimit(ledger.AccountLimit{Code: "AK-001", PoolLimit: 15000})
+		v := NewValidator(lp)
+		order := ledger.OrderEntity{NID: i + 1, AccountCode: "AK-001", InstrumentCode: instruments[i],
+			Side: "LEND", MarketPrice: 100, Quantity: 100}
+		err := v.validateLendOrder(order)
+		if ve, ok := err.(*ValidationError); !ok || ve.Field != "PoolLimit" {
+			t.Errorf("order on partition %d: error %v, want a PoolLimit ValidationError", i+1, err)
+		}
+	}
+}
+
+func TestValidatorUsesCalendar(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	clock := ledger.NewFakeClock(time.Date(2025, 12, 23, 10, 0, 0, 0, time.Local))
+	lp.SetClock(clock)
+	lp.SyncParameter(ledger.Parameter{BorrowMaxOpenDay: 30})
+	lp.SyncHoliday(ledger.Holiday{NID: 1, Date: time.Date(2025, 12, 25, 0, 0, 0, 0, time.UTC)})
+	lp.SyncSessionTime(ledger.SessionTime{
+		NID:           1,
+		Session1Start: time.Date(0, 1, 1, 9, 0, 0, 0, time.UTC),
+		Session1End:   time.Date(0, 1, 1, 15, 50, 0, 0, time.UTC),
+	})
+	v := NewValidator(lp)
+
+	dates := func(settlement time.Time, periode int) ledger.OrderEntity {
+		return ledger.OrderEntity{
+			Side:              "BORR",
+			SettlementDate:    settlement,
+			ReimbursementDate: settlement.AddDate(0, 0, periode),
+			Periode:           periode,
+		}
+	}
+	day := func(d int) time.Time { return time.Date(2025, 12, d, 0, 0, 0, 0, time.Local) }
+
+	if err := v.validateDates(dates(day(23), 7)); err != nil {
+		t.Errorf("settlement today rejected: %v", err)
+	}
+	if err := v.validateDates(dates(day(25), 7)); err == nil {
+		t.Error("settlement on a holiday accepted")
+	}
+	if err := v.validateDates(dates(day(23), 4)); err == nil {
+		t.Error("reimbursement on a Saturday accepted")
+	}
+
+	// New orders need a session, pending ones opened at SOD don't
+	if err := v.validateSession(ledger.OrderEntity{State: "S"}); err != nil {
+		t.Errorf("order in session rejected: %v", err)
+	}
+	clock.Set(time.Date(2025, 12, 23, 16, 0, 0, 0, time.Local))
+	if err := v.validateSession(ledger.OrderEntity{State: "S"}); err == nil {
+		t.Error("order after the last session accepted")
+	}
+	if err := v.validateSession(ledger.OrderEntity{State: "G"}); err != nil {
+		t.Errorf("pending order rejected outside session: %v", err)
+	}
+
+	// Too late to settle today
+	if err := v.validateDates(dates(day(23), 7)); err == nil {
+		t.Error("settlement today accepted after the last session")
+	}
+	if err := v.validateDates(dates(day(24), 7)); err != nil {
+		t.Errorf("settlement on the next business day rejected: %v", err)
+	}
+}
+
+func TestQuantityWithoutParameters(t *testing.T) {
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	v := NewValidator(lp)
+
+	order := ledger.OrderEntity{NID: 1, Side: "BORR", Quantity: 100}
+	if err := v.validateQuantity(order); err == nil {
+		t.Error("validateQuantity() accepted an order before any Parameter event")
+	}
+
+	lp.SyncParameter(ledger.Parameter{DenominationLimit: 100, MaxQuantity: 1000})
+	if err := v.validateQuantity(order); err != nil {
+		t.Errorf("validateQuantity() error: %v", err)
+	}
+}
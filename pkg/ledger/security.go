@@ -0,0 +1,407 @@
+package ledger
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"pmeonline/pkg/logging"
+)
+
+// Security header keys, added to the envelope when payloads are encrypted
+// or signed
+const (
+	HeaderKeyID     = "key-id"    // Encryption key of an encrypted payload
+	HeaderSignature = "signature" // Producer signature over envelope and payload
+)
+
+// Signer signs the messages a LedgerPoint commits
+type Signer interface {
+	Sign(data []byte) []byte
+}
+
+// Verifier checks the signatures of one producer
+type Verifier interface {
+	Verify(data []byte, sig []byte) bool
+}
+
+// HMACKey signs and verifies with HMAC-SHA256. Every service that verifies
+// a producer holds its secret, so prefer Ed25519 where that matters.
+type HMACKey []byte
+
+func (k HMACKey) Sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (k HMACKey) Verify(data []byte, sig []byte) bool {
+	return hmac.Equal(k.Sign(data), sig)
+}
+
+// Ed25519Signer signs with a producer's private key
+type Ed25519Signer ed25519.PrivateKey
+
+func (k Ed25519Signer) Sign(data []byte) []byte {
+	return ed25519.Sign(ed25519.PrivateKey(k), data)
+}
+
+// Ed25519Verifier verifies with a producer's public key
+type Ed25519Verifier ed25519.PublicKey
+
+func (k Ed25519Verifier) Verify(data []byte, sig []byte) bool {
+	return ed25519.Verify(ed25519.PublicKey(k), data, sig)
+}
+
+// Security configures payload encryption and producer signatures. Set it
+// with LedgerPoint.SetSecurity; a nil Security writes and accepts clear,
+// unsigned messages.
+type Security struct {
+	EncryptionKeys  map[string][]byte // AES keys (16, 24 or 32 bytes) by key id
+	EncryptionKeyID string            // Key new payloads are encrypted with, "" for clear JSON
+
+	Signer    Signer              // Signs committed messages, nil to not sign
+	Verifiers map[string]Verifier // Signature check by producer (LedgerPoint id)
+
+	// RequireSignatures rejects unsigned messages and messages from
+	// producers without a Verifier. Otherwise messages from producers
+	// without a Verifier are accepted unsigned, which allows turning signing
+	// on service by service. Messages claiming a producer with a Verifier
+	// must be signed either way.
+	RequireSignatures bool
+}
+
+// RejectedError is returned by ApplyMessage for messages that fail
+// signature verification or decryption, or break the Policy. They go to the rejected handler
+// instead of the dead-letter handler.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return "rejected: " + e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// SetSecurity enables payload encryption and signatures. Must be called
+// before Start.
+func (lp *LedgerPoint) SetSecurity(s *Security) {
+	lp.security = s
+}
+
+// SetRejectedHandler replaces the default handler for rejected messages,
+// which logs them. The handler runs on the processing goroutine and must not
+// block.
+func (lp *LedgerPoint) SetRejectedHandler(fn DeadLetterHandler) {
+	lp.rejected = fn
+}
+
+func (lp *LedgerPoint) logRejected(msg Message, err error) {
+	env, _ := ParseEnvelope(msg.Headers)
+	lp.Logger().Warn("Rejected message", logging.EventType(env.EventType), "producer", env.Producer,
+		logging.Offset(msg.Offset), "error", err)
+}
+
+// seal encrypts the payload of msg and signs it
+func (s *Security) seal(msg *Message) error {
+	if s.EncryptionKeyID != "" {
+		gcm, err := s.cipher(s.EncryptionKeyID)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		msg.Headers = append(msg.Headers, Header{Key: HeaderKeyID, Value: []byte(s.EncryptionKeyID)})
+		msg.Value = gcm.Seal(nonce, nonce, msg.Value, envelopeBytes(msg.Headers))
+	}
+
+	if s.Signer != nil {
+		msg.Headers = append(msg.Headers, Header{Key: HeaderSignature, Value: s.Signer.Sign(signedBytes(*msg))})
+	}
+	return nil
+}
+
+// open verifies the signature of msg and decrypts its payload
+func (s *Security) open(msg Message) (Message, error) {
+	env, err := ParseEnvelope(msg.Headers)
+	if err != nil {
+		return msg, err
+	}
+
+	sig := headerValue(msg.Headers, HeaderSignature)
+	verifier, known := s.Verifiers[env.Producer]
+	switch {
+	case sig == nil && s.RequireSignatures:
+		return msg, errors.New("message is not signed")
+	case sig == nil && known:
+		// Otherwise anyone able to write to the log could pose as the producer
+		return msg, fmt.Errorf("message claiming producer %q is not signed", env.Producer)
+	case sig != nil && known:
+		if !verifier.Verify(signedBytes(msg), sig) {
+			return msg, fmt.Errorf("invalid signature for producer %q", env.Producer)
+		}
+	case s.RequireSignatures:
+		return msg, fmt.Errorf("unknown producer %q", env.Producer)
+	}
+
+	if keyID := headerValue(msg.Headers, HeaderKeyID); keyID != nil {
+		gcm, err := s.cipher(string(keyID))
+		if err != nil {
+			return msg, err
+		}
+		if len(msg.Value) < gcm.NonceSize() {
+			return msg, errors.New("encrypted payload too short")
+		}
+		nonce, sealed := msg.Value[:gcm.NonceSize()], msg.Value[gcm.NonceSize():]
+		clear, err := gcm.Open(nil, nonce, sealed, envelopeBytes(msg.Headers))
+		if err != nil {
+			return msg, fmt.Errorf("failed to decrypt with key %q: %w", keyID, err)
+		}
+		msg.Value = clear
+	}
+	return msg, nil
+}
+
+// sealSnapshot encrypts the entities and event ids of snap with the current
+// encryption key. The header fields stay readable so stores can still pick a
+// snapshot by offset and time.
+func (s *Security) sealSnapshot(snap *Snapshot) (*Snapshot, error) {
+	if s.EncryptionKeyID == "" {
+		return snap, nil
+	}
+	gcm, err := s.cipher(s.EncryptionKeyID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := &Snapshot{
+		Version:   snap.Version,
+		Topic:     snap.Topic,
+		Offset:    snap.Offset,
+		CreatedAt: snap.CreatedAt,
+		Time:      snap.Time,
+		Offsets:   snap.Offsets,
+		KeyID:     s.EncryptionKeyID,
+	}
+	sealed.Sealed = gcm.Seal(nonce, nonce, data, snapshotBytes(sealed))
+	return sealed, nil
+}
+
+// openSnapshot decrypts a snapshot written by sealSnapshot
+func (s *Security) openSnapshot(snap *Snapshot) (*Snapshot, error) {
+	gcm, err := s.cipher(snap.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(snap.Sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted snapshot too short")
+	}
+	nonce, sealed := snap.Sealed[:gcm.NonceSize()], snap.Sealed[gcm.NonceSize():]
+	data, err := gcm.Open(nil, nonce, sealed, snapshotBytes(snap))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot with key %q: %w", snap.KeyID, err)
+	}
+
+	var clear Snapshot
+	if err := json.Unmarshal(data, &clear); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return &clear, nil
+}
+
+// snapshotBytes serializes the readable fields of a sealed snapshot as
+// authenticated data, so its contents can't be passed off as another offset
+func snapshotBytes(snap *Snapshot) []byte {
+	b := appendField(nil, []byte(snap.Topic))
+	b = binary.BigEndian.AppendUint64(b, uint64(snap.Offset))
+	for _, offset := range snap.Offsets {
+		b = binary.BigEndian.AppendUint64(b, uint64(offset))
+	}
+	return appendField(b, []byte(snap.KeyID))
+}
+
+func (s *Security) cipher(keyID string) (cipher.AEAD, error) {
+	key, ok := s.EncryptionKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// envelopeBytes serializes the envelope and key id headers, in a fixed
+// order, as authenticated data for encryption and signatures. This binds the
+// payload to its event type, producer and event id.
+func envelopeBytes(headers []Header) []byte {
+	var b []byte
+	for _, key := range []string{HeaderEventType, HeaderSchemaVersion, HeaderProducer, HeaderEventID, HeaderKeyID} {
+		b = appendField(b, headerValue(headers, key))
+	}
+	return b
+}
+
+// signedBytes is what a producer signs: the envelope and the payload as
+// written (encrypted when encryption is on)
+func signedBytes(msg Message) []byte {
+	return appendField(envelopeBytes(msg.Headers), msg.Value)
+}
+
+func appendField(b []byte, field []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+	return append(b, field...)
+}
+
+func headerValue(headers []Header, key string) []byte {
+	for _, h := range headers {
+		if h.Key == key {
+			return h.Value
+		}
+	}
+	return nil
+}
+
+// SecurityFromEnv builds a Security from the environment, or returns nil
+// when none of the variables is set:
+//
+//	LEDGER_ENCRYPTION_KEYS    k1=<base64 AES key>,k2=<base64 AES key>
+//	LEDGER_ENCRYPTION_KEY_ID  k2 (key for new payloads, empty for clear JSON)
+//	LEDGER_SIGNING_KEY        hmac:<base64 secret> or ed25519:<base64 private key or seed>
+//	LEDGER_VERIFY_KEYS        pmeapi=ed25519:<base64 public key>,eclearapi=hmac:<base64 secret>
+//	LEDGER_REQUIRE_SIGNATURES true to reject unsigned messages
+func SecurityFromEnv() (*Security, error) {
+	encKeys := os.Getenv("LEDGER_ENCRYPTION_KEYS")
+	encKeyID := os.Getenv("LEDGER_ENCRYPTION_KEY_ID")
+	signingKey := os.Getenv("LEDGER_SIGNING_KEY")
+	verifyKeys := os.Getenv("LEDGER_VERIFY_KEYS")
+	require := os.Getenv("LEDGER_REQUIRE_SIGNATURES") == "true"
+	if encKeys == "" && encKeyID == "" && signingKey == "" && verifyKeys == "" && !require {
+		return nil, nil
+	}
+
+	s := &Security{
+		EncryptionKeys:    make(map[string][]byte),
+		EncryptionKeyID:   encKeyID,
+		Verifiers:         make(map[string]Verifier),
+		RequireSignatures: require,
+	}
+
+	err := forEachPair(encKeys, func(id string, value string) error {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("LEDGER_ENCRYPTION_KEYS %s: %w", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return fmt.Errorf("LEDGER_ENCRYPTION_KEYS %s: %w", id, err)
+		}
+		s.EncryptionKeys[id] = key
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.EncryptionKeys[encKeyID]; encKeyID != "" && !ok {
+		return nil, fmt.Errorf("LEDGER_ENCRYPTION_KEY_ID %q is not in LEDGER_ENCRYPTION_KEYS", encKeyID)
+	}
+
+	if signingKey != "" {
+		if s.Signer, err = parseSigner(signingKey); err != nil {
+			return nil, fmt.Errorf("LEDGER_SIGNING_KEY: %w", err)
+		}
+	}
+
+	err = forEachPair(verifyKeys, func(producer string, value string) error {
+		verifier, err := parseVerifier(value)
+		if err != nil {
+			return fmt.Errorf("LEDGER_VERIFY_KEYS %s: %w", producer, err)
+		}
+		s.Verifiers[producer] = verifier
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// forEachPair calls fn for every name=value in a comma separated list
+func forEachPair(list string, fn func(name string, value string) error) error {
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected name=value, got %q", pair)
+		}
+		if err := fn(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseKey(s string) (alg string, key []byte, err error) {
+	alg, encoded, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", nil, errors.New("expected hmac:<key> or ed25519:<key>")
+	}
+	key, err = base64.StdEncoding.DecodeString(encoded)
+	return alg, key, err
+}
+
+func parseSigner(s string) (Signer, error) {
+	alg, key, err := parseKey(s)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case alg == "hmac":
+		return HMACKey(key), nil
+	case alg == "ed25519" && len(key) == ed25519.SeedSize:
+		return Ed25519Signer(ed25519.NewKeyFromSeed(key)), nil
+	case alg == "ed25519" && len(key) == ed25519.PrivateKeySize:
+		return Ed25519Signer(key), nil
+	}
+	return nil, fmt.Errorf("unsupported %s key of %d bytes", alg, len(key))
+}
+
+func parseVerifier(s string) (Verifier, error) {
+	alg, key, err := parseKey(s)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case alg == "hmac":
+		return HMACKey(key), nil
+	case alg == "ed25519" && len(key) == ed25519.PublicKeySize:
+		return Ed25519Verifier(key), nil
+	}
+	return nil, fmt.Errorf("unsupported %s key of %d bytes", alg, len(key))
+}
@@ -0,0 +1,184 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func sealedMessage(t *testing.T, sec *Security, event any, producer string, eventID string) Message {
+	t.Helper()
+	msg, err := events.encode(event, producer, eventID)
+	if err != nil {
+		t.Fatalf("encode() error: %v", err)
+	}
+	if err := sec.seal(&msg); err != nil {
+		t.Fatalf("seal() error: %v", err)
+	}
+	return msg
+}
+
+func TestEncryptedSignedMessageIsApplied(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	sec := &Security{
+		EncryptionKeys:    map[string][]byte{"k1": key},
+		EncryptionKeyID:   "k1",
+		Signer:            HMACKey("eclearapi-secret"),
+		Verifiers:         map[string]Verifier{"eclearapi": HMACKey("eclearapi-secret")},
+		RequireSignatures: true,
+	}
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SetSecurity(sec)
+	lp.SyncAccount(Account{Code: "YU-001"})
+
+	msg := sealedMessage(t, sec, AccountLimit{Code: "YU-001", TradeLimit: 1e9}, "eclearapi", "1")
+	if bytes.Contains(msg.Value, []byte("YU-001")) {
+		t.Error("payload is not encrypted")
+	}
+	if string(headerValue(msg.Headers, HeaderKeyID)) != "k1" {
+		t.Errorf("key-id header = %q, want k1", headerValue(msg.Headers, HeaderKeyID))
+	}
+
+	if err := lp.ApplyMessage(msg); err != nil {
+		t.Fatalf("ApplyMessage() error: %v", err)
+	}
+	if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 1e9 {
+		t.Errorf("TradeLimit = %v, want 1e9", account.TradeLimit)
+	}
+}
+
+func TestForgedMessagesAreRejected(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	sec := &Security{
+		Verifiers:         map[string]Verifier{"eclearapi": Ed25519Verifier(pub)},
+		RequireSignatures: true,
+	}
+	signed := &Security{Signer: Ed25519Signer(priv)}
+	limit := AccountLimit{Code: "YU-001", TradeLimit: 1e9}
+
+	tampered := sealedMessage(t, signed, limit, "eclearapi", "1")
+	tampered.Value = bytes.Replace(tampered.Value, []byte("YU-001"), []byte("YU-002"), 1)
+
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"unsigned", sealedMessage(t, &Security{}, limit, "eclearapi", "2")},
+		{"tampered payload", tampered},
+		{"wrong key", sealedMessage(t, &Security{Signer: Ed25519Signer(otherKey)}, limit, "eclearapi", "3")},
+		{"unknown producer", sealedMessage(t, signed, limit, "pmeapi", "4")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+			lp.SetSecurity(sec)
+			lp.SyncAccount(Account{Code: "YU-001"})
+
+			var rejected *RejectedError
+			if err := lp.ApplyMessage(tt.msg); !errors.As(err, &rejected) {
+				t.Fatalf("ApplyMessage() error = %v, want RejectedError", err)
+			}
+			if account, _ := lp.GetAccount("YU-001"); account.TradeLimit != 0 {
+				t.Error("rejected message was applied")
+			}
+		})
+	}
+}
+
+func TestSignaturesAreOptionalUnlessRequired(t *testing.T) {
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	lp.SetSecurity(&Security{Verifiers: map[string]Verifier{"eclearapi": HMACKey("secret")}})
+
+	// Producers that don't sign yet are still read
+	if err := lp.ApplyMessage(sealedMessage(t, &Security{}, Order{NID: 1}, "pmeapi", "1")); err != nil {
+		t.Errorf("ApplyMessage(unsigned from pmeapi) error: %v", err)
+	}
+	// but an unsigned message claiming a producer with a key is not
+	if err := lp.ApplyMessage(sealedMessage(t, &Security{}, AccountLimit{Code: "YU-001"}, "eclearapi", "1")); err == nil {
+		t.Error("ApplyMessage(unsigned from eclearapi) succeeded, want error")
+	}
+	// and neither is a signature that doesn't verify
+	bad := sealedMessage(t, &Security{Signer: HMACKey("guess")}, AccountLimit{Code: "YU-002"}, "eclearapi", "2")
+	if err := lp.ApplyMessage(bad); err == nil {
+		t.Error("ApplyMessage(bad signature) succeeded, want error")
+	}
+}
+
+func TestRejectedMessagesGoToRejectedHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewMemoryTransport("pme-ledger")
+	writer := CreateLedgerPointWithTransport(transport, "pmeapi")
+	writer.SetSecurity(&Security{Signer: HMACKey("pmeapi-secret")})
+	writer.Start(nil, ctx)
+	<-writer.Ready()
+
+	reader := CreateLedgerPointWithTransport(transport, "eclearapi")
+	reader.SetSecurity(&Security{
+		Signer: HMACKey("eclearapi-secret"),
+		Verifiers: map[string]Verifier{
+			"pmeapi":    HMACKey("pmeapi-secret"),
+			"eclearapi": HMACKey("eclearapi-secret"),
+		},
+		RequireSignatures: true,
+	})
+	rejected := make(chan error, 10)
+	reader.SetRejectedHandler(func(msg Message, err error) { rejected <- err })
+	reader.SetDeadLetterHandler(func(msg Message, err error) { t.Errorf("dead-lettered: %v", err) })
+
+	// An unsigned limit change claiming to come from pmeapi
+	reader.SyncAccount(Account{Code: "YU-001"})
+	forged, _ := events.encode(AccountLimit{Code: "YU-001", TradeLimit: 1e9}, "pmeapi", "99")
+	transport.Append(ctx, forged)
+
+	reader.Start(nil, ctx)
+	<-reader.Ready()
+
+	select {
+	case err := <-rejected:
+		if !errors.As(err, new(*RejectedError)) {
+			t.Errorf("rejected error = %v, want RejectedError", err)
+		}
+	default:
+		t.Error("forged message was not rejected")
+	}
+	if account, _ := reader.GetAccount("YU-001"); account.TradeLimit != 0 {
+		t.Error("forged AccountLimit was applied")
+	}
+}
+
+func TestSecurityFromEnv(t *testing.T) {
+	if sec, err := SecurityFromEnv(); sec != nil || err != nil {
+		t.Fatalf("SecurityFromEnv() without settings = %v, %v, want nil", sec, err)
+	}
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	b64 := base64.StdEncoding.EncodeToString
+	t.Setenv("LEDGER_ENCRYPTION_KEYS", "k1="+b64(bytes.Repeat([]byte{1}, 16))+", k2="+b64(bytes.Repeat([]byte{2}, 32)))
+	t.Setenv("LEDGER_ENCRYPTION_KEY_ID", "k2")
+	t.Setenv("LEDGER_SIGNING_KEY", "ed25519:"+b64(priv.Seed()))
+	t.Setenv("LEDGER_VERIFY_KEYS", "pmeapi=ed25519:"+b64(pub)+",eclearapi=hmac:"+b64([]byte("secret")))
+	t.Setenv("LEDGER_REQUIRE_SIGNATURES", "true")
+
+	sec, err := SecurityFromEnv()
+	if err != nil {
+		t.Fatalf("SecurityFromEnv() error: %v", err)
+	}
+	if len(sec.EncryptionKeys) != 2 || sec.EncryptionKeyID != "k2" || !sec.RequireSignatures || len(sec.Verifiers) != 2 {
+		t.Errorf("SecurityFromEnv() = %+v", sec)
+	}
+	msg := sealedMessage(t, sec, Holiday{NID: 1, Description: "Christmas"}, "pmeapi", "1")
+	if _, err := sec.open(msg); err != nil {
+		t.Errorf("open() of own message error: %v", err)
+	}
+
+	t.Setenv("LEDGER_ENCRYPTION_KEY_ID", "k3")
+	if _, err := SecurityFromEnv(); err == nil {
+		t.Error("SecurityFromEnv() with unknown key id succeeded, want error")
+	}
+}
@@ -0,0 +1,476 @@
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"pmeonline/pkg/logging"
+)
+
+// SnapshotVersion is bumped whenever the layout of Snapshot changes.
+// Snapshots written with a different version are ignored on load and the
+// LedgerPoint falls back to a full replay of the topic.
+const SnapshotVersion = 5
+
+// Snapshot is a point-in-time copy of the LedgerPoint entity maps together
+// with the Kafka offset of the last event it includes
+type Snapshot struct {
+	Version   int       `json:"version"`
+	Topic     string    `json:"topic"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	// Latest log time of the events included, zero when unknown (e.g.
+	// snapshots captured by tools). ViewAtTime only starts from snapshots
+	// whose events are all at or before the requested time.
+	Time time.Time `json:"time,omitempty"`
+
+	// Offsets of the last included event per partition on a partitioned
+	// ledger. Offset then only orders snapshots (see LedgerPoint.position).
+	Offsets []int64 `json:"offsets,omitempty"`
+
+	// Recently applied events, so duplicates delivered after the restore
+	// are still dropped (see dedup.go)
+	EventIDs []string `json:"event_ids,omitempty"`
+
+	// With payload encryption on, everything below Offsets is encrypted
+	// into Sealed with key KeyID and left empty (see Security.sealSnapshot)
+	KeyID  string `json:"key_id,omitempty"`
+	Sealed []byte `json:"sealed,omitempty"`
+
+	Participants        map[string]ParticipantEntity        `json:"participants"`
+	Accounts            map[string]AccountEntity            `json:"accounts"`
+	Instruments         map[string]InstrumentEntity         `json:"instruments"`
+	Parameter           ParameterEntity                     `json:"parameter"`
+	SessionTime         SessionTimeEntity                   `json:"session_time"`
+	Holidays            map[int]HolidayEntity               `json:"holidays"`
+	RiskRules           map[string]RiskRuleEntity           `json:"risk_rules"`
+	ConcentrationLimits map[string]ConcentrationLimitEntity `json:"concentration_limits"`
+	Orders              map[int]OrderEntity                 `json:"orders"`
+	Trades              map[int]TradeEntity                 `json:"trades"`
+	Contracts           map[int]ContractEntity              `json:"contracts"`
+}
+
+// SnapshotStore persists and loads LedgerPoint snapshots
+type SnapshotStore interface {
+	// Save persists the snapshot
+	Save(s *Snapshot) error
+	// Load returns the latest snapshot for the topic, or nil if there is none
+	Load(topic string) (*Snapshot, error)
+}
+
+// SnapshotHistory is implemented by stores that keep older snapshots as
+// well, so point-in-time views can start from one instead of the beginning
+// of the log
+type SnapshotHistory interface {
+	// LoadBefore returns the newest snapshot of the topic for which usable
+	// returns true, or nil if there is none
+	LoadBefore(topic string, usable func(*Snapshot) bool) (*Snapshot, error)
+}
+
+var _ SnapshotHistory = (*FileSnapshotStore)(nil)
+
+// ErrSnapshotPrune is returned by FileSnapshotStore.Save when the snapshot
+// was saved but older ones couldn't be removed
+var ErrSnapshotPrune = errors.New("failed to remove old snapshots")
+
+// FileSnapshotStore keeps snapshots as JSON files in a local directory.
+// Only the most recent Keep snapshots per topic are retained.
+type FileSnapshotStore struct {
+	Dir  string
+	Keep int
+}
+
+// NewFileSnapshotStore creates a snapshot store writing into dir
+func NewFileSnapshotStore(dir string) (*FileSnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return &FileSnapshotStore{Dir: dir, Keep: 3}, nil
+}
+
+// Save writes the snapshot to a temporary file and renames it into place so
+// a crash mid-write never leaves a truncated snapshot behind
+func (s *FileSnapshotStore) Save(snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	name := filepath.Join(s.Dir, snapshotFileName(snap.Topic, snap.Offset))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+
+	if err := s.prune(snap.Topic); err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshotPrune, err)
+	}
+	return nil
+}
+
+// Load reads the snapshot with the highest offset for the topic
+func (s *FileSnapshotStore) Load(topic string) (*Snapshot, error) {
+	offsets, err := s.list(topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) == 0 {
+		return nil, nil
+	}
+
+	return s.read(topic, offsets[len(offsets)-1])
+}
+
+// LoadBefore reads the snapshots of the topic from the newest down until
+// usable accepts one
+func (s *FileSnapshotStore) LoadBefore(topic string, usable func(*Snapshot) bool) (*Snapshot, error) {
+	offsets, err := s.list(topic)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(offsets) - 1; i >= 0; i-- {
+		snap, err := s.read(topic, offsets[i])
+		if err != nil {
+			return nil, err
+		}
+		if usable(snap) {
+			return snap, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *FileSnapshotStore) read(topic string, offset int64) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, snapshotFileName(topic, offset)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// list returns the offsets of all snapshots for the topic in ascending order
+func (s *FileSnapshotStore) list(topic string) ([]int64, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	prefix := "snapshot-" + topic + "-"
+	var offsets []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		offset, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets, nil
+}
+
+// prune removes all but the newest Keep snapshots of the topic
+func (s *FileSnapshotStore) prune(topic string) error {
+	if s.Keep <= 0 {
+		return nil
+	}
+	offsets, err := s.list(topic)
+	if err != nil || len(offsets) <= s.Keep {
+		return err
+	}
+	var errs []error
+	for _, offset := range offsets[:len(offsets)-s.Keep] {
+		if err := os.Remove(filepath.Join(s.Dir, snapshotFileName(topic, offset))); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func snapshotFileName(topic string, offset int64) string {
+	return fmt.Sprintf("snapshot-%s-%020d.json", topic, offset)
+}
+
+// ============================================================================
+// Capture and Restore
+// ============================================================================
+
+// EnableSnapshots makes the LedgerPoint restore from the latest snapshot in
+// store on Start and write a new snapshot every `every` applied events.
+// Must be called before Start.
+func (lp *LedgerPoint) EnableSnapshots(store SnapshotStore, every int) {
+	lp.snapshots = store
+	lp.snapshotEvery = every
+}
+
+// CaptureSnapshot copies the current entity maps into a Snapshot covering
+// everything up to and including offset
+func (lp *LedgerPoint) CaptureSnapshot(offset int64) *Snapshot {
+	snap := &Snapshot{
+		Version:   SnapshotVersion,
+		Topic:     lp.topic,
+		Offset:    offset,
+		CreatedAt: lp.clock.Now(),
+	}
+
+	lp.participantMu.RLock()
+	snap.Participants = make(map[string]ParticipantEntity, len(lp.participants))
+	for k, v := range lp.participants {
+		snap.Participants[k] = v
+	}
+	lp.participantMu.RUnlock()
+
+	lp.accountMu.RLock()
+	snap.Accounts = make(map[string]AccountEntity, len(lp.accounts))
+	for k, v := range lp.accounts {
+		snap.Accounts[k] = v
+	}
+	lp.accountMu.RUnlock()
+
+	lp.instrumentMu.RLock()
+	snap.Instruments = make(map[string]InstrumentEntity, len(lp.instruments))
+	for k, v := range lp.instruments {
+		snap.Instruments[k] = v
+	}
+	lp.instrumentMu.RUnlock()
+
+	snap.Parameter = lp.GetParameter()
+	snap.SessionTime = lp.GetSessionTime()
+
+	lp.holidayMu.RLock()
+	snap.Holidays = make(map[int]HolidayEntity, len(lp.holidays))
+	for k, v := range lp.holidays {
+		snap.Holidays[k] = v
+	}
+	lp.holidayMu.RUnlock()
+
+	lp.riskRuleMu.RLock()
+	snap.RiskRules = make(map[string]RiskRuleEntity, len(lp.riskRules))
+	for k, v := range lp.riskRules {
+		snap.RiskRules[k] = v
+	}
+	lp.riskRuleMu.RUnlock()
+
+	lp.concentrationMu.RLock()
+	snap.ConcentrationLimits = make(map[string]ConcentrationLimitEntity, len(lp.concentrationLimits))
+	for k, v := range lp.concentrationLimits {
+		snap.ConcentrationLimits[k] = v
+	}
+	lp.concentrationMu.RUnlock()
+
+	lp.ordersMu.RLock()
+	snap.Orders = make(map[int]OrderEntity, len(lp.orders))
+	for k, v := range lp.orders {
+		snap.Orders[k] = v
+	}
+	lp.ordersMu.RUnlock()
+
+	lp.tradesMu.RLock()
+	snap.Trades = make(map[int]TradeEntity, len(lp.trades))
+	for k, v := range lp.trades {
+		v.Lender = append([]int(nil), v.Lender...)
+		v.Borrower = append([]int(nil), v.Borrower...)
+		snap.Trades[k] = v
+	}
+	lp.tradesMu.RUnlock()
+
+	lp.contractsMu.RLock()
+	snap.Contracts = make(map[int]ContractEntity, len(lp.contracts))
+	for k, v := range lp.contracts {
+		snap.Contracts[k] = v
+	}
+	lp.contractsMu.RUnlock()
+
+	return snap
+}
+
+// RestoreSnapshot replaces the entity maps with the snapshot contents and
+// rebuilds the secondary indexes from them. Subscribers are not notified
+// about restored entities.
+func (lp *LedgerPoint) RestoreSnapshot(snap *Snapshot) {
+	lp.participantMu.Lock()
+	lp.participants = nonNilMap(snap.Participants)
+	lp.participantMu.Unlock()
+
+	lp.accountMu.Lock()
+	lp.accounts = nonNilMap(snap.Accounts)
+	lp.accountMu.Unlock()
+
+	lp.instrumentMu.Lock()
+	lp.instruments = nonNilMap(snap.Instruments)
+	lp.instrumentMu.Unlock()
+
+	lp.parameterMu.Lock()
+	lp.parameter = snap.Parameter
+	lp.parameterMu.Unlock()
+
+	lp.sessionTimeMu.Lock()
+	lp.sessionTime = snap.SessionTime
+	lp.sessionTimeMu.Unlock()
+
+	lp.holidayMu.Lock()
+	lp.holidays = nonNilMap(snap.Holidays)
+	lp.holidayMu.Unlock()
+
+	lp.riskRuleMu.Lock()
+	lp.riskRules = nonNilMap(snap.RiskRules)
+	lp.riskRuleMu.Unlock()
+
+	lp.concentrationMu.Lock()
+	lp.concentrationLimits = nonNilMap(snap.ConcentrationLimits)
+	lp.concentrationMu.Unlock()
+
+	lp.ordersMu.Lock()
+	lp.orders = nonNilMap(snap.Orders)
+	lp.reindexOrders()
+	lp.ordersMu.Unlock()
+
+	lp.tradesMu.Lock()
+	lp.trades = nonNilMap(snap.Trades)
+	lp.reindexTrades()
+	lp.tradesMu.Unlock()
+
+	lp.contractsMu.Lock()
+	lp.contracts = nonNilMap(snap.Contracts)
+	lp.reindexContracts()
+	lp.contractsMu.Unlock()
+}
+
+// loadSnapshot restores the latest usable snapshot and sets the offsets
+// reading resumes after. Without one they stay at -1 and everything is
+// replayed.
+func (lp *LedgerPoint) loadSnapshot() {
+	if lp.snapshots == nil {
+		return
+	}
+
+	snap, err := lp.snapshots.Load(lp.topic)
+	if err != nil {
+		lp.Logger().Warn("Failed to load snapshot, replaying from beginning", "error", err)
+		return
+	}
+	if snap == nil {
+		lp.Logger().Info("No snapshot found, replaying from beginning")
+		return
+	}
+	if snap.Version != SnapshotVersion {
+		lp.Logger().Warn("Ignoring snapshot of another version, replaying from beginning",
+			"version", snap.Version, "expected", SnapshotVersion)
+		return
+	}
+
+	if lp.Partitioned() != (snap.Offsets != nil) || (snap.Offsets != nil && len(snap.Offsets) != len(lp.offsets)) {
+		lp.Logger().Warn("Ignoring snapshot taken with a different partition layout, replaying from beginning")
+		return
+	}
+
+	snap, err = lp.unsealSnapshot(snap)
+	if err != nil {
+		lp.Logger().Warn("Failed to decrypt snapshot, replaying from beginning", "error", err)
+		return
+	}
+
+	lp.RestoreSnapshot(snap)
+	for _, key := range snap.EventIDs {
+		lp.dedup.add(key)
+	}
+	if snap.Offsets != nil {
+		for p, offset := range snap.Offsets {
+			atomic.StoreInt64(&lp.offsets[p], offset)
+		}
+	} else {
+		atomic.StoreInt64(&lp.offsets[ControlPartition], snap.Offset)
+	}
+	lp.Logger().Info("Restored snapshot", logging.Offset(snap.Offset),
+		"orders", len(snap.Orders), "trades", len(snap.Trades), "contracts", len(snap.Contracts))
+}
+
+// saveSnapshot writes a snapshot of the state at the last applied offset
+func (lp *LedgerPoint) saveSnapshot() {
+	position := lp.position()
+	if lp.snapshots == nil || position < 0 {
+		return
+	}
+
+	start := time.Now()
+	snap := lp.CaptureSnapshot(position)
+	snap.Time = lp.lastTime
+	if lp.Partitioned() {
+		snap.Offsets = make([]int64, len(lp.offsets))
+		for p := range lp.offsets {
+			snap.Offsets[p] = atomic.LoadInt64(&lp.offsets[p])
+		}
+	}
+	snap.EventIDs = lp.dedup.keys()
+	if lp.security != nil {
+		sealed, err := lp.security.sealSnapshot(snap)
+		if err != nil {
+			lp.Logger().Warn("Failed to encrypt snapshot", logging.Offset(position), "error", err)
+			return
+		}
+		snap = sealed
+	}
+	err := lp.snapshots.Save(snap)
+	switch {
+	case errors.Is(err, ErrSnapshotPrune):
+		lp.Logger().Warn("Failed to remove old snapshots", "error", err)
+	case err != nil:
+		lp.Logger().Warn("Failed to save snapshot", logging.Offset(position), "error", err)
+		return
+	}
+	lp.eventsSinceSnapshot = 0
+	lp.Logger().Info("Snapshot saved", logging.Offset(position), "duration", time.Since(start))
+}
+
+// unsealSnapshot returns snap with its contents decrypted if it was saved
+// with payload encryption on
+func (lp *LedgerPoint) unsealSnapshot(snap *Snapshot) (*Snapshot, error) {
+	if snap.Sealed == nil {
+		return snap, nil
+	}
+	if lp.security == nil {
+		return nil, fmt.Errorf("snapshot is encrypted with key %q but no encryption keys are set", snap.KeyID)
+	}
+	return lp.security.openSnapshot(snap)
+}
+
+// position is the offset of the last applied message. On a partitioned
+// ledger it is the number of messages applied across all partitions minus
+// one, which grows with every message like an offset does.
+func (lp *LedgerPoint) position() int64 {
+	var applied int64
+	for _, p := range lp.assigned {
+		applied += atomic.LoadInt64(&lp.offsets[p]) + 1
+	}
+	return applied - 1
+}
+
+func nonNilMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return make(map[K]V)
+	}
+	return m
+}
@@ -0,0 +1,146 @@
+package ledger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileSnapshotStoreLoadsLatest(t *testing.T) {
+	store, err := NewFileSnapshotStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSnapshotStore() error: %v", err)
+	}
+	store.Keep = 2
+
+	for _, offset := range []int64{5, 42, 17} {
+		if err := store.Save(&Snapshot{Version: SnapshotVersion, Topic: "pme-ledger", Offset: offset}); err != nil {
+			t.Fatalf("Save(%d) error: %v", offset, err)
+		}
+	}
+
+	snap, err := store.Load("pme-ledger")
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if snap == nil || snap.Offset != 42 {
+		t.Fatalf("Load() = %+v, want offset 42", snap)
+	}
+
+	// Older snapshots beyond Keep are pruned
+	files, _ := filepath.Glob(filepath.Join(store.Dir, "snapshot-pme-ledger-*.json"))
+	if len(files) != 2 {
+		t.Errorf("found %d snapshot files, want 2", len(files))
+	}
+
+	// Other topics are not affected
+	other, err := store.Load("other-topic")
+	if err != nil || other != nil {
+		t.Errorf("Load(other-topic) = %+v, %v, want nil, nil", other, err)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	src := CreateLedgerPoint("localhost:9092", "pme-ledger", "test")
+	src.SyncParticipant(Participant{NID: 1, Code: "YU", Name: "Yuanta", BorrEligibility: true})
+	src.SyncAccount(Account{NID: 2, Code: "YU-001", SID: "SID001", ParticipantCode: "YU"})
+	src.SyncAccountLimit(AccountLimit{Code: "YU-001", TradeLimit: 1e9, PoolLimit: 5e8})
+	src.SyncOrder(Order{NID: 10, AccountCode: "YU-001", InstrumentCode: "BBRI", Side: "BORR", Quantity: 100, Timestamp: time.Now()})
+	src.SyncOrderAck(OrderAck{OrderNID: 10, Timestamp: time.Now()})
+	src.SyncTrade(Trade{
+		NID:      20,
+		KpeiReff: "PME-20",
+		Borrower: []Contract{{NID: 201, TradeNID: 20, OrderNID: 10, Side: "BORR", Quantity: 100}},
+	})
+
+	store, err := NewFileSnapshotStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSnapshotStore() error: %v", err)
+	}
+	if err := store.Save(src.CaptureSnapshot(99)); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	dst := CreateLedgerPoint("localhost:9092", "pme-ledger", "test")
+	dst.EnableSnapshots(store, 10)
+	dst.loadSnapshot()
+	if offset := atomic.LoadInt64(&dst.offsets[ControlPartition]); offset != 99 {
+		t.Fatalf("offset after loadSnapshot() = %d, want 99", offset)
+	}
+
+	account, ok := dst.GetAccount("YU-001")
+	if !ok || account.TradeLimit != 1e9 {
+		t.Errorf("GetAccount() = %+v, %v", account, ok)
+	}
+	order, ok := dst.GetOrder(10)
+	if !ok || order.State != "M" || order.DoneQuantity != 100 {
+		t.Errorf("GetOrder() = %+v, %v, want state M with done quantity 100", order, ok)
+	}
+	trade, ok := dst.GetTrade(20)
+	if !ok || len(trade.Borrower) != 1 || trade.Borrower[0] != 201 {
+		t.Errorf("GetTrade() = %+v, %v", trade, ok)
+	}
+	if _, ok := dst.GetContract(201); !ok {
+		t.Error("GetContract(201) not found after restore")
+	}
+}
+
+func TestSnapshotVersionMismatchIsIgnored(t *testing.T) {
+	store, _ := NewFileSnapshotStore(t.TempDir())
+	if err := store.Save(&Snapshot{Version: SnapshotVersion + 1, Topic: "pme-ledger", Offset: 7}); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	lp := CreateLedgerPoint("localhost:9092", "pme-ledger", "test")
+	lp.EnableSnapshots(store, 10)
+	lp.loadSnapshot()
+	if offset := atomic.LoadInt64(&lp.offsets[ControlPartition]); offset != -1 {
+		t.Errorf("offset after loadSnapshot() = %d, want -1 for incompatible snapshot", offset)
+	}
+}
+
+func TestSnapshotIsEncryptedWithPayloads(t *testing.T) {
+	sec := &Security{
+		EncryptionKeys:  map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)},
+		EncryptionKeyID: "k1",
+	}
+	store, _ := NewFileSnapshotStore(t.TempDir())
+
+	src := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	src.SetSecurity(sec)
+	src.EnableSnapshots(store, 10)
+	src.SyncAccount(Account{NID: 2, Code: "YU-001", SID: "SID001", ParticipantCode: "YU"})
+	atomic.StoreInt64(&src.offsets[ControlPartition], 41)
+	src.saveSnapshot()
+
+	files, _ := filepath.Glob(filepath.Join(store.Dir, "snapshot-pme-ledger-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("found %d snapshot files, want 1", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if bytes.Contains(data, []byte("SID001")) {
+		t.Error("snapshot holds the account SID in clear")
+	}
+
+	dst := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	dst.SetSecurity(sec)
+	dst.EnableSnapshots(store, 10)
+	dst.loadSnapshot()
+	if offset := atomic.LoadInt64(&dst.offsets[ControlPartition]); offset != 41 {
+		t.Fatalf("offset after loadSnapshot() = %d, want 41", offset)
+	}
+	if account, ok := dst.GetAccount("YU-001"); !ok || account.SID != "SID001" {
+		t.Errorf("GetAccount() = %+v, %v, want SID001", account, ok)
+	}
+
+	// Without the key the snapshot is skipped and the topic replayed
+	plain := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	plain.EnableSnapshots(store, 10)
+	plain.loadSnapshot()
+	if offset := atomic.LoadInt64(&plain.offsets[ControlPartition]); offset != -1 {
+		t.Errorf("offset after loadSnapshot() without key = %d, want -1", offset)
+	}
+}
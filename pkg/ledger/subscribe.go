@@ -0,0 +1,46 @@
+package ledger
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Subscribe registers fn to be called for every event of type T applied by
+// the LedgerPoint, after its state has been updated. It is the typed
+// alternative to implementing all of LedgerPointInterface:
+//
+//	ledger.Subscribe(lp, func(a ledger.TradeAck) { ... })
+//
+// Subscribers run on the processing goroutine, so they see events in log
+// order and must not call CommitSync. Subscribe before Start to receive the
+// replay of historical events as well.
+func Subscribe[T any](lp *LedgerPoint, fn func(T)) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if _, ok := events.byType[t]; !ok {
+		panic(fmt.Sprintf("ledger: Subscribe to unknown event type %s", t))
+	}
+
+	lp.subscribersMu.Lock()
+	defer lp.subscribersMu.Unlock()
+	lp.subscribers[t] = append(lp.subscribers[t], func(event any) { fn(event.(T)) })
+}
+
+// notify forwards an applied event to LedgerPointInterface subscribers and
+// then to typed subscribers
+func (lp *LedgerPoint) notify(event any) {
+	t := reflect.TypeOf(event)
+
+	if et, ok := events.byType[t]; ok && et.legacy != nil {
+		for _, sync := range lp.allSync {
+			et.legacy(sync, event)
+		}
+	}
+
+	lp.subscribersMu.RLock()
+	subscribers := lp.subscribers[t]
+	lp.subscribersMu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
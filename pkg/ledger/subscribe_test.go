@@ -0,0 +1,47 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSubscribeReceivesTypedEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+
+	holidays := make(chan Holiday, 1)
+	Subscribe(lp, func(a Holiday) {
+		// State is updated before subscribers run
+		if _, ok := lp.GetHoliday(a.NID); !ok {
+			t.Errorf("holiday %d not in state when subscriber ran", a.NID)
+		}
+		holidays <- a
+	})
+	Subscribe(lp, func(a Eod) {
+		t.Errorf("Eod subscriber called unexpectedly")
+	})
+	lp.Start(nil, ctx)
+
+	lp.Commit <- Holiday{NID: 7, Description: "Independence Day"}
+
+	select {
+	case a := <-holidays:
+		if a.NID != 7 || a.Timestamp.IsZero() {
+			t.Errorf("subscriber got %+v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriber was not called")
+	}
+}
+
+func TestSubscribeUnknownTypePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Subscribe to an unregistered type did not panic")
+		}
+	}()
+	Subscribe(CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test"), func(string) {})
+}
@@ -0,0 +1,139 @@
+package ledger
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace context travels in W3C traceparent/tracestate message headers, so a
+// trace started where an event is committed (e.g. an HTTP request) continues
+// where it is applied. Spans go to the global OpenTelemetry tracer provider,
+// which does nothing until the service installs one (see pkg/tracing).
+//
+// The trace headers are not part of the signed envelope: they only say where
+// to file the spans.
+
+var propagator = propagation.TraceContext{}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("pmeonline/pkg/ledger")
+}
+
+// headerCarrier lets the propagator read and write message headers
+type headerCarrier struct {
+	headers *[]Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	return string(headerValue(*c.headers, key))
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	for i, h := range *c.headers {
+		if h.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(*c.headers))
+	for i, h := range *c.headers {
+		keys[i] = h.Key
+	}
+	return keys
+}
+
+// contextEvent is an event sent on Commit together with its trace context
+type contextEvent struct {
+	ctx   context.Context
+	event any
+}
+
+// WithContext wraps event so it carries the trace context of ctx when sent
+// on Commit:
+//
+//	lp.Commit <- ledger.WithContext(ctx, ledger.OrderAck{OrderNID: nid})
+//
+// Only the values of ctx are used; cancelling it doesn't stop the commit.
+// CommitSync takes the context directly.
+func WithContext(ctx context.Context, event any) any {
+	return contextEvent{ctx: context.WithoutCancel(ctx), event: event}
+}
+
+// asyncCommit turns something sent on Commit into a request nobody waits for
+func asyncCommit(event any) commitRequest {
+	if e, ok := event.(contextEvent); ok {
+		return commitRequest{ctx: e.ctx, event: e.event}
+	}
+	return commitRequest{ctx: context.Background(), event: event}
+}
+
+// startCommitSpan starts the span of writing msg and injects it into the
+// headers, so the spans of applying the event become its children
+func (lp *LedgerPoint) startCommitSpan(ctx context.Context, msg *Message) trace.Span {
+	env, _ := ParseEnvelope(msg.Headers)
+	ctx, span := tracer().Start(ctx, "ledger.commit "+env.EventType,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", lp.topic),
+			attribute.String("ledger.event_type", env.EventType),
+			attribute.String("ledger.event_id", env.EventID),
+		))
+	propagator.Inject(ctx, headerCarrier{&msg.Headers})
+	return span
+}
+
+// endCommitSpan records the outcome of the write
+func endCommitSpan(span trace.Span, partition int, offset int64, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(
+			attribute.Int("messaging.destination.partition.id", partition),
+			attribute.Int64("messaging.kafka.offset", offset),
+		)
+	}
+	span.End()
+}
+
+// startApplySpan continues the trace of a message read from the log. Only
+// live messages are traced: replaying the log on start doesn't repeat the
+// spans of old traces.
+func (lp *LedgerPoint) startApplySpan(msg Message, env Envelope) (context.Context, trace.Span) {
+	ctx := propagator.Extract(context.Background(), headerCarrier{&msg.Headers})
+	if !trace.SpanContextFromContext(ctx).IsValid() || !lp.IsReady() {
+		return context.Background(), nil
+	}
+	return tracer().Start(ctx, "ledger.apply "+env.EventType,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", lp.topic),
+			attribute.Int("messaging.destination.partition.id", msg.Partition),
+			attribute.Int64("messaging.kafka.offset", msg.Offset),
+			attribute.String("ledger.event_type", env.EventType),
+			attribute.String("ledger.event_id", env.EventID),
+			attribute.String("ledger.producer", env.Producer),
+		))
+}
+
+// CurrentContext returns the trace context of the message being applied, for
+// subscribers that continue its trace. Like CurrentOffset it is only
+// meaningful inside a subscriber callback; work handed to another goroutine
+// must take the context with it.
+func (lp *LedgerPoint) CurrentContext() context.Context {
+	if lp.currentCtx == nil {
+		return context.Background()
+	}
+	return lp.currentCtx
+}
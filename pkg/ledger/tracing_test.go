@@ -0,0 +1,91 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceContextCrossesTheLog(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lp := CreateLedgerPointWithTransport(NewMemoryTransport("pme-ledger"), "test")
+	applied := make(chan trace.SpanContext, 2)
+	Subscribe(lp, func(h Holiday) {
+		applied <- trace.SpanContextFromContext(lp.CurrentContext())
+	})
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+
+	requestCtx, request := provider.Tracer("test").Start(ctx, "request")
+	if _, err := lp.CommitSync(requestCtx, Holiday{NID: 1}); err != nil {
+		t.Fatalf("CommitSync() error: %v", err)
+	}
+	lp.Commit <- WithContext(requestCtx, Holiday{NID: 2})
+	request.End()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case sc := <-applied:
+			if sc.TraceID() != request.SpanContext().TraceID() {
+				t.Errorf("subscriber trace = %s, want the request's %s", sc.TraceID(), request.SpanContext().TraceID())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Holiday not applied")
+		}
+	}
+
+	// Apply spans end once the subscribers have returned
+	deadline := time.Now().Add(2 * time.Second)
+	for countSpans(recorder, "ledger.apply Holiday") < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// request -> ledger.commit Holiday -> ledger.apply Holiday, twice
+	parents := make(map[string][]trace.SpanID)
+	var commits []trace.SpanID
+	for _, span := range recorder.Ended() {
+		parents[span.Name()] = append(parents[span.Name()], span.Parent().SpanID())
+		if span.Name() == "ledger.commit Holiday" {
+			commits = append(commits, span.SpanContext().SpanID())
+		}
+	}
+	for _, parent := range parents["ledger.commit Holiday"] {
+		if parent != request.SpanContext().SpanID() {
+			t.Errorf("commit span parent = %s, want the request span", parent)
+		}
+	}
+	if len(commits) != 2 || len(parents["ledger.apply Holiday"]) != 2 {
+		t.Fatalf("spans = %v, want two commits and two applies", parents)
+	}
+	for _, parent := range parents["ledger.apply Holiday"] {
+		if parent != commits[0] && parent != commits[1] {
+			t.Errorf("apply span parent = %s, want a commit span", parent)
+		}
+	}
+	if _, ok := parents["ledger.apply ServiceStart"]; ok {
+		t.Error("replayed ServiceStart was traced")
+	}
+}
+
+func countSpans(recorder *tracetest.SpanRecorder, name string) int {
+	n := 0
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,50 @@
+package ledger
+
+import (
+	"context"
+	"time"
+)
+
+// FirstOffset tells OpenReader to start at the oldest message in the log
+const FirstOffset int64 = -2
+
+// Header is a key/value pair attached to a Message
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a single entry of the ledger log
+type Message struct {
+	Partition int // Set by the LedgerPoint on partitioned ledgers
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Time      time.Time
+}
+
+// Transport is the append-only log the LedgerPoint commits to and replays from
+type Transport interface {
+	// Topic returns the name of the log, used to key snapshots
+	Topic() string
+	// Append writes msgs to the end of the log and returns the offset
+	// assigned to the first one
+	Append(ctx context.Context, msgs ...Message) (int64, error)
+	// OpenReader returns a reader positioned at offset, or at the start of
+	// the log when offset is FirstOffset
+	OpenReader(offset int64) (TransportReader, error)
+	// HighWaterMark returns the offset the next appended message will get,
+	// i.e. one past the last message currently in the log
+	HighWaterMark(ctx context.Context) (int64, error)
+	// Close releases the resources held by the transport
+	Close() error
+}
+
+// TransportReader reads messages from a Transport in offset order
+type TransportReader interface {
+	// ReadMessage blocks until the next message is available or ctx is done
+	ReadMessage(ctx context.Context) (Message, error)
+	// Close stops the reader; pending ReadMessage calls return an error
+	Close() error
+}
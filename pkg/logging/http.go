@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// HeaderRequestID carries the request ID of an HTTP request and its response
+const HeaderRequestID = "X-Request-ID"
+
+// Middleware gives every request a request ID, taken from the X-Request-ID
+// header when the client sent a usable one and generated otherwise. The ID
+// is echoed in the response, and a logger with the request_id field is
+// stored in the request context for the handlers (see FromContext).
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+
+		ctx := WithLogger(r.Context(), logger.With(RequestID(id)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newRequestID returns 16 random hex digits
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,156 @@
+// Package logging builds the structured loggers of the PME services.
+//
+// Services create one logger with New, make it the default (so the standard
+// log package goes through it as well) and hand it to the LedgerPoint, OMS,
+// handlers and exporter, which add their fields:
+//
+//	logger.Info("Order acknowledged", logging.OrderNID(nid))
+//
+// The field helpers below keep the names the same in every service, so the
+// lifecycle of an order can be followed with a single filter such as
+// order_nid=1234 or kpei_reff="PME-20251129-1".
+//
+// Configuration:
+//
+//	LOG_FORMAT  text (default) or json
+//	LOG_LEVEL   debug, info (default), warn or error
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Field names shared by all services
+const (
+	KeyService     = "service"
+	KeyComponent   = "component"
+	KeyRequestID   = "request_id"
+	KeyOrderNID    = "order_nid"
+	KeyTradeNID    = "trade_nid"
+	KeyContractNID = "contract_nid"
+	KeyKpeiReff    = "kpei_reff"
+	KeyParticipant = "participant"
+	KeyAccount     = "account"
+	KeyInstrument  = "instrument"
+	KeyEventType   = "event_type"
+	KeyOffset      = "offset"
+	KeyTraceID     = "trace_id"
+	KeySpanID      = "span_id"
+)
+
+// OrderNID is the order_nid field
+func OrderNID(nid int) slog.Attr { return slog.Int(KeyOrderNID, nid) }
+
+// TradeNID is the trade_nid field
+func TradeNID(nid int) slog.Attr { return slog.Int(KeyTradeNID, nid) }
+
+// ContractNID is the contract_nid field
+func ContractNID(nid int) slog.Attr { return slog.Int(KeyContractNID, nid) }
+
+// KpeiReff is the kpei_reff field, the trade reference shared with eClear
+func KpeiReff(reff string) slog.Attr { return slog.String(KeyKpeiReff, reff) }
+
+// Participant is the participant field, a participant code
+func Participant(code string) slog.Attr { return slog.String(KeyParticipant, code) }
+
+// Account is the account field, an account code
+func Account(code string) slog.Attr { return slog.String(KeyAccount, code) }
+
+// Instrument is the instrument field, an instrument code
+func Instrument(code string) slog.Attr { return slog.String(KeyInstrument, code) }
+
+// RequestID is the request_id field
+func RequestID(id string) slog.Attr { return slog.String(KeyRequestID, id) }
+
+// EventType is the event_type field, the name of a ledger event
+func EventType(name string) slog.Attr { return slog.String(KeyEventType, name) }
+
+// Offset is the offset field, a position in the ledger
+func Offset(offset int64) slog.Attr { return slog.Int64(KeyOffset, offset) }
+
+// Component names the part of a service a logger belongs to
+func Component(name string) slog.Attr { return slog.String(KeyComponent, name) }
+
+// New creates the logger of service from LOG_FORMAT and LOG_LEVEL, writing
+// to stderr
+func New(service string) (*slog.Logger, error) {
+	return NewWithWriter(os.Stderr, service, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+}
+
+// Fatal logs msg with args at error level and exits, for errors that keep a
+// service from starting
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// NewWithWriter creates a logger writing to w in format ("text" or "json")
+// at level and above. Empty values select text and info.
+func NewWithWriter(w io.Writer, service string, format string, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q, want debug, info, warn or error", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, want text or json", format)
+	}
+	return slog.New(traceHandler{h}).With(KeyService, service), nil
+}
+
+// traceHandler adds the trace and span IDs of the context to records logged
+// with the *Context methods, linking logs to traces
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String(KeyTraceID, sc.TraceID().String()),
+			slog.String(KeySpanID, sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+type contextKey struct{}
+
+// WithLogger returns a context carrying logger, e.g. one with the request_id
+// of an HTTP request
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored with WithLogger, or the default
+// logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
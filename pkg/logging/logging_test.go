@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestJSONFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewWithWriter(&buf, "pmeoms", "json", "info")
+	if err != nil {
+		t.Fatalf("NewWithWriter() error: %v", err)
+	}
+
+	logger.Debug("hidden")
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+	logger.InfoContext(ctx, "Order acknowledged", OrderNID(42), KpeiReff("PME-1"), Participant("DX"))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("want one JSON record, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"level":        "INFO",
+		"msg":          "Order acknowledged",
+		KeyService:     "pmeoms",
+		KeyOrderNID:    float64(42),
+		KeyKpeiReff:    "PME-1",
+		KeyParticipant: "DX",
+		KeyTraceID:     span.SpanContext().TraceID().String(),
+		KeySpanID:      span.SpanContext().SpanID().String(),
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("%s = %v, want %v", key, record[key], value)
+		}
+	}
+}
+
+func TestInvalidSettings(t *testing.T) {
+	if _, err := NewWithWriter(&bytes.Buffer{}, "pmeapi", "xml", ""); err == nil {
+		t.Error("format xml accepted")
+	}
+	if _, err := NewWithWriter(&bytes.Buffer{}, "pmeapi", "", "loud"); err == nil {
+		t.Error("level loud accepted")
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := NewWithWriter(&buf, "pmeapi", "json", "")
+	handler := Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handled")
+	}))
+
+	// Client supplied IDs are kept
+	req := httptest.NewRequest("GET", "/api/order/list", nil)
+	req.Header.Set(HeaderRequestID, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get(HeaderRequestID); got != "req-1" {
+		t.Errorf("response %s = %q, want req-1", HeaderRequestID, got)
+	}
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil || record[KeyRequestID] != "req-1" {
+		t.Errorf("log record = %q, want request_id req-1", buf.String())
+	}
+
+	// Others are generated
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/order/list", nil))
+	if got := rec.Header().Get(HeaderRequestID); len(got) != 16 {
+		t.Errorf("generated %s = %q, want 16 hex digits", HeaderRequestID, got)
+	}
+}
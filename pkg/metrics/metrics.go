@@ -0,0 +1,128 @@
+// Package metrics exposes the Prometheus metrics of the PME services.
+// Services mount Handler on GET /metrics and call RegisterLedger for their
+// LedgerPoint; service specific metrics are registered by the packages that
+// update them.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"pmeonline/pkg/ledger"
+)
+
+// Namespace prefixes every metric name
+const Namespace = "pme"
+
+// Handler serves all registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterLedger registers a collector for the offsets, lag, applied events
+// and commit path of lp, labelled with the service name
+func RegisterLedger(lp *ledger.LedgerPoint, service string) {
+	prometheus.MustRegister(NewLedgerCollector(lp, service))
+}
+
+// LedgerCollector reads the state of a LedgerPoint on every scrape
+type LedgerCollector struct {
+	lp *ledger.LedgerPoint
+
+	ready         *prometheus.Desc
+	offset        *prometheus.Desc
+	highWaterMark *prometheus.Desc
+	lag           *prometheus.Desc
+	applied       *prometheus.Desc
+	queueDepth    *prometheus.Desc
+	queueCapacity *prometheus.Desc
+	receiveDepth  *prometheus.Desc
+	commits       *prometheus.Desc
+	writeLatency  *prometheus.Desc
+	writeMax      *prometheus.Desc
+}
+
+// NewLedgerCollector creates a collector for lp
+func NewLedgerCollector(lp *ledger.LedgerPoint, service string) *LedgerCollector {
+	labels := prometheus.Labels{"service": service}
+	desc := func(name string, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "ledger", name), help, variable, labels)
+	}
+
+	return &LedgerCollector{
+		lp:            lp,
+		ready:         desc("ready", "1 once the ledger has been replayed up to this process' start"),
+		offset:        desc("offset", "Offset of the last applied message", "partition"),
+		highWaterMark: desc("high_water_mark", "Offset the next message written to the partition will get", "partition"),
+		lag:           desc("lag", "Messages written but not yet applied", "partition"),
+		applied:       desc("events_applied_total", "Events applied by this process", "type"),
+		queueDepth:    desc("commit_queue_depth", "Commits waiting to be written"),
+		queueCapacity: desc("commit_queue_capacity", "Commits that can be queued before CommitSync is refused"),
+		receiveDepth:  desc("receive_queue_depth", "Messages read but not yet applied"),
+		commits:       desc("commits_total", "Commits by outcome (committed, failed, invalid or rejected because the queue was full)", "result"),
+		writeLatency:  desc("commit_write_seconds", "Latency of appends to the transport"),
+		writeMax:      desc("commit_write_max_seconds", "Slowest append to the transport"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *LedgerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.ready, c.offset, c.highWaterMark, c.lag, c.applied, c.queueDepth,
+		c.queueCapacity, c.receiveDepth, c.commits, c.writeLatency, c.writeMax,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector. The lag is left out when the
+// high-water marks can't be read within two seconds.
+func (c *LedgerCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(d *prometheus.Desc, v uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+
+	ready := 0.0
+	if c.lp.IsReady() {
+		ready = 1
+	}
+	gauge(c.ready, ready)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if info, err := c.lp.Lag(ctx); err == nil {
+		partitions := info.Partitions
+		if partitions == nil {
+			partitions = []ledger.LagInfo{info}
+		}
+		for _, p := range partitions {
+			partition := strconv.Itoa(p.Partition)
+			gauge(c.offset, float64(p.Offset), partition)
+			gauge(c.highWaterMark, float64(p.HighWaterMark), partition)
+			gauge(c.lag, float64(p.Lag), partition)
+		}
+	}
+
+	for eventType, n := range c.lp.AppliedEvents() {
+		counter(c.applied, n, eventType)
+	}
+
+	stats := c.lp.CommitStats()
+	gauge(c.queueDepth, float64(stats.QueueDepth))
+	gauge(c.queueCapacity, float64(stats.QueueCapacity))
+	gauge(c.receiveDepth, float64(stats.ReceiveDepth))
+	counter(c.commits, stats.Committed, "committed")
+	counter(c.commits, stats.Failed, "failed")
+	counter(c.commits, stats.Invalid, "invalid")
+	counter(c.commits, stats.Rejected, "rejected")
+	ch <- prometheus.MustNewConstSummary(c.writeLatency, stats.Batches, stats.TotalWriteLatency.Seconds(), nil)
+	gauge(c.writeMax, stats.MaxWriteLatency.Seconds())
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"pmeonline/pkg/ledger"
+)
+
+func TestLedgerCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lp := ledger.CreateLedgerPointWithTransport(ledger.NewMemoryTransport("pme-ledger"), "test")
+	lp.Start(nil, ctx)
+	<-lp.Ready()
+	if _, err := lp.CommitSync(ctx, ledger.Holiday{NID: 1}); err != nil {
+		t.Fatalf("CommitSync() error: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewLedgerCollector(lp, "test"))
+
+	// The Holiday is applied shortly after it is committed
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		values := gather(t, registry)
+		if values["pme_ledger_events_applied_total{type=Holiday}"] == 1 {
+			want := map[string]float64{
+				"pme_ledger_ready":                           1,
+				"pme_ledger_offset{partition=0}":             1,
+				"pme_ledger_lag{partition=0}":                0,
+				"pme_ledger_commits_total{result=committed}": 2,
+				"pme_ledger_commit_write_seconds_count":      2,
+			}
+			for name, v := range want {
+				if values[name] != v {
+					t.Errorf("%s = %v, want %v", name, values[name], v)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Holiday not counted as applied: %v", values)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// gather returns the value of every sample as name{label=value}
+func gather(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error: %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range m.GetLabel() {
+				if label.GetName() != "service" {
+					name += "{" + label.GetName() + "=" + label.GetValue() + "}"
+				}
+			}
+			switch {
+			case m.Gauge != nil:
+				values[name] = m.GetGauge().GetValue()
+			case m.Counter != nil:
+				values[name] = m.GetCounter().GetValue()
+			case m.Summary != nil:
+				values[name+"_count"] = float64(m.GetSummary().GetSampleCount())
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,71 @@
+// Package tracing installs the OpenTelemetry tracer provider of a PME
+// service. Traces cross services in the ledger message headers (see
+// pkg/ledger/tracing.go) and in W3C traceparent HTTP headers.
+//
+// The exporter is chosen with the standard OpenTelemetry variables:
+//
+//	OTEL_TRACES_EXPORTER         otlp, console (stdout) or none (default)
+//	OTEL_EXPORTER_OTLP_ENDPOINT  collector address, http://localhost:4318 by default
+//	OTEL_TRACES_SAMPLER_ARG      fraction of new traces to record, 1 by default
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup installs a tracer provider for service from the environment and
+// returns a function flushing and stopping it on shutdown. With no exporter
+// configured it only installs the propagator, so trace context is still
+// passed on.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "console", "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q, want otlp, console or none", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s exporter: %w", os.Getenv("OTEL_TRACES_EXPORTER"), err)
+	}
+
+	ratio := 1.0
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		if ratio, err = strconv.ParseFloat(arg, 64); err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q, want a fraction between 0 and 1", arg)
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer a package names its spans with
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}